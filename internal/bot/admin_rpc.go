@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"webBridgeBot/internal/rpc"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+)
+
+// adminRPCUser is a user as returned by the admin API. It converts from data.User.
+type adminRPCUser struct {
+	UserID       int64  `json:"userId"`
+	ChatID       int64  `json:"chatId"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	Username     string `json:"username"`
+	IsAuthorized bool   `json:"isAuthorized"`
+	IsAdmin      bool   `json:"isAdmin"`
+	Language     string `json:"language"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+// userParams are the params of the admin API methods acting on a user.
+type userParams struct {
+	UserID int64 `json:"userId"`
+	Admin  bool  `json:"admin"`
+}
+
+func decodeUserParams(params json.RawMessage) (userParams, error) {
+	var p userParams
+	if err := rpc.DecodeParams(params, &p); err != nil {
+		return p, err
+	}
+	if p.UserID == 0 {
+		return p, &rpc.Error{Code: rpc.CodeInvalidParams, Message: "userId is required"}
+	}
+	return p, nil
+}
+
+// runAdminRPC serves the admin API on the AdminRPCSocket Unix socket until ctx is cancelled.
+func (b *TelegramBot) runAdminRPC(ctx context.Context) {
+	s := rpc.NewServer(b.logger)
+	s.Register("users.list", b.rpcListUsers)
+	s.Register("users.authorize", b.rpcAuthorizeUser)
+	s.Register("users.deauthorize", b.rpcDeauthorizeUser)
+	s.Register("cache.stats", b.rpcCacheStats)
+	s.Register("streams.list", b.rpcListStreams)
+	s.Register("streams.kill", b.rpcKillStreams)
+	s.Register("prefetch", b.rpcPrefetch)
+	s.Register("config.reload", b.rpcReloadConfig)
+
+	b.logger.Printf("Serving the admin API on %s", b.config.AdminRPCSocket)
+	if err := s.ListenAndServe(ctx, b.config.AdminRPCSocket); err != nil {
+		b.logger.Printf("Admin API stopped: %v", err)
+	}
+}
+
+func (b *TelegramBot) rpcListUsers(context.Context, json.RawMessage) (any, error) {
+	users, err := b.userRepository.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]adminRPCUser, 0, len(users))
+	for _, u := range users {
+		result = append(result, adminRPCUser(u))
+	}
+	return result, nil
+}
+
+func (b *TelegramBot) rpcAuthorizeUser(_ context.Context, params json.RawMessage) (any, error) {
+	p, err := decodeUserParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.userRepository.GetUserInfo(p.UserID); err != nil {
+		return nil, fmt.Errorf("user %d has not started the bot", p.UserID)
+	}
+	if err := b.userRepository.AuthorizeUser(p.UserID, p.Admin); err != nil {
+		return nil, err
+	}
+	b.logger.Printf("Admin API authorized user %d (admin: %t)", p.UserID, p.Admin)
+	return true, nil
+}
+
+func (b *TelegramBot) rpcDeauthorizeUser(_ context.Context, params json.RawMessage) (any, error) {
+	p, err := decodeUserParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.userRepository.DeauthorizeUser(p.UserID); err != nil {
+		return nil, err
+	}
+	b.logger.Printf("Admin API deauthorized user %d", p.UserID)
+	return true, nil
+}
+
+func (b *TelegramBot) rpcCacheStats(context.Context, json.RawMessage) (any, error) {
+	return b.config.BinaryCache.GetStats()
+}
+
+func (b *TelegramBot) rpcListStreams(_ context.Context, params json.RawMessage) (any, error) {
+	p, err := decodeUserParams(params)
+	if err != nil {
+		return nil, err
+	}
+	connections := b.connections.UserConnections(p.UserID)
+	if connections == nil {
+		connections = []web.Connection{}
+	}
+	return connections, nil
+}
+
+func (b *TelegramBot) rpcKillStreams(_ context.Context, params json.RawMessage) (any, error) {
+	p, err := decodeUserParams(params)
+	if err != nil {
+		return nil, err
+	}
+	closed := b.streams.Cancel(p.UserID)
+	b.logger.Printf("Admin API closed %d streams and connections of user %d", closed, p.UserID)
+	return map[string]int{"closed": closed}, nil
+}
+
+// rpcReloadConfig reloads the configuration like SIGHUP and returns the settings that changed.
+func (b *TelegramBot) rpcReloadConfig(context.Context, json.RawMessage) (any, error) {
+	changed, err := b.reloadConfig()
+	if err != nil {
+		return nil, err
+	}
+	b.logger.Printf("Admin API reloaded the configuration: %v", changed)
+	return map[string][]string{"changed": append([]string{}, changed...)}, nil
+}
+
+// rpcPrefetch queues the file of a message to be cached for the user who sent it.
+func (b *TelegramBot) rpcPrefetch(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		MessageID int `json:"messageId"`
+	}
+	if err := rpc.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.MessageID <= 0 {
+		return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: "messageId is required"}
+	}
+	file, err := utils.FileFromMessage(ctx, b.tgClient, p.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("message %d does not contain supported media: %w", p.MessageID, err)
+	}
+	userID := b.mediaOwner(p.MessageID)
+	if err := b.checkPrefetch(userID, file); err != nil {
+		return nil, err
+	}
+	var chatID int64
+	if user, err := b.userRepository.GetUserInfo(userID); err == nil {
+		chatID = user.ChatID
+	}
+
+	job, err := b.enqueuePrefetch(chatID, userID, p.MessageID, file, false)
+	if errors.Is(err, errAlreadyCached) {
+		return map[string]any{"status": prefetchDone, "fileName": file.FileName}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"job": job.id, "status": prefetchQueued, "fileName": file.FileName}, nil
+}
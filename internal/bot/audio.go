@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	logging "webBridgeBot/internal/logger"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/web"
+)
+
+const (
+	// Larger tags are not read; they would delay the media message for a cover image.
+	maxID3TagSize   = 4 << 20
+	audioTagTimeout = 10 * time.Second
+)
+
+// hasID3 reports whether a file is an MP3, the audio format that carries ID3 tags.
+func hasID3(file *types.DocumentFile) bool {
+	return file.MimeType == "audio/mpeg" || file.MimeType == "audio/mp3" || strings.EqualFold(filepath.Ext(file.FileName), ".mp3")
+}
+
+// addAudioMetadata adds the waveform of voice notes, and the title, artist, album and year of
+// audio files to a media message. Telegram's own attributes are preferred over the ID3 tag.
+func (b *TelegramBot) addAudioMetadata(msg map[string]string, messageID int, file *types.DocumentFile) {
+	attr := file.AudioAttr
+	if attr.Voice {
+		msg["voice"] = "true"
+		if waveform := decodeWaveform(attr.Waveform); len(waveform) > 0 {
+			msg["waveform"] = formatWaveform(waveform)
+		}
+		return
+	}
+
+	title, performer := attr.Title, attr.Performer
+	if hasID3(file) {
+		ctx, cancel := context.WithTimeout(b.tgCtx, audioTagTimeout)
+		tags, err := b.readAudioTags(ctx, messageID, file)
+		cancel()
+		if err != nil {
+			b.logger.Printf("Failed to read the ID3 tag of message ID %d: %v", messageID, err)
+		} else if tags != nil {
+			if title == "" {
+				title = tags.Title
+			}
+			if performer == "" {
+				performer = tags.Artist
+			}
+			setIfNotEmpty(msg, "album", tags.Album)
+			setIfNotEmpty(msg, "year", tags.Year)
+		}
+	}
+	setIfNotEmpty(msg, "title", title)
+	setIfNotEmpty(msg, "performer", performer)
+}
+
+func setIfNotEmpty(msg map[string]string, key, value string) {
+	if value != "" {
+		msg[key] = value
+	}
+}
+
+// readAudioTags reads the ID3 tag at the start of a file. It returns nil if there is none. The
+// tag is read through the chunk cache, so playback starting afterwards does not fetch it again.
+func (b *TelegramBot) readAudioTags(ctx context.Context, messageID int, file *types.DocumentFile) (*web.AudioTags, error) {
+	if file.FileSize < web.ID3HeaderSize {
+		return nil, nil
+	}
+	header, err := b.readFileRange(ctx, messageID, file, 0, web.ID3HeaderSize-1)
+	if err != nil {
+		return nil, err
+	}
+	size := web.ID3Size(header)
+	if size == 0 {
+		return nil, nil
+	}
+	if size > maxID3TagSize || size > file.FileSize {
+		return nil, fmt.Errorf("ID3 tag of %d bytes is too large", size)
+	}
+	tag, err := b.readFileRange(ctx, messageID, file, 0, size-1)
+	if err != nil {
+		return nil, err
+	}
+	return web.ParseID3(tag)
+}
+
+// readFileRange reads the bytes start to end, inclusive, of a file.
+func (b *TelegramBot) readFileRange(ctx context.Context, messageID int, file *types.DocumentFile, start, end int64) ([]byte, error) {
+	lr, err := b.newFileReader(ctx, messageID, file, start, end, file.FileSize,
+		logging.WithFields(b.logger, logging.Fields{"module": "reader", "message_id": messageID}))
+	if err != nil {
+		return nil, err
+	}
+	defer lr.Close()
+	return io.ReadAll(lr)
+}
+
+// decodeWaveform unpacks the waveform of a voice note, which Telegram stores as 5-bit values
+// from 0 to 31.
+func decodeWaveform(packed []byte) []int {
+	count := len(packed) * 8 / 5
+	values := make([]int, count)
+	for i := range values {
+		bit := i * 5
+		value := int(packed[bit/8])
+		if bit/8+1 < len(packed) {
+			value |= int(packed[bit/8+1]) << 8
+		}
+		values[i] = value >> (bit % 8) & 31
+	}
+	return values
+}
+
+func formatWaveform(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
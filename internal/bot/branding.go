@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/web"
+
+	"github.com/gorilla/mux"
+)
+
+// playerBranding is how operators brand the web player, from the PLAYER_* settings.
+type playerBranding struct {
+	Title        string `json:"title"`
+	AccentColor  string `json:"accentColor"`
+	LogoURL      string `json:"logoUrl,omitempty"`
+	CustomCSSURL string `json:"customCssUrl,omitempty"`
+}
+
+// playerConfig is the branding of the player together with the settings of its chat.
+type playerConfig struct {
+	playerBranding
+	ChatID  int64  `json:"chatId"`
+	Theme   string `json:"theme"`
+	Density string `json:"density"`
+}
+
+func (b *TelegramBot) playerBranding() playerBranding {
+	live := b.live.Load()
+	branding := playerBranding{
+		Title:       live.PlayerTitle,
+		AccentColor: live.PlayerAccentColor,
+		LogoURL:     live.PlayerLogoURL,
+	}
+	if live.PlayerCustomCSS != "" {
+		branding.CustomCSSURL = b.basePath() + "custom.css"
+	}
+	return branding
+}
+
+// handlePlayerConfigAPI returns the branding and settings the player of a chat is rendered with.
+func (b *TelegramBot) handlePlayerConfigAPI(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.parseChatID(mux.Vars(r))
+	if err != nil {
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := b.userRepository.GetUserSettings(playerUserID(chatID))
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for chat %d: %v", chatID, err)
+		settings = data.DefaultUserSettings(playerUserID(chatID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(playerConfig{
+		playerBranding: b.playerBranding(),
+		ChatID:         chatID,
+		Theme:          settings.Theme,
+		Density:        settings.Density,
+	}); err != nil {
+		b.logger.Printf("Error encoding player config: %v", err)
+	}
+}
+
+// handleCustomCSS serves the stylesheet of PLAYER_CUSTOM_CSS, which the player loads after its
+// own styles.
+func (b *TelegramBot) handleCustomCSS(w http.ResponseWriter, r *http.Request) {
+	customCSS := b.live.Load().PlayerCustomCSS
+	if customCSS == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, customCSS)
+}
@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/celestix/gotgproto/ext"
+	gtypes "github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	wsMessageTypeText     = "text"
+	wsMessageTypeLocation = "location"
+)
+
+// isBridgeableMessage reports whether a message is plain text or a location, the messages shown
+// in the player's chat sidebar when text bridging is on. Commands are left to their handlers.
+func isBridgeableMessage(m *gtypes.Message) bool {
+	if m.Message == nil || m.IsService || m.Out {
+		return false
+	}
+	switch m.Media.(type) {
+	case *tg.MessageMediaGeo, *tg.MessageMediaGeoLive, *tg.MessageMediaVenue:
+		return true
+	case nil, *tg.MessageMediaEmpty, *tg.MessageMediaWebPage:
+		return m.Text != "" && !strings.HasPrefix(m.Text, "/")
+	}
+	return false
+}
+
+// handleBridgeCommand turns bridging of text messages and locations to the player on or off.
+func (b *TelegramBot) handleBridgeCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	settings, err := b.userRepository.GetUserSettings(user.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to load your settings.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		return b.sendReply(ctx, u, fmt.Sprintf("Text bridging is %s. Usage: /bridge_text on|off", onOff(settings.BridgeText)))
+	}
+	switch strings.ToLower(args[1]) {
+	case "on":
+		settings.BridgeText = true
+	case "off":
+		settings.BridgeText = false
+	default:
+		return b.sendReply(ctx, u, "Usage: /bridge_text on|off")
+	}
+
+	if err := b.userRepository.StoreUserSettings(settings); err != nil {
+		b.logger.Printf("Failed to store settings for user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to update your settings.")
+	}
+	if settings.BridgeText {
+		return b.sendReply(ctx, u, "Text bridging is on. Text messages, links and locations you send are shown next to the player.")
+	}
+	return b.sendReply(ctx, u, "Text bridging is off.")
+}
+
+// handleBridgeMessage shows a text message or location in the player of a user who turned text
+// bridging on. Live locations are sent again on every update and replace the earlier position.
+func (b *TelegramBot) handleBridgeMessage(ctx *ext.Context, u *ext.Update) error {
+	chatID := u.EffectiveChat().GetID()
+	if !b.isUserChat(ctx, chatID) {
+		return nil
+	}
+
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return nil
+	}
+	settings, err := b.userRepository.GetUserSettings(user.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", user.UserID, err)
+		return nil
+	}
+	if !settings.BridgeText {
+		return nil
+	}
+
+	m := u.EffectiveMessage
+	msg := map[string]string{
+		"messageId": strconv.Itoa(m.ID),
+		"date":      strconv.Itoa(m.Date),
+		"from":      u.EffectiveUser().FirstName,
+	}
+	if m.EditDate != 0 {
+		msg["edited"] = "true"
+	}
+
+	switch media := m.Media.(type) {
+	case *tg.MessageMediaGeo:
+		if !addLocation(msg, media.Geo) {
+			return nil
+		}
+	case *tg.MessageMediaGeoLive:
+		if !addLocation(msg, media.Geo) {
+			return nil
+		}
+		msg["live"] = "true"
+		msg["period"] = strconv.Itoa(media.Period)
+	case *tg.MessageMediaVenue:
+		if !addLocation(msg, media.Geo) {
+			return nil
+		}
+		setIfNotEmpty(msg, "title", media.Title)
+		setIfNotEmpty(msg, "address", media.Address)
+	default:
+		msg["type"] = wsMessageTypeText
+		msg["text"] = m.Text
+		if links := messageLinks(m.Text, m.Entities); len(links) > 0 {
+			encoded, err := json.Marshal(links)
+			if err != nil {
+				b.logger.Printf("Error encoding links of message ID %d: %v", m.ID, err)
+			} else {
+				msg["links"] = string(encoded)
+			}
+		}
+	}
+
+	b.publishToWebSocket(chatID, msg)
+	return nil
+}
+
+// addLocation adds the coordinates of a point and a link to it on a map to a message.
+func addLocation(msg map[string]string, geo tg.GeoPointClass) bool {
+	point, ok := geo.(*tg.GeoPoint)
+	if !ok {
+		return false
+	}
+	lat := strconv.FormatFloat(point.Lat, 'f', 6, 64)
+	long := strconv.FormatFloat(point.Long, 'f', 6, 64)
+	msg["type"] = wsMessageTypeLocation
+	msg["latitude"] = lat
+	msg["longitude"] = long
+	msg["mapUrl"] = fmt.Sprintf("https://www.openstreetmap.org/?mlat=%s&mlon=%s#map=16/%s/%s", lat, long, lat, long)
+	return true
+}
+
+// messageLinks returns the URLs in a message, both the ones written out and the ones behind
+// formatted text. Entity offsets count UTF-16 code units.
+func messageLinks(text string, entities []tg.MessageEntityClass) []string {
+	var units []uint16
+	var links []string
+	for _, entity := range entities {
+		switch e := entity.(type) {
+		case *tg.MessageEntityTextURL:
+			links = append(links, e.URL)
+		case *tg.MessageEntityURL:
+			if units == nil {
+				units = utf16.Encode([]rune(text))
+			}
+			if e.Offset >= 0 && e.Length > 0 && e.Offset+e.Length <= len(units) {
+				links = append(links, string(utf16.Decode(units[e.Offset:e.Offset+e.Length])))
+			}
+		}
+	}
+	return links
+}
@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+const cacheCompactCheckInterval = time.Hour
+
+// cacheCompactMu keeps the background routine and /cachecompact from compacting at once.
+var cacheCompactMu sync.Mutex
+
+// runCacheCompaction compacts the binary cache whenever its fragmentation exceeds the configured
+// threshold, until ctx is cancelled.
+func (b *TelegramBot) runCacheCompaction(ctx context.Context) {
+	ticker := time.NewTicker(cacheCompactCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		stats, err := b.config.BinaryCache.GetStats()
+		if err != nil {
+			b.logger.Printf("Failed to read cache statistics: %v", err)
+			continue
+		}
+		if stats.Fragmentation <= b.config.CacheCompactThreshold {
+			continue
+		}
+		b.logger.Printf("Cache files are %.1f%% fragmented, compacting", stats.Fragmentation*100)
+		if _, err := b.compactCache(); err != nil {
+			b.logger.Printf("Cache compaction failed: %v", err)
+		}
+	}
+}
+
+// compactCache compacts the binary cache and logs how much space was reclaimed.
+func (b *TelegramBot) compactCache() (int64, error) {
+	cacheCompactMu.Lock()
+	defer cacheCompactMu.Unlock()
+
+	start := time.Now()
+	reclaimed, err := b.config.BinaryCache.Compact()
+	if err != nil {
+		return 0, err
+	}
+	b.logger.Printf("Compacted cache in %s, reclaiming %s", time.Since(start).Round(time.Millisecond), formatBytes(reclaimed))
+	return reclaimed, nil
+}
+
+// handleCacheCompactCommand lets admins compact the binary cache on demand.
+func (b *TelegramBot) handleCacheCompactCommand(ctx *ext.Context, u *ext.Update) error {
+	adminID := u.EffectiveUser().ID
+	userInfo, err := b.userRepository.GetUserInfo(adminID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to compact the cache.")
+	}
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	reclaimed, err := b.compactCache()
+	if err != nil {
+		b.logger.Printf("Cache compaction failed: %v", err)
+		return b.sendReply(ctx, u, "Failed to compact the cache.")
+	}
+	if reclaimed == 0 {
+		return b.sendReply(ctx, u, "The cache is already compact.")
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("Cache compacted, %s reclaimed.", formatBytes(reclaimed)))
+}
@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// Admins are alerted once the cache metadata fails to save this many times in a row, so a
+// single transient error does not page anyone.
+const cacheSaveAlertThreshold = 3
+
+// handleCacheSaveError is called by the BinaryCache whenever its metadata cannot be written.
+// Admins are alerted once per run of failures; a successful save re-arms the alert.
+func (b *TelegramBot) handleCacheSaveError(err error, failures int) {
+	if failures != cacheSaveAlertThreshold {
+		return
+	}
+	go b.notifyAdmins(fmt.Sprintf("The cache metadata could not be saved %d times in a row, so cached media may be lost on restart. Check the free space and permissions of %s.\nLast error: %v", failures, b.config.CacheDirectory, err))
+}
+
+// notifyAdmins sends a message to every admin.
+func (b *TelegramBot) notifyAdmins(text string) {
+	admins, err := b.userRepository.GetAllAdmins()
+	if err != nil {
+		b.logger.Printf("Failed to retrieve admin list: %v", err)
+		return
+	}
+	for _, admin := range admins {
+		_, err := b.tgCtx.SendMessage(admin.ChatID, &tg.MessagesSendMessageRequest{Message: text})
+		if err != nil {
+			b.logger.Printf("Failed to notify admin %d: %v", admin.UserID, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+// handleCacheStatsCommand sends admins the usage and fragmentation of the binary cache.
+func (b *TelegramBot) handleCacheStatsCommand(ctx *ext.Context, u *ext.Update) error {
+	adminID := u.EffectiveUser().ID
+	userInfo, err := b.userRepository.GetUserInfo(adminID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to read the cache statistics.")
+	}
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	stats, err := b.config.BinaryCache.GetStats()
+	if err != nil {
+		b.logger.Printf("Failed to read cache statistics: %v", err)
+		return b.sendReply(ctx, u, "Failed to read the cache statistics.")
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Cache usage: %s of %s", formatBytes(stats.Size), formatBytes(stats.MaxSize))
+	if stats.MaxSize > 0 {
+		fmt.Fprintf(&sb, " (%.1f%%)", float64(stats.Size)*100/float64(stats.MaxSize))
+	}
+	fmt.Fprintf(&sb, "\nCached: %d chunks of %d files", stats.Chunks, stats.Locations)
+	if stats.Compressed > 0 {
+		fmt.Fprintf(&sb, ", %d of them compressed", stats.Compressed)
+	}
+	if stats.HotSize > 0 {
+		fmt.Fprintf(&sb, "\nStreaming (hot tier): %s", formatBytes(stats.HotSize))
+	}
+	sb.WriteString("\n")
+	fmt.Fprintf(&sb, "Cache files: %s", formatBytes(stats.FileSize))
+	if stats.Segments > 1 {
+		fmt.Fprintf(&sb, " in %d segments", stats.Segments)
+	}
+	fmt.Fprintf(&sb, ", %.1f%% fragmented, %d free parts\n", stats.Fragmentation*100, stats.FreeParts)
+	fmt.Fprintf(&sb, "Since start: %d hits, %d misses", stats.Hits, stats.Misses)
+	if stats.Hits+stats.Misses > 0 {
+		fmt.Fprintf(&sb, " (%.1f%% hit rate)", float64(stats.Hits)*100/float64(stats.Hits+stats.Misses))
+	}
+	fmt.Fprintf(&sb, ", %d evictions\n", stats.Evictions)
+	if stats.Corrupted > 0 {
+		fmt.Fprintf(&sb, "Corrupted chunks dropped: %d\n", stats.Corrupted)
+	}
+	if duplicates, err := b.fingerprintRepository.Duplicates(); err == nil && duplicates > 0 {
+		fmt.Fprintf(&sb, "Re-uploaded files sharing cached data: %d\n", duplicates)
+	}
+	budget := reader.RequestBudget().Stats()
+	fmt.Fprintf(&sb, "Telegram requests (%d/s, burst %d): %d first chunks, waited %s on average, %s at most; %d prefetched, waited %s on average, %s at most\n",
+		budget.RequestsPerSecond, budget.Burst,
+		budget.InteractiveRequests, averageWait(budget.InteractiveWait, budget.InteractiveRequests), budget.InteractiveMaxWait.Round(time.Millisecond),
+		budget.PrefetchRequests, averageWait(budget.PrefetchWait, budget.PrefetchRequests), budget.PrefetchMaxWait.Round(time.Millisecond))
+	fmt.Fprintf(&sb, "\nAPI: %s/api/cache-stats/%d?token=%s", b.config.BaseURL, adminID, b.statsToken(adminID))
+	fmt.Fprintf(&sb, "\nDashboard: %s/admin/stats/%d?token=%s", b.config.BaseURL, adminID, b.statsToken(adminID))
+
+	return b.sendReply(ctx, u, sb.String())
+}
+
+// averageWait returns the average of total over n requests, rounded for display.
+func averageWait(total time.Duration, n int64) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return (total / time.Duration(n)).Round(time.Millisecond)
+}
+
+// handleCacheStatsAPI returns the cache statistics as JSON for dashboards. It requires the stats
+// token of an admin.
+func (b *TelegramBot) handleCacheStatsAPI(w http.ResponseWriter, r *http.Request) {
+	stats, err := b.config.BinaryCache.GetStats()
+	if err != nil {
+		b.logger.Printf("Failed to read cache statistics: %v", err)
+		web.Error(w, "Failed to read cache statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		b.logger.Printf("Error encoding cache statistics: %v", err)
+	}
+}
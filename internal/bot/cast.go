@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const callbackCast = "cb_Cast"
+
+// handleCastCommand looks for renderers on the local network and offers a button for each to
+// play the replied-to media, or the media most recently played in the user's player.
+func (b *TelegramBot) handleCastCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+	if b.caster == nil {
+		return b.sendReply(ctx, u, "Casting is not enabled on this server.")
+	}
+
+	messageID, isReply := replyToMessageID(u)
+	if !isReply {
+		recent, err := b.mediaRepository.ListRecent(user.UserID, 1, 0)
+		if err != nil {
+			b.logger.Printf("Failed to look up the current media of user %d: %v", user.UserID, err)
+			return b.sendReply(ctx, u, "Failed to find the media to cast.")
+		}
+		if len(recent) == 0 {
+			return b.sendReply(ctx, u, "Nothing has been played yet. Reply to a media message with /cast to cast it.")
+		}
+		messageID = recent[0].MessageID
+	}
+
+	renderers, err := b.caster.Discover(ctx)
+	if err != nil {
+		b.logger.Printf("Renderer discovery failed: %v", err)
+		return b.sendReply(ctx, u, "Failed to search the network for TVs and speakers.")
+	}
+	if len(renderers) == 0 {
+		return b.sendReply(ctx, u, "No DLNA renderers were found on the local network.")
+	}
+
+	markup := &tg.ReplyInlineMarkup{}
+	for _, renderer := range renderers {
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{
+			Buttons: []tg.KeyboardButtonClass{
+				&tg.KeyboardButtonCallback{
+					Text: renderer.Name,
+					Data: []byte(fmt.Sprintf("%s,%s,%d", callbackCast, renderer.ID, messageID)),
+				},
+			},
+		})
+	}
+	_, err = ctx.Reply(u, "Choose where to play the media:", &ext.ReplyOpts{Markup: markup})
+	if err != nil {
+		b.logger.Printf("Failed to send renderer list to user %d: %v", user.UserID, err)
+	}
+	return err
+}
+
+// handleCastCallback sends the stream URL of a media message to the chosen renderer.
+func (b *TelegramBot) handleCastCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 || b.caster == nil {
+		return nil
+	}
+	messageID, err := strconv.Atoi(dataParts[2])
+	if err != nil {
+		return err
+	}
+
+	answer := func(text string) error {
+		_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			Alert:   true,
+			QueryID: u.CallbackQuery.QueryID,
+			Message: text,
+		})
+		return nil
+	}
+
+	renderer, ok := b.caster.Renderer(dataParts[1])
+	if !ok {
+		return answer("This device is no longer known. Run /cast again.")
+	}
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return answer("The media could not be found.")
+	}
+
+	if err := b.caster.Play(ctx, renderer, b.generateFileURL(messageID, file), file.FileName, file.MimeType); err != nil {
+		b.logger.Printf("Failed to cast message ID %d to %s: %v", messageID, renderer.Name, err)
+		return answer(fmt.Sprintf("%s did not accept the media.", renderer.Name))
+	}
+	b.recordPlayed(u.CallbackQuery.UserID, messageID)
+	return answer(fmt.Sprintf("Playing %s on %s.", file.FileName, renderer.Name))
+}
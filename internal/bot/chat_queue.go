@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"sync"
+
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+)
+
+// chatQueue serializes the processing of updates per chat, so that replies to a chat are sent in
+// the order its messages arrived, even while a slow media message is still being processed.
+type chatQueue struct {
+	mu sync.Mutex
+	// chats holds the updates waiting for each busy chat, in the order they arrived. A chat is
+	// busy while it has an entry, even an empty one.
+	chats map[int64][]chan struct{}
+}
+
+func newChatQueue() *chatQueue {
+	return &chatQueue{chats: make(map[int64][]chan struct{})}
+}
+
+// acquire blocks until the updates of the chat that arrived earlier are handled and returns a
+// function that releases the chat. Unlike a mutex, which wakes its waiters in any order, the chat
+// is handed to its waiters first in, first out.
+func (q *chatQueue) acquire(chatID int64) func() {
+	q.mu.Lock()
+	waiters, busy := q.chats[chatID]
+	if !busy {
+		q.chats[chatID] = nil
+		q.mu.Unlock()
+		return func() { q.release(chatID) }
+	}
+	turn := make(chan struct{})
+	q.chats[chatID] = append(waiters, turn)
+	q.mu.Unlock()
+
+	<-turn
+	return func() { q.release(chatID) }
+}
+
+// release hands the chat to the update that has waited longest, or frees it if none waits.
+func (q *chatQueue) release(chatID int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.chats[chatID]
+	if len(waiters) == 0 {
+		delete(q.chats, chatID)
+		return
+	}
+	q.chats[chatID] = waiters[1:]
+	close(waiters[0])
+}
+
+// sequenced wraps a handler so that updates of the same chat are handled one at a time, in order.
+func (b *TelegramBot) sequenced(handler handlers.CallbackResponse) handlers.CallbackResponse {
+	return func(ctx *ext.Context, u *ext.Update) error {
+		chat := u.EffectiveChat()
+		if chat == nil {
+			return handler(ctx, u)
+		}
+		release := b.chatQueue.acquire(chat.GetID())
+		defer release()
+		return handler(ctx, u)
+	}
+}
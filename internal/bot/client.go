@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"webBridgeBot/internal/config"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/sessionMaker"
+	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/telegram"
+)
+
+// newTelegramClient connects to Telegram as the bot, or in user mode as the user account. The
+// session is kept in cfg.SessionPath and locked by the returned guard until it is released. With
+// a nil conversator a user session must already exist, since the server cannot ask for a login
+// code.
+func newTelegramClient(cfg *config.Configuration, conversator gotgproto.AuthConversator) (*gotgproto.Client, *sessionGuard, error) {
+	clientType := gotgproto.ClientTypeBot(cfg.BotToken)
+	if cfg.ClientType == config.ClientTypeUser {
+		clientType = gotgproto.ClientTypePhone(cfg.PhoneNumber)
+	}
+
+	guard, err := newSessionGuard(sessionFile(cfg, cfg.SessionPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := gotgproto.NewClient(cfg.ApiID, cfg.ApiHash, clientType,
+		clientOpts(cfg, guard, conversator, cfg.ClientType == config.ClientTypeUser && conversator == nil))
+	if err != nil {
+		guard.release()
+		if cfg.ClientType == config.ClientTypeUser && conversator == nil {
+			if guard.stored {
+				return nil, nil, fmt.Errorf("Telegram no longer accepts the user session in %s, which may have been terminated from another device; run 'webBridgeBot login' again: %w", cfg.SessionPath, err)
+			}
+			return nil, nil, fmt.Errorf("failed to start user session from %s, run 'webBridgeBot login' first: %w", cfg.SessionPath, err)
+		}
+		return nil, nil, fmt.Errorf("failed to initialize Telegram client: %w", err)
+	}
+	if err := guard.restrict(); err != nil {
+		client.Stop()
+		guard.release()
+		return nil, nil, fmt.Errorf("failed to restrict access to session %s: %w", cfg.SessionPath, err)
+	}
+	return client, guard, nil
+}
+
+// newWorkerClient connects to Telegram as a worker bot. Each worker keeps its session in a file
+// of its own in the session directory, named after the ID of the bot.
+func newWorkerClient(cfg *config.Configuration, token string) (*gotgproto.Client, *sessionGuard, error) {
+	botID, _, _ := strings.Cut(token, ":")
+	guard, err := newSessionGuard(sessionFile(cfg, filepath.Join(cfg.SessionDirectory, "workers", botID+".session")))
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := gotgproto.NewClient(cfg.ApiID, cfg.ApiHash, gotgproto.ClientTypeBot(token), clientOpts(cfg, guard, nil, false))
+	if err != nil {
+		guard.release()
+		return nil, nil, fmt.Errorf("failed to start worker bot %s: %w", botID, err)
+	}
+	if err := guard.restrict(); err != nil {
+		client.Stop()
+		guard.release()
+		return nil, nil, fmt.Errorf("failed to restrict access to the session of worker bot %s: %w", botID, err)
+	}
+	return client, guard, nil
+}
+
+// sessionFile returns the file a session is kept in, or an empty path if sessions are kept in
+// memory.
+func sessionFile(cfg *config.Configuration, path string) string {
+	if cfg.SessionMode == config.SessionModeMemory {
+		return ""
+	}
+	return path
+}
+
+// clientOpts returns the options of a client whose session is kept in the file of guard, or in
+// memory if it has none.
+func clientOpts(cfg *config.Configuration, guard *sessionGuard, conversator gotgproto.AuthConversator, noAutoAuth bool) *gotgproto.ClientOpts {
+	opts := &gotgproto.ClientOpts{
+		InMemory:         guard.path == "",
+		Session:          sessionMaker.SimpleSession(),
+		DisableCopyright: true,
+		AuthConversator:  conversator,
+		NoAutoAuth:       noAutoAuth,
+		Middlewares:      []telegram.Middleware{guard.middleware()},
+	}
+	if guard.path != "" {
+		opts.Session = sessionMaker.SqlSession(sqlite.Open(fmt.Sprintf("file:%s?mode=rwc", guard.path)))
+	}
+	return opts
+}
+
+// Login runs the interactive phone login for user mode and saves the session for later runs.
+func Login(cfg *config.Configuration, logger *log.Logger) error {
+	if cfg.ClientType != config.ClientTypeUser {
+		return errors.New("login is only needed with CLIENT_TYPE=user")
+	}
+	if cfg.ApiID == 0 || cfg.ApiHash == "" {
+		return errors.New("API_ID and API_HASH are required")
+	}
+
+	// The basic conversator asks for the phone number, code and 2FA password on the terminal.
+	client, guard, err := newTelegramClient(cfg, gotgproto.BasicConversator())
+	if err != nil {
+		return err
+	}
+	defer guard.release()
+	defer client.Stop()
+
+	logger.Printf("Logged in as %s %s (@%s), session saved to %s", client.Self.FirstName, client.Self.LastName, client.Self.Username, cfg.SessionPath)
+	return nil
+}
@@ -0,0 +1,28 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"webBridgeBot/internal/types"
+)
+
+// fileETag returns a strong entity tag for a file. Telegram documents are immutable, so the
+// document ID and size identify the content.
+func fileETag(file *types.DocumentFile) string {
+	return fmt.Sprintf(`"%d-%d"`, file.ID, file.FileSize)
+}
+
+// etagMatches reports whether an If-None-Match header lists the given entity tag. Weak tags are
+// compared by their opaque value, as required for If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
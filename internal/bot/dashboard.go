@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/web"
+)
+
+const (
+	dashboardTmplPath = "templates/stats.html"
+
+	// How often the dashboard is sent fresh statistics.
+	dashboardRefreshInterval = 2 * time.Second
+	// Requests for the same media from the same client within this window count as reconnects.
+	reconnectWindow = 10 * time.Second
+)
+
+// dashboardSnapshot is what the stats dashboard shows.
+type dashboardSnapshot struct {
+	Time        time.Time           `json:"time"`
+	Connections web.ConnectionStats `json:"connections"`
+	Cache       *reader.CacheStats  `json:"cache,omitempty"`
+	Requests    reader.BudgetStats  `json:"telegramRequests"`
+}
+
+func (b *TelegramBot) dashboardSnapshot() dashboardSnapshot {
+	snapshot := dashboardSnapshot{
+		Time:        time.Now().UTC(),
+		Connections: b.connections.Stats(),
+		Requests:    reader.RequestBudget().Stats(),
+	}
+	if stats, err := b.config.BinaryCache.GetStats(); err != nil {
+		b.logger.Printf("Failed to read cache statistics: %v", err)
+	} else {
+		snapshot.Cache = &stats
+	}
+	return snapshot
+}
+
+// handleDashboard renders the admin stats dashboard. It requires the stats token of an admin.
+func (b *TelegramBot) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles(dashboardTmplPath)
+	if err != nil {
+		b.logger.Printf("Error loading template: %v", err)
+		web.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := t.Execute(w, map[string]interface{}{
+		"BasePath":        b.basePath(),
+		"RefreshInterval": dashboardRefreshInterval.Milliseconds(),
+	}); err != nil {
+		b.logger.Printf("Error rendering template: %v", err)
+		web.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// handleDashboardWebSocket pushes a snapshot of the connections and the cache to the dashboard
+// until it disconnects.
+func (b *TelegramBot) handleDashboardWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		b.logger.Printf("Dashboard WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	// The dashboard sends nothing; reading only notices when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(dashboardRefreshInterval)
+	defer ticker.Stop()
+	for {
+		_ = ws.SetWriteDeadline(time.Now().Add(dashboardRefreshInterval))
+		if err := ws.WriteJSON(b.dashboardSnapshot()); err != nil {
+			return
+		}
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleConnectionsAPI returns the tracked stream connections as JSON. It requires the stats
+// token of an admin.
+func (b *TelegramBot) handleConnectionsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.connections.Stats()); err != nil {
+		b.logger.Printf("Error encoding connection statistics: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+
+	"github.com/gotd/td/tg"
+)
+
+// fingerprintTimeout bounds the Telegram requests that fingerprint a new file. Files too large to
+// be hashed in time keep their own document.
+const fingerprintTimeout = 30 * time.Second
+
+// indexDocument fingerprints the content of a file the first time its document is seen, so a
+// file uploaded again, which Telegram stores as a new document, shares the cached data and URL
+// hash of the first upload. It is called before the URLs of new media are generated; files that
+// cannot be fingerprinted keep their own document.
+func (b *TelegramBot) indexDocument(file *types.DocumentFile) {
+	if _, ok, err := b.fingerprintRepository.Canonical(file.ID); err != nil || ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fingerprintTimeout)
+	defer cancel()
+	fingerprint, err := reader.Fingerprint(ctx, b.tgClient.API(), file.Location, file.FileSize)
+	if err != nil {
+		b.logger.Printf("Failed to fingerprint document %d: %v", file.ID, err)
+		return
+	}
+	canonicalID, err := b.fingerprintRepository.Record(file.ID, fingerprint, file.FileSize)
+	if err != nil {
+		b.logger.Printf("Failed to index document %d: %v", file.ID, err)
+		return
+	}
+	if canonicalID != file.ID {
+		b.logger.Printf("Document %d has the same content as document %d and shares its cached data", file.ID, canonicalID)
+	}
+}
+
+// canonicalDocument returns the ID of the document whose cached data and URL hash a document
+// shares, which is the document itself unless the same file was indexed under another one first.
+func (b *TelegramBot) canonicalDocument(documentID int64) int64 {
+	if canonicalID, ok := b.canonicalDocuments.Load(documentID); ok {
+		return canonicalID.(int64)
+	}
+	canonicalID, ok, err := b.fingerprintRepository.Canonical(documentID)
+	if err != nil {
+		b.logger.Printf("Failed to look up the canonical document of %d: %v", documentID, err)
+		return documentID
+	}
+	if !ok {
+		return documentID
+	}
+	// Indexed documents never change their canonical document, so it can be kept.
+	b.canonicalDocuments.Store(documentID, canonicalID)
+	return canonicalID
+}
+
+// readerOptions returns the options to stream a file of a message with, caching its chunks
+// under its canonical document and refreshing its file reference from the message.
+func (b *TelegramBot) readerOptions(messageID int, file *types.DocumentFile) reader.Options {
+	opts := b.config.ReaderOptions()
+	opts.CacheKey = b.canonicalDocument(file.ID)
+	opts.Refresh = func(ctx context.Context) (*tg.InputDocumentFileLocation, error) {
+		refreshed, err := utils.RefreshFileFromMessage(ctx, b.tgClient, messageID)
+		if err != nil {
+			return nil, err
+		}
+		// The file may be one of the alternative qualities of the message's file.
+		for i := 0; i <= len(refreshed.Alternatives); i++ {
+			if variant, _ := refreshed.Variant(i); variant.ID == file.ID {
+				return variant.Location, nil
+			}
+		}
+		return nil, fmt.Errorf("message ID %d no longer contains document %d", messageID, file.ID)
+	}
+	return opts
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package bot
+
+import "errors"
+
+// freeDiskSpace is not supported on this platform, so the disk space watchdog stops.
+func freeDiskSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
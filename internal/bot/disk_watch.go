@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const diskWatchInterval = time.Minute
+
+// runDiskWatchdog keeps the free space on the disk holding the cache above MinFreeDisk, checking
+// it every minute until ctx is cancelled.
+func (b *TelegramBot) runDiskWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(diskWatchInterval)
+	defer ticker.Stop()
+
+	low := false
+	for {
+		if err := b.checkDiskSpace(&low); err != nil {
+			b.logger.Printf("Disk space check failed: %v", err)
+			if errors.Is(err, errors.ErrUnsupported) {
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDiskSpace limits the cache to the space its files may take without the free disk space
+// dropping below MinFreeDisk, up to MaxCacheSize. While the disk is low, the cache is shrunk and
+// compacted to give space back, and admins are alerted once; low tracks whether they were.
+func (b *TelegramBot) checkDiskSpace(low *bool) error {
+	free, err := freeDiskSpace(b.config.CacheDirectory)
+	if err != nil {
+		return err
+	}
+	stats, err := b.config.BinaryCache.GetStats()
+	if err != nil {
+		return err
+	}
+
+	limit := min(b.config.MaxCacheSize, max(stats.FileSize+free-b.config.MinFreeDisk, 0))
+	if limit != stats.MaxSize {
+		evicted, err := b.config.BinaryCache.SetMaxSize(limit)
+		if err != nil {
+			return err
+		}
+		if evicted > 0 {
+			b.logger.Printf("Free disk space is %s, limited the cache to %s and evicted %d chunks", formatBytes(free), formatBytes(limit), evicted)
+		}
+	}
+
+	if free >= b.config.MinFreeDisk {
+		if *low {
+			b.logger.Printf("Free disk space is back to %s", formatBytes(free))
+			*low = false
+		}
+		return nil
+	}
+	// Compact only if the cache files hold space that is not used by the cache after evicting.
+	var reclaimed int64
+	if stats.FileSize > limit || stats.FileSize > stats.Size {
+		if reclaimed, err = b.compactCache(); err != nil {
+			return err
+		}
+	}
+	if !*low {
+		*low = true
+		go b.notifyAdmins(fmt.Sprintf("Free disk space in %s is down to %s, below the %s kept free. The cache was limited to %s and compacted, giving back %s. It grows back up to %s once there is space again.",
+			b.config.CacheDirectory, formatBytes(free), formatBytes(b.config.MinFreeDisk), formatBytes(limit), formatBytes(reclaimed), formatBytes(b.config.MaxCacheSize)))
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/reader"
+
+	"github.com/celestix/gotgproto"
+)
+
+// DiagnosticStatus is the outcome of a check run by Doctor.
+type DiagnosticStatus int
+
+const (
+	DiagnosticOK DiagnosticStatus = iota
+	DiagnosticWarning
+	DiagnosticFailed
+)
+
+func (s DiagnosticStatus) String() string {
+	switch s {
+	case DiagnosticOK:
+		return "OK"
+	case DiagnosticWarning:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+// Diagnostic is the result of a check run by Doctor.
+type Diagnostic struct {
+	Check  string
+	Status DiagnosticStatus
+	Detail string
+	// Fix tells what to do about a warning or failure.
+	Fix string
+}
+
+// doctorTimeout bounds the checks that go over the network.
+const doctorTimeout = 15 * time.Second
+
+// Doctor checks what the bot needs to run: the configuration, the Telegram credentials, the
+// database, the cache directory, the session files, the port and whether BASE_URL reaches it. It
+// is meant to be run while the bot is stopped. Otherwise the port is reported as taken, BASE_URL
+// is checked against the running bot, and the credentials are not checked since the session is
+// locked.
+func Doctor(ctx context.Context, cfg *config.Configuration) []Diagnostic {
+	if err := cfg.Validate(); err != nil {
+		return []Diagnostic{{
+			Check:  "Configuration",
+			Status: DiagnosticFailed,
+			Detail: err.Error(),
+			Fix:    "Correct these settings in the environment or .env, then run the doctor again",
+		}}
+	}
+	diagnostics := []Diagnostic{
+		{Check: "Configuration", Status: DiagnosticOK, Detail: "All settings are valid"},
+		checkTelegram(ctx, cfg),
+		checkDatabase(ctx, cfg),
+		checkCacheDirectory(cfg),
+		checkCacheFormat(cfg),
+		checkSessions(cfg),
+	}
+	if d, ok := checkDiskRoom(cfg); ok {
+		diagnostics = append(diagnostics, d)
+	}
+	port := checkPort(cfg)
+	return append(diagnostics, port, checkBaseURL(ctx, cfg, port.Status == DiagnosticOK))
+}
+
+// startupDiagnostics are the quick local checks run as the bot starts. Loading the configuration
+// already checked the settings, the credentials, the database and the cache format.
+func startupDiagnostics(cfg *config.Configuration) []Diagnostic {
+	diagnostics := []Diagnostic{checkCacheDirectory(cfg)}
+	if d, ok := checkDiskRoom(cfg); ok {
+		diagnostics = append(diagnostics, d)
+	}
+	return append(diagnostics, checkPort(cfg))
+}
+
+// logStartupDiagnostics logs the problems found by startupDiagnostics. The bot starts anyway.
+func (b *TelegramBot) logStartupDiagnostics() {
+	for _, d := range startupDiagnostics(b.config) {
+		if d.Status != DiagnosticOK {
+			b.logger.Printf("Startup check %s: %s: %s. %s", d.Status, d.Check, d.Detail, d.Fix)
+		}
+	}
+}
+
+func checkTelegram(ctx context.Context, cfg *config.Configuration) Diagnostic {
+	d := Diagnostic{Check: "Telegram credentials"}
+	type result struct {
+		client *gotgproto.Client
+		guard  *sessionGuard
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client, guard, err := newTelegramClient(cfg, nil)
+		done <- result{client, guard, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	select {
+	case r := <-done:
+		if errors.Is(r.err, errSessionInUse) {
+			// Connecting with the session of a running instance would get it revoked.
+			d.Status, d.Detail = DiagnosticWarning, "The session is in use by the running bot, so it was not checked"
+			d.Fix = "Stop the bot to check the credentials"
+			return d
+		}
+		if r.err != nil {
+			d.Status, d.Detail = DiagnosticFailed, r.err.Error()
+			d.Fix = "Check API_ID and API_HASH at https://my.telegram.org and BOT_TOKEN with @BotFather"
+			if cfg.ClientType == config.ClientTypeUser {
+				d.Fix = "Check API_ID and API_HASH at https://my.telegram.org and run 'webBridgeBot login'"
+			}
+			return d
+		}
+		defer r.guard.release()
+		defer r.client.Stop()
+		d.Detail = fmt.Sprintf("Logged in as @%s (ID %d)", r.client.Self.Username, r.client.Self.ID)
+	case <-ctx.Done():
+		d.Status, d.Detail = DiagnosticFailed, "Timed out connecting to Telegram"
+		d.Fix = "Check that this machine can reach the Telegram servers"
+	}
+	return d
+}
+
+func checkDatabase(ctx context.Context, cfg *config.Configuration) Diagnostic {
+	d := Diagnostic{Check: "Database"}
+	db, err := data.Open(cfg.DBDriver, cfg.DBDSN)
+	if err != nil {
+		d.Status, d.Detail, d.Fix = DiagnosticFailed, err.Error(), "Check DB_DRIVER and DB_DSN, and that the database server is running"
+		return d
+	}
+	defer db.Close()
+
+	if err := db.CheckIntegrity(ctx); err != nil {
+		d.Status, d.Detail = DiagnosticFailed, err.Error()
+		d.Fix = "Restore the database from a backup; for SQLite, the .recover command of the sqlite3 shell saves what is left"
+		return d
+	}
+	migrator := data.NewMigrator(db)
+	version, err := migrator.CurrentVersion()
+	if err != nil {
+		d.Status, d.Detail, d.Fix = DiagnosticFailed, err.Error(), "Check that the database user may create and read tables"
+		return d
+	}
+	switch latest := migrator.LatestVersion(); {
+	case version > latest:
+		d.Status = DiagnosticFailed
+		d.Detail = fmt.Sprintf("Schema is at version %d, newer than version %d of this release", version, latest)
+		d.Fix = fmt.Sprintf("Run the newer release, or use it to revert with 'webBridgeBot db migrate --to %d'", latest)
+	case version < latest:
+		d.Detail = fmt.Sprintf("%s database is intact; %d migrations will be applied at startup", cfg.DBDriver, latest-version)
+	default:
+		d.Detail = fmt.Sprintf("%s database is intact and up to date", cfg.DBDriver)
+	}
+	return d
+}
+
+// checkCacheDirectory checks that cache files can be created in the cache directory.
+func checkCacheDirectory(cfg *config.Configuration) Diagnostic {
+	d := Diagnostic{Check: "Cache directory"}
+	err := os.MkdirAll(cfg.CacheDirectory, 0755)
+	if err == nil {
+		var f *os.File
+		if f, err = os.CreateTemp(cfg.CacheDirectory, ".doctor-*"); err == nil {
+			_, err = f.Write([]byte("ok"))
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("Cannot write to %s: %v", cfg.CacheDirectory, err)
+		d.Fix = fmt.Sprintf("Make it writable by the user running the bot (uid %d), or set CACHE_DIRECTORY to a writable directory", os.Getuid())
+		return d
+	}
+	d.Detail = fmt.Sprintf("%s is writable", cfg.CacheDirectory)
+	return d
+}
+
+// checkSessions checks that only the user running the bot can read the session files. The bot
+// restricts them when it starts, so this finds files copied or created by hand since.
+func checkSessions(cfg *config.Configuration) Diagnostic {
+	d := Diagnostic{Check: "Sessions"}
+	if cfg.SessionMode == config.SessionModeMemory {
+		d.Detail = "Sessions are kept in memory and created on every start"
+		return d
+	}
+	workers, _ := filepath.Glob(filepath.Join(cfg.SessionDirectory, "workers", "*.session"))
+	var found int
+	var exposed []string
+	for _, file := range append([]string{cfg.SessionPath}, workers...) {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		found++
+		if info.Mode().Perm()&0o077 != 0 {
+			exposed = append(exposed, file)
+		}
+	}
+	if len(exposed) > 0 {
+		d.Status, d.Detail = DiagnosticWarning, fmt.Sprintf("Other users can read %s", strings.Join(exposed, ", "))
+		d.Fix = "Run 'chmod 600' on them, or start the bot once to do so"
+		return d
+	}
+	d.Detail = fmt.Sprintf("%d session files, readable by their owner only", found)
+	return d
+}
+
+// checkCacheFormat checks that the cache on disk can be opened with CHUNK_SIZE and CACHE_LAYOUT.
+func checkCacheFormat(cfg *config.Configuration) Diagnostic {
+	d := Diagnostic{Check: "Cache format"}
+	if _, err := os.Stat(filepath.Join(cfg.CacheDirectory, "metadata.dat")); errors.Is(err, fs.ErrNotExist) {
+		d.Detail = "No cache yet, an empty one will be created"
+		return d
+	}
+	format, err := reader.ReadCacheFormat(cfg.CacheDirectory, cfg.ChunkSize)
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("Cannot read the cache metadata: %v", err)
+		d.Fix = "Run 'webBridgeBot cache purge' to start with an empty cache"
+		return d
+	}
+	switch {
+	case format.Legacy:
+		d.Status = DiagnosticWarning
+		d.Detail = "The cache was written by an older release"
+		d.Fix = "It is migrated at startup if it used the same CHUNK_SIZE; otherwise run 'webBridgeBot cache migrate --legacy_chunk_size <size>'"
+	case format.ChunkSize != cfg.ChunkSize || format.Layout != cfg.CacheLayout:
+		d.Status = DiagnosticFailed
+		d.Detail = fmt.Sprintf("The cache has %d-byte chunks in the %s layout, but CHUNK_SIZE is %d and CACHE_LAYOUT is %s",
+			format.ChunkSize, format.Layout, cfg.ChunkSize, cfg.CacheLayout)
+		d.Fix = "Run 'webBridgeBot cache migrate'"
+	default:
+		d.Detail = fmt.Sprintf("%d-byte chunks in the %s layout", format.ChunkSize, format.Layout)
+	}
+	return d
+}
+
+// checkDiskRoom checks that the cache may grow to MAX_CACHE_SIZE without going below
+// MIN_FREE_DISK. It returns false if the free space cannot be read on this platform.
+func checkDiskRoom(cfg *config.Configuration) (Diagnostic, bool) {
+	d := Diagnostic{Check: "Disk space"}
+	free, err := freeDiskSpace(cfg.CacheDirectory)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return d, false
+	}
+	if err != nil {
+		d.Status, d.Detail, d.Fix = DiagnosticWarning, fmt.Sprintf("Cannot read the free disk space: %v", err), "Check that CACHE_DIRECTORY exists"
+		return d, true
+	}
+
+	// The cache files already on the disk count towards the room of the cache.
+	var used int64
+	filepath.WalkDir(cfg.CacheDirectory, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				used += info.Size()
+			}
+		}
+		return nil
+	})
+	room := free + used - max(cfg.MinFreeDisk, 0)
+	if room < cfg.MaxCacheSize {
+		d.Status = DiagnosticWarning
+		d.Detail = fmt.Sprintf("%s free, so the cache can only grow to %s of MAX_CACHE_SIZE %s", formatBytes(free), formatBytes(max(room, 0)), formatBytes(cfg.MaxCacheSize))
+		d.Fix = "Free up disk space, or lower MAX_CACHE_SIZE or MIN_FREE_DISK"
+		return d, true
+	}
+	d.Detail = fmt.Sprintf("%s free, enough for MAX_CACHE_SIZE %s", formatBytes(free), formatBytes(cfg.MaxCacheSize))
+	return d, true
+}
+
+func checkPort(cfg *config.Configuration) Diagnostic {
+	d := Diagnostic{Check: "Port"}
+	listener, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("Port %s is not available: %v", cfg.Port, err)
+		d.Fix = "Stop the program using it, such as another instance of the bot, or set PORT to a free port"
+		return d
+	}
+	listener.Close()
+	d.Detail = fmt.Sprintf("Port %s is free", cfg.Port)
+	return d
+}
+
+// checkBaseURL checks that BASE_URL reaches this machine. While the port is free, a temporary
+// server on it answers a random path, which proves that requests to BASE_URL arrive here.
+// Otherwise BASE_URL is expected to reach a running bot.
+func checkBaseURL(ctx context.Context, cfg *config.Configuration, portFree bool) Diagnostic {
+	d := Diagnostic{Check: "BASE_URL"}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	client := &http.Client{}
+	base := strings.TrimSuffix(cfg.BaseURL, "/")
+
+	if !portFree {
+		resp, err := doctorGet(ctx, client, base+"/sw.js")
+		if err != nil {
+			d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("%s cannot be reached: %v", cfg.BaseURL, err)
+			d.Fix = "Check that the host name resolves to this machine and that the firewall and reverse proxy let requests through"
+			return d
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("%s answered %s instead of the bot", cfg.BaseURL, resp.Status)
+			d.Fix = fmt.Sprintf("Point the reverse proxy for %s to port %s of this machine", cfg.BaseURL, cfg.Port)
+			return d
+		}
+		d.Detail = fmt.Sprintf("%s reaches the bot running on port %s", cfg.BaseURL, cfg.Port)
+		return d
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		d.Status, d.Detail = DiagnosticFailed, err.Error()
+		return d
+	}
+	token := hex.EncodeToString(buf)
+	listener, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		d.Status, d.Detail, d.Fix = DiagnosticFailed, err.Error(), "Run the doctor again"
+		return d
+	}
+	// A reverse proxy may strip the path of BASE_URL, so only the last element is compared.
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == token {
+			io.WriteString(w, token)
+			return
+		}
+		http.NotFound(w, r)
+	})}
+	go func() {
+		if cfg.TLSCertFile != "" {
+			server.ServeTLS(listener, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			server.Serve(listener)
+		}
+	}()
+	defer server.Close()
+
+	resp, err := doctorGet(ctx, client, base+"/.doctor/"+token)
+	if err != nil {
+		d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("%s cannot be reached: %v", cfg.BaseURL, err)
+		d.Fix = fmt.Sprintf("Check that the host name resolves to this machine and that the firewall and reverse proxy forward requests to port %s", cfg.Port)
+		return d
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, int64(len(token))))
+	if resp.StatusCode != http.StatusOK || string(body) != token {
+		d.Status, d.Detail = DiagnosticFailed, fmt.Sprintf("%s reaches another server, which answered %s", cfg.BaseURL, resp.Status)
+		d.Fix = fmt.Sprintf("Point the reverse proxy for %s to port %s of this machine", cfg.BaseURL, cfg.Port)
+		return d
+	}
+	d.Detail = fmt.Sprintf("%s reaches port %s of this machine", cfg.BaseURL, cfg.Port)
+	return d
+}
+
+func doctorGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webBridgeBot/internal/types"
+
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	gtypes "github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	wsMessageTypeGallery = "gallery"
+	callbackGallery      = "cb_Gallery"
+
+	// Telegram delivers the messages of an album as separate updates in quick succession. An
+	// album is complete once no new item arrived for this long.
+	albumCollectDelay = 1500 * time.Millisecond
+	// Number of complete albums kept for the pagination buttons.
+	maxAlbums = 200
+)
+
+// galleryItem is an image of an album as sent to the web player.
+type galleryItem struct {
+	MessageID    int    `json:"messageId"`
+	URL          string `json:"url"`
+	FileName     string `json:"fileName"`
+	MimeType     string `json:"mimeType"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+}
+
+// album is the images of a Telegram media group sent to one chat.
+type album struct {
+	chatID int64
+	items  []galleryItem
+	timer  *time.Timer // Fires once the album is complete.
+}
+
+// albumCollector gathers the images of albums until they are complete and then keeps them for
+// paging through them.
+type albumCollector struct {
+	mu       sync.Mutex
+	pending  map[int64]*album // By grouped ID
+	complete map[int64]*album
+	order    []int64 // Grouped IDs of complete albums, oldest first
+}
+
+func newAlbumCollector() *albumCollector {
+	return &albumCollector{pending: make(map[int64]*album), complete: make(map[int64]*album)}
+}
+
+// get returns the items of a complete album.
+func (c *albumCollector) get(groupedID int64) ([]galleryItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.complete[groupedID]
+	if !ok {
+		return nil, false
+	}
+	return a.items, true
+}
+
+// isImageDocument reports whether a message carries an image sent as a file. Compressed photos
+// are not supported, but albums of image files are shown as a gallery.
+func isImageDocument(m *gtypes.Message) bool {
+	doc := filters.GetDocument(m)
+	return doc != nil && strings.HasPrefix(doc.MimeType, "image/")
+}
+
+// addToAlbum adds an image of a media group to its album. The album is sent to the chat and its
+// player as one gallery once it is complete.
+func (b *TelegramBot) addToAlbum(chatID, groupedID int64, messageID int, file *types.DocumentFile) {
+	item := galleryItem{
+		MessageID: messageID,
+		URL:       b.generateFileURL(messageID, file),
+		FileName:  file.FileName,
+		MimeType:  file.MimeType,
+	}
+	if b.hasThumbnail(file) {
+		item.ThumbnailURL = b.generateThumbnailURL(messageID, file)
+	}
+
+	c := b.albums
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.pending[groupedID]
+	if !ok {
+		a = &album{chatID: chatID}
+		a.timer = time.AfterFunc(albumCollectDelay, func() { b.completeAlbum(groupedID) })
+		c.pending[groupedID] = a
+	} else {
+		a.timer.Reset(albumCollectDelay)
+	}
+	a.items = append(a.items, item)
+}
+
+// completeAlbum sends a complete album to the chat with buttons to page through it, and to the
+// player as a gallery.
+func (b *TelegramBot) completeAlbum(groupedID int64) {
+	c := b.albums
+	c.mu.Lock()
+	a, ok := c.pending[groupedID]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, groupedID)
+	// Updates may arrive out of order; the album is ordered as it was sent.
+	sort.Slice(a.items, func(i, j int) bool { return a.items[i].MessageID < a.items[j].MessageID })
+	c.complete[groupedID] = a
+	c.order = append(c.order, groupedID)
+	if len(c.order) > maxAlbums {
+		delete(c.complete, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.mu.Unlock()
+
+	b.logger.Printf("Received an album of %d images in chat ID %d", len(a.items), a.chatID)
+	b.publishGallery(a.chatID, a.items, 0)
+
+	text, markup := galleryPage(groupedID, a.items, 0)
+	_, err := b.tgCtx.SendMessage(a.chatID, &tg.MessagesSendMessageRequest{
+		Message:     text,
+		ReplyMarkup: markup,
+		ReplyTo:     &tg.InputReplyToMessage{ReplyToMsgID: a.items[0].MessageID},
+	})
+	if err != nil {
+		b.logger.Printf("Failed to send album to chat ID %d: %v", a.chatID, err)
+	}
+}
+
+// publishGallery shows the images in the player, starting with the one at index.
+func (b *TelegramBot) publishGallery(chatID int64, items []galleryItem, index int) {
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		b.logger.Printf("Error encoding gallery for chat ID %d: %v", chatID, err)
+		return
+	}
+	b.publishToWebSocket(chatID, map[string]string{
+		"type":  wsMessageTypeGallery,
+		"items": string(encoded),
+		"index": strconv.Itoa(index),
+	})
+}
+
+// galleryPage describes the image at index with buttons to the previous and next image.
+func galleryPage(groupedID int64, items []galleryItem, index int) (string, *tg.ReplyInlineMarkup) {
+	item := items[index]
+	text := fmt.Sprintf("Album, image %d of %d: %s\n%s", index+1, len(items), item.FileName, item.URL)
+	markup := &tg.ReplyInlineMarkup{}
+	// addPageButtons counts pages from 1.
+	addPageButtons(markup, index+1, index+1 < len(items), "« Previous", "Next »", func(page int) string {
+		return fmt.Sprintf("%s,%d,%d", callbackGallery, groupedID, page-1)
+	})
+	markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{
+		Buttons: []tg.KeyboardButtonClass{
+			&tg.KeyboardButtonURL{Text: "Open Image", URL: item.URL},
+		},
+	})
+	return text, markup
+}
+
+// handleGalleryCallback moves the album message and the player to another image.
+func (b *TelegramBot) handleGalleryCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 {
+		return nil
+	}
+	groupedID, err := strconv.ParseInt(dataParts[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	index, err := strconv.Atoi(dataParts[2])
+	if err != nil {
+		return nil
+	}
+
+	items, ok := b.albums.get(groupedID)
+	if !ok || index < 0 || index >= len(items) {
+		_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			Alert:   true,
+			QueryID: u.CallbackQuery.QueryID,
+			Message: "This album is no longer available. Send it again to browse it.",
+		})
+		return nil
+	}
+
+	chatID := u.EffectiveChat().GetID()
+	text, markup := galleryPage(groupedID, items, index)
+	_, err = ctx.EditMessage(chatID, &tg.MessagesEditMessageRequest{
+		ID:          u.CallbackQuery.MsgID,
+		Message:     text,
+		ReplyMarkup: markup,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to edit album message for chat ID %d: %v", chatID, err)
+	}
+	b.publishGallery(chatID, items, index)
+
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{QueryID: u.CallbackQuery.QueryID})
+	return nil
+}
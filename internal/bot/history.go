@@ -0,0 +1,308 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/web"
+	"webBridgeBot/internal/webhook"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gorilla/mux"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	callbackHistory = "cb_History"
+	historyPageSize = 10
+
+	historyKindAll    = "all"
+	historyKindRecent = "recent"
+
+	// Keeps file names short enough to fit on an inline button.
+	maxHistoryButtonLength = 40
+)
+
+// historyEntry is a media item as returned by the history API.
+type historyEntry struct {
+	MessageID int    `json:"messageId"`
+	FileName  string `json:"fileName"`
+	FileSize  int64  `json:"fileSize"`
+	MimeType  string `json:"mimeType"`
+	URL       string `json:"url"`
+	Title     string `json:"title,omitempty"`
+	Performer string `json:"performer,omitempty"`
+	// ThumbnailURL may not resolve, since the history does not record whether a file has one.
+	ThumbnailURL string     `json:"thumbnailUrl"`
+	SentAt       time.Time  `json:"sentAt"`
+	PlayedAt     *time.Time `json:"playedAt,omitempty"`
+}
+
+// handleHistoryCommand lists all media the user has sent, newest first.
+func (b *TelegramBot) handleHistoryCommand(ctx *ext.Context, u *ext.Update) error {
+	return b.sendHistory(ctx, u, historyKindAll)
+}
+
+// handleRecentCommand lists the media most recently sent to the user's player.
+func (b *TelegramBot) handleRecentCommand(ctx *ext.Context, u *ext.Update) error {
+	return b.sendHistory(ctx, u, historyKindRecent)
+}
+
+func (b *TelegramBot) sendHistory(ctx *ext.Context, u *ext.Update, kind string) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	page := 1
+	if args := strings.Fields(u.EffectiveMessage.Text); len(args) > 1 {
+		page, err = strconv.Atoi(args[1])
+		if err != nil || page < 1 {
+			return b.sendReply(ctx, u, fmt.Sprintf("Usage: /%s [page]", historyCommand(kind)))
+		}
+	}
+
+	text, markup, err := b.historyPage(user.UserID, kind, page)
+	if err != nil {
+		b.logger.Printf("Failed to list media history of user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to load your history.")
+	}
+
+	_, err = ctx.Reply(u, text, &ext.ReplyOpts{Markup: markup})
+	if err != nil {
+		b.logger.Printf("Failed to send history to user %d: %v", user.UserID, err)
+	}
+	return err
+}
+
+// handleHistoryCallback shows another page of a history message.
+func (b *TelegramBot) handleHistoryCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 {
+		return nil
+	}
+	page, err := strconv.Atoi(dataParts[2])
+	if err != nil || page < 1 {
+		return nil
+	}
+
+	userID := u.CallbackQuery.UserID
+	text, markup, err := b.historyPage(userID, dataParts[1], page)
+	if err != nil {
+		b.logger.Printf("Failed to list media history of user %d: %v", userID, err)
+		return err
+	}
+
+	_, err = ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
+		ID:          u.CallbackQuery.MsgID,
+		Message:     text,
+		ReplyMarkup: markup,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to edit history message for user %d: %v", userID, err)
+	}
+
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{QueryID: u.CallbackQuery.QueryID})
+	return nil
+}
+
+// historyPage renders one page of the user's media with a button per item to play it again.
+func (b *TelegramBot) historyPage(userID int64, kind string, page int) (string, *tg.ReplyInlineMarkup, error) {
+	items, hasMore, err := b.listHistory(userID, kind, page)
+	if err != nil {
+		return "", nil, err
+	}
+
+	title := "Your media"
+	if kind == historyKindRecent {
+		title = "Recently played"
+	}
+	if len(items) == 0 {
+		if page > 1 {
+			return fmt.Sprintf("%s: there is no page %d.", title, page), nil, nil
+		}
+		return fmt.Sprintf("%s: nothing yet. Send a video or audio file to get started.", title), nil, nil
+	}
+
+	text, markup := mediaListPage(title, items, page, func(item data.MediaItem) time.Time {
+		if kind == historyKindRecent && item.PlayedAt.Valid {
+			return item.PlayedAt.Time
+		}
+		return item.SentAt
+	})
+	addPageButtons(markup, page, hasMore, "« Newer", "Older »", func(page int) string {
+		return fmt.Sprintf("%s,%s,%d", callbackHistory, kind, page)
+	})
+	return text, markup, nil
+}
+
+// mediaListPage renders a page of media items with a button per item to play it again.
+func mediaListPage(title string, items []data.MediaItem, page int, when func(data.MediaItem) time.Time) (string, *tg.ReplyInlineMarkup) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s, page %d:\n\n", title, page)
+	markup := &tg.ReplyInlineMarkup{}
+	for i, item := range items {
+		fmt.Fprintf(&sb, "%d. %s (%s, %s)\n", (page-1)*historyPageSize+i+1, item.FileName, formatBytes(item.FileSize), when(item).Format("2006-01-02 15:04"))
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{
+			Buttons: []tg.KeyboardButtonClass{
+				&tg.KeyboardButtonCallback{
+					Text: "▶ " + truncate(item.FileName, maxHistoryButtonLength),
+					Data: []byte(fmt.Sprintf("%s,%d", callbackResendToPlayer, item.MessageID)),
+				},
+			},
+		})
+	}
+	return sb.String(), markup
+}
+
+// addPageButtons adds buttons to the previous and next page, whose callback data is built by
+// navData, to a paginated list.
+func addPageButtons(markup *tg.ReplyInlineMarkup, page int, hasMore bool, previous, next string, navData func(page int) string) {
+	var nav []tg.KeyboardButtonClass
+	if page > 1 {
+		nav = append(nav, &tg.KeyboardButtonCallback{
+			Text: previous,
+			Data: []byte(navData(page - 1)),
+		})
+	}
+	if hasMore {
+		nav = append(nav, &tg.KeyboardButtonCallback{
+			Text: next,
+			Data: []byte(navData(page + 1)),
+		})
+	}
+	if len(nav) > 0 {
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: nav})
+	}
+}
+
+// listHistory returns one page of the user's media and whether more pages follow.
+func (b *TelegramBot) listHistory(userID int64, kind string, page int) ([]data.MediaItem, bool, error) {
+	// Fetch one extra item to find out whether there is a next page.
+	list := b.mediaRepository.ListHistory
+	if kind == historyKindRecent {
+		list = b.mediaRepository.ListRecent
+	}
+	items, err := list(userID, historyPageSize+1, (page-1)*historyPageSize)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(items) > historyPageSize {
+		return items[:historyPageSize], true, nil
+	}
+	return items, false, nil
+}
+
+// recordMedia adds a bridged media message to the user's history and announces it to webhooks.
+func (b *TelegramBot) recordMedia(userID int64, messageID int, file *types.DocumentFile, caption string) {
+	err := b.mediaRepository.RecordMedia(userID, &data.MediaItem{
+		MessageID: messageID,
+		FileName:  file.FileName,
+		FileSize:  file.FileSize,
+		MimeType:  file.MimeType,
+		Hash:      b.fileHash(file),
+		Title:     file.AudioAttr.Title,
+		Performer: file.AudioAttr.Performer,
+		Caption:   caption,
+
+		DocumentID: file.Location.ID,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to record media history for message ID %d: %v", messageID, err)
+	}
+	b.webhooks.Send(webhook.EventMediaBridged, map[string]any{
+		"userId":    userID,
+		"messageId": messageID,
+		"fileName":  file.FileName,
+		"fileSize":  file.FileSize,
+		"mimeType":  file.MimeType,
+		"url":       b.generateFileURL(messageID, file),
+	})
+}
+
+// recordPlayed moves a media message to the top of the user's recently played list.
+func (b *TelegramBot) recordPlayed(userID int64, messageID int) {
+	if err := b.mediaRepository.MarkPlayed(userID, messageID); err != nil {
+		b.logger.Printf("Failed to record playback of message ID %d: %v", messageID, err)
+	}
+}
+
+// handleHistoryAPI returns a page of the chat's media history for the player. The kind query
+// parameter selects "recent" (the default) or "all".
+func (b *TelegramBot) handleHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.parseChatID(mux.Vars(r))
+	if err != nil {
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = historyKindRecent
+	}
+	if kind != historyKindRecent && kind != historyKindAll {
+		web.Error(w, "Invalid kind", http.StatusBadRequest)
+		return
+	}
+	page := 1
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			web.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+
+	items, hasMore, err := b.listHistory(playerUserID(chatID), kind, page)
+	if err != nil {
+		b.logger.Printf("Failed to list media history of chat %d: %v", chatID, err)
+		web.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]historyEntry, 0, len(items))
+	for _, item := range items {
+		entry := historyEntry{
+			MessageID:    item.MessageID,
+			FileName:     item.FileName,
+			FileSize:     item.FileSize,
+			MimeType:     item.MimeType,
+			URL:          fmt.Sprintf("%s/%d/%s", b.config.BaseURL, item.MessageID, item.Hash),
+			Title:        item.Title,
+			Performer:    item.Performer,
+			ThumbnailURL: fmt.Sprintf("%s/thumb/%d/%s", b.config.BaseURL, item.MessageID, item.Hash),
+			SentAt:       item.SentAt,
+		}
+		if item.PlayedAt.Valid {
+			entry.PlayedAt = &item.PlayedAt.Time
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":   entries,
+		"page":    page,
+		"hasMore": hasMore,
+	})
+}
+
+func historyCommand(kind string) string {
+	if kind == historyKindRecent {
+		return "recent"
+	}
+	return "history"
+}
+
+// truncate shortens s to at most limit runes, marking the cut with an ellipsis.
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/web"
+
+	"github.com/gorilla/mux"
+)
+
+// generateHLSURL returns the playlist URL of a video converted to HLS.
+func (b *TelegramBot) generateHLSURL(messageID int, file *types.DocumentFile) string {
+	return fmt.Sprintf("%s/hls/%d/%s/playlist.m3u8", b.config.BaseURL, messageID, b.fileHash(file))
+}
+
+// localStreamURL returns the stream URL of a file on the loopback interface, which is how
+// ffmpeg reads Telegram files. With TLS_CERT_FILE set, the server only speaks HTTPS; ffmpeg does
+// not verify certificates by default, so the certificate not naming 127.0.0.1 does not matter.
+func (b *TelegramBot) localStreamURL(messageID int, file *types.DocumentFile) string {
+	scheme := "http"
+	if b.config.TLSCertFile != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%s/%d/%s?local=%s", scheme, b.config.Port, messageID, b.fileHash(file), b.localToken)
+}
+
+// newLocalToken returns the random token with which the bot marks the local stream URLs.
+func newLocalToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// localRequest reports whether a request comes from ffmpeg reading a local stream URL, which is
+// not rate limited. Other clients on the loopback interface, such as a reverse proxy, are.
+func (b *TelegramBot) localRequest(r *http.Request) bool {
+	token := r.URL.Query().Get("local")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(b.localToken)) == 1
+}
+
+// handleHLS serves the HLS playlist and segments of a file. ffmpeg reads the file through the
+// local stream endpoint, so it benefits from range requests and the chunk cache.
+func (b *TelegramBot) handleHLS(w http.ResponseWriter, r *http.Request) {
+	if b.hls == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	messageID, file, ok := b.fileFromRequest(w, r)
+	if !ok {
+		return
+	}
+	sourceURL := b.localStreamURL(messageID, file)
+
+	name := mux.Vars(r)["name"]
+	if name == "playlist.m3u8" {
+		playlist, err := b.hls.Playlist(r.Context(), file.ID, sourceURL)
+		if err != nil {
+			b.logger.Printf("Error producing HLS playlist for message ID %d: %v", messageID, err)
+			web.Error(w, "Failed to convert the media", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(playlist)
+		return
+	}
+
+	segment, err := b.hls.Segment(r.Context(), file.ID, name, sourceURL)
+	if errors.Is(err, web.ErrSegmentNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		b.logger.Printf("Error producing HLS segment %s for message ID %d: %v", name, messageID, err)
+		web.Error(w, "Failed to convert the media", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Write(segment)
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/types"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	// Media that arrives within this time of the previous media of a chat, as when many files
+	// are forwarded at once, is collected into a batch, which is complete once no new media
+	// arrived for this long.
+	ingestBatchWindow = 3 * time.Second
+	// Number of file names listed in the summary of a batch.
+	ingestSummaryItems = 10
+)
+
+// ingestItem is a media message of a batch.
+type ingestItem struct {
+	messageID int
+	fileName  string
+	fileSize  int64
+}
+
+// ingestBatch is media a user sent in quick succession. The first item is handled like any
+// media; the others are added to the queue and answered with one summary.
+type ingestBatch struct {
+	chatID, userID    int64
+	lang              string
+	items             []ingestItem
+	size              int64
+	progressMessageID int
+	lastUpdate        time.Time
+	timer             *time.Timer // Fires once the batch is complete.
+}
+
+// lastMedia is the last media of a chat that was handled on its own.
+type lastMedia struct {
+	item ingestItem
+	at   time.Time
+}
+
+// ingestCollector gathers the media of each chat into batches.
+type ingestCollector struct {
+	mu      sync.Mutex
+	last    map[int64]lastMedia    // By chat ID
+	batches map[int64]*ingestBatch // By chat ID
+}
+
+func newIngestCollector() *ingestCollector {
+	return &ingestCollector{last: make(map[int64]lastMedia), batches: make(map[int64]*ingestBatch)}
+}
+
+// addToBatch adds media to the chat's batch, starting one if the previous media of the chat
+// arrived within ingestBatchWindow. It returns false if the media should be handled on its own.
+func (b *TelegramBot) addToBatch(chatID, userID int64, lang string, messageID int, file *types.DocumentFile) bool {
+	item := ingestItem{messageID: messageID, fileName: file.FileName, fileSize: file.FileSize}
+
+	c := b.ingest
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	batch, ok := c.batches[chatID]
+	if !ok {
+		previous, seen := c.last[chatID]
+		if !seen || now.Sub(previous.at) > ingestBatchWindow {
+			c.last[chatID] = lastMedia{item: item, at: now}
+			return false
+		}
+		delete(c.last, chatID)
+		batch = &ingestBatch{chatID: chatID, userID: userID, lang: lang}
+		batch.items = append(batch.items, previous.item)
+		batch.size = previous.item.fileSize
+		batch.timer = time.AfterFunc(ingestBatchWindow, func() { b.completeBatch(chatID) })
+		c.batches[chatID] = batch
+	} else {
+		batch.timer.Reset(ingestBatchWindow)
+	}
+	batch.items = append(batch.items, item)
+	batch.size += item.fileSize
+	b.reportBatchProgress(batch)
+	return true
+}
+
+// reportBatchProgress sends or edits the message that shows how much of a batch has been
+// processed so far. The caller holds the collector's lock.
+func (b *TelegramBot) reportBatchProgress(batch *ingestBatch) {
+	text := i18n.T(batch.lang, "batch.progress", len(batch.items), formatBytes(batch.size))
+	if batch.progressMessageID == 0 {
+		msg, err := b.tgCtx.SendMessage(batch.chatID, &tg.MessagesSendMessageRequest{Message: text})
+		if err != nil {
+			b.logger.Printf("Failed to send batch progress to chat ID %d: %v", batch.chatID, err)
+			return
+		}
+		batch.progressMessageID = msg.ID
+		batch.lastUpdate = time.Now()
+		return
+	}
+	if time.Since(batch.lastUpdate) < progressUpdateInterval {
+		return
+	}
+	batch.lastUpdate = time.Now()
+	_, err := b.tgCtx.EditMessage(batch.chatID, &tg.MessagesEditMessageRequest{ID: batch.progressMessageID, Message: text})
+	if err != nil {
+		b.logger.Printf("Failed to edit batch progress in chat ID %d: %v", batch.chatID, err)
+	}
+}
+
+// completeBatch adds the media of a complete batch after the first, which is already playing,
+// to the user's queue and replaces the progress message with a summary.
+func (b *TelegramBot) completeBatch(chatID int64) {
+	c := b.ingest
+	c.mu.Lock()
+	batch, ok := c.batches[chatID]
+	if ok {
+		delete(c.batches, chatID)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	queued := 0
+	for _, item := range batch.items[1:] {
+		if err := b.playlistRepository.Enqueue(batch.userID, item.messageID, item.fileName); err != nil {
+			b.logger.Printf("Failed to enqueue message ID %d for user %d: %v", item.messageID, batch.userID, err)
+			continue
+		}
+		queued++
+	}
+	b.publishQueue(chatID, batch.userID)
+	b.logger.Printf("Received a batch of %d media files in chat ID %d, queued %d", len(batch.items), chatID, queued)
+
+	var sb strings.Builder
+	sb.WriteString(i18n.T(batch.lang, "batch.summary", len(batch.items), formatBytes(batch.size), batch.items[0].fileName, queued))
+	for i, item := range batch.items {
+		if i == ingestSummaryItems {
+			sb.WriteString("\n" + i18n.T(batch.lang, "batch.more", len(batch.items)-i))
+			break
+		}
+		fmt.Fprintf(&sb, "\n%d. %s", i+1, item.fileName)
+	}
+	markup := &tg.ReplyInlineMarkup{
+		Rows: []tg.KeyboardButtonRow{{
+			Buttons: []tg.KeyboardButtonClass{
+				&tg.KeyboardButtonURL{Text: i18n.T(batch.lang, "button.openWebURL"), URL: b.playerURL(chatID)},
+			},
+		}},
+	}
+
+	if batch.progressMessageID != 0 {
+		_, err := b.tgCtx.EditMessage(chatID, &tg.MessagesEditMessageRequest{
+			ID:          batch.progressMessageID,
+			Message:     sb.String(),
+			ReplyMarkup: markup,
+		})
+		if err == nil {
+			return
+		}
+		b.logger.Printf("Failed to edit batch summary in chat ID %d: %v", chatID, err)
+	}
+	_, err := b.tgCtx.SendMessage(chatID, &tg.MessagesSendMessageRequest{Message: sb.String(), ReplyMarkup: markup})
+	if err != nil {
+		b.logger.Printf("Failed to send batch summary to chat ID %d: %v", chatID, err)
+	}
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/i18n"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const callbackLanguage = "cb_Language"
+
+// userLanguage returns the language of a user: the one chosen with /language, else the language of
+// their Telegram app if it is supported, else the default.
+func (b *TelegramBot) userLanguage(userID int64, langCode string) string {
+	user, err := b.userRepository.GetUserInfo(userID)
+	if err != nil {
+		user = nil
+	}
+	return languageOf(user, langCode)
+}
+
+// languageOf returns the language of a stored user, who may be nil, with the language code of
+// their Telegram app.
+func languageOf(user *data.User, langCode string) string {
+	if user != nil && i18n.Supported(user.Language) {
+		return user.Language
+	}
+	if lang := i18n.Match(langCode); lang != "" {
+		return lang
+	}
+	return i18n.DefaultLanguage
+}
+
+// updateLanguage returns the language of the user who sent an update.
+func (b *TelegramBot) updateLanguage(u *ext.Update) string {
+	if user := u.EffectiveUser(); user != nil {
+		return b.userLanguage(user.ID, user.LangCode)
+	}
+	if u.CallbackQuery != nil {
+		return b.userLanguage(u.CallbackQuery.UserID, "")
+	}
+	return i18n.DefaultLanguage
+}
+
+// handleLanguageCommand sets the language of the bot and the player with /language <code>, or shows
+// a button for each language.
+func (b *TelegramBot) handleLanguageCommand(ctx *ext.Context, u *ext.Update) error {
+	userID := u.EffectiveUser().ID
+	lang := b.updateLanguage(u)
+	if _, err := b.userRepository.GetUserInfo(userID); err != nil {
+		return b.sendReply(ctx, u, i18n.T(lang, "auth.notAuthorized"))
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		_, err := ctx.Reply(u, i18n.T(lang, "language.choose", i18n.Name(lang)), &ext.ReplyOpts{Markup: languageMarkup()})
+		if err != nil {
+			b.logger.Printf("Failed to send languages to user %d: %v", userID, err)
+		}
+		return err
+	}
+
+	chosen := i18n.Match(args[1])
+	if chosen == "" {
+		return b.sendReply(ctx, u, i18n.T(lang, "language.usage", strings.Join(i18n.Languages(), ", ")))
+	}
+	if err := b.userRepository.SetUserLanguage(userID, chosen); err != nil {
+		b.logger.Printf("Failed to set language of user %d: %v", userID, err)
+		return err
+	}
+	return b.sendReply(ctx, u, i18n.T(chosen, "language.set", i18n.Name(chosen)))
+}
+
+// handleLanguageCallback stores the language of a button of the /language message.
+func (b *TelegramBot) handleLanguageCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 2 || !i18n.Supported(dataParts[1]) {
+		return nil
+	}
+	userID := u.CallbackQuery.UserID
+	chosen := dataParts[1]
+	if err := b.userRepository.SetUserLanguage(userID, chosen); err != nil {
+		b.logger.Printf("Failed to set language of user %d: %v", userID, err)
+		return nil
+	}
+
+	_, err := ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
+		ID:      u.CallbackQuery.MsgID,
+		Message: i18n.T(chosen, "language.set", i18n.Name(chosen)),
+	})
+	if err != nil && !tg.IsMessageNotModified(err) {
+		b.logger.Printf("Failed to edit language message for user %d: %v", userID, err)
+	}
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{QueryID: u.CallbackQuery.QueryID})
+	return nil
+}
+
+func languageMarkup() *tg.ReplyInlineMarkup {
+	var buttons []tg.KeyboardButtonClass
+	for _, lang := range i18n.Languages() {
+		buttons = append(buttons, &tg.KeyboardButtonCallback{
+			Text: i18n.Name(lang),
+			Data: []byte(fmt.Sprintf("%s,%s", callbackLanguage, lang)),
+		})
+	}
+	return &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{Buttons: buttons}}}
+}
@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	gtypes "github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+// messageLinkPattern matches links to channel posts: t.me/c/<channel>/<id> for private channels
+// and t.me/<username>/<id> for public ones, optionally with a topic ID before the message ID.
+var messageLinkPattern = regexp.MustCompile(`(?i)^(?:https?://)?(?:www\.)?(?:t|telegram)\.me/(?:c/(\d+)|(?:s/)?([a-z][a-z0-9_]{3,31}))/(?:\d+/)?(\d+)/?(?:\?\S*)?$`)
+
+var (
+	errLinkNotFound   = errors.New("message not found")
+	errLinkNotMember  = errors.New("not a member of the channel")
+	errLinkNotChannel = errors.New("not a channel")
+)
+
+// messageLink is a link to a message of a channel, by ID for private channels and by username
+// for public ones.
+type messageLink struct {
+	ChannelID int64
+	Username  string
+	MessageID int
+}
+
+func parseMessageLink(text string) (messageLink, bool) {
+	match := messageLinkPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return messageLink{}, false
+	}
+	messageID, err := strconv.Atoi(match[3])
+	if err != nil || messageID <= 0 {
+		return messageLink{}, false
+	}
+	link := messageLink{Username: match[2], MessageID: messageID}
+	if match[1] != "" {
+		link.ChannelID, err = strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return messageLink{}, false
+		}
+	}
+	return link, true
+}
+
+// isMessageLink reports whether a message is just a link to a channel post.
+func isMessageLink(m *gtypes.Message) bool {
+	if m.Message == nil || m.Out {
+		return false
+	}
+	_, ok := parseMessageLink(m.Text)
+	return ok
+}
+
+// handleMessageLink streams the media of a linked channel post. The post is forwarded into the
+// chat, where the bot can stream it like media sent to it.
+func (b *TelegramBot) handleMessageLink(ctx *ext.Context, u *ext.Update) error {
+	chatID := u.EffectiveChat().GetID()
+	if !b.isUserChat(ctx, chatID) {
+		return nil
+	}
+	link, _ := parseMessageLink(u.EffectiveMessage.Text)
+
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, i18n.T(languageOf(user, u.EffectiveUser().LangCode), "auth.notAuthorized"))
+	}
+	lang := b.updateLanguage(u)
+	b.recordActiveUser(user.UserID)
+
+	channelID, err := b.linkedChannel(ctx, link, user.UserID)
+	switch {
+	case errors.Is(err, errLinkNotMember):
+		b.sendReply(ctx, u, i18n.T(lang, "link.notMember"))
+		return dispatcher.EndGroups
+	case err != nil:
+		b.logger.Printf("Failed to resolve the channel of link %s from chat ID %d: %v", u.EffectiveMessage.Text, chatID, err)
+		b.sendReply(ctx, u, i18n.T(lang, "link.notFound"))
+		return dispatcher.EndGroups
+	}
+
+	message, err := b.linkedMessage(ctx, channelID, link.MessageID)
+	if err != nil {
+		b.logger.Printf("Failed to fetch message ID %d of channel %d for chat ID %d: %v", link.MessageID, channelID, chatID, err)
+		b.sendReply(ctx, u, i18n.T(lang, "link.notFound"))
+		return dispatcher.EndGroups
+	}
+	file, err := utils.FileFromMedia(message.Media)
+	if err != nil {
+		b.sendReply(ctx, u, i18n.T(lang, "link.noMedia"))
+		return dispatcher.EndGroups
+	}
+	if b.rejectLargeFile(ctx, u, lang, user.UserID, file) {
+		return dispatcher.EndGroups
+	}
+
+	messageID, err := b.forwardToChat(ctx, channelID, link.MessageID, chatID)
+	if err != nil {
+		b.logger.Printf("Failed to forward message ID %d of channel %d to chat ID %d: %v", link.MessageID, channelID, chatID, err)
+		if tg.IsChatForwardsRestricted(err) {
+			b.sendReply(ctx, u, i18n.T(lang, "link.restricted"))
+		} else {
+			b.sendReply(ctx, u, i18n.T(lang, "link.notFound"))
+		}
+		return dispatcher.EndGroups
+	}
+
+	b.indexDocument(file)
+	fileURL := b.generateFileURL(messageID, file)
+	b.logger.Printf("Generated media file URL for message ID %d in chat ID %d from a link: %s", messageID, chatID, fileURL)
+	b.recordMedia(user.UserID, messageID, file, message.Message)
+	if err := b.sendMediaToUser(ctx, u, lang, messageID, fileURL, file); err != nil {
+		return err
+	}
+	return dispatcher.EndGroups
+}
+
+// linkedChannel returns the ID of the channel of a link and makes sure its peer is stored.
+// Anyone may read public channels; for private ones the user must be a member, which the bot can
+// only check as an admin of the channel.
+func (b *TelegramBot) linkedChannel(ctx *ext.Context, link messageLink, userID int64) (int64, error) {
+	if link.Username != "" {
+		chat, err := ctx.ResolveUsername(link.Username)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve @%s: %w", link.Username, err)
+		}
+		channel, ok := chat.(*gtypes.Channel)
+		if !ok {
+			return 0, fmt.Errorf("@%s: %w", link.Username, errLinkNotChannel)
+		}
+		return channel.ID, nil
+	}
+
+	channel, err := utils.GetLogChannelPeer(ctx, ctx.Raw, ctx.PeerStorage, link.ChannelID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get channel %d: %w", link.ChannelID, err)
+	}
+	_, err = ctx.Raw.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     channel,
+		Participant: ctx.PeerStorage.GetInputPeerById(userID),
+	})
+	if err != nil {
+		b.logger.Printf("Could not confirm that user %d is a member of channel %d: %v", userID, link.ChannelID, err)
+		return 0, errLinkNotMember
+	}
+	return channel.ChannelID, nil
+}
+
+// linkedMessage fetches a message of a channel whose peer is stored.
+func (b *TelegramBot) linkedMessage(ctx *ext.Context, channelID int64, messageID int) (*tg.Message, error) {
+	peer, ok := ctx.PeerStorage.GetInputPeerById(channelID).(*tg.InputPeerChannel)
+	if !ok {
+		return nil, fmt.Errorf("channel %d: %w", channelID, errLinkNotChannel)
+	}
+	result, err := ctx.Raw.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+		Channel: &tg.InputChannel{ChannelID: peer.ChannelID, AccessHash: peer.AccessHash},
+		ID:      []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages, ok := result.AsModified()
+	if !ok {
+		return nil, errLinkNotFound
+	}
+	for _, m := range messages.GetMessages() {
+		if message, ok := m.(*tg.Message); ok && message.ID == messageID {
+			return message, nil
+		}
+	}
+	return nil, errLinkNotFound
+}
+
+// forwardToChat forwards a message of a channel into a chat of the bot and returns the ID of the
+// copy. Streams fetch media by message ID from the bot's own chats, so channel posts are
+// streamed from such a copy.
+func (b *TelegramBot) forwardToChat(ctx *ext.Context, channelID int64, messageID int, chatID int64) (int, error) {
+	updates, err := ctx.ForwardMessages(channelID, chatID, &tg.MessagesForwardMessagesRequest{ID: []int{messageID}})
+	if err != nil {
+		return 0, err
+	}
+	forwardedID, ok := newMessageID(updates)
+	if !ok {
+		return 0, errors.New("forward returned no message")
+	}
+	return forwardedID, nil
+}
+
+// newMessageID returns the ID of the message a forward or post created, in a chat or a channel.
+func newMessageID(updates tg.UpdatesClass) (int, bool) {
+	var list []tg.UpdateClass
+	switch u := updates.(type) {
+	case *tg.Updates:
+		list = u.Updates
+	case *tg.UpdatesCombined:
+		list = u.Updates
+	}
+	for _, update := range list {
+		var m tg.MessageClass
+		switch u := update.(type) {
+		case *tg.UpdateNewMessage:
+			m = u.Message
+		case *tg.UpdateNewChannelMessage:
+			m = u.Message
+		}
+		if message, ok := m.(*tg.Message); ok {
+			return message.ID, true
+		}
+	}
+	return 0, false
+}
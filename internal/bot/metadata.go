@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	metadataPruneInterval = 24 * time.Hour
+	// Files of messages that were not looked up for this long are removed from the database.
+	metadataRetention = 30 * 24 * time.Hour
+)
+
+// runMetadataPrune removes the stored files of messages that are no longer requested once a day,
+// until ctx is cancelled.
+func (b *TelegramBot) runMetadataPrune(ctx context.Context) {
+	ticker := time.NewTicker(metadataPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		pruned, err := b.metadataRepository.Prune(time.Now().Add(-metadataRetention))
+		if err != nil {
+			b.logger.Printf("Failed to prune file metadata: %v", err)
+			continue
+		}
+		if pruned > 0 {
+			b.logger.Printf("Pruned the files of %d messages that were not requested for %v", pruned, metadataRetention)
+		}
+	}
+}
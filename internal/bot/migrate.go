@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"webBridgeBot/internal/data"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+// handleMigrateCommand shows admins which schema migrations have been applied to the database.
+func (b *TelegramBot) handleMigrateCommand(ctx *ext.Context, u *ext.Update) error {
+	// Only allow admins to run this command
+	userInfo, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to read the migration status.")
+	}
+
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	migrator := data.NewMigrator(b.db)
+	statuses, err := migrator.Status()
+	if err != nil {
+		b.logger.Printf("Failed to read migration status: %v", err)
+		return b.sendReply(ctx, u, "Failed to read the migration status.")
+	}
+
+	var sb strings.Builder
+	current := 0
+	pending := 0
+	for _, status := range statuses {
+		if status.Applied {
+			current = status.Version
+			fmt.Fprintf(&sb, "✅ %d. %s (%s)\n", status.Version, status.Name, status.AppliedAt.Format("2006-01-02 15:04"))
+		} else {
+			pending++
+			fmt.Fprintf(&sb, "⏳ %d. %s\n", status.Version, status.Name)
+		}
+	}
+
+	header := fmt.Sprintf("Database: %s, schema version %d of %d", b.db.Driver(), current, migrator.LatestVersion())
+	if pending > 0 {
+		header += fmt.Sprintf(", %d pending", pending)
+	}
+	return b.sendReply(ctx, u, header+"\n\n"+sb.String())
+}
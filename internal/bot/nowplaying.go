@@ -0,0 +1,318 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	wsMessageTypePlayerState = "playerState"
+	wsMessageTypeControl     = "control"
+	callbackPlayer           = "cb_Player"
+
+	controlActionPlay  = "play"
+	controlActionPause = "pause"
+	controlActionSeek  = "seek"
+
+	// How far the rewind and fast-forward buttons jump.
+	playerSkipSeconds = 10
+)
+
+// playerState is the playback state last reported by the player of a chat.
+type playerState struct {
+	MessageID int
+	FileName  string
+	Position  float64 // Seconds
+	Duration  float64 // Seconds, 0 if unknown
+	Paused    bool
+	Buffering bool
+	UpdatedAt time.Time
+}
+
+// currentPosition estimates the position now from the last report.
+func (s playerState) currentPosition() float64 {
+	position := s.Position
+	if !s.Paused && !s.Buffering {
+		position += time.Since(s.UpdatedAt).Seconds()
+	}
+	if s.Duration > 0 && position > s.Duration {
+		position = s.Duration
+	}
+	return position
+}
+
+// playerStateStore keeps the playback state of each chat's player.
+type playerStateStore struct {
+	mu     sync.Mutex
+	states map[int64]playerState
+}
+
+func newPlayerStateStore() *playerStateStore {
+	return &playerStateStore{states: make(map[int64]playerState)}
+}
+
+func (s *playerStateStore) get(chatID int64) (playerState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[chatID]
+	return state, ok
+}
+
+func (s *playerStateStore) set(chatID int64, state playerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[chatID] = state
+}
+
+// handlePlayerState stores the playback state reported by a player.
+func (b *TelegramBot) handlePlayerState(chatID int64, report *playerReport) {
+	b.playerStates.set(chatID, playerState{
+		MessageID: report.MessageID,
+		FileName:  report.FileName,
+		Position:  math.Max(report.Position, 0),
+		Duration:  math.Max(report.Duration, 0),
+		Paused:    report.Paused,
+		Buffering: report.Buffering,
+		UpdatedAt: time.Now(),
+	})
+}
+
+// publishControlCommand tells the player of a chat to play, pause or seek to an absolute
+// position. The player ignores commands for media it is no longer playing.
+func (b *TelegramBot) publishControlCommand(chatID int64, messageID int, action string, position float64) {
+	msg := map[string]string{
+		"type":      wsMessageTypeControl,
+		"action":    action,
+		"messageId": strconv.Itoa(messageID),
+	}
+	if action == controlActionSeek {
+		msg["position"] = strconv.FormatFloat(position, 'f', 3, 64)
+	}
+	b.publishToWebSocket(chatID, msg)
+}
+
+// handleNowPlayingCommand shows what the player is playing, with its position and buttons to
+// control it.
+func (b *TelegramBot) handleNowPlayingCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	chatID := u.EffectiveChat().GetID()
+	state, ok := b.nowPlaying(chatID)
+	if !ok {
+		return b.sendReply(ctx, u, "Nothing is playing in your web player.")
+	}
+	_, err = ctx.Reply(u, nowPlayingMessage(state), &ext.ReplyOpts{Markup: nowPlayingMarkup(state)})
+	if err != nil {
+		b.logger.Printf("Failed to send now playing to chat ID %d: %v", chatID, err)
+	}
+	return err
+}
+
+// nowPlaying returns the playback state of a chat's player if it is connected and playing.
+func (b *TelegramBot) nowPlaying(chatID int64) (playerState, bool) {
+	state, ok := b.playerStates.get(chatID)
+	if !ok || state.MessageID == 0 || !b.wsManager.Connected(chatID) {
+		return playerState{}, false
+	}
+	state.Position = state.currentPosition()
+	state.UpdatedAt = time.Now()
+	return state, true
+}
+
+// handlePlayerCallback applies a button of the /nowplaying message and updates the message.
+func (b *TelegramBot) handlePlayerCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 2 {
+		return nil
+	}
+	chatID := u.EffectiveChat().GetID()
+	state, ok := b.nowPlaying(chatID)
+	if !ok {
+		_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			Alert:   true,
+			QueryID: u.CallbackQuery.QueryID,
+			Message: "Nothing is playing in your web player.",
+		})
+		return nil
+	}
+
+	// The message is updated with the expected state; the player reports the actual one.
+	switch dataParts[1] {
+	case "rewind":
+		state.Position = math.Max(state.Position-playerSkipSeconds, 0)
+		b.publishControlCommand(chatID, state.MessageID, controlActionSeek, state.Position)
+	case "forward":
+		state.Position += playerSkipSeconds
+		if state.Duration > 0 {
+			state.Position = math.Min(state.Position, state.Duration)
+		}
+		b.publishControlCommand(chatID, state.MessageID, controlActionSeek, state.Position)
+	case "toggle":
+		if state.Paused {
+			b.publishControlCommand(chatID, state.MessageID, controlActionPlay, 0)
+		} else {
+			b.publishControlCommand(chatID, state.MessageID, controlActionPause, 0)
+		}
+		state.Paused = !state.Paused
+	case "jump":
+		// Jumps to a share of the duration, like a chapter mark.
+		if len(dataParts) < 3 {
+			return nil
+		}
+		percent, err := strconv.Atoi(dataParts[2])
+		if err != nil || percent < 0 || percent > 100 {
+			return nil
+		}
+		if state.Duration <= 0 {
+			_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+				Alert:   true,
+				QueryID: u.CallbackQuery.QueryID,
+				Message: "The length of this media is not known yet.",
+			})
+			return nil
+		}
+		state.Position = state.Duration * float64(percent) / 100
+		b.publishControlCommand(chatID, state.MessageID, controlActionSeek, state.Position)
+	case "refresh":
+	default:
+		return nil
+	}
+
+	_, err := ctx.EditMessage(chatID, &tg.MessagesEditMessageRequest{
+		ID:          u.CallbackQuery.MsgID,
+		Message:     nowPlayingMessage(state),
+		ReplyMarkup: nowPlayingMarkup(state),
+	})
+	if err != nil && !tg.IsMessageNotModified(err) {
+		b.logger.Printf("Failed to edit now playing message in chat ID %d: %v", chatID, err)
+	}
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{QueryID: u.CallbackQuery.QueryID})
+	return nil
+}
+
+func nowPlayingMessage(state playerState) string {
+	status := "Playing"
+	switch {
+	case state.Buffering:
+		status = "Buffering"
+	case state.Paused:
+		status = "Paused"
+	}
+
+	var progress string
+	if state.Duration > 0 {
+		filled := int(state.Position / state.Duration * progressBarWidth)
+		filled = min(max(filled, 0), progressBarWidth)
+		progress = fmt.Sprintf("[%s%s] %s / %s",
+			strings.Repeat("#", filled),
+			strings.Repeat("-", progressBarWidth-filled),
+			formatPlaybackTime(state.Position),
+			formatPlaybackTime(state.Duration),
+		)
+	} else {
+		progress = formatPlaybackTime(state.Position)
+	}
+	return fmt.Sprintf("Now playing: %s\n%s\n%s", state.FileName, status, progress)
+}
+
+func nowPlayingMarkup(state playerState) *tg.ReplyInlineMarkup {
+	toggle := "Pause"
+	if state.Paused {
+		toggle = "Play"
+	}
+	button := func(text, action string) tg.KeyboardButtonClass {
+		return &tg.KeyboardButtonCallback{Text: text, Data: []byte(fmt.Sprintf("%s,%s", callbackPlayer, action))}
+	}
+	markup := &tg.ReplyInlineMarkup{
+		Rows: []tg.KeyboardButtonRow{
+			{Buttons: []tg.KeyboardButtonClass{
+				button(fmt.Sprintf("« %ds", playerSkipSeconds), "rewind"),
+				button(toggle, "toggle"),
+				button(fmt.Sprintf("%ds »", playerSkipSeconds), "forward"),
+			}},
+		},
+	}
+	if state.Duration > 0 {
+		var jumps []tg.KeyboardButtonClass
+		for _, percent := range []int{0, 25, 50, 75} {
+			text := fmt.Sprintf("%d%% (%s)", percent, formatPlaybackTime(state.Duration*float64(percent)/100))
+			jumps = append(jumps, button(text, fmt.Sprintf("jump,%d", percent)))
+		}
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: jumps})
+	}
+	markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{button("Refresh", "refresh")}})
+	return markup
+}
+
+// handleSeekCommand moves the player to a position given as [h:]mm:ss or seconds. Without one
+// it shows the position with buttons to jump to a quarter, half or three quarters of the media.
+func (b *TelegramBot) handleSeekCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	chatID := u.EffectiveChat().GetID()
+	state, ok := b.nowPlaying(chatID)
+	if !ok {
+		return b.sendReply(ctx, u, "Nothing is playing in your web player.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) >= 2 {
+		position, err := parsePlaybackTime(args[1])
+		if err != nil {
+			return b.sendReply(ctx, u, "Usage: /seek <mm:ss>, for example /seek 1:30 or /seek 1:02:03")
+		}
+		if state.Duration > 0 && position > state.Duration {
+			return b.sendReply(ctx, u, fmt.Sprintf("%s is past the end of %s, which is %s long.", formatPlaybackTime(position), state.FileName, formatPlaybackTime(state.Duration)))
+		}
+		b.publishControlCommand(chatID, state.MessageID, controlActionSeek, position)
+		state.Position = position
+	}
+
+	_, err = ctx.Reply(u, nowPlayingMessage(state), &ext.ReplyOpts{Markup: nowPlayingMarkup(state)})
+	if err != nil {
+		b.logger.Printf("Failed to send seek reply to chat ID %d: %v", chatID, err)
+	}
+	return err
+}
+
+// parsePlaybackTime parses a position given as seconds, mm:ss or h:mm:ss.
+func parsePlaybackTime(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var seconds float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		// Minutes and seconds after the first field must be below 60.
+		if i > 0 && value >= 60 {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// formatPlaybackTime renders seconds as m:ss, or h:mm:ss for an hour or more.
+func formatPlaybackTime(seconds float64) string {
+	total := int(seconds)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
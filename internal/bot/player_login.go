@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	playerLoginTmplPath = "templates/player_login.html"
+	playerSessionCookie = "webbridgebot_player"
+	// How old the data of the login widget may be when it reaches the auth URL.
+	playerLoginMaxAge = 10 * time.Minute
+)
+
+// sessionSecret is the secret that session cookies and player links are derived from. A user
+// account has no bot token, so its API hash is used instead.
+func (b *TelegramBot) sessionSecret() string {
+	if b.config.BotToken != "" {
+		return b.config.BotToken
+	}
+	return b.config.ApiHash
+}
+
+// newPlayerSessions returns the sessions of visitors signed in to their player.
+func (b *TelegramBot) newPlayerSessions() *web.SessionManager {
+	key := sha256.Sum256([]byte(b.sessionSecret() + ":player-session"))
+	return web.NewSessionManager(key[:], web.SessionConfig{
+		CookieName: playerSessionCookie,
+		Path:       b.basePath(),
+		TTL:        b.config.PlayerSessionTTL,
+		Secure:     strings.HasPrefix(b.config.BaseURL, "https://"),
+	})
+}
+
+// playerURL returns the link to the player of a chat that the bot sends. Unless
+// PLAYER_LOGIN_REQUIRED is set, it carries a token that signs the visitor in.
+func (b *TelegramBot) playerURL(chatID int64) string {
+	if b.config.PlayerLoginRequired {
+		return fmt.Sprintf("%s/%d", b.config.BaseURL, chatID)
+	}
+	return fmt.Sprintf("%s/%d?token=%s", b.config.BaseURL, chatID, b.playerLinkToken(chatID))
+}
+
+func (b *TelegramBot) playerLinkToken(chatID int64) string {
+	return utils.GenerateChatToken(b.sessionSecret()+":player-link", chatID, b.config.HashLength)
+}
+
+// playerSession returns the session of a request if it may use the player of a chat. That is
+// only the user of the private chat, signed in with a link from the bot or with Telegram.
+func (b *TelegramBot) playerSession(r *http.Request, chatID int64) (web.Session, bool) {
+	session, err := b.playerSessions.Get(r)
+	if err != nil || session.UserID != chatID {
+		return web.Session{}, false
+	}
+	return session, true
+}
+
+// playerUserID returns the ID of the user of a player. The player is bound to a private chat,
+// whose ID is the ID of the user.
+func playerUserID(chatID int64) int64 {
+	return chatID
+}
+
+// requirePlayerSession lets only the signed-in user of a chat use its player APIs. Requests
+// that change something must also carry the CSRF token of the session.
+func (b *TelegramBot) requirePlayerSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chatID, err := b.parseChatID(mux.Vars(r))
+		if err != nil {
+			web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+			return
+		}
+		session, ok := b.playerSession(r, chatID)
+		if !ok {
+			web.Error(w, "Sign in to use this player", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if err := b.playerSessions.CheckCSRF(r, session); err != nil {
+				b.logger.Printf("Rejected %s %s of chat ID %d from client %s: %v", r.Method, r.URL.Path, chatID, web.ClientIP(r, b.trustedProxies), err)
+				web.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signInWithLink starts a session for the chat of a player link and sends the visitor to the
+// player without the token in the URL. It reports false if the token is not valid.
+func (b *TelegramBot) signInWithLink(w http.ResponseWriter, r *http.Request, chatID int64) bool {
+	token := r.URL.Query().Get("token")
+	if b.config.PlayerLoginRequired || !utils.CheckChatToken(token, b.sessionSecret()+":player-link", chatID, b.config.HashLength) {
+		return false
+	}
+	b.playerSessions.Create(w, chatID)
+	http.Redirect(w, r, b.basePath()+strconv.FormatInt(chatID, 10), http.StatusSeeOther)
+	return true
+}
+
+// handlePlayerLogin shows the login widget, which signs the visitor in to their own player.
+func (b *TelegramBot) handlePlayerLogin(w http.ResponseWriter, r *http.Request) {
+	if session, err := b.playerSessions.Get(r); err == nil {
+		http.Redirect(w, r, b.basePath()+strconv.FormatInt(session.UserID, 10), http.StatusSeeOther)
+		return
+	}
+	b.renderPlayerLogin(w, r, r.URL.Query().Get("error"))
+}
+
+func (b *TelegramBot) renderPlayerLogin(w http.ResponseWriter, r *http.Request, loginError string) {
+	lang := languageOf(nil, i18n.MatchAcceptLanguage(r.Header.Get("Accept-Language")))
+	t, err := template.New(path.Base(playerLoginTmplPath)).Funcs(template.FuncMap{
+		"t": func(key string, args ...interface{}) string { return i18n.T(lang, key, args...) },
+	}).ParseFiles(playerLoginTmplPath)
+	if err != nil {
+		b.logger.Printf("Error loading template: %v", err)
+		web.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+	live := b.live.Load()
+	if err := t.Execute(w, map[string]interface{}{
+		"BasePath":    b.basePath(),
+		"Title":       live.PlayerTitle,
+		"AccentColor": live.PlayerAccentColor,
+		"Language":    lang,
+		"Direction":   i18n.Direction(lang),
+		"BotUsername": b.loginWidgetBot(),
+		"AuthURL":     b.config.BaseURL + "/login/auth",
+		"LinkLogin":   !b.config.PlayerLoginRequired,
+		"Error":       loginError,
+	}); err != nil {
+		b.logger.Printf("Error rendering template: %v", err)
+		web.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// loginWidgetBot returns the username of the bot for the login widget, or "" with a user
+// account, which cannot verify logins.
+func (b *TelegramBot) loginWidgetBot() string {
+	if b.config.ClientType != config.ClientTypeBot {
+		return ""
+	}
+	return b.tgClient.Self.Username
+}
+
+// handlePlayerAuth is the auth URL of the login widget. It signs in authorized users and sends
+// them to their player.
+func (b *TelegramBot) handlePlayerAuth(w http.ResponseWriter, r *http.Request) {
+	login, err := web.VerifyTelegramLogin(r.URL.Query(), b.config.BotToken, playerLoginMaxAge)
+	if err != nil {
+		b.logger.Printf("Rejected player login: %v", err)
+		http.Redirect(w, r, b.basePath()+"login?error=invalid", http.StatusSeeOther)
+		return
+	}
+	user, err := b.userRepository.GetUserInfo(login.ID)
+	if err != nil || !user.IsAuthorized {
+		b.logger.Printf("Rejected player login of user %d, who is not authorized", login.ID)
+		http.Redirect(w, r, b.basePath()+"login?error=forbidden", http.StatusSeeOther)
+		return
+	}
+
+	b.playerSessions.Create(w, login.ID)
+	http.Redirect(w, r, b.basePath()+strconv.FormatInt(login.ID, 10), http.StatusSeeOther)
+}
+
+func (b *TelegramBot) handlePlayerLogout(w http.ResponseWriter, r *http.Request) {
+	// Without the token, another site could sign the visitor out.
+	if session, err := b.playerSessions.Get(r); err == nil {
+		if err := b.playerSessions.CheckCSRF(r, session); err != nil {
+			web.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+	}
+	b.playerSessions.Clear(w)
+	http.Redirect(w, r, b.basePath()+"login", http.StatusSeeOther)
+}
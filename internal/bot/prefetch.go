@@ -0,0 +1,359 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	callbackPrefetch = "cb_Prefetch"
+
+	// Jobs queued or running at once; more are refused until some finish.
+	prefetchQueueSize = 100
+	// Finished jobs kept for /jobs.
+	prefetchJobHistory = 50
+)
+
+const (
+	prefetchQueued  = "queued"
+	prefetchRunning = "running"
+	prefetchDone    = "done"
+	prefetchFailed  = "failed"
+)
+
+var (
+	errAlreadyCached     = errors.New("already cached")
+	errPrefetchQueueFull = errors.New("too many files are waiting to be cached")
+)
+
+// prefetchJob downloads a whole file into the cache.
+type prefetchJob struct {
+	id        int
+	chatID    int64
+	userID    int64
+	messageID int
+	file      *types.DocumentFile
+	key       int64 // Cache key of the file
+	// Message in chatID edited with the progress, if the job was started by the user.
+	statusMessageID int
+
+	// Guarded by prefetchQueue.mu.
+	status   string
+	cached   int64
+	err      error
+	finished time.Time
+}
+
+// prefetchQueue holds the prefetch jobs, which a pool of workers runs in the order they were
+// queued. A file is queued once at a time.
+type prefetchQueue struct {
+	mu      sync.Mutex
+	nextID  int
+	active  map[int64]*prefetchJob // Queued or running jobs by cache key
+	jobs    []*prefetchJob         // Recent jobs, oldest first
+	pending chan *prefetchJob
+}
+
+func newPrefetchQueue() *prefetchQueue {
+	return &prefetchQueue{active: make(map[int64]*prefetchJob), pending: make(chan *prefetchJob, prefetchQueueSize)}
+}
+
+// enqueuePrefetch queues the file of a message to be cached. If the file is already queued, that
+// job is returned. With notify, a message in the chat shows the progress of the job.
+func (b *TelegramBot) enqueuePrefetch(chatID, userID int64, messageID int, file *types.DocumentFile, notify bool) (*prefetchJob, error) {
+	key := b.canonicalDocument(file.ID)
+	if b.cachedFileBytes(key, file.FileSize) == file.FileSize {
+		return nil, errAlreadyCached
+	}
+
+	q := b.prefetchQueue
+	q.mu.Lock()
+	if job, ok := q.active[key]; ok {
+		q.mu.Unlock()
+		return job, nil
+	}
+	if len(q.active) >= prefetchQueueSize {
+		q.mu.Unlock()
+		return nil, errPrefetchQueueFull
+	}
+	q.nextID++
+	job := &prefetchJob{id: q.nextID, chatID: chatID, userID: userID, messageID: messageID, file: file, key: key, status: prefetchQueued}
+	q.active[key] = job
+	q.jobs = append(q.jobs, job)
+	q.trim()
+	q.mu.Unlock()
+
+	// The status message is sent before a worker can pick up the job, which edits it.
+	if notify {
+		msg, err := b.tgCtx.SendMessage(chatID, &tg.MessagesSendMessageRequest{Message: fmt.Sprintf("Queued %s for caching (job #%d).", file.FileName, job.id)})
+		if err != nil {
+			b.logger.Printf("Failed to send prefetch status to chat ID %d: %v", chatID, err)
+		} else {
+			job.statusMessageID = msg.ID
+		}
+	}
+	q.pending <- job
+	return job, nil
+}
+
+// trim drops the oldest finished jobs beyond the history. It must be called with q.mu held.
+func (q *prefetchQueue) trim() {
+	finished := 0
+	for _, job := range q.jobs {
+		if !job.finished.IsZero() {
+			finished++
+		}
+	}
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if !job.finished.IsZero() && finished > prefetchJobHistory {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+}
+
+// runPrefetchWorkers runs queued prefetch jobs with the configured number of workers until ctx
+// is cancelled.
+func (b *TelegramBot) runPrefetchWorkers(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < b.config.PrefetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-b.prefetchQueue.pending:
+					b.runPrefetch(ctx, job)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runPrefetch reads a file through the cache, which keeps every chunk the reader downloads. Its
+// requests wait behind those of viewers.
+func (b *TelegramBot) runPrefetch(ctx context.Context, job *prefetchJob) {
+	q := b.prefetchQueue
+	q.mu.Lock()
+	job.status = prefetchRunning
+	q.mu.Unlock()
+
+	start := time.Now()
+	lastUpdate := start
+	progress := &progressWriter{onWrite: func(written int64) {
+		q.mu.Lock()
+		job.cached = written
+		q.mu.Unlock()
+		if time.Since(lastUpdate) >= progressUpdateInterval {
+			lastUpdate = time.Now()
+			b.updatePrefetchStatus(job, fmt.Sprintf("Caching %s\n%s", job.file.FileName, formatProgress(written, job.file.FileSize)))
+		}
+	}}
+	err := b.readIntoCache(ctx, job.messageID, job.file, progress)
+
+	q.mu.Lock()
+	job.finished = time.Now()
+	job.err = err
+	job.status = prefetchDone
+	if err != nil {
+		job.status = prefetchFailed
+	}
+	delete(q.active, job.key)
+	q.trim()
+	q.mu.Unlock()
+
+	if err != nil {
+		b.logger.Printf("Failed to prefetch file of message ID %d: %v", job.messageID, err)
+		b.updatePrefetchStatus(job, fmt.Sprintf("Failed to cache %s: %s of it is cached.", job.file.FileName, formatBytes(b.cachedFileBytes(job.key, job.file.FileSize))))
+		return
+	}
+	b.logger.Printf("Prefetched %s of message ID %d in %v", formatBytes(job.file.FileSize), job.messageID, time.Since(start).Round(time.Second))
+	b.updatePrefetchStatus(job, fmt.Sprintf("Cached %s; it now plays from the cache.", job.file.FileName))
+}
+
+func (b *TelegramBot) updatePrefetchStatus(job *prefetchJob, text string) {
+	if job.statusMessageID == 0 {
+		return
+	}
+	_, err := b.tgCtx.EditMessage(job.chatID, &tg.MessagesEditMessageRequest{ID: job.statusMessageID, Message: text})
+	if err != nil {
+		b.logger.Printf("Failed to edit prefetch status in chat ID %d: %v", job.chatID, err)
+	}
+}
+
+func (b *TelegramBot) readIntoCache(ctx context.Context, messageID int, file *types.DocumentFile, w io.Writer) error {
+	lr, err := b.newFileReader(ctx, messageID, file, 0, file.FileSize-1, file.FileSize, b.logger)
+	if err != nil {
+		return err
+	}
+	defer lr.Close()
+	_, err = reader.Copy(w, lr)
+	return err
+}
+
+// progressWriter discards what is written and reports the total so far.
+type progressWriter struct {
+	written int64
+	onWrite func(written int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	p.onWrite(p.written)
+	return len(b), nil
+}
+
+// cachedFileBytes returns how many bytes of a file of size bytes are cached under key.
+func (b *TelegramBot) cachedFileBytes(key, size int64) int64 {
+	var cached int64
+	for _, span := range b.config.BinaryCache.CachedSpans(key, b.config.ChunkSize) {
+		cached += min(span[1], size) - span[0]
+	}
+	return cached
+}
+
+// checkPrefetch reports why a user may not cache a file, if they may not.
+func (b *TelegramBot) checkPrefetch(userID int64, file *types.DocumentFile) error {
+	if file.FileSize <= 0 || file.FileSize > reader.MaxSupportedFileSize {
+		return fmt.Errorf("%s cannot be cached", file.FileName)
+	}
+	if limit, err := b.fileSizeLimit(userID); err == nil && file.FileSize > limit {
+		return fmt.Errorf("%s is larger than your file size limit of %s", file.FileName, formatBytes(limit))
+	}
+	// A file larger than the cache would evict its own first chunks.
+	if stats, err := b.config.BinaryCache.GetStats(); err == nil && stats.MaxSize > 0 && file.FileSize > stats.MaxSize {
+		return fmt.Errorf("%s is larger than the cache (%s)", file.FileName, formatBytes(stats.MaxSize))
+	}
+	return nil
+}
+
+// autoPrefetch caches small files as soon as they are sent, if enabled.
+func (b *TelegramBot) autoPrefetch(chatID, userID int64, messageID int, file *types.DocumentFile) {
+	if file.FileSize > b.config.PrefetchAutoMaxSize || b.checkPrefetch(userID, file) != nil {
+		return
+	}
+	if _, err := b.enqueuePrefetch(chatID, userID, messageID, file, false); err != nil && !errors.Is(err, errAlreadyCached) {
+		b.logger.Printf("Failed to queue message ID %d for caching: %v", messageID, err)
+	}
+}
+
+// handlePrefetchCommand caches the file of the replied media message in the background, so it
+// later plays without waiting for Telegram, even after a restart.
+func (b *TelegramBot) handlePrefetchCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	messageID, isReply := replyToMessageID(u)
+	if !isReply {
+		return b.sendReply(ctx, u, "Usage: reply to a media message with /prefetch")
+	}
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return b.sendReply(ctx, u, "The replied message does not contain supported media.")
+	}
+	if err := b.checkPrefetch(user.UserID, file); err != nil {
+		return b.sendReply(ctx, u, err.Error()+".")
+	}
+
+	switch _, err := b.enqueuePrefetch(u.EffectiveChat().GetID(), user.UserID, messageID, file, true); {
+	case errors.Is(err, errAlreadyCached):
+		return b.sendReply(ctx, u, fmt.Sprintf("%s is already cached.", file.FileName))
+	case err != nil:
+		return b.sendReply(ctx, u, fmt.Sprintf("Failed to queue %s: %v.", file.FileName, err))
+	}
+	return nil
+}
+
+// handlePrefetchCallback queues the file of a message from its "Cache for offline" button.
+func (b *TelegramBot) handlePrefetchCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 2 {
+		return nil
+	}
+	messageID, err := strconv.Atoi(dataParts[1])
+	if err != nil {
+		return err
+	}
+	lang := b.updateLanguage(u)
+	answer := func(text string) error {
+		_, err := ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{QueryID: u.CallbackQuery.QueryID, Message: text})
+		return err
+	}
+
+	user, err := b.userRepository.GetUserInfo(u.CallbackQuery.UserID)
+	if err != nil || !user.IsAuthorized {
+		return answer(i18n.T(lang, "auth.notAuthorized"))
+	}
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return answer(i18n.T(lang, "media.prefetchUnavailable"))
+	}
+	if err := b.checkPrefetch(user.UserID, file); err != nil {
+		return answer(i18n.T(lang, "media.prefetchUnavailable"))
+	}
+	switch _, err := b.enqueuePrefetch(u.EffectiveChat().GetID(), user.UserID, messageID, file, true); {
+	case errors.Is(err, errAlreadyCached):
+		return answer(i18n.T(lang, "media.alreadyCached", file.FileName))
+	case err != nil:
+		return answer(i18n.T(lang, "media.prefetchUnavailable"))
+	}
+	return answer(i18n.T(lang, "media.prefetchQueued", file.FileName))
+}
+
+// handleJobsCommand lists the recent prefetch jobs of the user, or of everyone for admins.
+func (b *TelegramBot) handleJobsCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	q := b.prefetchQueue
+	q.mu.Lock()
+	var sb strings.Builder
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		job := q.jobs[i]
+		if job.userID != user.UserID && !user.IsAdmin {
+			continue
+		}
+		fmt.Fprintf(&sb, "#%d %s: %s", job.id, job.file.FileName, job.status)
+		switch job.status {
+		case prefetchRunning:
+			fmt.Fprintf(&sb, " %s", formatProgress(job.cached, job.file.FileSize))
+		case prefetchFailed:
+			fmt.Fprintf(&sb, " (%v)", job.err)
+		}
+		if user.IsAdmin && job.userID != user.UserID {
+			fmt.Fprintf(&sb, ", user %d", job.userID)
+		}
+		sb.WriteString("\n")
+	}
+	q.mu.Unlock()
+
+	if sb.Len() == 0 {
+		return b.sendReply(ctx, u, "No files are being cached. Reply to a media message with /prefetch or use its \"Cache for offline\" button.")
+	}
+	return b.sendReply(ctx, u, "Caching jobs, newest first:\n"+sb.String())
+}
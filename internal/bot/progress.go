@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	wsMessageTypeDownloadProgress = "downloadProgress"
+
+	progressBarWidth       = 20
+	progressUpdateInterval = 3 * time.Second // Minimum time between edits of a progress message.
+	// progressExpiry is how long a download is tracked without reports, after which the player
+	// is assumed to have been closed before it finished.
+	progressExpiry = 10 * time.Minute
+)
+
+// playerReport is a message sent by the web player over the WebSocket connection.
+type playerReport struct {
+	Type      string `json:"type"`
+	MessageID int    `json:"messageId"`
+	FileName  string `json:"fileName"`
+	Loaded    int64  `json:"loaded"`
+	Total     int64  `json:"total"`
+	Done      bool   `json:"done"`
+	// Action and Position describe playback changes in a watch-together room.
+	Action   string  `json:"action"`
+	Position float64 `json:"position"`
+	// Duration, Paused and Buffering complete the playback state reported for /nowplaying.
+	Duration  float64 `json:"duration"`
+	Paused    bool    `json:"paused"`
+	Buffering bool    `json:"buffering"`
+}
+
+// downloadProgress tracks the Telegram message used to display a client download's progress.
+type downloadProgress struct {
+	progressMessageID int // Zero while the message is being sent.
+	lastPercent       int
+	lastUpdate        time.Time
+	lastReport        time.Time
+	// doneText is the text of a report of the finished download that came while the message was
+	// being sent, which the message is edited to once sent.
+	doneText string
+}
+
+// downloadProgressTracker keeps the progress messages of active client downloads per chat and media message.
+type downloadProgressTracker struct {
+	mu        sync.Mutex
+	downloads map[string]*downloadProgress
+}
+
+func newDownloadProgressTracker() *downloadProgressTracker {
+	return &downloadProgressTracker{downloads: make(map[string]*downloadProgress)}
+}
+
+// handlePlayerReport dispatches a message received from the web player.
+func (b *TelegramBot) handlePlayerReport(chatID int64, payload []byte) bool {
+	var report playerReport
+	if err := json.Unmarshal(payload, &report); err != nil || report.Type == "" {
+		return false
+	}
+
+	switch report.Type {
+	case wsMessageTypeDownloadProgress:
+		b.handleDownloadProgress(chatID, &report)
+	case wsMessageTypeMediaEnded:
+		b.handleMediaEnded(chatID, &report)
+	case wsMessageTypePlayback:
+		b.handlePlaybackReport(chatID, &report)
+	case wsMessageTypePlayerState:
+		b.handlePlayerState(chatID, &report)
+	default:
+		b.logger.Printf("Unknown player report type %q from chat ID %d", report.Type, chatID)
+	}
+	return true
+}
+
+// handleDownloadProgress creates or edits a bot message showing the progress of a download made from the player.
+func (b *TelegramBot) handleDownloadProgress(chatID int64, report *playerReport) {
+	// Reports come from the browser, so counts that cannot be right are ignored.
+	if report.Total <= 0 || report.Loaded < 0 || report.Loaded > report.Total {
+		return
+	}
+	percent := int(float64(report.Loaded) * 100 / float64(report.Total))
+	key := fmt.Sprintf("%d:%d", chatID, report.MessageID)
+	text := fmt.Sprintf("Downloading %s\n%s", report.FileName, formatProgress(report.Loaded, report.Total))
+	if report.Done {
+		text = fmt.Sprintf("Downloaded %s (%s)", report.FileName, formatBytes(report.Total))
+	}
+
+	now := time.Now()
+	t := b.downloadProgress
+	t.mu.Lock()
+	for k, p := range t.downloads {
+		if now.Sub(p.lastReport) > progressExpiry {
+			delete(t.downloads, k)
+		}
+	}
+
+	progress, exists := t.downloads[key]
+	if !exists {
+		if report.Done {
+			// The download finished before its progress was reported, so no message is edited later.
+			t.mu.Unlock()
+			b.sendDownloadProgress(chatID, text)
+			return
+		}
+		progress = &downloadProgress{lastPercent: percent, lastUpdate: now, lastReport: now}
+		t.downloads[key] = progress
+		t.mu.Unlock()
+
+		// The message is sent without holding the lock, so reports of other downloads are not held
+		// up by Telegram. Reports of this download that come meanwhile are dropped, except the last.
+		messageID := b.sendDownloadProgress(chatID, text)
+		t.mu.Lock()
+		progress.progressMessageID = messageID
+		doneText := progress.doneText
+		if messageID == 0 && t.downloads[key] == progress {
+			delete(t.downloads, key)
+		}
+		t.mu.Unlock()
+		if messageID != 0 && doneText != "" {
+			b.editDownloadProgress(chatID, messageID, doneText)
+		}
+		return
+	}
+
+	progress.lastReport = now
+	if progress.progressMessageID == 0 {
+		if report.Done {
+			progress.doneText = text
+			delete(t.downloads, key)
+		}
+		t.mu.Unlock()
+		return
+	}
+	if report.Done {
+		delete(t.downloads, key)
+	} else if percent == progress.lastPercent || now.Sub(progress.lastUpdate) < progressUpdateInterval {
+		t.mu.Unlock()
+		return
+	}
+	progress.lastPercent = percent
+	progress.lastUpdate = now
+	messageID := progress.progressMessageID
+	t.mu.Unlock()
+
+	b.editDownloadProgress(chatID, messageID, text)
+}
+
+// sendDownloadProgress sends a progress message and returns its ID, or zero if it failed.
+func (b *TelegramBot) sendDownloadProgress(chatID int64, text string) int {
+	msg, err := b.tgCtx.SendMessage(chatID, &tg.MessagesSendMessageRequest{Message: text})
+	if err != nil {
+		b.logger.Printf("Failed to send download progress to chat ID %d: %v", chatID, err)
+		return 0
+	}
+	return msg.ID
+}
+
+// editDownloadProgress replaces the text of a progress message.
+func (b *TelegramBot) editDownloadProgress(chatID int64, messageID int, text string) {
+	_, err := b.tgCtx.EditMessage(chatID, &tg.MessagesEditMessageRequest{
+		ID:      messageID,
+		Message: text,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to edit download progress in chat ID %d: %v", chatID, err)
+	}
+}
+
+// formatProgress renders a textual progress bar such as "[#####---------------] 25% (1.0 MB / 4.0 MB)".
+func formatProgress(current, total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	// Computed in floating point, so large sizes do not overflow.
+	ratio := float64(current) / float64(total)
+	filled := min(max(int(ratio*progressBarWidth), 0), progressBarWidth)
+	return fmt.Sprintf("[%s%s] %d%% (%s / %s)",
+		strings.Repeat("#", filled),
+		strings.Repeat("-", progressBarWidth-filled),
+		int(ratio*100),
+		formatBytes(current),
+		formatBytes(total),
+	)
+}
+
+// formatBytes renders a byte count in a human-readable unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
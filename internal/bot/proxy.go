@@ -0,0 +1,409 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+const (
+	externalProbeTimeout = 15 * time.Second
+	maxProxyRedirects    = 5
+)
+
+var (
+	errPrivateAddress    = errors.New("destination resolves to a private or local address")
+	errDomainNotAllowed  = errors.New("domain is not in the allowlist")
+	errExternalTooLarge  = errors.New("file is too large")
+	errTooManyRedirects  = errors.New("too many redirects")
+	errUnsupportedScheme = errors.New("unsupported URL scheme")
+)
+
+// reservedNetworks are the ranges isPublicIP refuses beyond those the net package classifies:
+// "this network", carrier-grade NAT, IETF protocol assignments, documentation, benchmarking,
+// the reserved class E block and their IPv6 counterparts.
+var reservedNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "192.0.2.0/24", "198.18.0.0/15",
+		"198.51.100.0/24", "203.0.113.0/24", "240.0.0.0/4",
+		"64:ff9b::/96", "64:ff9b:1::/48", "100::/64", "2001::/23", "2001:db8::/32", "2002::/16",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPublicIP reports whether an IP address is routable on the public internet.
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		// Also catches IPv4-mapped IPv6 addresses such as ::ffff:127.0.0.1.
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// domainAllowlist holds the domains external media may be fetched from. A nil list allows
+// every domain.
+type domainAllowlist []string
+
+// parseDomainAllowlist parses a comma-separated list of domains.
+func parseDomainAllowlist(list string) domainAllowlist {
+	var domains domainAllowlist
+	for _, domain := range strings.Split(list, ",") {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// allows reports whether a host is one of the domains or a subdomain of one.
+func (l domainAllowlist) allows(host string) bool {
+	if l == nil {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range l {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeDialControl rejects connections to private and local addresses at connect time,
+// which also protects against DNS rebinding after validation.
+func safeDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newExternalHTTPClient returns the shared HTTP client used for external media. It pools
+// connections, can only reach public addresses and follows redirects only to allowed hosts.
+func newExternalHTTPClient(cfg *config.Configuration, allowlist domainAllowlist) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.ProxyDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        cfg.ProxyMaxIdleConns,
+		MaxIdleConnsPerHost: cfg.ProxyMaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.ProxyIdleConnTimeout,
+		TLSHandshakeTimeout: cfg.ProxyTLSHandshakeTimeout,
+		ForceAttemptHTTP2:   true,
+	}
+	if cfg.ProxyOutboundURL != "" {
+		// Private addresses cannot be refused when the outbound proxy connects, so the hosts
+		// are limited to the allowlist instead.
+		if len(allowlist) == 0 {
+			return nil, errors.New("PROXY_ALLOWED_DOMAINS must be set when PROXY_OUTBOUND_URL is set")
+		}
+		outbound, err := url.Parse(cfg.ProxyOutboundURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbound proxy URL: %w", err)
+		}
+		// The outbound proxy resolves the destination itself, so only the URL validation applies.
+		transport.Proxy = http.ProxyURL(outbound)
+	} else {
+		dialer.Control = safeDialControl
+	}
+	checkRedirect := func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxProxyRedirects {
+			return errTooManyRedirects
+		}
+		_, err := validateExternalURL(req.Context(), req.URL.String(), allowlist)
+		return err
+	}
+	return &http.Client{Transport: transport, CheckRedirect: checkRedirect}, nil
+}
+
+// validateExternalURL checks that a user-supplied URL is an http(s) URL pointing to a public
+// host on the allowlist.
+func validateExternalURL(ctx context.Context, rawURL string, allowlist domainAllowlist) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w %q", errUnsupportedScheme, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("URL has no host")
+	}
+	if u.User != nil {
+		return nil, errors.New("URL must not contain credentials")
+	}
+	if !allowlist.allows(u.Hostname()) {
+		return nil, errDomainNotAllowed
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve host: %w", err)
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip.IP) {
+			return nil, errPrivateAddress
+		}
+	}
+	return u, nil
+}
+
+// externalMedia describes a media file reachable at an external URL.
+type externalMedia struct {
+	URL      string
+	FileName string
+	MimeType string
+	Size     int64
+}
+
+// probeExternalMedia issues a HEAD request to check that a URL serves playable media of at most
+// maxSize bytes.
+func probeExternalMedia(ctx context.Context, client *http.Client, u *url.URL, maxSize int64) (*externalMedia, error) {
+	ctx, cancel := context.WithTimeout(ctx, externalProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HEAD request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD request returned %s", resp.Status)
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mimeType, "video/") && !strings.HasPrefix(mimeType, "audio/") && !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("unsupported content type %q", mimeType)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("%w (%d bytes)", errExternalTooLarge, resp.ContentLength)
+	}
+
+	fileName := path.Base(u.Path)
+	if fileName == "." || fileName == "/" {
+		fileName = u.Hostname()
+	}
+	return &externalMedia{
+		URL:      u.String(),
+		FileName: fileName,
+		MimeType: mimeType,
+		Size:     resp.ContentLength,
+	}, nil
+}
+
+// proxyURL returns the signed URL under which the server proxies an external media URL.
+func (b *TelegramBot) proxyURL(rawURL string) string {
+	hash := utils.GenerateToken(b.sessionSecret()+":proxy", rawURL, b.config.HashLength)
+	return fmt.Sprintf("%s/proxy?url=%s&hash=%s", b.config.BaseURL, url.QueryEscape(rawURL), hash)
+}
+
+// handleAddCommand validates a direct media URL and pushes it to the user's player through the proxy.
+func (b *TelegramBot) handleAddCommand(ctx *ext.Context, u *ext.Update) error {
+	chatID := u.EffectiveChat().GetID()
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		return b.sendReply(ctx, u, "Usage: /add <url>")
+	}
+
+	target, err := validateExternalURL(ctx, args[1], b.proxyAllowlist)
+	if err != nil {
+		b.logger.Printf("Rejected URL %q from chat ID %d: %v", args[1], chatID, err)
+		return b.sendReply(ctx, u, fmt.Sprintf("This URL cannot be added: %v", err))
+	}
+
+	media, err := probeExternalMedia(ctx, b.httpClient, target, b.config.ProxyMaxResponseSize)
+	if err != nil {
+		b.logger.Printf("Failed to probe URL %q from chat ID %d: %v", target, chatID, err)
+		return b.sendReply(ctx, u, fmt.Sprintf("This URL cannot be added: %v", err))
+	}
+
+	fileURL := b.proxyURL(media.URL)
+	b.publishToWebSocket(chatID, map[string]string{
+		"url":      fileURL,
+		"fileName": media.FileName,
+		"mimeType": media.MimeType,
+		"fileSize": strconv.FormatInt(media.Size, 10),
+	})
+
+	lang := b.updateLanguage(u)
+	return b.sendMediaURLReply(ctx, u, lang, i18n.T(lang, "media.sentToPlayer", media.FileName), fileURL)
+}
+
+// proxiedResponseHeaders are the headers of the external server passed on to the player.
+var proxiedResponseHeaders = []string{
+	"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag",
+}
+
+// handleProxy streams an external media URL that was previously signed by the bot. Range
+// requests are passed on, so the player can seek, and the URL is checked against the allowlist
+// and for private addresses again, as both may have changed since it was signed.
+func (b *TelegramBot) handleProxy(w http.ResponseWriter, r *http.Request) {
+	rawURL := r.URL.Query().Get("url")
+	if rawURL == "" || !utils.CheckToken(r.URL.Query().Get("hash"), b.sessionSecret()+":proxy", rawURL, b.config.HashLength) {
+		web.Error(w, "Invalid authentication hash", http.StatusBadRequest)
+		return
+	}
+
+	target, err := validateExternalURL(r.Context(), rawURL, b.proxyAllowlist)
+	if err != nil {
+		b.logger.Printf("Refused to proxy %s for %s: %v", rawURL, web.ClientIP(r, b.trustedProxies), err)
+		web.Error(w, "URL is not allowed", http.StatusForbidden)
+		return
+	}
+
+	if b.externalCache != nil && b.serveCachedExternal(w, r, target.String()) {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), nil)
+	if err != nil {
+		web.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	for _, header := range []string{"Range", "If-Range"} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		b.logger.Printf("Error fetching proxied URL %s: %v", target, err)
+		if errors.Is(err, errPrivateAddress) || errors.Is(err, errDomainNotAllowed) || errors.Is(err, errTooManyRedirects) {
+			web.Error(w, "URL is not allowed", http.StatusForbidden)
+			return
+		}
+		web.Error(w, "Failed to fetch media", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		b.logger.Printf("Proxied URL %s returned %s", target, resp.Status)
+		web.Error(w, "Failed to fetch media", http.StatusBadGateway)
+		return
+	}
+
+	maxSize := b.config.ProxyMaxResponseSize
+	if size := proxiedFileSize(resp); size > maxSize {
+		b.logger.Printf("Refused to proxy %s: %d bytes exceed the limit of %d", target, size, maxSize)
+		web.Error(w, "Media is too large", http.StatusForbidden)
+		return
+	}
+
+	for _, header := range proxiedResponseHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The size check above relies on the headers; the copy is capped as well for responses
+	// without them or with wrong ones.
+	written, err := io.Copy(w, io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		b.logger.Printf("Error proxying content from %s: %v", target, err)
+		return
+	}
+	if written == maxSize {
+		if n, _ := resp.Body.Read(make([]byte, 1)); n > 0 {
+			b.logger.Printf("Stopped proxying %s after %d bytes", target, maxSize)
+		}
+	}
+}
+
+// serveCachedExternal serves an external file through the cache. It returns false, without
+// writing a response, if the file is not cached and cannot be, so it is proxied directly.
+func (b *TelegramBot) serveCachedExternal(w http.ResponseWriter, r *http.Request, rawURL string) bool {
+	file, err := b.externalCache.Open(r.Context(), rawURL)
+	if err != nil {
+		if !errors.Is(err, web.ErrNotCacheable) {
+			b.logger.Printf("Not caching %s: %v", rawURL, err)
+		}
+		return false
+	}
+	if file.Size() > b.config.ProxyMaxResponseSize {
+		b.logger.Printf("Refused to proxy %s: %d bytes exceed the limit of %d", rawURL, file.Size(), b.config.ProxyMaxResponseSize)
+		web.Error(w, "Media is too large", http.StatusForbidden)
+		return true
+	}
+
+	w.Header().Set("Content-Type", file.ContentType())
+	if etag := file.ETag(); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("X-Accel-Buffering", "no")
+	http.ServeContent(w, r, "", file.ModTime(), file)
+	return true
+}
+
+// proxiedFileSize returns the size of the whole external file as far as the response tells,
+// or -1 if it does not.
+func proxiedFileSize(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-1023/4096, or */4096 for an unknown total.
+		contentRange := resp.Header.Get("Content-Range")
+		if i := strings.LastIndexByte(contentRange, '/'); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+		return -1
+	}
+	return resp.ContentLength
+}
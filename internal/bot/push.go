@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+// replyToMessageID returns the ID of the message the update's message replies to.
+func replyToMessageID(u *ext.Update) (int, bool) {
+	if u.EffectiveMessage == nil || u.EffectiveMessage.Message == nil {
+		return 0, false
+	}
+	replyTo, ok := u.EffectiveMessage.Message.GetReplyTo()
+	if !ok {
+		return 0, false
+	}
+	header, ok := replyTo.(*tg.MessageReplyHeader)
+	if !ok {
+		return 0, false
+	}
+	return header.GetReplyToMsgID()
+}
+
+// handlePushToCommand lets an admin push the replied-to media to another user's player.
+func (b *TelegramBot) handlePushToCommand(ctx *ext.Context, u *ext.Update) error {
+	// Only allow admins to run this command
+	adminID := u.EffectiveUser().ID
+	userInfo, err := b.userRepository.GetUserInfo(adminID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to push the media.")
+	}
+
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	messageID, isReply := replyToMessageID(u)
+	if len(args) < 2 || !isReply {
+		return b.sendReply(ctx, u, "Usage: reply to a media message with /pushto <user_id>")
+	}
+	targetUserID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return b.sendReply(ctx, u, "Invalid user ID.")
+	}
+
+	target, err := b.userRepository.GetUserInfo(targetUserID)
+	if err != nil || !target.IsAuthorized {
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d is not an authorized user.", targetUserID))
+	}
+
+	settings, err := b.userRepository.GetUserSettings(targetUserID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", targetUserID, err)
+		return b.sendReply(ctx, u, "Failed to push the media.")
+	}
+	if !settings.AllowPushes {
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d does not accept pushed media. They can enable it with /settings.", targetUserID))
+	}
+
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return b.sendReply(ctx, u, "The replied message does not contain supported media.")
+	}
+
+	fileURL := b.generateFileURL(messageID, file)
+	b.publishToWebSocket(target.ChatID, b.constructWebSocketMessage(messageID, fileURL, file))
+	b.logger.Printf("Admin %d pushed message ID %d to user %d", adminID, messageID, targetUserID)
+
+	_, err = ctx.SendMessage(target.ChatID, &tg.MessagesSendMessageRequest{
+		Message: fmt.Sprintf("An administrator sent %s to your web player.", file.FileName),
+	})
+	if err != nil {
+		b.logger.Printf("Failed to notify user %d about pushed media: %v", targetUserID, err)
+	}
+
+	return b.sendReply(ctx, u, fmt.Sprintf("%s has been pushed to user %d.", file.FileName, targetUserID))
+}
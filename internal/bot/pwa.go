@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"webBridgeBot/internal/web"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	swTmplPath   = "templates/sw.js"
+	iconTmplPath = "templates/icon.svg"
+)
+
+// webAppManifest describes the fields of the web app manifest served to browsers.
+type webAppManifest struct {
+	Name            string       `json:"name"`
+	ShortName       string       `json:"short_name"`
+	StartURL        string       `json:"start_url"`
+	Scope           string       `json:"scope"`
+	Display         string       `json:"display"`
+	BackgroundColor string       `json:"background_color"`
+	ThemeColor      string       `json:"theme_color"`
+	Icons           []webAppIcon `json:"icons"`
+}
+
+type webAppIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// basePath returns the path component of the configured BaseURL with a trailing slash,
+// which is used as the scope for the manifest and the service worker.
+func (b *TelegramBot) basePath() string {
+	u, err := url.Parse(b.config.BaseURL)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return strings.TrimSuffix(u.Path, "/") + "/"
+}
+
+// handleManifest serves the web app manifest for a chat's player so it can be installed as a PWA.
+func (b *TelegramBot) handleManifest(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.parseChatID(mux.Vars(r))
+	if err != nil {
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	scope := b.basePath()
+	live := b.live.Load()
+	manifest := webAppManifest{
+		Name:            live.PlayerTitle,
+		ShortName:       live.PlayerTitle,
+		StartURL:        fmt.Sprintf("%s%d", scope, chatID),
+		Scope:           scope,
+		Display:         "fullscreen",
+		BackgroundColor: "#222222",
+		ThemeColor:      live.PlayerAccentColor,
+		Icons: []webAppIcon{
+			{Src: scope + "icon.svg", Sizes: "any", Type: "image/svg+xml"},
+			{Src: scope + "icon.svg", Sizes: "any", Type: "image/svg+xml", Purpose: "maskable"},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		b.logger.Printf("Error encoding web app manifest: %v", err)
+	}
+}
+
+// handleServiceWorker serves the service worker script that caches the player shell for offline use.
+func (b *TelegramBot) handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles(swTmplPath)
+	if err != nil {
+		b.logger.Printf("Error loading service worker template: %v", err)
+		web.Error(w, "Failed to load service worker", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Service-Worker-Allowed", b.basePath())
+	if err := t.Execute(w, map[string]interface{}{"Scope": b.basePath()}); err != nil {
+		b.logger.Printf("Error rendering service worker template: %v", err)
+	}
+}
+
+// handleIcon serves the application icon referenced by the manifest.
+func (b *TelegramBot) handleIcon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, iconTmplPath)
+}
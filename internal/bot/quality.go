@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const callbackSelectQuality = "cb_Quality"
+
+// mediaQuality describes one selectable variant of a media file for the player.
+type mediaQuality struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// qualityList returns the original file and its alternatives as selectable qualities.
+func (b *TelegramBot) qualityList(messageID int, file *types.DocumentFile) []mediaQuality {
+	qualities := make([]mediaQuality, 0, len(file.Alternatives)+1)
+	for i := 0; i <= len(file.Alternatives); i++ {
+		variant, _ := file.Variant(i)
+		qualities = append(qualities, mediaQuality{
+			Label: variant.QualityLabel(),
+			URL:   b.generateFileURL(messageID, variant),
+		})
+	}
+	return qualities
+}
+
+// encodeQualities serializes the qualities of a file for the WebSocket message.
+func (b *TelegramBot) encodeQualities(messageID int, file *types.DocumentFile) string {
+	encoded, err := json.Marshal(b.qualityList(messageID, file))
+	if err != nil {
+		b.logger.Printf("Error marshalling qualities for message ID %d: %v", messageID, err)
+		return "[]"
+	}
+	return string(encoded)
+}
+
+// findFileVariant returns the variant of a file whose stream hash matches the given hash. Hashes
+// of the variant's own document, from before its content was indexed, stay valid.
+func (b *TelegramBot) findFileVariant(file *types.DocumentFile, hash string) (*types.DocumentFile, bool) {
+	for i := 0; i <= len(file.Alternatives); i++ {
+		variant, _ := file.Variant(i)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(b.fileHash(variant))) == 1 {
+			return variant, true
+		}
+		expectedHash := utils.PackFile(variant.FileName, variant.FileSize, variant.MimeType, variant.ID)
+		if utils.CheckHash(hash, expectedHash, b.config.HashLength) {
+			return variant, true
+		}
+	}
+	return nil, false
+}
+
+// qualityButtons returns a keyboard row to switch the player between the available qualities.
+func qualityButtons(messageID int, file *types.DocumentFile) (tg.KeyboardButtonRow, bool) {
+	if len(file.Alternatives) == 0 {
+		return tg.KeyboardButtonRow{}, false
+	}
+	var row tg.KeyboardButtonRow
+	for i := 0; i <= len(file.Alternatives); i++ {
+		variant, _ := file.Variant(i)
+		row.Buttons = append(row.Buttons, &tg.KeyboardButtonCallback{
+			Text: variant.QualityLabel(),
+			Data: []byte(fmt.Sprintf("%s,%d,%d", callbackSelectQuality, messageID, i)),
+		})
+	}
+	return row, true
+}
+
+// handleQualityCallback sends the selected quality of a media message to the player.
+func (b *TelegramBot) handleQualityCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 {
+		return nil
+	}
+	messageID, err := strconv.Atoi(dataParts[1])
+	if err != nil {
+		return err
+	}
+	index, err := strconv.Atoi(dataParts[2])
+	if err != nil {
+		return err
+	}
+
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return err
+	}
+	variant, ok := file.Variant(index)
+	if !ok {
+		return nil
+	}
+
+	wsMsg := b.constructWebSocketMessage(messageID, b.generateFileURL(messageID, variant), variant)
+	wsMsg["qualities"] = b.encodeQualities(messageID, file)
+	b.publishToWebSocket(u.EffectiveChat().GetID(), wsMsg)
+
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+		QueryID: u.CallbackQuery.QueryID,
+		Message: fmt.Sprintf("Switched the player to %s.", variant.QualityLabel()),
+	})
+	return nil
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+const (
+	wsMessageTypeQueueUpdate = "queueUpdate"
+	wsMessageTypeMediaEnded  = "mediaEnded"
+)
+
+// handleQueueCommand adds the replied-to media to the end of the user's queue, or lists the queue.
+func (b *TelegramBot) handleQueueCommand(ctx *ext.Context, u *ext.Update) error {
+	return b.enqueueFromCommand(ctx, u, false)
+}
+
+// handlePlayNextCommand puts the replied-to media at the front of the user's queue, or skips
+// to the next queued item.
+func (b *TelegramBot) handlePlayNextCommand(ctx *ext.Context, u *ext.Update) error {
+	if _, isReply := replyToMessageID(u); isReply {
+		return b.enqueueFromCommand(ctx, u, true)
+	}
+
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	item, err := b.playNext(ctx, user.ChatID)
+	if err != nil {
+		b.logger.Printf("Failed to play next queued item for user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to play the next item.")
+	}
+	if item == nil {
+		return b.sendReply(ctx, u, "Your queue is empty.")
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("Now playing %s.", item.FileName))
+}
+
+// handleClearQueueCommand removes all items from the user's queue.
+func (b *TelegramBot) handleClearQueueCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	removed, err := b.playlistRepository.ClearQueue(user.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to clear queue of user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to clear your queue.")
+	}
+	b.publishQueue(u.EffectiveChat().GetID(), user.UserID)
+	return b.sendReply(ctx, u, fmt.Sprintf("Removed %d items from your queue.", removed))
+}
+
+// enqueueFromCommand adds the replied-to media to the queue, at the front if next is set. Without
+// a reply it lists the queue.
+func (b *TelegramBot) enqueueFromCommand(ctx *ext.Context, u *ext.Update, next bool) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	messageID, isReply := replyToMessageID(u)
+	if !isReply {
+		return b.sendQueue(ctx, u, user.UserID)
+	}
+
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return b.sendReply(ctx, u, "The replied message does not contain supported media.")
+	}
+
+	if next {
+		err = b.playlistRepository.EnqueueNext(user.UserID, messageID, file.FileName)
+	} else {
+		err = b.playlistRepository.Enqueue(user.UserID, messageID, file.FileName)
+	}
+	if err != nil {
+		b.logger.Printf("Failed to enqueue message ID %d for user %d: %v", messageID, user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to add the media to your queue.")
+	}
+	b.publishQueue(u.EffectiveChat().GetID(), user.UserID)
+
+	if next {
+		return b.sendReply(ctx, u, fmt.Sprintf("%s will play next.", file.FileName))
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("Added %s to your queue.", file.FileName))
+}
+
+// sendQueue replies with the items of the user's queue.
+func (b *TelegramBot) sendQueue(ctx *ext.Context, u *ext.Update, userID int64) error {
+	queue, err := b.playlistRepository.ListQueue(userID)
+	if err != nil {
+		b.logger.Printf("Failed to list queue of user %d: %v", userID, err)
+		return b.sendReply(ctx, u, "Failed to load your queue.")
+	}
+	if len(queue) == 0 {
+		return b.sendReply(ctx, u, "Your queue is empty. Reply to a media message with /queue to add it.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Your queue:\n")
+	for i, item := range queue {
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, item.FileName)
+	}
+	return b.sendReply(ctx, u, sb.String())
+}
+
+// playNext removes the first item from the queue and sends it to the chat's player. It returns
+// nil if the queue is empty.
+func (b *TelegramBot) playNext(ctx context.Context, chatID int64) (*data.QueueItem, error) {
+	item, err := b.playlistRepository.PopNext(playerUserID(chatID))
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	file, err := utils.FileFromMessage(ctx, b.tgClient, item.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch queued message %d: %w", item.MessageID, err)
+	}
+	b.publishMedia(chatID, item.MessageID, b.constructWebSocketMessage(item.MessageID, b.generateFileURL(item.MessageID, file), file))
+	b.recordPlayed(chatID, item.MessageID)
+	b.publishQueue(chatID, chatID)
+	return item, nil
+}
+
+// publishQueue sends the user's current queue to the chat's player.
+func (b *TelegramBot) publishQueue(chatID, userID int64) {
+	queue, err := b.playlistRepository.ListQueue(userID)
+	if err != nil {
+		b.logger.Printf("Failed to list queue of user %d: %v", userID, err)
+		return
+	}
+	encoded, err := json.Marshal(queue)
+	if err != nil {
+		b.logger.Printf("Error encoding queue: %v", err)
+		return
+	}
+	b.publishToWebSocket(chatID, map[string]string{
+		"type":  wsMessageTypeQueueUpdate,
+		"queue": string(encoded),
+	})
+}
+
+// handleMediaEnded auto-advances the player to the next queued item when playback finishes.
+func (b *TelegramBot) handleMediaEnded(chatID int64, report *playerReport) {
+	if b.rooms.isGuest(chatID) {
+		return
+	}
+	item, err := b.playNext(b.tgCtx, chatID)
+	if err != nil {
+		b.logger.Printf("Failed to advance queue of chat ID %d after message ID %d: %v", chatID, report.MessageID, err)
+		return
+	}
+	if item != nil {
+		b.logger.Printf("Advanced queue of chat ID %d to message ID %d", chatID, item.MessageID)
+	}
+}
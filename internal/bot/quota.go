@@ -0,0 +1,326 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+// mediaOwner returns the user who sent a media message, or 0 if it is not known.
+func (b *TelegramBot) mediaOwner(messageID int) int64 {
+	userID, ok, err := b.mediaRepository.OwnerOf(messageID)
+	if err != nil {
+		b.logger.Printf("Failed to look up the owner of message ID %d: %v", messageID, err)
+	}
+	if !ok {
+		return 0
+	}
+	return userID
+}
+
+// quotaFor returns the quota that applies to a user: their own if an admin set one, otherwise
+// the configured default. Admins are not limited by the default.
+func (b *TelegramBot) quotaFor(userID int64) (data.Quota, error) {
+	quota, ok, err := b.quotaRepository.GetQuota(userID)
+	if err != nil || ok {
+		return quota, err
+	}
+	user, err := b.userRepository.GetUserInfo(userID)
+	if err == nil && user.IsAdmin {
+		return data.Quota{}, nil
+	}
+	live := b.live.Load()
+	return data.Quota{DailyBytes: live.QuotaDailyBytes, MonthlyBytes: live.QuotaMonthlyBytes}, nil
+}
+
+// fileSizeLimit returns the largest file a user may bridge: their own limit if an admin set one,
+// otherwise the configured default. Admins are not limited by the default. No limit goes above
+// the largest file that can be streamed.
+func (b *TelegramBot) fileSizeLimit(userID int64) (int64, error) {
+	limit, ok, err := b.quotaRepository.GetFileSizeLimit(userID)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		limit = b.live.Load().MaxFileSize
+		if user, err := b.userRepository.GetUserInfo(userID); err == nil && user.IsAdmin {
+			limit = 0
+		}
+	}
+	if limit <= 0 || limit > reader.MaxSupportedFileSize {
+		limit = reader.MaxSupportedFileSize
+	}
+	return limit, nil
+}
+
+// rejectLargeFile replies and returns true if a file is larger than the user may bridge.
+func (b *TelegramBot) rejectLargeFile(ctx *ext.Context, u *ext.Update, lang string, userID int64, file *types.DocumentFile) bool {
+	if file.FileSize > reader.MaxSupportedFileSize {
+		b.logger.Printf("File of %d bytes from user %d exceeds the supported maximum", file.FileSize, userID)
+		b.sendReply(ctx, u, i18n.T(lang, "media.tooLarge", reader.MaxSupportedFileSize))
+		return true
+	}
+	limit, err := b.fileSizeLimit(userID)
+	if err != nil {
+		// Failing open, as with quotas, keeps the bot usable when the database has a hiccup.
+		b.logger.Printf("Failed to load the file size limit of user %d: %v", userID, err)
+		return false
+	}
+	if file.FileSize > limit {
+		b.logger.Printf("File of %d bytes from user %d exceeds their limit of %d bytes", file.FileSize, userID, limit)
+		b.sendReply(ctx, u, i18n.T(lang, "media.overLimit", formatBytes(file.FileSize), formatBytes(limit)))
+		return true
+	}
+	return false
+}
+
+// checkQuota reports whether the user may stream more. If not, it writes a 429 response that
+// tells the client when the quota resets. Streams that have started are not cut off, so a user
+// may go over the quota by the rest of one response.
+func (b *TelegramBot) checkQuota(w http.ResponseWriter, userID int64) bool {
+	quota, err := b.quotaFor(userID)
+	if err != nil {
+		// Failing open keeps the media playable when the database has a hiccup.
+		b.logger.Printf("Failed to load the quota of user %d: %v", userID, err)
+		return true
+	}
+
+	now := time.Now().UTC()
+	if quota.DailyBytes > 0 {
+		used, err := b.quotaRepository.UsageToday(userID, now)
+		if err == nil && used >= quota.DailyBytes {
+			b.rejectOverQuota(w, userID, "daily", now.Truncate(24*time.Hour).Add(24*time.Hour))
+			return false
+		}
+	}
+	if quota.MonthlyBytes > 0 {
+		used, err := b.quotaRepository.UsageThisMonth(userID, now)
+		if err == nil && used >= quota.MonthlyBytes {
+			b.rejectOverQuota(w, userID, "monthly", time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC))
+			return false
+		}
+	}
+	return true
+}
+
+func (b *TelegramBot) rejectOverQuota(w http.ResponseWriter, userID int64, period string, resetAt time.Time) {
+	b.logger.Printf("User %d is over the %s quota", userID, period)
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+	web.Error(w, fmt.Sprintf("The %s streaming quota of this media's owner is used up", period), http.StatusTooManyRequests)
+}
+
+// cachedBytes returns how much of the binary cache holds files the user has sent.
+func (b *TelegramBot) cachedBytes(userID int64) (int64, error) {
+	documentIDs, err := b.mediaRepository.DocumentIDs(userID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, id := range documentIDs {
+		for _, span := range b.config.BinaryCache.CachedSpans(id, b.config.ChunkSize) {
+			total += span[1] - span[0]
+		}
+	}
+	return total, nil
+}
+
+// usageReport describes the usage and quota of a user.
+func (b *TelegramBot) usageReport(userID int64) (string, error) {
+	now := time.Now()
+	today, err := b.quotaRepository.UsageToday(userID, now)
+	if err != nil {
+		return "", err
+	}
+	month, err := b.quotaRepository.UsageThisMonth(userID, now)
+	if err != nil {
+		return "", err
+	}
+	quota, err := b.quotaFor(userID)
+	if err != nil {
+		return "", err
+	}
+	cached, err := b.cachedBytes(userID)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Streamed today: %s\n", formatUsage(today, quota.DailyBytes))
+	fmt.Fprintf(&sb, "Streamed this month: %s\n", formatUsage(month, quota.MonthlyBytes))
+	fmt.Fprintf(&sb, "Cache space used by your media: %s", formatBytes(cached))
+	return sb.String(), nil
+}
+
+func formatUsage(used, limit int64) string {
+	if limit <= 0 {
+		return formatBytes(used) + " (no limit)"
+	}
+	return fmt.Sprintf("%s of %s", formatBytes(used), formatBytes(limit))
+}
+
+// handleUsageCommand shows users how much their media was streamed and how much of the cache
+// it takes.
+func (b *TelegramBot) handleUsageCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	report, err := b.usageReport(user.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to build the usage report of user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to load your usage.")
+	}
+	return b.sendReply(ctx, u, report)
+}
+
+// handleQuotaCommand shows or sets the streaming quota of a user.
+// Usage: /quota <user_id> [<limit> [daily|monthly] | off]
+func (b *TelegramBot) handleQuotaCommand(ctx *ext.Context, u *ext.Update) error {
+	adminID := u.EffectiveUser().ID
+	userInfo, err := b.userRepository.GetUserInfo(adminID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to update the quota.")
+	}
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	const usage = "Usage: /quota <user_id> [<limit> [daily|monthly] | off], with limits such as 500MB or 20GB"
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		return b.sendReply(ctx, u, usage)
+	}
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return b.sendReply(ctx, u, "Invalid user ID.")
+	}
+
+	if len(args) > 2 {
+		if err := b.updateQuota(userID, args[2:]); err != nil {
+			if errors.Is(err, errQuotaUsage) {
+				return b.sendReply(ctx, u, usage)
+			}
+			b.logger.Printf("Failed to update the quota of user %d: %v", userID, err)
+			return b.sendReply(ctx, u, "Failed to update the quota.")
+		}
+		b.logger.Printf("Admin %d changed the quota of user %d to %s", adminID, userID, strings.Join(args[2:], " "))
+	}
+
+	report, err := b.usageReport(userID)
+	if err != nil {
+		b.logger.Printf("Failed to build the usage report of user %d: %v", userID, err)
+		return b.sendReply(ctx, u, "Failed to load the usage.")
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("User %d\n%s", userID, report))
+}
+
+var errQuotaUsage = errors.New("invalid quota arguments")
+
+// updateQuota applies the arguments of /quota after the user ID. A limit without a period is
+// monthly; "off" removes the user's own quota, so the default applies again.
+func (b *TelegramBot) updateQuota(userID int64, args []string) error {
+	if args[0] == "off" {
+		return b.quotaRepository.DeleteQuota(userID)
+	}
+	limit, err := parseByteSize(args[0])
+	if err != nil {
+		return errQuotaUsage
+	}
+	period := "monthly"
+	if len(args) > 1 {
+		period = args[1]
+	}
+
+	quota, err := b.quotaFor(userID)
+	if err != nil {
+		return err
+	}
+	switch period {
+	case "daily":
+		quota.DailyBytes = limit
+	case "monthly":
+		quota.MonthlyBytes = limit
+	default:
+		return errQuotaUsage
+	}
+	return b.quotaRepository.SetQuota(userID, quota)
+}
+
+// parseByteSize parses sizes such as 1048576, 500MB or 1.5GB, in powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if strings.HasSuffix(s, suffix) {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	s = strings.TrimSuffix(s, "B")
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// handleLimitCommand shows or sets the largest file a user may bridge.
+// Usage: /limit <user_id> [<size> | off]
+func (b *TelegramBot) handleLimitCommand(ctx *ext.Context, u *ext.Update) error {
+	adminID := u.EffectiveUser().ID
+	userInfo, err := b.userRepository.GetUserInfo(adminID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to update the file size limit.")
+	}
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	const usage = "Usage: /limit <user_id> [<size> | off], with sizes such as 2147483648, 500MB or 2GB"
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 || len(args) > 3 {
+		return b.sendReply(ctx, u, usage)
+	}
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return b.sendReply(ctx, u, "Invalid user ID.")
+	}
+
+	if len(args) == 3 {
+		if args[2] == "off" {
+			err = b.quotaRepository.DeleteFileSizeLimit(userID)
+		} else {
+			limit, parseErr := parseByteSize(args[2])
+			if parseErr != nil || limit <= 0 {
+				return b.sendReply(ctx, u, usage)
+			}
+			err = b.quotaRepository.SetFileSizeLimit(userID, limit)
+		}
+		if err != nil {
+			b.logger.Printf("Failed to update the file size limit of user %d: %v", userID, err)
+			return b.sendReply(ctx, u, "Failed to update the file size limit.")
+		}
+		b.logger.Printf("Admin %d changed the file size limit of user %d to %s", adminID, userID, args[2])
+	}
+
+	limit, err := b.fileSizeLimit(userID)
+	if err != nil {
+		b.logger.Printf("Failed to load the file size limit of user %d: %v", userID, err)
+		return b.sendReply(ctx, u, "Failed to load the file size limit.")
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("User %d can bridge files of up to %s.", userID, formatBytes(limit)))
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"webBridgeBot/internal/config"
+	logging "webBridgeBot/internal/logger"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/web"
+)
+
+// liveSettings are the settings applied again when the configuration is reloaded. The rest of
+// b.config is fixed while the bot runs; handlers read these from b.live instead.
+type liveSettings struct {
+	LogLevel                  string
+	RateLimitPerIP            int
+	RateLimitPerChat          int
+	RateLimitBurst            int
+	TelegramRequestsPerSecond int
+	TelegramRequestBurst      int
+	MaxStreamBandwidth        int64
+	MaxTotalBandwidth         int64
+	MaxStreamsPerUser         int
+	QuotaDailyBytes           int64
+	QuotaMonthlyBytes         int64
+	MaxFileSize               int64
+	PlayerTitle               string
+	PlayerAccentColor         string
+	PlayerLogoURL             string
+	PlayerCustomCSS           string
+
+	// bandwidth caps all streams started with these settings together; nil is unlimited.
+	bandwidth *web.Bandwidth
+}
+
+func newLiveSettings(cfg *config.Configuration) *liveSettings {
+	return &liveSettings{
+		LogLevel:                  cfg.LogLevel,
+		RateLimitPerIP:            cfg.RateLimitPerIP,
+		RateLimitPerChat:          cfg.RateLimitPerChat,
+		RateLimitBurst:            cfg.RateLimitBurst,
+		TelegramRequestsPerSecond: cfg.TelegramRequestsPerSecond,
+		TelegramRequestBurst:      cfg.TelegramRequestBurst,
+		MaxStreamBandwidth:        cfg.MaxStreamBandwidth,
+		MaxTotalBandwidth:         cfg.MaxTotalBandwidth,
+		MaxStreamsPerUser:         cfg.MaxStreamsPerUser,
+		QuotaDailyBytes:           cfg.QuotaDailyBytes,
+		QuotaMonthlyBytes:         cfg.QuotaMonthlyBytes,
+		MaxFileSize:               cfg.MaxFileSize,
+		PlayerTitle:               cfg.PlayerTitle,
+		PlayerAccentColor:         cfg.PlayerAccentColor,
+		PlayerLogoURL:             cfg.PlayerLogoURL,
+		PlayerCustomCSS:           cfg.PlayerCustomCSS,
+		bandwidth:                 web.NewBandwidth(cfg.MaxTotalBandwidth),
+	}
+}
+
+// changes lists the settings that differ from old, as NAME=value.
+func (s *liveSettings) changes(old *liveSettings) []string {
+	settings := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"LOG_LEVEL", old.LogLevel, s.LogLevel},
+		{"RATE_LIMIT_PER_IP", old.RateLimitPerIP, s.RateLimitPerIP},
+		{"RATE_LIMIT_PER_CHAT", old.RateLimitPerChat, s.RateLimitPerChat},
+		{"RATE_LIMIT_BURST", old.RateLimitBurst, s.RateLimitBurst},
+		{"TELEGRAM_REQUESTS_PER_SECOND", old.TelegramRequestsPerSecond, s.TelegramRequestsPerSecond},
+		{"TELEGRAM_REQUEST_BURST", old.TelegramRequestBurst, s.TelegramRequestBurst},
+		{"MAX_STREAM_BANDWIDTH", old.MaxStreamBandwidth, s.MaxStreamBandwidth},
+		{"MAX_TOTAL_BANDWIDTH", old.MaxTotalBandwidth, s.MaxTotalBandwidth},
+		{"MAX_STREAMS_PER_USER", old.MaxStreamsPerUser, s.MaxStreamsPerUser},
+		{"QUOTA_DAILY_BYTES", old.QuotaDailyBytes, s.QuotaDailyBytes},
+		{"QUOTA_MONTHLY_BYTES", old.QuotaMonthlyBytes, s.QuotaMonthlyBytes},
+		{"MAX_FILE_SIZE", old.MaxFileSize, s.MaxFileSize},
+		{"PLAYER_TITLE", old.PlayerTitle, s.PlayerTitle},
+		{"PLAYER_ACCENT_COLOR", old.PlayerAccentColor, s.PlayerAccentColor},
+		{"PLAYER_LOGO_URL", old.PlayerLogoURL, s.PlayerLogoURL},
+		{"PLAYER_CUSTOM_CSS", old.PlayerCustomCSS, s.PlayerCustomCSS},
+	}
+	var changed []string
+	for _, setting := range settings {
+		if setting.old != setting.new {
+			changed = append(changed, fmt.Sprintf("%s=%v", setting.name, setting.new))
+		}
+	}
+	return changed
+}
+
+// reloadConfig reads the configuration again and applies the settings that can change while
+// the bot runs. Nothing is applied if the new configuration has a problem. It returns the
+// settings that changed.
+func (b *TelegramBot) reloadConfig() ([]string, error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+	cfg, err := config.Reload(b.logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	b.ipLimiter.SetLimit(cfg.RateLimitPerIP, cfg.RateLimitBurst)
+	b.chatLimiter.SetLimit(cfg.RateLimitPerChat, cfg.RateLimitBurst)
+	// Validate checked the budget, so setting it cannot fail.
+	reader.SetRequestBudget(cfg.TelegramRequestsPerSecond, cfg.TelegramRequestBurst)
+	if b.workers != nil {
+		for _, w := range b.workers.workers {
+			w.budget.SetRate(cfg.TelegramRequestsPerSecond, cfg.TelegramRequestBurst)
+		}
+	}
+
+	live, old := newLiveSettings(&cfg), b.live.Load()
+	// Keep sharing the bucket of the running streams unless the total changed.
+	if live.MaxTotalBandwidth == old.MaxTotalBandwidth {
+		live.bandwidth = old.bandwidth
+	}
+	b.live.Store(live)
+	return live.changes(old), nil
+}
+
+// runConfigReload reloads the configuration on SIGHUP until ctx is cancelled.
+func (b *TelegramBot) runConfigReload(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-hangup:
+		case <-ctx.Done():
+			return
+		}
+		changed, err := b.reloadConfig()
+		switch {
+		case err != nil:
+			b.logger.Printf("Failed to reload the configuration, keeping the current one: %v", err)
+		case len(changed) == 0:
+			b.logger.Printf("Reloaded the configuration, no live settings changed")
+		default:
+			b.logger.Printf("Reloaded the configuration: %s", strings.Join(changed, ", "))
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	wsMessageTypeRoom     = "room"
+	wsMessageTypeSync     = "sync"
+	wsMessageTypePlayback = "playback"
+
+	playbackActionPlay     = "play"
+	playbackActionPause    = "pause"
+	playbackActionSeek     = "seek"
+	playbackActionPosition = "position" // Periodic report used to correct drift.
+
+	// Players further than this from the room's position are moved back in line.
+	maxPlaybackDrift = 2.0 // seconds
+)
+
+// room is a watch-together session whose members' players are kept in sync.
+type room struct {
+	id      string
+	ownerID int64
+	members map[int64]bool
+
+	// The position of the current media at updatedAt, which advances while playing.
+	messageID int
+	playing   bool
+	position  float64
+	updatedAt time.Time
+}
+
+// currentPosition returns where playback should be now.
+func (r *room) currentPosition() float64 {
+	if !r.playing {
+		return r.position
+	}
+	return r.position + time.Since(r.updatedAt).Seconds()
+}
+
+// roomManager keeps the rooms in memory. They last until they are closed or the bot restarts.
+type roomManager struct {
+	mu     sync.Mutex
+	rooms  map[string]*room
+	byChat map[int64]*room
+}
+
+func newRoomManager() *roomManager {
+	return &roomManager{rooms: make(map[string]*room), byChat: make(map[int64]*room)}
+}
+
+// members returns the members of the room the chat belongs to, or nil if it is in no room.
+func (m *roomManager) members(chatID int64) []int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.byChat[chatID]
+	if !ok {
+		return nil
+	}
+	members := make([]int64, 0, len(r.members))
+	for member := range r.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+	return members
+}
+
+// isGuest reports whether the chat is in a room it does not own. Guests follow the owner's
+// playback, including the owner's queue.
+func (m *roomManager) isGuest(chatID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.byChat[chatID]
+	return ok && r.ownerID != chatID
+}
+
+// handleRoomCommand manages watch-together rooms.
+// Usage: /room [create | invite <user_id> | leave | close]
+func (b *TelegramBot) handleRoomCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		return b.sendReply(ctx, u, b.roomStatus(user.UserID))
+	}
+
+	m := b.rooms
+	switch args[1] {
+	case "create":
+		if !user.IsAdmin {
+			return b.sendReply(ctx, u, "Only administrators can create rooms.")
+		}
+		id, err := newRoomID()
+		if err != nil {
+			b.logger.Printf("Failed to generate room ID: %v", err)
+			return b.sendReply(ctx, u, "Failed to create the room.")
+		}
+		m.mu.Lock()
+		if _, ok := m.byChat[user.UserID]; ok {
+			m.mu.Unlock()
+			return b.sendReply(ctx, u, "You are already in a room. Leave it first with /room leave.")
+		}
+		r := &room{id: id, ownerID: user.UserID, members: map[int64]bool{user.UserID: true}, updatedAt: time.Now()}
+		m.rooms[id] = r
+		m.byChat[user.UserID] = r
+		m.mu.Unlock()
+
+		b.logger.Printf("User %d created room %s", user.UserID, id)
+		b.publishToWebSocket(user.UserID, map[string]string{"type": wsMessageTypeRoom, "room": id})
+		return b.sendReply(ctx, u, fmt.Sprintf("Room %s created. Invite others with /room invite <user_id>; everything you play is then played for everyone.", id))
+
+	case "invite":
+		if len(args) < 3 {
+			return b.sendReply(ctx, u, "Usage: /room invite <user_id>")
+		}
+		targetID, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return b.sendReply(ctx, u, "Invalid user ID.")
+		}
+		target, err := b.userRepository.GetUserInfo(targetID)
+		if err != nil || !target.IsAuthorized {
+			return b.sendReply(ctx, u, "Only authorized users can be invited.")
+		}
+
+		m.mu.Lock()
+		r, ok := m.byChat[user.UserID]
+		if !ok || (r.ownerID != user.UserID && !user.IsAdmin) {
+			m.mu.Unlock()
+			return b.sendReply(ctx, u, "Only the owner of a room can invite to it.")
+		}
+		if _, busy := m.byChat[targetID]; busy {
+			m.mu.Unlock()
+			return b.sendReply(ctx, u, "That user is already in a room.")
+		}
+		r.members[targetID] = true
+		m.byChat[targetID] = r
+		messageID, position, playing := r.messageID, r.currentPosition(), r.playing
+		m.mu.Unlock()
+
+		b.publishToWebSocket(target.ChatID, map[string]string{"type": wsMessageTypeRoom, "room": r.id})
+		if messageID != 0 {
+			b.sendRoomMedia(ctx, target.ChatID, messageID, position, playing)
+		}
+		_, err = ctx.SendMessage(target.ChatID, &tg.MessagesSendMessageRequest{
+			Message: fmt.Sprintf("%s added you to watch-together room %s. Your web player now follows the room; leave it with /room leave.", u.EffectiveUser().FirstName, r.id),
+		})
+		if err != nil {
+			b.logger.Printf("Failed to notify user %d about room %s: %v", targetID, r.id, err)
+		}
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d joined room %s.", targetID, r.id))
+
+	case "leave", "close":
+		m.mu.Lock()
+		r, ok := m.byChat[user.UserID]
+		if !ok {
+			m.mu.Unlock()
+			return b.sendReply(ctx, u, "You are not in a room.")
+		}
+		leaving := []int64{user.UserID}
+		// The room ends when its owner leaves or closes it.
+		if args[1] == "close" || r.ownerID == user.UserID {
+			if r.ownerID != user.UserID && !user.IsAdmin {
+				m.mu.Unlock()
+				return b.sendReply(ctx, u, "Only the owner of a room can close it.")
+			}
+			leaving = leaving[:0]
+			for member := range r.members {
+				leaving = append(leaving, member)
+			}
+			delete(m.rooms, r.id)
+		}
+		for _, member := range leaving {
+			delete(r.members, member)
+			delete(m.byChat, member)
+		}
+		m.mu.Unlock()
+
+		for _, member := range leaving {
+			b.publishToWebSocket(member, map[string]string{"type": wsMessageTypeRoom, "room": ""})
+		}
+		if len(leaving) > 1 || r.ownerID == user.UserID {
+			return b.sendReply(ctx, u, fmt.Sprintf("Room %s closed.", r.id))
+		}
+		return b.sendReply(ctx, u, fmt.Sprintf("You left room %s.", r.id))
+	}
+	return b.sendReply(ctx, u, "Usage: /room [create | invite <user_id> | leave | close]")
+}
+
+// roomStatus describes the room the user is in.
+func (b *TelegramBot) roomStatus(userID int64) string {
+	m := b.rooms
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.byChat[userID]
+	if !ok {
+		return "You are not in a room. Administrators can create one with /room create."
+	}
+	var members []string
+	for member := range r.members {
+		members = append(members, strconv.FormatInt(member, 10))
+	}
+	sort.Strings(members)
+	state := "paused"
+	if r.playing {
+		state = "playing"
+	}
+	return fmt.Sprintf("Room %s (owner %d)\nMembers: %s\nPlayback: %s at %s", r.id, r.ownerID, strings.Join(members, ", "), state, formatPosition(r.currentPosition()))
+}
+
+// publishMedia sends a media message to the chat's player, or to every player of its room. A
+// new media item restarts the room's playback position.
+func (b *TelegramBot) publishMedia(chatID int64, messageID int, message map[string]string) {
+	m := b.rooms
+	m.mu.Lock()
+	r, ok := m.byChat[chatID]
+	if ok {
+		r.messageID = messageID
+		r.playing = true
+		r.position = 0
+		r.updatedAt = time.Now()
+	}
+	m.mu.Unlock()
+	if !ok {
+		b.publishToWebSocket(chatID, message)
+		return
+	}
+	for _, member := range m.members(chatID) {
+		b.publishToWebSocket(member, message)
+	}
+}
+
+// sendRoomMedia brings a player that joins a room to the room's media and position.
+func (b *TelegramBot) sendRoomMedia(ctx context.Context, chatID int64, messageID int, position float64, playing bool) {
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return
+	}
+	b.publishToWebSocket(chatID, b.constructWebSocketMessage(messageID, b.generateFileURL(messageID, file), file))
+	b.sendSync(chatID, messageID, playbackActionSeek, position, playing)
+}
+
+// handlePlaybackReport applies a play, pause or seek of a room member to the room and forwards
+// it to the other members. Position reports only correct players that drifted too far.
+func (b *TelegramBot) handlePlaybackReport(chatID int64, report *playerReport) {
+	m := b.rooms
+	m.mu.Lock()
+	r, ok := m.byChat[chatID]
+	if !ok || report.MessageID != r.messageID {
+		m.mu.Unlock()
+		return
+	}
+
+	if report.Action == playbackActionPosition {
+		expected, playing := r.currentPosition(), r.playing
+		m.mu.Unlock()
+		if math.Abs(report.Position-expected) > maxPlaybackDrift {
+			b.sendSync(chatID, report.MessageID, playbackActionSeek, expected, playing)
+		}
+		return
+	}
+
+	// Players that were just synced report the change back; it is not applied twice.
+	if math.Abs(report.Position-r.currentPosition()) < 0.5 &&
+		(report.Action == playbackActionSeek || r.playing == (report.Action == playbackActionPlay)) {
+		m.mu.Unlock()
+		return
+	}
+
+	switch report.Action {
+	case playbackActionPlay:
+		r.playing = true
+	case playbackActionPause:
+		r.playing = false
+	case playbackActionSeek:
+	default:
+		m.mu.Unlock()
+		return
+	}
+	r.position = report.Position
+	r.updatedAt = time.Now()
+	playing := r.playing
+	m.mu.Unlock()
+
+	for _, member := range m.members(chatID) {
+		if member != chatID {
+			b.sendSync(member, report.MessageID, report.Action, report.Position, playing)
+		}
+	}
+}
+
+// sendSync tells a player to move to a position and to play or pause.
+func (b *TelegramBot) sendSync(chatID int64, messageID int, action string, position float64, playing bool) {
+	b.publishToWebSocket(chatID, map[string]string{
+		"type":      wsMessageTypeSync,
+		"action":    action,
+		"messageId": strconv.Itoa(messageID),
+		"position":  strconv.FormatFloat(position, 'f', 3, 64),
+		"playing":   strconv.FormatBool(playing),
+	})
+}
+
+// announceRoom tells a player that connects which room its chat is in.
+func (b *TelegramBot) announceRoom(chatID int64) {
+	m := b.rooms
+	m.mu.Lock()
+	r, ok := m.byChat[chatID]
+	var id string
+	var messageID int
+	var position float64
+	var playing bool
+	if ok {
+		id, messageID, position, playing = r.id, r.messageID, r.currentPosition(), r.playing
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	b.publishToWebSocket(chatID, map[string]string{"type": wsMessageTypeRoom, "room": id})
+	if messageID != 0 {
+		b.sendRoomMedia(b.tgCtx, chatID, messageID, position, playing)
+	}
+}
+
+func newRoomID() (string, error) {
+	buf := make([]byte, 3)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// formatPosition formats a playback position in seconds as m:ss or h:mm:ss.
+func formatPosition(seconds float64) string {
+	total := int(seconds)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
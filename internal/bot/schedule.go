@@ -0,0 +1,214 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	scheduleCheckInterval = 30 * time.Second
+	// maxScheduledJobs is how many jobs a user may have pending.
+	maxScheduledJobs = 20
+	// maxScheduleAhead is how far ahead media can be scheduled.
+	maxScheduleAhead = 365 * 24 * time.Hour
+)
+
+var errScheduleTime = errors.New("invalid time")
+
+// parseScheduleTime parses the time of /schedule: a delay such as 30m or 1h30m, a time of day
+// such as 21:00, which is the next one to come, or a date and time such as 2024-05-01 21:00.
+// Times of day and dates are in UTC.
+func parseScheduleTime(args []string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	text := strings.Join(args, " ")
+	if delay, err := time.ParseDuration(text); err == nil {
+		if delay <= 0 {
+			return time.Time{}, errScheduleTime
+		}
+		return now.Add(delay), nil
+	}
+	if clock, err := time.Parse("15:04", text); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04"} {
+		if at, err := time.Parse(layout, text); err == nil {
+			if !at.After(now) {
+				return time.Time{}, errScheduleTime
+			}
+			return at, nil
+		}
+	}
+	return time.Time{}, errScheduleTime
+}
+
+// handleScheduleCommand schedules the replied-to media to play in the user's player later.
+func (b *TelegramBot) handleScheduleCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	const usage = "Usage: reply to a media message with /schedule <time>, where the time is a delay such as 30m or 2h, a time of day such as 21:00, or a date such as 2024-05-01 21:00 (UTC)."
+	args := strings.Fields(u.EffectiveMessage.Text)
+	messageID, isReply := replyToMessageID(u)
+	if len(args) < 2 || !isReply {
+		return b.sendReply(ctx, u, usage)
+	}
+	now := time.Now()
+	runAt, err := parseScheduleTime(args[1:], now)
+	if err != nil {
+		return b.sendReply(ctx, u, usage)
+	}
+	if runAt.Sub(now) > maxScheduleAhead {
+		return b.sendReply(ctx, u, "Media can be scheduled up to a year ahead.")
+	}
+
+	pending, err := b.scheduleRepository.CountJobs(user.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to count scheduled jobs of user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to schedule the media.")
+	}
+	if pending >= maxScheduledJobs {
+		return b.sendReply(ctx, u, fmt.Sprintf("You already have %d scheduled items. Cancel some with /unschedule first.", pending))
+	}
+
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return b.sendReply(ctx, u, "The replied message does not contain supported media.")
+	}
+
+	err = b.scheduleRepository.AddJob(&data.ScheduledJob{
+		UserID:    user.UserID,
+		ChatID:    user.ChatID,
+		MessageID: messageID,
+		FileName:  file.FileName,
+		RunAt:     runAt,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to schedule message ID %d for user %d: %v", messageID, user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to schedule the media.")
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("%s will play in your web player at %s UTC. See /schedules to list or cancel it.", file.FileName, runAt.Format("2006-01-02 15:04")))
+}
+
+// handleSchedulesCommand lists the user's scheduled media.
+func (b *TelegramBot) handleSchedulesCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	userID := user.UserID
+	jobs, err := b.scheduleRepository.ListJobs(userID)
+	if err != nil {
+		b.logger.Printf("Failed to list scheduled jobs of user %d: %v", userID, err)
+		return b.sendReply(ctx, u, "Failed to list your scheduled media.")
+	}
+	if len(jobs) == 0 {
+		return b.sendReply(ctx, u, "Nothing is scheduled. Reply to a media message with /schedule <time> to schedule it.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Scheduled media (UTC):")
+	for _, job := range jobs {
+		fmt.Fprintf(&sb, "\n%d. %s at %s", job.ID, job.FileName, job.RunAt.UTC().Format("2006-01-02 15:04"))
+	}
+	sb.WriteString("\n\nCancel one with /unschedule <number>.")
+	return b.sendReply(ctx, u, sb.String())
+}
+
+// handleUnscheduleCommand cancels one of the user's scheduled media.
+func (b *TelegramBot) handleUnscheduleCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 {
+		return b.sendReply(ctx, u, "Usage: /unschedule <number>, with the number shown by /schedules")
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return b.sendReply(ctx, u, "Invalid number.")
+	}
+
+	userID := user.UserID
+	cancelled, err := b.scheduleRepository.CancelJob(userID, id)
+	if err != nil {
+		b.logger.Printf("Failed to cancel scheduled job %d of user %d: %v", id, userID, err)
+		return b.sendReply(ctx, u, "Failed to cancel the scheduled media.")
+	}
+	if !cancelled {
+		return b.sendReply(ctx, u, fmt.Sprintf("You have no scheduled media with number %d.", id))
+	}
+	return b.sendReply(ctx, u, "The scheduled media has been cancelled.")
+}
+
+// runScheduler plays scheduled media when its time comes, until ctx is done. Jobs missed while
+// the bot was down run as soon as it is back.
+func (b *TelegramBot) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		jobs, err := b.scheduleRepository.DueJobs(time.Now())
+		if err != nil {
+			b.logger.Printf("Failed to load scheduled jobs: %v", err)
+			continue
+		}
+		for _, job := range jobs {
+			b.runScheduledJob(job)
+		}
+	}
+}
+
+// runScheduledJob plays the media of a job in the user's player and reminds them in the chat.
+// The job is removed first, so it runs at most once.
+func (b *TelegramBot) runScheduledJob(job data.ScheduledJob) {
+	if err := b.scheduleRepository.DeleteJob(job.ID); err != nil {
+		b.logger.Printf("Failed to remove scheduled job %d: %v", job.ID, err)
+		return
+	}
+	user, err := b.userRepository.GetUserInfo(job.UserID)
+	if err != nil || !user.IsAuthorized {
+		b.logger.Printf("Skipping scheduled job %d of user %d, who is not authorized", job.ID, job.UserID)
+		return
+	}
+
+	file, err := utils.FileFromMessage(b.tgCtx, b.tgClient, job.MessageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for scheduled message ID %d: %v", job.MessageID, err)
+		return
+	}
+	fileURL := b.generateFileURL(job.MessageID, file)
+	b.publishMedia(job.ChatID, job.MessageID, b.constructWebSocketMessage(job.MessageID, fileURL, file))
+	b.logger.Printf("Played scheduled message ID %d in chat ID %d", job.MessageID, job.ChatID)
+
+	_, err = b.tgCtx.SendMessage(job.ChatID, &tg.MessagesSendMessageRequest{
+		Message: fmt.Sprintf("It is time for %s. It is playing in your web player: %s", file.FileName, fileURL),
+		ReplyTo: &tg.InputReplyToMessage{ReplyToMsgID: job.MessageID},
+	})
+	if err != nil {
+		b.logger.Printf("Failed to send the reminder of scheduled job %d: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"webBridgeBot/internal/data"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	callbackSearch = "cb_Search"
+
+	// The term is carried in the callback data of the page buttons, which Telegram limits to
+	// 64 bytes.
+	maxSearchTermLength = 48
+)
+
+// handleSearchCommand searches the file names, audio titles, performers and captions of the
+// media the user has sent.
+func (b *TelegramBot) handleSearchCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	term := strings.Join(args[1:], " ")
+	if term == "" {
+		return b.sendReply(ctx, u, "Usage: /search <term>")
+	}
+	if len(term) > maxSearchTermLength {
+		return b.sendReply(ctx, u, fmt.Sprintf("Search terms can be at most %d characters long.", maxSearchTermLength))
+	}
+
+	text, markup, err := b.searchPage(user.UserID, term, 1)
+	if err != nil {
+		b.logger.Printf("Failed to search media of user %d: %v", user.UserID, err)
+		return b.sendReply(ctx, u, "Failed to search your media.")
+	}
+
+	_, err = ctx.Reply(u, text, &ext.ReplyOpts{Markup: markup})
+	if err != nil {
+		b.logger.Printf("Failed to send search results to user %d: %v", user.UserID, err)
+	}
+	return err
+}
+
+// handleSearchCallback shows another page of search results.
+func (b *TelegramBot) handleSearchCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 {
+		return nil
+	}
+	page, err := strconv.Atoi(dataParts[1])
+	if err != nil || page < 1 {
+		return nil
+	}
+	// The term may itself contain commas.
+	term := strings.Join(dataParts[2:], ",")
+
+	userID := u.CallbackQuery.UserID
+	text, markup, err := b.searchPage(userID, term, page)
+	if err != nil {
+		b.logger.Printf("Failed to search media of user %d: %v", userID, err)
+		return err
+	}
+
+	_, err = ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
+		ID:          u.CallbackQuery.MsgID,
+		Message:     text,
+		ReplyMarkup: markup,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to edit search results for user %d: %v", userID, err)
+	}
+
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{QueryID: u.CallbackQuery.QueryID})
+	return nil
+}
+
+// searchPage renders one page of search results with a button per item to play it again.
+func (b *TelegramBot) searchPage(userID int64, term string, page int) (string, *tg.ReplyInlineMarkup, error) {
+	// Fetch one extra item to find out whether there is a next page.
+	items, err := b.mediaRepository.Search(userID, term, historyPageSize+1, (page-1)*historyPageSize)
+	if err != nil {
+		return "", nil, err
+	}
+	hasMore := len(items) > historyPageSize
+	if hasMore {
+		items = items[:historyPageSize]
+	}
+
+	if len(items) == 0 {
+		if page > 1 {
+			return fmt.Sprintf("Results for \"%s\": there is no page %d.", term, page), nil, nil
+		}
+		return fmt.Sprintf("No media matches \"%s\".", term), nil, nil
+	}
+
+	text, markup := mediaListPage(fmt.Sprintf("Results for \"%s\"", term), items, page, func(item data.MediaItem) time.Time {
+		return item.SentAt
+	})
+	addPageButtons(markup, page, hasMore, "« Previous", "Next »", func(page int) string {
+		return fmt.Sprintf("%s,%d,%s", callbackSearch, page, term)
+	})
+	return text, markup, nil
+}
@@ -0,0 +1,205 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/webhook"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// errSessionInUse reports that another running instance holds the lock of a session.
+var errSessionInUse = errors.New("session is in use by another running instance")
+
+// sessionInvalidErrors are the errors with which Telegram rejects requests of a session it no
+// longer accepts, such as one terminated from another device or used by two clients at once.
+var sessionInvalidErrors = []string{
+	"AUTH_KEY_UNREGISTERED",
+	"AUTH_KEY_INVALID",
+	"AUTH_KEY_DUPLICATED",
+	"SESSION_REVOKED",
+	"SESSION_EXPIRED",
+	"USER_DEACTIVATED",
+	"USER_DEACTIVATED_BAN",
+}
+
+// sessionInvalidated reports whether Telegram rejected a request because the session of the
+// client is no longer valid. Other 400 errors with similar names, such as INPUT_USER_DEACTIVATED,
+// are about the peers of a request instead.
+func sessionInvalidated(err error) bool {
+	rpcErr, ok := tgerr.As(err)
+	if !ok || (rpcErr.Code != 401 && rpcErr.Code != 406) {
+		return false
+	}
+	return tgerr.Is(err, sessionInvalidErrors...)
+}
+
+// sessionGuard keeps a Telegram session from being used by two instances at once, which makes
+// Telegram revoke it with AUTH_KEY_DUPLICATED, and watches for Telegram invalidating it.
+type sessionGuard struct {
+	path string
+	lock *os.File // Nil for sessions kept in memory
+	// stored is whether the session file held a session before the client started.
+	stored bool
+	// loggedIn is set once the client sends a successful login request.
+	loggedIn  atomic.Bool
+	invalid   atomic.Bool
+	onInvalid atomic.Pointer[func(error)]
+}
+
+// newSessionGuard locks the session kept in the SQLite file path. An empty path is a session kept
+// in memory, which needs no lock.
+func newSessionGuard(path string) (*sessionGuard, error) {
+	g := &sessionGuard{path: path}
+	if path == "" {
+		return g, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock session %s: %w", path, err)
+	}
+	g.lock = lock
+	g.stored = storedSession(path)
+	return g, nil
+}
+
+// storedSession reports whether a session file holds a session. gotgproto keeps it in the
+// sessions table.
+func storedSession(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	db, err := data.Open(data.DriverSQLite, fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE LENGTH(data) > 0`).Scan(&n); err != nil {
+		return false
+	}
+	return n > 0
+}
+
+// restrict makes the session file readable by the user running the bot only, since anyone who
+// can read it can act as the bot or the account.
+func (g *sessionGuard) restrict() error {
+	if g.path == "" {
+		return nil
+	}
+	info, err := os.Stat(g.path)
+	if err != nil || info.Mode().Perm()&0o077 == 0 {
+		return err
+	}
+	return os.Chmod(g.path, 0o600)
+}
+
+// release unlocks the session.
+func (g *sessionGuard) release() {
+	if g.lock != nil {
+		g.lock.Close()
+	}
+}
+
+// relogin reports whether the client had to log in again although a session was stored, because
+// Telegram no longer accepted it.
+func (g *sessionGuard) relogin() bool {
+	return g.stored && g.loggedIn.Load()
+}
+
+// watch calls fn, once, when Telegram rejects a request because the session is no longer valid.
+// Before it is called, such errors are expected: a new session is unauthorized until it logs in.
+func (g *sessionGuard) watch(fn func(error)) {
+	g.onInvalid.Store(&fn)
+}
+
+// middleware observes the requests of the client for logins and invalidated sessions.
+func (g *sessionGuard) middleware() telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			if err == nil {
+				switch input.(type) {
+				case *tg.AuthImportBotAuthorizationRequest, *tg.AuthSignInRequest, *tg.AuthCheckPasswordRequest:
+					g.loggedIn.Store(true)
+				}
+				return err
+			}
+			if fn := g.onInvalid.Load(); fn != nil && sessionInvalidated(err) && g.invalid.CompareAndSwap(false, true) {
+				go (*fn)(err)
+			}
+			return err
+		}
+	})
+}
+
+// watchSessions reports the sessions Telegram no longer accepted at startup, which the clients
+// logged in again for, and watches for Telegram invalidating them while the bot runs.
+func (b *TelegramBot) watchSessions() {
+	if b.session.relogin() {
+		b.logger.Printf("Telegram no longer accepted the stored session of @%s, logged in again", b.tgClient.Self.Username)
+		b.webhooks.Send(webhook.EventSessionInvalidated, sessionEvent(b.tgClient, false, "logged in again at startup"))
+		go b.notifyAdmins(fmt.Sprintf("Telegram no longer accepted the stored session of @%s, so it logged in again. If the session was not terminated on purpose, make sure no other instance uses %s.", b.tgClient.Self.Username, b.config.SessionPath))
+	}
+	b.session.watch(b.handleSessionInvalidated)
+
+	if b.workers == nil {
+		return
+	}
+	for _, w := range b.workers.workers {
+		if w.session.relogin() {
+			b.logger.Printf("Telegram no longer accepted the stored session of worker bot @%s, logged in again", w.client.Self.Username)
+		}
+		w.session.watch(func(err error) { b.handleWorkerInvalidated(w, err) })
+	}
+}
+
+// handleSessionInvalidated stops the bot once Telegram invalidated its session. The bot cannot
+// message the admins any more, so it alerts them through the log and webhooks; a bot logs in
+// again when restarted and then tells the admins.
+func (b *TelegramBot) handleSessionInvalidated(err error) {
+	b.logger.Printf("Telegram invalidated the session of @%s: %v", b.tgClient.Self.Username, err)
+	b.webhooks.Send(webhook.EventSessionInvalidated, sessionEvent(b.tgClient, false, err.Error()))
+	b.sessionLost <- err
+}
+
+// handleWorkerInvalidated takes a worker out of the pool once Telegram invalidated its session,
+// so its downloads go to the other workers or the main bot.
+func (b *TelegramBot) handleWorkerInvalidated(w *worker, err error) {
+	w.lost.Store(true)
+	b.logger.Printf("Telegram invalidated the session of worker bot @%s: %v", w.client.Self.Username, err)
+	b.webhooks.Send(webhook.EventSessionInvalidated, sessionEvent(w.client, true, err.Error()))
+	b.notifyAdmins(fmt.Sprintf("Telegram invalidated the session of worker bot @%s (%v), so it no longer downloads files. Restart the bot to log it in again.", w.client.Self.Username, err))
+}
+
+// sessionEvent returns the data of a session.invalidated event.
+func sessionEvent(client *gotgproto.Client, worker bool, reason string) map[string]any {
+	return map[string]any{
+		"userId":   client.Self.ID,
+		"username": client.Self.Username,
+		"worker":   worker,
+		"reason":   reason,
+	}
+}
+
+// reloginHint tells how to get the bot going again after Telegram invalidated its session.
+func (b *TelegramBot) reloginHint() string {
+	if b.config.ClientType == config.ClientTypeUser {
+		return "Run 'webBridgeBot login' to log in again"
+	}
+	return "Restart the bot to log in again, and make sure no other instance uses " + b.config.SessionPath
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package bot
+
+import "os"
+
+// lockFile opens the lock file of a session. File locks are not supported here, so sessions are
+// not protected from being used by two instances.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+}
@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gorilla/mux"
+	"github.com/gotd/td/tg"
+)
+
+const callbackSettings = "cb_Settings"
+
+// handleSettingsCommand shows the current player preferences of the user with buttons to change them.
+func (b *TelegramBot) handleSettingsCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	userID := user.UserID
+	settings, err := b.userRepository.GetUserSettings(userID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", userID, err)
+		return b.sendReply(ctx, u, "Failed to load your settings.")
+	}
+
+	_, err = ctx.Reply(u, settingsMessage(settings), &ext.ReplyOpts{Markup: settingsMarkup(settings)})
+	if err != nil {
+		b.logger.Printf("Failed to send settings to user %d: %v", userID, err)
+	}
+	return err
+}
+
+// handleSettingsCallback applies a preference change selected from the /settings keyboard.
+func (b *TelegramBot) handleSettingsCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 {
+		return nil
+	}
+
+	userID := u.CallbackQuery.UserID
+	if user, err := b.userRepository.GetUserInfo(userID); err != nil || !user.IsAuthorized {
+		_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			QueryID: u.CallbackQuery.QueryID,
+			Message: "You are not authorized to use this bot.",
+		})
+		return nil
+	}
+	settings, err := b.userRepository.GetUserSettings(userID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", userID, err)
+		return err
+	}
+
+	switch dataParts[1] {
+	case "theme":
+		settings.Theme = dataParts[2]
+	case "density":
+		settings.Density = dataParts[2]
+	case "pushes":
+		settings.AllowPushes = dataParts[2] == "on"
+	case "media":
+		if !data.IsValidMediaType(dataParts[2]) {
+			return nil
+		}
+		settings.ToggleMediaType(dataParts[2])
+	default:
+		return nil
+	}
+
+	if err := b.userRepository.StoreUserSettings(settings); err != nil {
+		b.logger.Printf("Failed to store settings for user %d: %v", userID, err)
+		_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			QueryID: u.CallbackQuery.QueryID,
+			Message: "Failed to update your settings.",
+		})
+		return nil
+	}
+
+	_, err = ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
+		ID:          u.CallbackQuery.MsgID,
+		Message:     settingsMessage(settings),
+		ReplyMarkup: settingsMarkup(settings),
+	})
+	if err != nil {
+		b.logger.Printf("Failed to edit settings message for user %d: %v", userID, err)
+	}
+
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+		QueryID: u.CallbackQuery.QueryID,
+		Message: "Settings updated. Reload the player to apply them.",
+	})
+	return nil
+}
+
+func settingsMessage(settings *data.UserSettings) string {
+	var bridged []string
+	for _, mediaType := range data.MediaTypes {
+		if settings.Bridges(mediaType) {
+			bridged = append(bridged, mediaType)
+		}
+	}
+	media := strings.Join(bridged, ", ")
+	if media == "" {
+		media = "none"
+	}
+	return fmt.Sprintf("Player settings\nTheme: %s\nDensity: %s\nAdmin pushes: %s\nText bridging: %s (change with /bridge_text)\nBridged media: %s", settings.Theme, settings.Density, onOff(settings.AllowPushes), onOff(settings.BridgeText), media)
+}
+
+// mediaTypeLabels are the names of the kinds of media on the /settings buttons.
+var mediaTypeLabels = map[string]string{
+	data.MediaTypeVideo:    "Video",
+	data.MediaTypeAudio:    "Audio",
+	data.MediaTypeVoice:    "Voice",
+	data.MediaTypePhoto:    "Photos",
+	data.MediaTypeDocument: "Documents",
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func settingsMarkup(settings *data.UserSettings) *tg.ReplyInlineMarkup {
+	nextTheme := data.ThemeLight
+	if settings.Theme == data.ThemeLight {
+		nextTheme = data.ThemeDark
+	}
+	nextDensity := data.DensityCompact
+	if settings.Density == data.DensityCompact {
+		nextDensity = data.DensityComfortable
+	}
+
+	markup := &tg.ReplyInlineMarkup{
+		Rows: []tg.KeyboardButtonRow{
+			{
+				Buttons: []tg.KeyboardButtonClass{
+					&tg.KeyboardButtonCallback{
+						Text: fmt.Sprintf("Theme: %s", nextTheme),
+						Data: []byte(fmt.Sprintf("%s,theme,%s", callbackSettings, nextTheme)),
+					},
+					&tg.KeyboardButtonCallback{
+						Text: fmt.Sprintf("Density: %s", nextDensity),
+						Data: []byte(fmt.Sprintf("%s,density,%s", callbackSettings, nextDensity)),
+					},
+				},
+			},
+			{
+				Buttons: []tg.KeyboardButtonClass{
+					&tg.KeyboardButtonCallback{
+						Text: fmt.Sprintf("Admin pushes: %s", onOff(!settings.AllowPushes)),
+						Data: []byte(fmt.Sprintf("%s,pushes,%s", callbackSettings, onOff(!settings.AllowPushes))),
+					},
+				},
+			},
+		},
+	}
+
+	// One button per kind of media, three to a row.
+	var row tg.KeyboardButtonRow
+	for _, mediaType := range data.MediaTypes {
+		row.Buttons = append(row.Buttons, &tg.KeyboardButtonCallback{
+			Text: fmt.Sprintf("%s: %s", mediaTypeLabels[mediaType], onOff(!settings.Bridges(mediaType))),
+			Data: []byte(fmt.Sprintf("%s,media,%s", callbackSettings, mediaType)),
+		})
+		if len(row.Buttons) == 3 {
+			markup.Rows = append(markup.Rows, row)
+			row = tg.KeyboardButtonRow{}
+		}
+	}
+	if len(row.Buttons) > 0 {
+		markup.Rows = append(markup.Rows, row)
+	}
+	return markup
+}
+
+// handleSettingsAPI returns the player preferences for a chat on GET and updates them on POST.
+func (b *TelegramBot) handleSettingsAPI(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.parseChatID(mux.Vars(r))
+	if err != nil {
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := b.userRepository.GetUserSettings(playerUserID(chatID))
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for chat %d: %v", chatID, err)
+		web.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var update struct {
+			Theme   string `json:"theme"`
+			Density string `json:"density"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			web.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if update.Theme != "" {
+			settings.Theme = update.Theme
+		}
+		if update.Density != "" {
+			settings.Density = update.Density
+		}
+		if !data.IsValidTheme(settings.Theme) || !data.IsValidDensity(settings.Density) {
+			web.Error(w, "Invalid settings", http.StatusBadRequest)
+			return
+		}
+		if err := b.userRepository.StoreUserSettings(settings); err != nil {
+			b.logger.Printf("Failed to store settings for chat %d: %v", chatID, err)
+			web.Error(w, "Failed to store settings", http.StatusInternalServerError)
+			return
+		}
+	default:
+		web.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"theme":   settings.Theme,
+		"density": settings.Density,
+	})
+}
@@ -0,0 +1,274 @@
+package bot
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gorilla/mux"
+)
+
+const shareTmplPath = "templates/share.html"
+
+// shareStreamTTL is how long the stream URL of a visit of a share page stays valid, which is long
+// enough to watch a film with pauses.
+const shareStreamTTL = 6 * time.Hour
+
+// newShareToken returns a random, unguessable token for a share page.
+func newShareToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleShareCommand creates a public share page for the replied-to media message.
+// Usage: /share [max_views] [hours]
+func (b *TelegramBot) handleShareCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	messageID, isReply := replyToMessageID(u)
+	if !isReply {
+		return b.sendReply(ctx, u, "Usage: reply to a media message with /share [max_views] [hours]")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	share := &data.Share{MessageID: messageID, OwnerID: user.UserID}
+	if len(args) > 1 {
+		share.MaxViews, err = strconv.Atoi(args[1])
+		if err != nil || share.MaxViews < 0 {
+			return b.sendReply(ctx, u, "Invalid number of views.")
+		}
+	}
+	if len(args) > 2 {
+		hours, err := strconv.Atoi(args[2])
+		if err != nil || hours <= 0 {
+			return b.sendReply(ctx, u, "Invalid number of hours.")
+		}
+		share.ExpiresAt = sql.NullTime{Time: time.Now().UTC().Add(time.Duration(hours) * time.Hour), Valid: true}
+	}
+
+	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+		return b.sendReply(ctx, u, "The replied message does not contain supported media.")
+	}
+	share.Title = file.FileName
+
+	share.Token, err = newShareToken()
+	if err != nil {
+		b.logger.Printf("Failed to generate share token: %v", err)
+		return b.sendReply(ctx, u, "Failed to create the share page.")
+	}
+	if err := b.shareRepository.CreateShare(share); err != nil {
+		b.logger.Printf("Failed to store share for message ID %d: %v", messageID, err)
+		return b.sendReply(ctx, u, "Failed to create the share page.")
+	}
+
+	limits := "unlimited views"
+	if share.MaxViews > 0 {
+		limits = fmt.Sprintf("%d views", share.MaxViews)
+	}
+	if share.ExpiresAt.Valid {
+		limits += fmt.Sprintf(", expires %s", share.ExpiresAt.Time.Format(time.RFC1123))
+	}
+	shareURL := fmt.Sprintf("%s/share/%s", b.config.BaseURL, share.Token)
+	// Without a link preview, so Telegram fetching the page does not look like a visitor.
+	text := fmt.Sprintf("Share page for %s (%s):\n%s\nRevoke it with /revokeshare %s", share.Title, limits, shareURL, share.Token)
+	if _, err := ctx.Reply(u, text, &ext.ReplyOpts{NoWebpage: true}); err != nil {
+		b.logger.Printf("Failed to send share page to user %d: %v", user.UserID, err)
+		return err
+	}
+	return nil
+}
+
+// shareStreamURL returns the URL of the media of a share for a visit of its page. The URL is
+// signed and expires, and the view is counted the first time it is streamed from, so that
+// fetching the page alone, as link previews do, does not use up a view.
+func (b *TelegramBot) shareStreamURL(share *data.Share) (string, error) {
+	view, err := newShareToken()
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(shareStreamTTL).Unix(), 10)
+	sig := utils.GenerateToken(b.sessionSecret()+":share-stream", share.Token+":"+view+":"+expires, b.config.HashLength)
+	return fmt.Sprintf("%s/share/%s/stream?view=%s&expires=%s&sig=%s", b.config.BaseURL, share.Token, view, expires, sig), nil
+}
+
+// shareStreamView returns the view of the stream URL of a share a request carries and when the
+// URL expires, or false if the URL is not valid.
+func (b *TelegramBot) shareStreamView(r *http.Request, share *data.Share) (string, time.Time, bool) {
+	query := r.URL.Query()
+	view, expires := query.Get("view"), query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || view == "" || time.Now().Unix() > unix {
+		return "", time.Time{}, false
+	}
+	if !utils.CheckToken(query.Get("sig"), b.sessionSecret()+":share-stream", share.Token+":"+view+":"+expires, b.config.HashLength) {
+		return "", time.Time{}, false
+	}
+	return view, time.Unix(unix, 0), true
+}
+
+// shareViews remembers which visits of share pages have been counted, until their stream URLs
+// expire, so the many range requests of one playback count as one view.
+type shareViews struct {
+	mu     sync.Mutex
+	visits map[string]*shareVisit
+}
+
+type shareVisit struct {
+	once    sync.Once
+	expires time.Time
+	counted bool
+	err     error
+}
+
+func newShareViews() *shareViews {
+	return &shareViews{visits: make(map[string]*shareVisit)}
+}
+
+// count calls record for the first stream request of a visit and returns its result for every
+// request of the visit.
+func (v *shareViews) count(view string, expires time.Time, record func() (bool, error)) (bool, error) {
+	now := time.Now()
+	v.mu.Lock()
+	for key, visit := range v.visits {
+		if now.After(visit.expires) {
+			delete(v.visits, key)
+		}
+	}
+	visit, ok := v.visits[view]
+	if !ok {
+		visit = &shareVisit{expires: expires}
+		v.visits[view] = visit
+	}
+	v.mu.Unlock()
+
+	visit.once.Do(func() { visit.counted, visit.err = record() })
+	return visit.counted, visit.err
+}
+
+// handleRevokeShareCommand revokes a share page created by the user.
+func (b *TelegramBot) handleRevokeShareCommand(ctx *ext.Context, u *ext.Update) error {
+	user, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil || !user.IsAuthorized {
+		return b.sendReply(ctx, u, "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		return b.sendReply(ctx, u, "Usage: /revokeshare <token>")
+	}
+
+	revoked, err := b.shareRepository.RevokeShare(args[1], user.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to revoke share %s: %v", args[1], err)
+		return b.sendReply(ctx, u, "Failed to revoke the share page.")
+	}
+	if !revoked {
+		return b.sendReply(ctx, u, "No share page with this token was found.")
+	}
+	return b.sendReply(ctx, u, "The share page has been revoked.")
+}
+
+// handleSharePage renders the public landing page of a share. The view is counted once its media
+// is streamed.
+func (b *TelegramBot) handleSharePage(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	share, err := b.shareRepository.GetShare(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !share.IsAvailable(time.Now().UTC()) || (share.MaxViews > 0 && share.Views >= share.MaxViews) {
+		web.Error(w, "This share is no longer available", http.StatusGone)
+		return
+	}
+
+	file, err := utils.StoredFileFromMessage(r.Context(), b.tgClient, share.MessageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for share %s: %v", token, err)
+		web.Error(w, "Unable to retrieve the shared file", http.StatusBadGateway)
+		return
+	}
+
+	streamURL, err := b.shareStreamURL(share)
+	if err != nil {
+		b.logger.Printf("Failed to generate stream URL of share %s: %v", token, err)
+		web.Error(w, "Failed to load share", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles(shareTmplPath)
+	if err != nil {
+		b.logger.Printf("Error loading template: %v", err)
+		web.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := t.Execute(w, map[string]interface{}{
+		"Title":     share.Title,
+		"MimeType":  file.MimeType,
+		"StreamURL": streamURL,
+		"BasePath":  b.basePath(),
+	}); err != nil {
+		b.logger.Printf("Error rendering template: %v", err)
+		web.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// handleShareStream streams the media of a share through the stream URL of a visit of its page,
+// counting the visit as a view on its first request, while the share is neither revoked nor
+// expired and has views left.
+func (b *TelegramBot) handleShareStream(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	share, err := b.shareRepository.GetShare(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !share.IsAvailable(time.Now().UTC()) {
+		web.Error(w, "This share is no longer available", http.StatusGone)
+		return
+	}
+	view, expires, ok := b.shareStreamView(r, share)
+	if !ok {
+		web.Error(w, "This stream link has expired, open the share page again", http.StatusForbidden)
+		return
+	}
+	counted, err := b.shareViews.count(view, expires, func() (bool, error) { return b.shareRepository.RecordView(token) })
+	if err != nil {
+		b.logger.Printf("Failed to record view of share %s: %v", token, err)
+		web.Error(w, "Failed to load share", http.StatusInternalServerError)
+		return
+	}
+	if !counted {
+		web.Error(w, "This share is no longer available", http.StatusGone)
+		return
+	}
+
+	file, err := utils.StoredFileFromMessage(r.Context(), b.tgClient, share.MessageID)
+	if err != nil {
+		b.logger.Printf("Error fetching file for share %s: %v", token, err)
+		web.Error(w, "Unable to retrieve the shared file", http.StatusBadGateway)
+		return
+	}
+
+	b.serveFile(w, r, share.MessageID, file, false)
+}
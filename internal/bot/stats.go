@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gorilla/mux"
+)
+
+const (
+	statsRollupInterval = time.Minute
+	defaultReportDays   = 7
+	maxReportDays       = 365
+	reportTopFiles      = 5
+)
+
+// statsReport is the payload returned by the statistics API.
+type statsReport struct {
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	UniqueUsers int64             `json:"uniqueUsers"`
+	Days        []data.DailyStats `json:"days"`
+	TopFiles    []data.FileStats  `json:"topFiles"`
+}
+
+// statsToken returns the token an admin must present to read the statistics API.
+func (b *TelegramBot) statsToken(userID int64) string {
+	return utils.GenerateChatToken(b.config.BotToken+":stats", userID, b.config.HashLength)
+}
+
+// recordStream adds a served response to the daily statistics and, if the media is known to
+// belong to a user, to the user's usage.
+func (b *TelegramBot) recordStream(messageID int, ownerID int64, file *types.DocumentFile, start, written int64) {
+	day := data.StatsDay(time.Now())
+	if err := b.statsRepository.RecordStream(day, messageID, file.FileName, written, start == 0); err != nil {
+		b.logger.Printf("Failed to record stream statistics for message ID %d: %v", messageID, err)
+	}
+	if ownerID != 0 && written > 0 {
+		if err := b.quotaRepository.RecordUsage(ownerID, day, written); err != nil {
+			b.logger.Printf("Failed to record usage of user %d: %v", ownerID, err)
+		}
+	}
+}
+
+// recordActiveUser marks the user as active today.
+func (b *TelegramBot) recordActiveUser(userID int64) {
+	if err := b.statsRepository.RecordUser(data.StatsDay(time.Now()), userID); err != nil {
+		b.logger.Printf("Failed to record active user %d: %v", userID, err)
+	}
+}
+
+// runStatsRollup periodically adds the binary cache hits and misses and the rate limited requests
+// to the daily statistics until ctx is cancelled.
+func (b *TelegramBot) runStatsRollup(ctx context.Context) {
+	ticker := time.NewTicker(statsRollupInterval)
+	defer ticker.Stop()
+
+	var last statsCounters
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// Flush the counters of the last interval before exiting.
+			b.rollupStats(&last)
+			return
+		}
+		b.rollupStats(&last)
+	}
+}
+
+// statsCounters are the in-memory counters that are rolled up into the daily statistics.
+type statsCounters struct {
+	cacheHits, cacheMisses, rateLimited int64
+}
+
+func (b *TelegramBot) currentStatsCounters() statsCounters {
+	var c statsCounters
+	cache, err := b.config.BinaryCache.GetStats()
+	if err != nil {
+		b.logger.Printf("Failed to read cache statistics: %v", err)
+	}
+	c.cacheHits, c.cacheMisses = cache.Hits, cache.Misses
+	c.rateLimited = b.ipLimiter.Limited() + b.chatLimiter.Limited()
+	return c
+}
+
+// rollupStats records the counter increments since the last rollup.
+func (b *TelegramBot) rollupStats(last *statsCounters) {
+	current := b.currentStatsCounters()
+	day := data.StatsDay(time.Now())
+
+	if current.cacheHits != last.cacheHits || current.cacheMisses != last.cacheMisses {
+		if err := b.statsRepository.AddCacheStats(day, current.cacheHits-last.cacheHits, current.cacheMisses-last.cacheMisses); err != nil {
+			b.logger.Printf("Failed to record cache statistics: %v", err)
+		} else {
+			last.cacheHits, last.cacheMisses = current.cacheHits, current.cacheMisses
+		}
+	}
+	if current.rateLimited != last.rateLimited {
+		if err := b.statsRepository.AddRateLimited(day, current.rateLimited-last.rateLimited); err != nil {
+			b.logger.Printf("Failed to record rate limiting statistics: %v", err)
+		} else {
+			last.rateLimited = current.rateLimited
+		}
+	}
+}
+
+// buildStatsReport collects the statistics of the last given number of days, including today.
+func (b *TelegramBot) buildStatsReport(days int) (*statsReport, error) {
+	now := time.Now()
+	report := &statsReport{
+		From: data.StatsDay(now.AddDate(0, 0, -(days - 1))),
+		To:   data.StatsDay(now),
+	}
+
+	var err error
+	if report.Days, err = b.statsRepository.GetDailyStats(report.From, report.To); err != nil {
+		return nil, fmt.Errorf("failed to load daily statistics: %w", err)
+	}
+	if report.UniqueUsers, err = b.statsRepository.CountUniqueUsers(report.From, report.To); err != nil {
+		return nil, fmt.Errorf("failed to count unique users: %w", err)
+	}
+	if report.TopFiles, err = b.statsRepository.GetTopFiles(report.From, report.To, reportTopFiles); err != nil {
+		return nil, fmt.Errorf("failed to load top files: %w", err)
+	}
+	return report, nil
+}
+
+// parseReportDays parses the number of days of a report, falling back to the default.
+func parseReportDays(s string) (int, bool) {
+	if s == "" {
+		return defaultReportDays, true
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days <= 0 || days > maxReportDays {
+		return 0, false
+	}
+	return days, true
+}
+
+// handleReportCommand sends admins a summary of the usage statistics.
+// Usage: /report [days]
+func (b *TelegramBot) handleReportCommand(ctx *ext.Context, u *ext.Update) error {
+	// Only allow admins to run this command
+	adminID := u.EffectiveUser().ID
+	userInfo, err := b.userRepository.GetUserInfo(adminID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		return b.sendReply(ctx, u, "Failed to build the report.")
+	}
+
+	if !userInfo.IsAdmin {
+		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+	}
+
+	args := strings.Fields(u.EffectiveMessage.Text)
+	var daysArg string
+	if len(args) > 1 {
+		daysArg = args[1]
+	}
+	days, ok := parseReportDays(daysArg)
+	if !ok {
+		return b.sendReply(ctx, u, fmt.Sprintf("Usage: /report [days], with at most %d days", maxReportDays))
+	}
+
+	report, err := b.buildStatsReport(days)
+	if err != nil {
+		b.logger.Printf("Failed to build statistics report: %v", err)
+		return b.sendReply(ctx, u, "Failed to build the report.")
+	}
+
+	var sb strings.Builder
+	var streams, bytes, hits, misses, limited int64
+	fmt.Fprintf(&sb, "Usage from %s to %s\n\n", report.From, report.To)
+	for _, day := range report.Days {
+		fmt.Fprintf(&sb, "%s: %d streams, %s, %d users\n", day.Day, day.Streams, formatBytes(day.Bytes), day.UniqueUsers)
+		streams += day.Streams
+		bytes += day.Bytes
+		hits += day.CacheHits
+		misses += day.CacheMisses
+		limited += day.RateLimited
+	}
+	fmt.Fprintf(&sb, "\nTotal: %d streams, %s, %d unique users\n", streams, formatBytes(bytes), report.UniqueUsers)
+	if hits+misses > 0 {
+		fmt.Fprintf(&sb, "Cache hit rate: %.1f%%\n", float64(hits)*100/float64(hits+misses))
+	}
+	if limited > 0 {
+		fmt.Fprintf(&sb, "Rate limited requests: %d\n", limited)
+	}
+	if len(report.TopFiles) > 0 {
+		sb.WriteString("\nTop files:\n")
+		for i, f := range report.TopFiles {
+			fmt.Fprintf(&sb, "%d. %s (%d streams, %s)\n", i+1, f.FileName, f.Streams, formatBytes(f.Bytes))
+		}
+	}
+	fmt.Fprintf(&sb, "\nAPI: %s/api/stats/%d?token=%s&days=%d", b.config.BaseURL, adminID, b.statsToken(adminID), days)
+
+	return b.sendReply(ctx, u, sb.String())
+}
+
+// requireStatsToken serves a request only if it carries the chat ID and stats token of an admin.
+func (b *TelegramBot) requireStatsToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := b.parseChatID(mux.Vars(r))
+		if err != nil {
+			web.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		if !utils.CheckChatToken(r.URL.Query().Get("token"), b.config.BotToken+":stats", userID, b.config.HashLength) {
+			web.Error(w, "Invalid token", http.StatusForbidden)
+			return
+		}
+
+		user, err := b.userRepository.GetUserInfo(userID)
+		if err != nil || !user.IsAdmin {
+			web.Error(w, "User is not an admin", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleStatsAPI returns the daily statistics as JSON for charts. It requires the stats token of
+// an admin, see requireStatsToken.
+func (b *TelegramBot) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	days, ok := parseReportDays(r.URL.Query().Get("days"))
+	if !ok {
+		web.Error(w, "Invalid number of days", http.StatusBadRequest)
+		return
+	}
+
+	report, err := b.buildStatsReport(days)
+	if err != nil {
+		b.logger.Printf("Failed to build statistics report: %v", err)
+		web.Error(w, "Failed to load statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		b.logger.Printf("Error encoding statistics: %v", err)
+	}
+}
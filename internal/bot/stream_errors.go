@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/gotd/td/tg"
+)
+
+const wsMessageTypeStreamError = "streamError"
+
+// abortStream handles a failure that happened after the response headers were sent. Since the
+// status code can no longer be changed, the connection is aborted so the client sees a truncated
+// response instead of error text mixed into the media, and can retry cleanly.
+func (b *TelegramBot) abortStream(r *http.Request, messageID int, err error) {
+	if r.Context().Err() != nil {
+		// The client went away; there is nobody to signal.
+		b.logger.Printf("Client %s closed the stream for message ID %d: %v", web.ClientIP(r, b.trustedProxies), messageID, err)
+		return
+	}
+
+	b.logger.Printf("Error streaming content for message ID %d: %v", messageID, err)
+	if b.config.StreamErrorMode == config.StreamErrorModeNotify {
+		go b.notifyStreamError(messageID)
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// notifyStreamError sends a streamError event to the web player of the chat the message belongs to.
+func (b *TelegramBot) notifyStreamError(messageID int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	message, err := utils.GetMessage(ctx, b.tgClient, messageID)
+	if err != nil {
+		b.logger.Printf("Failed to resolve chat for stream error of message ID %d: %v", messageID, err)
+		return
+	}
+	peer, ok := message.PeerID.(*tg.PeerUser)
+	if !ok {
+		return
+	}
+
+	b.publishToWebSocket(peer.UserID, map[string]string{
+		"type":      wsMessageTypeStreamError,
+		"messageId": strconv.Itoa(messageID),
+	})
+}
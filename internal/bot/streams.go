@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"webBridgeBot/internal/i18n"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+const (
+	streamLimitTmplPath = "templates/streams_limit.html"
+	// How long clients refused for too many streams are asked to wait before retrying.
+	streamLimitRetryAfter = 30 * time.Second
+)
+
+// renderStreamLimit refuses a stream because the media's owner has too many streams active.
+// Browsers opening the URL get a page explaining it; players get a plain error.
+func (b *TelegramBot) renderStreamLimit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(streamLimitRetryAfter.Seconds())))
+	w.Header().Del("ETag")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		web.Error(w, "Too many streams of this media's owner are active", http.StatusTooManyRequests)
+		return
+	}
+
+	lang := languageOf(nil, i18n.MatchAcceptLanguage(r.Header.Get("Accept-Language")))
+	t, err := template.New(path.Base(streamLimitTmplPath)).Funcs(template.FuncMap{
+		"t": func(key string, args ...interface{}) string { return i18n.T(lang, key, args...) },
+	}).ParseFiles(streamLimitTmplPath)
+	if err != nil {
+		b.logger.Printf("Error loading template: %v", err)
+		web.Error(w, "Too many streams of this media's owner are active", http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	live := b.live.Load()
+	if err := t.Execute(w, map[string]interface{}{
+		"BasePath":    b.basePath(),
+		"Title":       live.PlayerTitle,
+		"AccentColor": live.PlayerAccentColor,
+		"Language":    lang,
+		"Direction":   i18n.Direction(lang),
+		"Limit":       live.MaxStreamsPerUser,
+	}); err != nil {
+		b.logger.Printf("Error rendering template: %v", err)
+	}
+}
+
+// userIDArg returns the user ID that is the only argument of a command.
+func userIDArg(u *ext.Update) (int64, bool) {
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) != 2 {
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	return userID, err == nil
+}
+
+// handleStreamsCommand lets an admin list the active streams of a user's media.
+func (b *TelegramBot) handleStreamsCommand(ctx *ext.Context, u *ext.Update) error {
+	if !b.requireAdmin(ctx, u, "Failed to list the streams.") {
+		return nil
+	}
+	userID, ok := userIDArg(u)
+	if !ok {
+		return b.sendReply(ctx, u, "Usage: /streams <user_id>")
+	}
+
+	connections := b.connections.UserConnections(userID)
+	if len(connections) == 0 {
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d has no active streams.", userID))
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Active streams of user %d", userID)
+	if limit := b.live.Load().MaxStreamsPerUser; limit > 0 {
+		fmt.Fprintf(&sb, " (limit %d)", limit)
+	}
+	sb.WriteString(":")
+	for _, c := range connections {
+		fmt.Fprintf(&sb, "\n- Message %d to %s, %s sent, since %s UTC", c.MessageID, c.ClientIP, formatBytes(c.BytesSent), c.StartedAt.UTC().Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprintf(&sb, "\n\nStop them with /killstreams %d.", userID)
+	return b.sendReply(ctx, u, sb.String())
+}
+
+// handleKillStreamsCommand lets an admin close the active streams of a user's media and the
+// connection of their player.
+func (b *TelegramBot) handleKillStreamsCommand(ctx *ext.Context, u *ext.Update) error {
+	if !b.requireAdmin(ctx, u, "Failed to stop the streams.") {
+		return nil
+	}
+	userID, ok := userIDArg(u)
+	if !ok {
+		return b.sendReply(ctx, u, "Usage: /killstreams <user_id>")
+	}
+	closed := b.streams.Cancel(userID)
+	b.logger.Printf("Admin %d closed %d streams and connections of user %d", u.EffectiveUser().ID, closed, userID)
+	if closed == 0 {
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d has no active streams or player connections.", userID))
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("Closed %d streams and player connections of user %d.", closed, userID))
+}
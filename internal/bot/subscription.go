@@ -0,0 +1,337 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	gtypes "github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+// channelRefPattern matches a channel given as @username, t.me/username, t.me/c/<id> or a bare
+// ID, with or without the -100 prefix of the Bot API.
+var channelRefPattern = regexp.MustCompile(`(?i)^(?:(?:https?://)?(?:www\.)?(?:t|telegram)\.me/(?:c/(\d+)|(?:s/)?([a-z][a-z0-9_]{3,31}))/?|@([a-z][a-z0-9_]{3,31})|(-?\d+))$`)
+
+// mimeTypePattern matches the entries of a subscription filter: video, video/* or video/mp4.
+var mimeTypePattern = regexp.MustCompile(`^[a-z]+(?:/(?:\*|[a-z0-9.+-]+))?$`)
+
+// parseChannelRef returns the username or the ID of a channel reference.
+func parseChannelRef(arg string) (username string, channelID int64, ok bool) {
+	match := channelRefPattern.FindStringSubmatch(arg)
+	if match == nil {
+		return "", 0, false
+	}
+	switch {
+	case match[2] != "":
+		return match[2], 0, true
+	case match[3] != "":
+		return match[3], 0, true
+	}
+	id := match[1]
+	if id == "" {
+		id = strings.TrimPrefix(match[4], "-100")
+	}
+	channelID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || channelID <= 0 {
+		return "", 0, false
+	}
+	return "", channelID, true
+}
+
+// resolveChannel returns the ID and title of a channel reference and makes sure its peer is
+// stored. The bot must be able to see the channel: as its admin, or as a member in userbot mode.
+func (b *TelegramBot) resolveChannel(ctx *ext.Context, arg string) (int64, string, error) {
+	username, channelID, ok := parseChannelRef(arg)
+	if !ok {
+		return 0, "", fmt.Errorf("invalid channel %q", arg)
+	}
+	if username != "" {
+		chat, err := ctx.ResolveUsername(username)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to resolve @%s: %w", username, err)
+		}
+		channel, ok := chat.(*gtypes.Channel)
+		if !ok {
+			return 0, "", fmt.Errorf("@%s: %w", username, errLinkNotChannel)
+		}
+		return channel.ID, channel.Title, nil
+	}
+
+	input, err := utils.GetLogChannelPeer(ctx, ctx.Raw, ctx.PeerStorage, channelID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get channel %d: %w", channelID, err)
+	}
+	title := strconv.FormatInt(channelID, 10)
+	chats, err := ctx.Raw.ChannelsGetChannels(ctx, []tg.InputChannelClass{input})
+	if err == nil && len(chats.GetChats()) > 0 {
+		if channel, ok := chats.GetChats()[0].(*tg.Channel); ok {
+			title = channel.Title
+		}
+	}
+	return channelID, title, nil
+}
+
+// parseMimeTypes parses a comma-separated subscription filter such as "video,audio/mpeg".
+func parseMimeTypes(arg string) ([]string, bool) {
+	var mimeTypes []string
+	for _, mimeType := range strings.Split(strings.ToLower(arg), ",") {
+		mimeType = strings.TrimSpace(mimeType)
+		if mimeType == "" {
+			continue
+		}
+		if !mimeTypePattern.MatchString(mimeType) {
+			return nil, false
+		}
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	return mimeTypes, len(mimeTypes) > 0
+}
+
+// subscriptionArgs parses the optional user ID and filter that follow the channel of
+// /subscribe and /unsubscribe. The user defaults to the admin who sent the command.
+func subscriptionArgs(args []string, adminID int64) (userID int64, mimeTypes []string, ok bool) {
+	userID = adminID
+	for _, arg := range args {
+		if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+			userID = id
+			continue
+		}
+		if mimeTypes != nil {
+			return 0, nil, false
+		}
+		if mimeTypes, ok = parseMimeTypes(arg); !ok {
+			return 0, nil, false
+		}
+	}
+	return userID, mimeTypes, true
+}
+
+// requireAdmin replies and returns false unless the sender of the update is an admin.
+func (b *TelegramBot) requireAdmin(ctx *ext.Context, u *ext.Update, failure string) bool {
+	userInfo, err := b.userRepository.GetUserInfo(u.EffectiveUser().ID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
+		b.sendReply(ctx, u, failure)
+		return false
+	}
+	if !userInfo.IsAdmin {
+		b.sendReply(ctx, u, "You are not authorized to perform this action.")
+		return false
+	}
+	return true
+}
+
+// handleSubscribeCommand lets an admin send the new media posts of a channel to a user's player
+// and history, optionally only media of some types.
+func (b *TelegramBot) handleSubscribeCommand(ctx *ext.Context, u *ext.Update) error {
+	if !b.requireAdmin(ctx, u, "Failed to subscribe.") {
+		return nil
+	}
+	adminID := u.EffectiveUser().ID
+
+	const usage = "Usage: /subscribe <channel> [user_id] [types], with types such as video,audio/mpeg"
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		return b.sendReply(ctx, u, usage)
+	}
+	userID, mimeTypes, ok := subscriptionArgs(args[2:], adminID)
+	if !ok {
+		return b.sendReply(ctx, u, usage)
+	}
+
+	target, err := b.userRepository.GetUserInfo(userID)
+	if err != nil || !target.IsAuthorized {
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d is not an authorized user.", userID))
+	}
+	if userID != adminID {
+		settings, err := b.userRepository.GetUserSettings(userID)
+		if err != nil {
+			b.logger.Printf("Failed to retrieve settings for user %d: %v", userID, err)
+			return b.sendReply(ctx, u, "Failed to subscribe.")
+		}
+		if !settings.AllowPushes {
+			return b.sendReply(ctx, u, fmt.Sprintf("User %d does not accept pushed media. They can enable it with /settings.", userID))
+		}
+	}
+
+	channelID, title, err := b.resolveChannel(ctx, args[1])
+	if err != nil {
+		b.logger.Printf("Failed to resolve channel %s for a subscription: %v", args[1], err)
+		return b.sendReply(ctx, u, "Channel not found. The bot must be an admin of the channel, or a member when it runs as a user account.")
+	}
+
+	err = b.subscriptionRepository.Subscribe(&data.Subscription{
+		ChannelID:    channelID,
+		UserID:       userID,
+		ChannelTitle: title,
+		MimeTypes:    mimeTypes,
+		CreatedBy:    adminID,
+	})
+	if err != nil {
+		b.logger.Printf("Failed to subscribe user %d to channel %d: %v", userID, channelID, err)
+		return b.sendReply(ctx, u, "Failed to subscribe.")
+	}
+	b.logger.Printf("Admin %d subscribed user %d to channel %d (%s)", adminID, userID, channelID, describeMimeTypes(mimeTypes))
+	return b.sendReply(ctx, u, fmt.Sprintf("User %d now receives %s from %s.", userID, describeMimeTypes(mimeTypes), title))
+}
+
+// handleUnsubscribeCommand removes a subscription of the admin or of another user.
+func (b *TelegramBot) handleUnsubscribeCommand(ctx *ext.Context, u *ext.Update) error {
+	if !b.requireAdmin(ctx, u, "Failed to unsubscribe.") {
+		return nil
+	}
+
+	const usage = "Usage: /unsubscribe <channel> [user_id]"
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 || len(args) > 3 {
+		return b.sendReply(ctx, u, usage)
+	}
+	userID, mimeTypes, ok := subscriptionArgs(args[2:], u.EffectiveUser().ID)
+	if !ok || mimeTypes != nil {
+		return b.sendReply(ctx, u, usage)
+	}
+	username, channelID, ok := parseChannelRef(args[1])
+	if !ok {
+		return b.sendReply(ctx, u, usage)
+	}
+	// A channel given by ID can be unsubscribed from even if the bot has lost access to it.
+	if username != "" {
+		resolved, _, err := b.resolveChannel(ctx, args[1])
+		if err != nil {
+			return b.sendReply(ctx, u, "Channel not found.")
+		}
+		channelID = resolved
+	}
+
+	removed, err := b.subscriptionRepository.Unsubscribe(channelID, userID)
+	if err != nil {
+		b.logger.Printf("Failed to unsubscribe user %d from channel %d: %v", userID, channelID, err)
+		return b.sendReply(ctx, u, "Failed to unsubscribe.")
+	}
+	if !removed {
+		return b.sendReply(ctx, u, fmt.Sprintf("User %d is not subscribed to that channel.", userID))
+	}
+	return b.sendReply(ctx, u, fmt.Sprintf("User %d no longer receives posts of channel %d.", userID, channelID))
+}
+
+// handleSubscriptionsCommand lists all channel subscriptions.
+func (b *TelegramBot) handleSubscriptionsCommand(ctx *ext.Context, u *ext.Update) error {
+	if !b.requireAdmin(ctx, u, "Failed to list the subscriptions.") {
+		return nil
+	}
+	subscriptions, err := b.subscriptionRepository.ListAll()
+	if err != nil {
+		b.logger.Printf("Failed to list subscriptions: %v", err)
+		return b.sendReply(ctx, u, "Failed to list the subscriptions.")
+	}
+	if len(subscriptions) == 0 {
+		return b.sendReply(ctx, u, "There are no channel subscriptions. Add one with /subscribe <channel>.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Channel subscriptions:")
+	for i, s := range subscriptions {
+		if i == 0 || subscriptions[i-1].ChannelID != s.ChannelID {
+			fmt.Fprintf(&sb, "\n\n%s (%d)", s.ChannelTitle, s.ChannelID)
+		}
+		fmt.Fprintf(&sb, "\n• User %d: %s", s.UserID, describeMimeTypes(s.MimeTypes))
+	}
+	return b.sendReply(ctx, u, sb.String())
+}
+
+func describeMimeTypes(mimeTypes []string) string {
+	if len(mimeTypes) == 0 {
+		return "all media"
+	}
+	return strings.Join(mimeTypes, ", ")
+}
+
+// isChannelMediaPost reports whether a message is a post with a file in a channel.
+func isChannelMediaPost(m *gtypes.Message) bool {
+	if m.Message == nil {
+		return false
+	}
+	if _, ok := m.PeerID.(*tg.PeerChannel); !ok {
+		return false
+	}
+	_, ok := m.Media.(*tg.MessageMediaDocument)
+	return ok
+}
+
+// handleChannelPost sends the media of a new channel post to the players and histories of the
+// channel's subscribers whose filter it passes.
+func (b *TelegramBot) handleChannelPost(ctx *ext.Context, u *ext.Update) error {
+	message := u.EffectiveMessage.Message
+	channelID := message.PeerID.(*tg.PeerChannel).ChannelID
+
+	subscriptions, err := b.subscriptionRepository.ListByChannel(channelID)
+	if err != nil {
+		b.logger.Printf("Failed to list the subscriptions of channel %d: %v", channelID, err)
+		return dispatcher.EndGroups
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	file, err := utils.FileFromMedia(message.Media)
+	if err != nil {
+		b.logger.Printf("Unsupported media in message ID %d of channel %d: %v", message.ID, channelID, err)
+		return dispatcher.EndGroups
+	}
+	if file.FileSize > reader.MaxSupportedFileSize {
+		b.logger.Printf("File of %d bytes in message ID %d of channel %d exceeds the supported maximum", file.FileSize, message.ID, channelID)
+		return dispatcher.EndGroups
+	}
+
+	for i := range subscriptions {
+		if subscriptions[i].Matches(file.MimeType) {
+			b.deliverChannelPost(ctx, &subscriptions[i], message, file)
+		}
+	}
+	return dispatcher.EndGroups
+}
+
+// deliverChannelPost forwards a channel post into a subscriber's chat, from where it is
+// streamed, records it in their history and plays it in their player.
+func (b *TelegramBot) deliverChannelPost(ctx *ext.Context, s *data.Subscription, message *tg.Message, file *types.DocumentFile) {
+	user, err := b.userRepository.GetUserInfo(s.UserID)
+	if err != nil || !user.IsAuthorized {
+		b.logger.Printf("Skipping post of channel %d for user %d, who is not authorized", s.ChannelID, s.UserID)
+		return
+	}
+	settings, err := b.userRepository.GetUserSettings(s.UserID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", s.UserID, err)
+		return
+	}
+	// Users can stop pushes from admins at any time, also for subscriptions made before.
+	if s.UserID != s.CreatedBy && !settings.AllowPushes {
+		return
+	}
+	if !settings.Bridges(data.MediaTypeOf(file.MimeType, file.AudioAttr.Voice)) {
+		return
+	}
+	if limit, err := b.fileSizeLimit(s.UserID); err == nil && file.FileSize > limit {
+		b.logger.Printf("Skipping post of channel %d for user %d, its %d bytes exceed their limit", s.ChannelID, s.UserID, file.FileSize)
+		return
+	}
+
+	messageID, err := b.forwardToChat(ctx, s.ChannelID, message.ID, user.ChatID)
+	if err != nil {
+		b.logger.Printf("Failed to forward message ID %d of channel %d to chat ID %d: %v", message.ID, s.ChannelID, user.ChatID, err)
+		return
+	}
+	b.indexDocument(file)
+	fileURL := b.generateFileURL(messageID, file)
+	b.recordMedia(user.UserID, messageID, file, message.Message)
+	b.publishMedia(user.ChatID, messageID, b.constructWebSocketMessage(messageID, fileURL, file))
+	b.logger.Printf("Sent message ID %d of channel %d to chat ID %d as message ID %d", message.ID, s.ChannelID, user.ChatID, messageID)
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gorilla/mux"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	callbackSubtitles = "cb_Subs"
+	subtitlesOff      = -1
+
+	// Probing reads the start of the file through the stream endpoint, which for a file that
+	// is not cached yet means a round trip to Telegram.
+	subtitleProbeTimeout = 15 * time.Second
+)
+
+// subtitleContainers are the video types that can carry text subtitle tracks.
+var subtitleContainers = map[string]bool{
+	"video/x-matroska": true,
+	"video/webm":       true,
+	"video/mp4":        true,
+	"video/quicktime":  true,
+}
+
+// mediaSubtitle describes a subtitle track for the player.
+type mediaSubtitle struct {
+	Label    string `json:"label"`
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+// subtitleTracks returns the text subtitle tracks embedded in a video. Files that cannot have
+// any, and files that fail to probe, have none.
+func (b *TelegramBot) subtitleTracks(messageID int, file *types.DocumentFile) []web.SubtitleTrack {
+	if b.subtitles == nil || !subtitleContainers[file.MimeType] {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), subtitleProbeTimeout)
+	defer cancel()
+	tracks, err := b.subtitles.Tracks(ctx, file.ID, b.localStreamURL(messageID, file))
+	if err != nil {
+		b.logger.Printf("Failed to list subtitle tracks of message ID %d: %v", messageID, err)
+		return nil
+	}
+	return tracks
+}
+
+// encodeSubtitles serializes the subtitle tracks of a file for the WebSocket message.
+func (b *TelegramBot) encodeSubtitles(messageID int, file *types.DocumentFile, tracks []web.SubtitleTrack) string {
+	subtitles := make([]mediaSubtitle, 0, len(tracks))
+	for i, track := range tracks {
+		subtitles = append(subtitles, mediaSubtitle{
+			Label:    track.Label(),
+			Language: track.Language,
+			URL:      fmt.Sprintf("%s/subs/%d/%s/%d.vtt", b.config.BaseURL, messageID, b.fileHash(file), i),
+		})
+	}
+	encoded, err := json.Marshal(subtitles)
+	if err != nil {
+		b.logger.Printf("Error marshalling subtitles for message ID %d: %v", messageID, err)
+		return "[]"
+	}
+	return string(encoded)
+}
+
+// subtitleButtons returns a keyboard row to switch the player's subtitles.
+func subtitleButtons(messageID int, tracks []web.SubtitleTrack) (tg.KeyboardButtonRow, bool) {
+	if len(tracks) == 0 {
+		return tg.KeyboardButtonRow{}, false
+	}
+	row := tg.KeyboardButtonRow{
+		Buttons: []tg.KeyboardButtonClass{
+			&tg.KeyboardButtonCallback{
+				Text: "Subtitles off",
+				Data: []byte(fmt.Sprintf("%s,%d,%d", callbackSubtitles, messageID, subtitlesOff)),
+			},
+		},
+	}
+	for i, track := range tracks {
+		row.Buttons = append(row.Buttons, &tg.KeyboardButtonCallback{
+			Text: track.Label(),
+			Data: []byte(fmt.Sprintf("%s,%d,%d", callbackSubtitles, messageID, i)),
+		})
+	}
+	return row, true
+}
+
+// handleSubtitlesCallback tells the player to show a subtitle track, or none.
+func (b *TelegramBot) handleSubtitlesCallback(ctx *ext.Context, u *ext.Update, dataParts []string) error {
+	if len(dataParts) < 3 {
+		return nil
+	}
+	messageID, err := strconv.Atoi(dataParts[1])
+	if err != nil {
+		return err
+	}
+	track, err := strconv.Atoi(dataParts[2])
+	if err != nil {
+		return err
+	}
+
+	answer := "Subtitles turned off."
+	if track != subtitlesOff {
+		file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+		if err != nil {
+			b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
+			return err
+		}
+		tracks := b.subtitleTracks(messageID, file)
+		if track < 0 || track >= len(tracks) {
+			return nil
+		}
+		answer = fmt.Sprintf("Showing %s subtitles.", tracks[track].Label())
+	}
+
+	b.publishToWebSocket(u.EffectiveChat().GetID(), map[string]string{
+		"type":      "subtitles",
+		"messageId": strconv.Itoa(messageID),
+		"track":     strconv.Itoa(track),
+	})
+
+	_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+		QueryID: u.CallbackQuery.QueryID,
+		Message: answer,
+	})
+	return nil
+}
+
+// handleSubtitles serves a subtitle track of a video converted to WebVTT.
+func (b *TelegramBot) handleSubtitles(w http.ResponseWriter, r *http.Request) {
+	if b.subtitles == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	messageID, file, ok := b.fileFromRequest(w, r)
+	if !ok {
+		return
+	}
+	track, err := strconv.Atoi(mux.Vars(r)["track"])
+	if err != nil {
+		web.Error(w, "Invalid track", http.StatusBadRequest)
+		return
+	}
+
+	vtt, err := b.subtitles.WebVTT(r.Context(), file.ID, track, b.localStreamURL(messageID, file))
+	if errors.Is(err, web.ErrTrackNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		b.logger.Printf("Error extracting subtitle track %d of message ID %d: %v", track, messageID, err)
+		web.Error(w, "Failed to extract the subtitles", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(vtt)
+}
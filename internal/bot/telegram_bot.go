@@ -1,30 +1,42 @@
 package bot
 
 import (
-	"database/sql"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"webBridgeBot/internal/cache"
 	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/i18n"
+	logging "webBridgeBot/internal/logger"
 	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/web"
+	"webBridgeBot/internal/webhook"
 
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/dispatcher/handlers"
 	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
 	"github.com/celestix/gotgproto/ext"
-	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/celestix/gotgproto/storage"
 	gtypes "github.com/celestix/gotgproto/types"
-	"github.com/glebarez/sqlite"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/gotd/td/tg"
+	"webBridgeBot/internal/cast"
 	"webBridgeBot/internal/config"
 	"webBridgeBot/internal/types"
 	"webBridgeBot/internal/utils"
@@ -39,85 +51,359 @@ const (
 type TelegramBot struct {
 	config         *config.Configuration
 	tgClient       *gotgproto.Client
+	session        *sessionGuard
+	sessionLost    chan error // Receives the error with which Telegram invalidated the session.
 	tgCtx          *ext.Context
 	logger         *log.Logger
 	userRepository *data.UserRepository
-	db             *sql.DB
+	db             *data.DB
+
+	shareRepository *data.ShareRepository
+	shareViews      *shareViews
+	statsRepository *data.StatsRepository
+
+	playlistRepository *data.PlaylistRepository
+	mediaRepository    *data.MediaRepository
+	quotaRepository    *data.QuotaRepository
+	// Indexes documents by their content, so files uploaded twice share their cached data.
+	fingerprintRepository *data.FingerprintRepository
+	canonicalDocuments    sync.Map // Canonical document IDs by document ID
+	// Keeps the files of messages, which the cache package reads and writes.
+	metadataRepository *data.MetadataRepository
+
+	subscriptionRepository *data.SubscriptionRepository
+	scheduleRepository     *data.ScheduleRepository
+
+	downloadProgress *downloadProgressTracker
+	prefetchQueue    *prefetchQueue
+	httpClient       *http.Client
+	proxyAllowlist   domainAllowlist
+	chatQueue        *chatQueue
+	hls              *web.HLSTranscoder
+	subtitles        *web.SubtitleExtractor
+	externalCache    *web.ExternalCache // Nil if caching external media is disabled.
+	caster           *cast.Caster
+	rooms            *roomManager
+	albums           *albumCollector
+	ingest           *ingestCollector
+	playerStates     *playerStateStore
+	server           *web.Server
+	ipLimiter        *web.RateLimiter
+	chatLimiter      *web.RateLimiter
+	live             atomic.Pointer[liveSettings] // Settings applied again on reload.
+	reloadMu         sync.Mutex
+	streams          *web.StreamRegistry
+	connections      *web.ConnectionTracker
+	trustedProxies   *web.TrustedProxies
+	cors             *web.CORSPolicy     // Nil if no other site may use the streams and APIs.
+	compression      []string            // Content codings of compressed responses, by preference.
+	localToken       string              // Marks the local stream URLs read by ffmpeg.
+	webhooks         *webhook.Dispatcher // Nil if no webhooks are set up.
+	workers          *workerPool         // Nil if the bot downloads files itself.
+	upgrader         websocket.Upgrader
+	wsManager        *web.WebSocketManager
+	playerSessions   *web.SessionManager // Visitors signed in to their player.
 }
 
-var (
-	wsClients = make(map[int64]*websocket.Conn)
-
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
-)
-
 // NewTelegramBot creates a new instance of TelegramBot.
 func NewTelegramBot(config *config.Configuration, logger *log.Logger) (*TelegramBot, error) {
-	dsn := fmt.Sprintf("file:%s?mode=rwc", config.DatabasePath)
-	tgClient, err := gotgproto.NewClient(
-		config.ApiID,
-		config.ApiHash,
-		gotgproto.ClientTypeBot(config.BotToken),
-		&gotgproto.ClientOpts{
-			InMemory:         true,
-			Session:          sessionMaker.SqlSession(sqlite.Open(dsn)),
-			DisableCopyright: true,
-		})
+	tgClient, session, err := newTelegramClient(config, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Telegram client: %w", err)
+		return nil, err
 	}
 
 	// Initialize the database connection
-	db, err := sql.Open("sqlite", dsn)
+	db, err := data.Open(config.DBDriver, config.DBDSN)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		return nil, fmt.Errorf("failed to open %s database: %w", config.DBDriver, err)
+	}
+
+	// Bring the database schema up to date
+	applied, err := data.NewMigrator(db).Migrate()
+	if err != nil {
+		return nil, err
+	}
+	if applied > 0 {
+		logger.Printf("Applied %d database migrations", applied)
 	}
 
-	// Create a new UserRepository
 	userRepository := data.NewUserRepository(db)
+	shareRepository := data.NewShareRepository(db)
+	statsRepository := data.NewStatsRepository(db)
+	playlistRepository := data.NewPlaylistRepository(db)
+	mediaRepository := data.NewMediaRepository(db)
+	quotaRepository := data.NewQuotaRepository(db)
+	fingerprintRepository := data.NewFingerprintRepository(db)
+	metadataRepository := data.NewMetadataRepository(db)
+	cache.Init(metadataRepository, config.MetadataTTL)
+	subscriptionRepository := data.NewSubscriptionRepository(db)
+	scheduleRepository := data.NewScheduleRepository(db)
+
+	workers, err := newWorkerPool(context.Background(), config, tgClient, data.NewStorageRepository(db),
+		logging.WithFields(logger, logging.Fields{"module": "workers"}))
+	if err != nil {
+		return nil, err
+	}
 
-	// Initialize the database schema
-	if err := userRepository.InitDB(); err != nil {
+	proxyAllowlist := parseDomainAllowlist(config.ProxyAllowedDomains)
+	httpClient, err := newExternalHTTPClient(config, proxyAllowlist)
+	if err != nil {
 		return nil, err
 	}
 
-	return &TelegramBot{
+	webLogger := logging.WithFields(logger, logging.Fields{"module": "web"})
+	var hls *web.HLSTranscoder
+	var subtitles *web.SubtitleExtractor
+	if config.FFmpegPath != "" {
+		hls = web.NewHLSTranscoder(web.HLSConfig{
+			FFmpegPath:      config.FFmpegPath,
+			WorkDir:         filepath.Join(config.CacheDirectory, "hls"),
+			Transcode:       config.HLSTranscode,
+			SegmentDuration: config.HLSSegmentDuration,
+			IdleTimeout:     config.HLSIdleTimeout,
+		}, config.BinaryCache, webLogger)
+		subtitles = web.NewSubtitleExtractor(config.FFmpegPath, config.BinaryCache, webLogger)
+	}
+	var externalCache *web.ExternalCache
+	if config.ProxyCacheTTL > 0 {
+		externalCache = web.NewExternalCache(httpClient, config.BinaryCache, web.ExternalCacheConfig{
+			ChunkSize:   config.ChunkSize,
+			TTL:         config.ProxyCacheTTL,
+			MaxFileSize: config.ProxyCacheMaxFileSize,
+		}, webLogger)
+	}
+
+	trustedProxies, err := web.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	cors, err := web.ParseCORSPolicy(config.CORSAllowedOrigins, config.CORSAllowedHeaders)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := web.ParseCompression(config.HTTPCompression)
+	if err != nil {
+		return nil, err
+	}
+	webhookURLs, err := webhook.ParseURLs(config.WebhookURLs)
+	if err != nil {
+		return nil, err
+	}
+	webhookEvents, err := webhook.ParseEvents(config.WebhookEvents)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := webhook.NewDispatcher(webhook.Config{URLs: webhookURLs, Secret: config.WebhookSecret, Events: webhookEvents},
+		logging.WithFields(logger, logging.Fields{"module": "webhook"}))
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	localToken, err := newLocalToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate local stream token: %w", err)
+	}
+
+	var caster *cast.Caster
+	if config.CastEnabled {
+		caster = cast.NewCaster(config.CastDiscoveryTimeout)
+	}
+
+	b := &TelegramBot{
 		config:         config,
 		tgClient:       tgClient,
+		session:        session,
+		sessionLost:    make(chan error, 1),
 		tgCtx:          tgClient.CreateContext(),
-		logger:         logger,
+		logger:         logging.WithFields(logger, logging.Fields{"module": "bot"}),
 		userRepository: userRepository,
 		db:             db,
-	}, nil
+
+		shareRepository: shareRepository,
+		shareViews:      newShareViews(),
+		statsRepository: statsRepository,
+
+		playlistRepository: playlistRepository,
+		mediaRepository:    mediaRepository,
+		quotaRepository:    quotaRepository,
+
+		fingerprintRepository: fingerprintRepository,
+		metadataRepository:    metadataRepository,
+
+		subscriptionRepository: subscriptionRepository,
+		scheduleRepository:     scheduleRepository,
+
+		downloadProgress: newDownloadProgressTracker(),
+		prefetchQueue:    newPrefetchQueue(),
+		httpClient:       httpClient,
+		proxyAllowlist:   proxyAllowlist,
+		chatQueue:        newChatQueue(),
+		hls:              hls,
+		subtitles:        subtitles,
+		externalCache:    externalCache,
+		caster:           caster,
+		rooms:            newRoomManager(),
+		albums:           newAlbumCollector(),
+		ingest:           newIngestCollector(),
+		playerStates:     newPlayerStateStore(),
+		ipLimiter:        web.NewRateLimiter(config.RateLimitPerIP, config.RateLimitBurst),
+		chatLimiter:      web.NewRateLimiter(config.RateLimitPerChat, config.RateLimitBurst),
+		streams:          web.NewStreamRegistry(),
+		connections:      web.NewConnectionTracker(reconnectWindow),
+		wsManager:        web.NewWebSocketManager(webLogger),
+		trustedProxies:   trustedProxies,
+		cors:             cors,
+		compression:      compression,
+		localToken:       localToken,
+		webhooks:         webhooks,
+		workers:          workers,
+	}
+	b.upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return b.cors.CheckOrigin(r, b.config.BaseURL) },
+	}
+	b.live.Store(newLiveSettings(config))
+	b.playerSessions = b.newPlayerSessions()
+	config.BinaryCache.SetSaveErrorHandler(b.handleCacheSaveError)
+	return b, nil
 }
 
-// Run starts the Telegram bot and web server.
-func (b *TelegramBot) Run() {
+// Run starts the Telegram bot and web server and blocks until ctx is cancelled, then shuts down
+// gracefully.
+func (b *TelegramBot) Run(ctx context.Context) {
 	b.logger.Printf("Starting Telegram bot (@%s)...\n", b.tgClient.Self.Username)
+	// The background tasks also stop when the bot stops for another reason than ctx, so that
+	// shutdown does not wait for them forever.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	b.registerHandlers()
+	b.logStartupDiagnostics()
+	b.watchSessions()
 
+	b.server = b.newWebServer()
 	go b.startWebServer()
 
-	if err := b.tgClient.Idle(); err != nil {
-		b.logger.Fatalf("Failed to start Telegram client: %s", err)
+	rollupDone := make(chan struct{})
+	go func() {
+		b.runStatsRollup(ctx)
+		close(rollupDone)
+	}()
+	if b.config.CacheCompactThreshold > 0 {
+		go b.runCacheCompaction(ctx)
+	}
+	if b.config.MinFreeDisk > 0 {
+		go b.runDiskWatchdog(ctx)
+	}
+	go b.wsManager.Sweep(ctx)
+	go b.runScheduler(ctx)
+	go b.runMetadataPrune(ctx)
+	go b.runPrefetchWorkers(ctx)
+	if b.webhooks != nil {
+		go b.webhooks.Run(ctx)
+		go b.runEvictionStormWatch(ctx)
+	}
+	if b.config.AdminRPCSocket != "" {
+		go b.runAdminRPC(ctx)
+	}
+	go b.runConfigReload(ctx)
+
+	idle := make(chan error, 1)
+	go func() { idle <- b.tgClient.Idle() }()
+
+	select {
+	case err := <-idle:
+		if err != nil {
+			b.logger.Fatalf("Failed to start Telegram client: %s", err)
+		}
+	case err := <-b.sessionLost:
+		cancel()
+		b.shutdown(rollupDone)
+		b.logger.Fatalf("Stopped because Telegram invalidated the session: %v. %s", err, b.reloginHint())
+	case <-ctx.Done():
+	}
+
+	cancel()
+	b.shutdown(rollupDone)
+}
+
+// shutdown stops accepting requests and lets active streams finish within the grace period
+// before the Telegram client and the database are closed.
+func (b *TelegramBot) shutdown(rollupDone <-chan struct{}) {
+	b.logger.Printf("Shutting down, waiting up to %s for active streams to finish", b.config.ShutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.ShutdownGracePeriod)
+	defer cancel()
+	if err := b.server.Shutdown(ctx); err != nil {
+		b.logger.Printf("Closing remaining connections after the grace period: %v", err)
+		_ = b.server.Close()
+	}
+
+	if b.hls != nil {
+		b.hls.Stop()
 	}
+	if b.workers != nil {
+		b.workers.stop()
+	}
+	b.tgClient.Stop()
+	b.session.release()
+	b.webhooks.Flush(ctx)
+
+	<-rollupDone
+	if err := b.db.Close(); err != nil {
+		b.logger.Printf("Failed to close database: %v", err)
+	}
+	b.logger.Printf("Shutdown complete")
 }
 
 func (b *TelegramBot) registerHandlers() {
 	clientDispatcher := b.tgClient.Dispatcher
-	clientDispatcher.AddHandler(handlers.NewCommand("start", b.handleStartCommand))
-	clientDispatcher.AddHandler(handlers.NewCommand("authorize", b.handleAuthorizeUser))
-	clientDispatcher.AddHandler(handlers.NewCommand("deauthorize", b.handleDeauthorizeUser)) // Add this line
-	clientDispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("cb_"), b.handleCallbackQuery))
+	clientDispatcher.AddHandler(handlers.NewCommand("start", b.sequenced(b.handleStartCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("authorize", b.sequenced(b.handleAuthorizeUser)))
+	clientDispatcher.AddHandler(handlers.NewCommand("deauthorize", b.sequenced(b.handleDeauthorizeUser))) // Add this line
+	clientDispatcher.AddHandler(handlers.NewCommand("settings", b.sequenced(b.handleSettingsCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("add", b.sequenced(b.handleAddCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("pushto", b.sequenced(b.handlePushToCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("share", b.sequenced(b.handleShareCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("revokeshare", b.sequenced(b.handleRevokeShareCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("report", b.sequenced(b.handleReportCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("cachestats", b.sequenced(b.handleCacheStatsCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("cachecompact", b.sequenced(b.handleCacheCompactCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("prefetch", b.sequenced(b.handlePrefetchCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("jobs", b.sequenced(b.handleJobsCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("usage", b.sequenced(b.handleUsageCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("quota", b.sequenced(b.handleQuotaCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("limit", b.sequenced(b.handleLimitCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("streams", b.sequenced(b.handleStreamsCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("killstreams", b.sequenced(b.handleKillStreamsCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("queue", b.sequenced(b.handleQueueCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("playnext", b.sequenced(b.handlePlayNextCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("clearqueue", b.sequenced(b.handleClearQueueCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("history", b.sequenced(b.handleHistoryCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("recent", b.sequenced(b.handleRecentCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("search", b.sequenced(b.handleSearchCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("room", b.sequenced(b.handleRoomCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("cast", b.sequenced(b.handleCastCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("migrate", b.sequenced(b.handleMigrateCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("bridge_text", b.sequenced(b.handleBridgeCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("nowplaying", b.sequenced(b.handleNowPlayingCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("seek", b.sequenced(b.handleSeekCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("language", b.sequenced(b.handleLanguageCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("subscribe", b.sequenced(b.handleSubscribeCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("unsubscribe", b.sequenced(b.handleUnsubscribeCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("subscriptions", b.sequenced(b.handleSubscriptionsCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("schedule", b.sequenced(b.handleScheduleCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("schedules", b.sequenced(b.handleSchedulesCommand)))
+	clientDispatcher.AddHandler(handlers.NewCommand("unschedule", b.sequenced(b.handleUnscheduleCommand)))
+	clientDispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("cb_"), b.sequenced(b.handleCallbackQuery)))
 	clientDispatcher.AddHandler(handlers.NewAnyUpdate(b.handleAnyUpdate))
-	clientDispatcher.AddHandler(handlers.NewMessage(filters.Message.Audio, b.handleMediaMessages))
-	clientDispatcher.AddHandler(handlers.NewMessage(filters.Message.Video, b.handleMediaMessages))
-	clientDispatcher.AddHandler(handlers.NewMessage(filters.Message.Photo, b.handleMediaMessages))
+	// Channel posts go to subscribers before the media handlers, which only serve user chats.
+	clientDispatcher.AddHandler(handlers.NewMessage(isChannelMediaPost, b.sequenced(b.handleChannelPost)))
+	clientDispatcher.AddHandler(handlers.NewMessage(filters.Message.Audio, b.sequenced(b.handleMediaMessages)))
+	clientDispatcher.AddHandler(handlers.NewMessage(filters.Message.Video, b.sequenced(b.handleMediaMessages)))
+	clientDispatcher.AddHandler(handlers.NewMessage(filters.Message.Photo, b.sequenced(b.handleMediaMessages)))
+	clientDispatcher.AddHandler(handlers.NewMessage(isImageDocument, b.sequenced(b.handleMediaMessages)))
+	// Links to channel posts are streamed rather than bridged as text.
+	clientDispatcher.AddHandler(handlers.NewMessage(isMessageLink, b.sequenced(b.handleMessageLink)))
+	clientDispatcher.AddHandler(handlers.NewMessage(isBridgeableMessage, b.sequenced(b.handleBridgeMessage)))
 }
 
 func (b *TelegramBot) handleStartCommand(ctx *ext.Context, u *ext.Update) error {
@@ -153,6 +439,14 @@ func (b *TelegramBot) handleStartCommand(ctx *ext.Context, u *ext.Update) error
 		if err != nil {
 			b.logger.Printf("Failed to store user info: %v", err)
 		}
+		b.webhooks.Send(webhook.EventUserRegistered, map[string]any{
+			"userId":       user.ID,
+			"username":     user.Username,
+			"firstName":    user.FirstName,
+			"lastName":     user.LastName,
+			"isAdmin":      isAdmin,
+			"isAuthorized": isAuthorized,
+		})
 
 		// Notify admins if the user is not an admin
 		if !isAdmin {
@@ -164,22 +458,17 @@ func (b *TelegramBot) handleStartCommand(ctx *ext.Context, u *ext.Update) error
 	}
 
 	// Send the start message to the user
-	webURL := fmt.Sprintf("%s/%d", b.config.BaseURL, chatID)
-	startMsg := fmt.Sprintf(
-		"Hello %s, I am @%s, your bridge between Telegram and the Web!\n"+
-			"You can forward media to this bot, and I will play it on your web player instantly.\n"+
-			"Click on 'Open Web URL' below or access your player here: %s",
-		user.FirstName, ctx.Self.Username, webURL,
-	)
-	err = b.sendMediaURLReply(ctx, u, startMsg, webURL)
+	lang := languageOf(existingUser, user.LangCode)
+	webURL := b.playerURL(chatID)
+	startMsg := i18n.T(lang, "start.greeting", user.FirstName, ctx.Self.Username, webURL)
+	err = b.sendMediaURLReply(ctx, u, lang, startMsg, webURL)
 	if err != nil {
 		b.logger.Printf("Failed to send start message: %v", err)
 	}
 
 	// If the user is not authorized, send an additional message informing them
 	if !isAuthorized {
-		authorizationMsg := "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation."
-		return b.sendReply(ctx, u, authorizationMsg)
+		return b.sendReply(ctx, u, i18n.T(lang, "auth.notAuthorized"))
 	}
 
 	return nil
@@ -193,15 +482,14 @@ func (b *TelegramBot) notifyAdminsAboutNewUser(newUser *tg.User) {
 		return
 	}
 
-	var notificationMsg string
+	name := fmt.Sprintf("%s %s", newUser.FirstName, newUser.LastName)
 	if username, hasUsername := newUser.GetUsername(); hasUsername {
-		notificationMsg = fmt.Sprintf("A new user has joined: @%s %s %s\nID: %d\nUse this command: /authorize %d", username, newUser.FirstName, newUser.LastName, newUser.ID, newUser.ID)
-	} else {
-		notificationMsg = fmt.Sprintf("A new user has joined: %s %s\nID: %d\nUse this command: /authorize %d", newUser.FirstName, newUser.LastName, newUser.ID, newUser.ID)
+		name = fmt.Sprintf("@%s %s", username, name)
 	}
 
 	for _, admin := range admins {
 		b.logger.Printf("Notifying admin %d about new user %d", admin.UserID, newUser.ID)
+		notificationMsg := i18n.T(admin.Language, "admin.newUser", name, newUser.ID, newUser.ID)
 		_, err := b.tgCtx.SendMessage(admin.ChatID, &tg.MessagesSendMessageRequest{Message: notificationMsg})
 		if err != nil {
 			b.logger.Printf("Failed to notify admin %d: %v", admin.UserID, err)
@@ -210,26 +498,28 @@ func (b *TelegramBot) notifyAdminsAboutNewUser(newUser *tg.User) {
 }
 
 func (b *TelegramBot) handleAuthorizeUser(ctx *ext.Context, u *ext.Update) error {
+	lang := b.updateLanguage(u)
+
 	// Only allow admins to run this command
 	adminID := u.EffectiveUser().ID
 	userInfo, err := b.userRepository.GetUserInfo(adminID)
 	if err != nil {
 		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
-		return b.sendReply(ctx, u, "Failed to authorize the user.")
+		return b.sendReply(ctx, u, i18n.T(lang, "authorize.failed"))
 	}
 
 	if !userInfo.IsAdmin {
-		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+		return b.sendReply(ctx, u, i18n.T(lang, "auth.adminOnly"))
 	}
 
 	// Parse the user ID and optional admin flag from the command
 	args := strings.Fields(u.EffectiveMessage.Text)
 	if len(args) < 2 {
-		return b.sendReply(ctx, u, "Usage: /authorize <user_id> [admin]")
+		return b.sendReply(ctx, u, i18n.T(lang, "authorize.usage"))
 	}
 	targetUserID, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return b.sendReply(ctx, u, "Invalid user ID.")
+		return b.sendReply(ctx, u, i18n.T(lang, "user.invalidID"))
 	}
 
 	isAdmin := len(args) > 2 && args[2] == "admin"
@@ -238,47 +528,48 @@ func (b *TelegramBot) handleAuthorizeUser(ctx *ext.Context, u *ext.Update) error
 	err = b.userRepository.AuthorizeUser(targetUserID, isAdmin)
 	if err != nil {
 		b.logger.Printf("Failed to authorize user %d: %v", targetUserID, err)
-		return b.sendReply(ctx, u, "Failed to authorize the user.")
+		return b.sendReply(ctx, u, i18n.T(lang, "authorize.failed"))
 	}
 
-	adminMsg := ""
 	if isAdmin {
-		adminMsg = " as an admin"
+		return b.sendReply(ctx, u, i18n.T(lang, "authorize.doneAdmin", targetUserID))
 	}
-	return b.sendReply(ctx, u, fmt.Sprintf("User %d has been authorized%s.", targetUserID, adminMsg))
+	return b.sendReply(ctx, u, i18n.T(lang, "authorize.done", targetUserID))
 }
 
 func (b *TelegramBot) handleDeauthorizeUser(ctx *ext.Context, u *ext.Update) error {
+	lang := b.updateLanguage(u)
+
 	// Only allow admins to run this command
 	adminID := u.EffectiveUser().ID
 	userInfo, err := b.userRepository.GetUserInfo(adminID)
 	if err != nil {
 		b.logger.Printf("Failed to retrieve user info for admin check: %v", err)
-		return b.sendReply(ctx, u, "Failed to deauthorize the user.")
+		return b.sendReply(ctx, u, i18n.T(lang, "deauthorize.failed"))
 	}
 
 	if !userInfo.IsAdmin {
-		return b.sendReply(ctx, u, "You are not authorized to perform this action.")
+		return b.sendReply(ctx, u, i18n.T(lang, "auth.adminOnly"))
 	}
 
 	// Parse the user ID from the command
 	args := strings.Fields(u.EffectiveMessage.Text)
 	if len(args) < 2 {
-		return b.sendReply(ctx, u, "Usage: /deauthorize <user_id>")
+		return b.sendReply(ctx, u, i18n.T(lang, "deauthorize.usage"))
 	}
 	targetUserID, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return b.sendReply(ctx, u, "Invalid user ID.")
+		return b.sendReply(ctx, u, i18n.T(lang, "user.invalidID"))
 	}
 
 	// Deauthorize the user
 	err = b.userRepository.DeauthorizeUser(targetUserID)
 	if err != nil {
 		b.logger.Printf("Failed to deauthorize user %d: %v", targetUserID, err)
-		return b.sendReply(ctx, u, "Failed to deauthorize the user.")
+		return b.sendReply(ctx, u, i18n.T(lang, "deauthorize.failed"))
 	}
 
-	return b.sendReply(ctx, u, fmt.Sprintf("User %d has been deauthorized.", targetUserID))
+	return b.sendReply(ctx, u, i18n.T(lang, "deauthorize.done", targetUserID))
 }
 
 func (b *TelegramBot) handleAnyUpdate(ctx *ext.Context, u *ext.Update) error {
@@ -300,10 +591,11 @@ func (b *TelegramBot) handleMediaMessages(ctx *ext.Context, u *ext.Update) error
 		return fmt.Errorf("failed to retrieve user info: %v", err)
 	}
 
+	lang := languageOf(existingUser, user.LangCode)
 	if !existingUser.IsAuthorized {
-		authorizationMsg := "You are not authorized to use this bot yet. Please ask one of the administrators to authorize you and wait until you receive a confirmation."
-		return b.sendReply(ctx, u, authorizationMsg)
+		return b.sendReply(ctx, u, i18n.T(lang, "auth.notAuthorized"))
 	}
+	b.recordActiveUser(user.ID)
 
 	if supported, err := isSupportedMedia(u.EffectiveMessage); !supported || err != nil {
 		b.logger.Printf("Unsupported media type received in chat ID %d", chatID)
@@ -316,10 +608,37 @@ func (b *TelegramBot) handleMediaMessages(ctx *ext.Context, u *ext.Update) error
 		return err
 	}
 
+	if b.rejectLargeFile(ctx, u, lang, user.ID, file) {
+		return dispatcher.EndGroups
+	}
+
+	settings, err := b.userRepository.GetUserSettings(user.ID)
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for user %d: %v", user.ID, err)
+	} else if mediaType := data.MediaTypeOf(file.MimeType, file.AudioAttr.Voice); !settings.Bridges(mediaType) {
+		b.logger.Printf("Not bridging %s message ID %d in chat ID %d, turned off in the user's settings", mediaType, u.EffectiveMessage.Message.ID, chatID)
+		return dispatcher.EndGroups
+	}
+
+	b.indexDocument(file)
 	fileURL := b.generateFileURL(u.EffectiveMessage.Message.ID, file)
 	b.logger.Printf("Generated media file URL for message ID %d in chat ID %d: %s", u.EffectiveMessage.Message.ID, chatID, fileURL)
+	b.recordMedia(user.ID, u.EffectiveMessage.Message.ID, file, u.EffectiveMessage.Message.Message)
+	if b.config.PrefetchAutoMaxSize > 0 {
+		b.autoPrefetch(chatID, user.ID, u.EffectiveMessage.Message.ID, file)
+	}
+
+	// The images of an album are shown together as a gallery.
+	if groupedID, ok := u.EffectiveMessage.Message.GetGroupedID(); ok && strings.HasPrefix(file.MimeType, "image/") {
+		b.addToAlbum(chatID, groupedID, u.EffectiveMessage.Message.ID, file)
+		return nil
+	}
+	// Many files sent at once are answered with one summary instead of a reply each.
+	if b.addToBatch(chatID, user.ID, lang, u.EffectiveMessage.Message.ID, file) {
+		return nil
+	}
 
-	return b.sendMediaToUser(ctx, u, fileURL, file)
+	return b.sendMediaToUser(ctx, u, lang, u.EffectiveMessage.Message.ID, fileURL, file)
 }
 
 func (b *TelegramBot) isUserChat(ctx *ext.Context, chatID int64) bool {
@@ -339,14 +658,14 @@ func (b *TelegramBot) sendReply(ctx *ext.Context, u *ext.Update, msg string) err
 	return err
 }
 
-func (b *TelegramBot) sendMediaURLReply(ctx *ext.Context, u *ext.Update, msg, webURL string) error {
+func (b *TelegramBot) sendMediaURLReply(ctx *ext.Context, u *ext.Update, lang, msg, webURL string) error {
 	_, err := ctx.Reply(u, msg, &ext.ReplyOpts{
 		Markup: &tg.ReplyInlineMarkup{
 			Rows: []tg.KeyboardButtonRow{
 				{
 					Buttons: []tg.KeyboardButtonClass{
-						&tg.KeyboardButtonURL{Text: "Open Web URL", URL: webURL},
-						&tg.KeyboardButtonURL{Text: "WebBridgeBot on GitHub", URL: "https://github.com/mshafiee/webbridgebot"},
+						&tg.KeyboardButtonURL{Text: i18n.T(lang, "button.openWebURL"), URL: webURL},
+						&tg.KeyboardButtonURL{Text: i18n.T(lang, "button.github"), URL: "https://github.com/mshafiee/webbridgebot"},
 					},
 				},
 			},
@@ -358,71 +677,143 @@ func (b *TelegramBot) sendMediaURLReply(ctx *ext.Context, u *ext.Update, msg, we
 	return err
 }
 
-func (b *TelegramBot) sendMediaToUser(ctx *ext.Context, u *ext.Update, fileURL string, file *types.DocumentFile) error {
-	_, err := ctx.Reply(u, fileURL, &ext.ReplyOpts{
-		Markup: &tg.ReplyInlineMarkup{
-			Rows: []tg.KeyboardButtonRow{
-				{
-					Buttons: []tg.KeyboardButtonClass{
-						&tg.KeyboardButtonCallback{
-							Text: "Resend to Player",
-							Data: []byte(fmt.Sprintf("%s,%d", callbackResendToPlayer, u.EffectiveMessage.Message.ID)),
-						},
-						&tg.KeyboardButtonURL{Text: "Stream URL", URL: fileURL},
+// sendMediaToUser replies with the links of the media of a message in the chat and sends it to
+// the player. The message is usually the update's own, but can be a copy the bot made.
+func (b *TelegramBot) sendMediaToUser(ctx *ext.Context, u *ext.Update, lang string, messageID int, fileURL string, file *types.DocumentFile) error {
+	markup := &tg.ReplyInlineMarkup{
+		Rows: []tg.KeyboardButtonRow{
+			{
+				Buttons: []tg.KeyboardButtonClass{
+					&tg.KeyboardButtonCallback{
+						Text: i18n.T(lang, "button.resend"),
+						Data: []byte(fmt.Sprintf("%s,%d", callbackResendToPlayer, messageID)),
+					},
+					&tg.KeyboardButtonURL{Text: i18n.T(lang, "button.streamURL"), URL: fileURL},
+				},
+			},
+			{
+				Buttons: []tg.KeyboardButtonClass{
+					&tg.KeyboardButtonURL{Text: i18n.T(lang, "button.download"), URL: b.generateDownloadURL(messageID, file)},
+					&tg.KeyboardButtonCallback{
+						Text: i18n.T(lang, "button.cacheOffline"),
+						Data: []byte(fmt.Sprintf("%s,%d", callbackPrefetch, messageID)),
 					},
 				},
 			},
 		},
-	})
+	}
+	if row, ok := qualityButtons(messageID, file); ok {
+		markup.Rows = append(markup.Rows, row)
+	}
+	if row, ok := subtitleButtons(messageID, b.subtitleTracks(messageID, file)); ok {
+		markup.Rows = append(markup.Rows, row)
+	}
+	_, err := ctx.Reply(u, fileURL, &ext.ReplyOpts{Markup: markup})
 	if err != nil {
-		b.logger.Printf("Error sending reply for chat ID %d, message ID %d: %v", u.EffectiveChat().GetID(), u.EffectiveMessage.Message.ID, err)
+		b.logger.Printf("Error sending reply for chat ID %d, message ID %d: %v", u.EffectiveChat().GetID(), messageID, err)
 		return err
 	}
 
-	wsMsg := b.constructWebSocketMessage(fileURL, file)
-	b.publishToWebSocket(u.EffectiveChat().GetID(), wsMsg)
+	wsMsg := b.constructWebSocketMessage(messageID, fileURL, file)
+	b.publishMedia(u.EffectiveChat().GetID(), messageID, wsMsg)
 	return nil
 }
 
-func (b *TelegramBot) constructWebSocketMessage(fileURL string, file *types.DocumentFile) map[string]string {
-	return map[string]string{
-		"url":      fileURL,
-		"fileName": file.FileName,
-		"fileId":   strconv.Itoa(int(file.ID)),
-		"mimeType": file.MimeType,
-		"duration": strconv.Itoa(int(file.VideoAttr.Duration)),
-		"width":    strconv.Itoa(file.VideoAttr.W),
-		"height":   strconv.Itoa(file.VideoAttr.H),
+func (b *TelegramBot) constructWebSocketMessage(messageID int, fileURL string, file *types.DocumentFile) map[string]string {
+	msg := map[string]string{
+		"url":       fileURL,
+		"messageId": strconv.Itoa(messageID),
+		"fileName":  file.FileName,
+		"fileId":    strconv.FormatInt(file.ID, 10),
+		"mimeType":  file.MimeType,
+		"duration":  strconv.Itoa(int(file.VideoAttr.Duration)),
+		"width":     strconv.Itoa(file.VideoAttr.W),
+		"height":    strconv.Itoa(file.VideoAttr.H),
+	}
+	if len(file.Alternatives) > 0 {
+		msg["qualities"] = b.encodeQualities(messageID, file)
+	}
+	if b.hls != nil && strings.HasPrefix(file.MimeType, "video") {
+		msg["hlsUrl"] = b.generateHLSURL(messageID, file)
+	}
+	if tracks := b.subtitleTracks(messageID, file); len(tracks) > 0 {
+		msg["subtitles"] = b.encodeSubtitles(messageID, file, tracks)
 	}
+	if b.hasThumbnail(file) {
+		msg["thumbnailUrl"] = b.generateThumbnailURL(messageID, file)
+	}
+	if strings.HasPrefix(file.MimeType, "audio") {
+		b.addAudioMetadata(msg, messageID, file)
+	}
+	return msg
 }
 
 func (b *TelegramBot) generateFileURL(messageID int, file *types.DocumentFile) string {
-	hash := utils.GetShortHash(utils.PackFile(
+	return fmt.Sprintf("%s/%d/%s", b.config.BaseURL, messageID, b.fileHash(file))
+}
+
+// generateDownloadURL returns the URL that serves a file as an attachment.
+func (b *TelegramBot) generateDownloadURL(messageID int, file *types.DocumentFile) string {
+	return fmt.Sprintf("%s/download/%d/%s", b.config.BaseURL, messageID, b.fileHash(file))
+}
+
+// fileHash returns the short hash that authorizes access to a file. Files with the same content
+// share the hash of their canonical document.
+func (b *TelegramBot) fileHash(file *types.DocumentFile) string {
+	return utils.GetShortHash(utils.PackFile(
 		file.FileName,
 		file.FileSize,
 		file.MimeType,
-		file.ID,
+		b.canonicalDocument(file.ID),
 	), b.config.HashLength)
-	return fmt.Sprintf("%s/%d/%s", b.config.BaseURL, messageID, hash)
 }
 
 func (b *TelegramBot) publishToWebSocket(chatID int64, message map[string]string) {
-	if client, ok := wsClients[chatID]; ok {
-		messageJSON, err := json.Marshal(message)
-		if err != nil {
-			log.Println("Error marshalling message:", err)
-			return
-		}
-		if err := client.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
-			log.Println("Error sending WebSocket message:", err)
-			delete(wsClients, chatID)
-			client.Close()
-		}
+	if !b.wsManager.Connected(chatID) {
+		return
+	}
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		log.Println("Error marshalling message:", err)
+		return
+	}
+	if err := b.wsManager.Send(chatID, messageJSON); err != nil && !errors.Is(err, web.ErrNoWebSocketClient) {
+		b.logger.Printf("Error sending WebSocket message to chat ID %d: %v", chatID, err)
 	}
 }
 
 func (b *TelegramBot) handleCallbackQuery(ctx *ext.Context, u *ext.Update) error {
 	dataParts := strings.Split(string(u.CallbackQuery.Data), ",")
+	if len(dataParts) > 0 && dataParts[0] == callbackSettings {
+		return b.handleSettingsCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackSelectQuality {
+		return b.handleQualityCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackSubtitles {
+		return b.handleSubtitlesCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackSearch {
+		return b.handleSearchCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackCast {
+		return b.handleCastCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackGallery {
+		return b.handleGalleryCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackPlayer {
+		return b.handlePlayerCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackLanguage {
+		return b.handleLanguageCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackPrefetch {
+		return b.handlePrefetchCallback(ctx, u, dataParts)
+	}
+	if len(dataParts) > 0 && dataParts[0] == callbackHistory {
+		return b.handleHistoryCallback(ctx, u, dataParts)
+	}
 	if len(dataParts) > 0 && dataParts[0] == callbackResendToPlayer && len(dataParts) > 1 {
 		messageID, err := strconv.Atoi(dataParts[1])
 		if err != nil {
@@ -434,13 +825,14 @@ func (b *TelegramBot) handleCallbackQuery(ctx *ext.Context, u *ext.Update) error
 			b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
 		}
 
-		wsMsg := b.constructWebSocketMessage(b.generateFileURL(messageID, file), file)
-		b.publishToWebSocket(u.EffectiveChat().GetID(), wsMsg)
+		wsMsg := b.constructWebSocketMessage(messageID, b.generateFileURL(messageID, file), file)
+		b.publishMedia(u.EffectiveChat().GetID(), messageID, wsMsg)
+		b.recordPlayed(u.CallbackQuery.UserID, messageID)
 
 		_, _ = ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
 			Alert:   true,
 			QueryID: u.CallbackQuery.QueryID,
-			Message: fmt.Sprintf("The %s file has been sent to the web player.", file.FileName),
+			Message: i18n.T(b.updateLanguage(u), "media.sentToPlayer", file.FileName),
 		})
 	}
 	return nil
@@ -461,49 +853,154 @@ func isSupportedMedia(m *gtypes.Message) (bool, error) {
 	}
 }
 
-func (b *TelegramBot) startWebServer() {
-	router := mux.NewRouter()
-
-	router.HandleFunc("/ws/{chatID}", b.handleWebSocket)
-	router.HandleFunc("/{messageID}/{hash}", b.handleStream)
-	router.HandleFunc("/{chatID}", b.handlePlayer)
-	router.HandleFunc("/{chatID}/", b.handlePlayer)
+// newWebServer creates the HTTP server with all routes.
+func (b *TelegramBot) newWebServer() *web.Server {
+	var tlsConfig *tls.Config
+	if b.config.TLSCertFile != "" {
+		// HTTP/2 is negotiated over TLS; WebSockets still upgrade over HTTP/1.1.
+		tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: []string{"h2", "http/1.1"},
+		}
+	}
+	server := web.NewServer(fmt.Sprintf(":%s", b.config.Port), tlsConfig)
+
+	clientIP := func(r *http.Request) string { return web.ClientIP(r, b.trustedProxies) }
+	httpLogger := logging.WithFields(b.logger, logging.Fields{"module": "http"})
+	// The request ID comes first, so the access log and the handlers' logs carry it.
+	server.Use(web.RequestID(), web.Logging(httpLogger, clientIP), web.Recovery(httpLogger))
+
+	// Requests that reach Telegram or external hosts are limited per client IP, player APIs per chat.
+	byIP := b.ipLimiter.Middleware(func(r *http.Request) string {
+		// ffmpeg reads the HLS sources, subtitles and thumbnails through local stream URLs.
+		if b.localRequest(r) {
+			return ""
+		}
+		return clientIP(r)
+	})
+	byChat := b.chatLimiter.Middleware(func(r *http.Request) string {
+		return mux.Vars(r)["chatID"]
+	})
+	// Pages, JSON, subtitles and HLS playlists are compressed; media is not.
+	compress := web.Compress(b.compression)
+	// Streams and APIs may be used by the sites allowed by CORS_ALLOWED_ORIGINS. The policy comes
+	// first, so preflight requests are answered before they are limited or authenticated.
+	cors := b.cors.Middleware()
+	// Player APIs are only served to the signed-in user of the chat.
+	player := []web.Middleware{byChat, b.requirePlayerSession}
+	playerAPI := []web.Middleware{cors, byChat, b.requirePlayerSession}
+	playerJSON := []web.Middleware{cors, byChat, b.requirePlayerSession, compress}
+
+	server.HandleFunc("/ws/{chatID}", b.handleWebSocket, player...)
+	server.HandleFunc("/manifest/{chatID}", b.handleManifest, compress)
+	server.HandleFunc("/sw.js", b.handleServiceWorker, compress)
+	server.HandleFunc("/icon.svg", b.handleIcon, compress)
+	server.HandleFunc("/custom.css", b.handleCustomCSS, compress)
+	server.HandleFunc("/api/settings/{chatID}", b.handleSettingsAPI, playerJSON...).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	server.HandleFunc("/api/upload/{chatID}", b.handleUpload, playerAPI...).Methods(http.MethodPost, http.MethodOptions)
+	server.HandleFunc("/api/stats/{chatID}", b.handleStatsAPI, cors, byChat, b.requireStatsToken, compress).Methods(http.MethodGet, http.MethodOptions)
+	server.HandleFunc("/api/cache-stats/{chatID}", b.handleCacheStatsAPI, cors, byChat, b.requireStatsToken, compress).Methods(http.MethodGet, http.MethodOptions)
+	server.HandleFunc("/api/connections/{chatID}", b.handleConnectionsAPI, cors, byChat, b.requireStatsToken, compress).Methods(http.MethodGet, http.MethodOptions)
+	server.HandleFunc("/admin/stats/{chatID}", b.handleDashboard, byChat, b.requireStatsToken, compress).Methods(http.MethodGet)
+	server.HandleFunc("/admin/stats/{chatID}/ws", b.handleDashboardWebSocket, byChat, b.requireStatsToken)
+	server.HandleFunc("/api/player-config/{chatID}", b.handlePlayerConfigAPI, playerJSON...).Methods(http.MethodGet, http.MethodOptions)
+	// The login widget needs a bot, so the admin panel is not available with a user account.
+	if b.config.ClientType == config.ClientTypeBot {
+		sessionKey := sha256.Sum256([]byte(b.config.BotToken + ":admin-session"))
+		web.NewAdminPanel(web.AdminPanelConfig{
+			BotToken:    b.config.BotToken,
+			BotUsername: b.tgClient.Self.Username,
+			BaseURL:     b.config.BaseURL,
+			BasePath:    b.basePath(),
+			SessionTTL:  b.config.AdminSessionTTL,
+			SessionKey:  sessionKey[:],
+		}, b.userRepository, b.streams, logging.WithFields(b.logger, logging.Fields{"module": "admin"})).Register(server, byIP, compress)
+	}
+	server.HandleFunc("/login", b.handlePlayerLogin, byIP, compress).Methods(http.MethodGet)
+	if b.config.ClientType == config.ClientTypeBot {
+		server.HandleFunc("/login/auth", b.handlePlayerAuth, byIP).Methods(http.MethodGet)
+	}
+	server.HandleFunc("/logout", b.handlePlayerLogout, byIP).Methods(http.MethodPost)
+	server.HandleFunc("/api/history/{chatID}", b.handleHistoryAPI, playerJSON...).Methods(http.MethodGet, http.MethodOptions)
+	// Proxied media may be played by pages of other sites.
+	server.HandleFunc("/proxy", b.handleProxy, byIP, web.CORS("*")).Methods(http.MethodGet, http.MethodHead)
+	server.HandleFunc("/hls/{messageID}/{hash}/{name}", b.handleHLS, cors, byIP, compress).Methods(http.MethodGet, http.MethodOptions)
+	server.HandleFunc("/subs/{messageID}/{hash}/{track:[0-9]+}.vtt", b.handleSubtitles, cors, byIP, compress).Methods(http.MethodGet, http.MethodOptions)
+	server.HandleFunc("/thumb/{messageID}/{hash}", b.handleThumbnail, cors, byIP).Methods(http.MethodGet, http.MethodOptions)
+	server.HandleFunc("/download/{messageID}/{hash}", b.handleDownload, cors, byIP).Methods(http.MethodGet, http.MethodHead, http.MethodOptions)
+	server.HandleFunc("/share/{token}", b.handleSharePage, byIP, compress).Methods(http.MethodGet)
+	server.HandleFunc("/share/{token}/stream", b.handleShareStream, cors, byIP).Methods(http.MethodGet, http.MethodHead, http.MethodOptions)
+	server.HandleFunc("/{messageID}/{hash}", b.handleStream, cors, byIP).Methods(http.MethodGet, http.MethodHead, http.MethodOptions)
+	server.HandleFunc("/{chatID}", b.handlePlayer, compress)
+	server.HandleFunc("/{chatID}/", b.handlePlayer, compress)
+
+	// WebSocket connections are hijacked, so Shutdown does not wait for them and they are
+	// closed explicitly.
+	server.OnShutdown(b.closeWebSockets)
+	return server
+}
 
-	log.Printf("Web server started on port %s", b.config.Port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%s", b.config.Port), router); err != nil {
+func (b *TelegramBot) startWebServer() {
+	if b.config.TLSCertFile != "" {
+		log.Printf("Web server started on port %s with TLS and HTTP/2", b.config.Port)
+	} else {
+		log.Printf("Web server started on port %s", b.config.Port)
+	}
+	if err := b.server.Start(b.config.TLSCertFile, b.config.TLSKeyFile); err != nil {
 		log.Panic(err)
 	}
 }
 
+// closeWebSockets tells all connected players that the server is going away and disconnects them.
+func (b *TelegramBot) closeWebSockets() {
+	b.wsManager.CloseAll(websocket.CloseGoingAway, "server shutting down")
+}
+
 // handleWebSocket manages WebSocket connections.
 func (b *TelegramBot) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	chatID, err := b.parseChatID(mux.Vars(r))
 	if err != nil {
-		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
 		return
 	}
 
-	ws, err := upgrader.Upgrade(w, r, nil)
+	// The upgrader refuses handshakes from sites not allowed by CORS_ALLOWED_ORIGINS, since
+	// browsers send the session cookie with WebSocket handshakes from any site.
+	ws, err := b.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	defer ws.Close()
 
-	// Register the WebSocket client.
-	wsClients[chatID] = ws
+	// Register the WebSocket client. The manager writes to it and keeps it alive. Admins can
+	// close it together with the user's streams.
+	client := b.wsManager.Register(chatID, ws)
+	// Removing a client twice does nothing; this covers a handler that panicked.
+	defer b.wsManager.Remove(client, "handler stopped")
+	ctx, done := b.streams.Track(r.Context(), chatID)
+	defer done()
+	go func() {
+		<-ctx.Done()
+		b.wsManager.Close(client, websocket.ClosePolicyViolation, "closed by an admin")
+	}()
+	b.recordActiveUser(chatID)
+	b.publishQueue(chatID, chatID)
+	b.announceRoom(chatID)
 
 	for {
-		// Keep the connection alive or handle control messages.
-		messageType, p, err := ws.ReadMessage()
+		messageType, p, err := client.ReadMessage()
 		if err != nil {
-			log.Println(err)
-			delete(wsClients, chatID)
+			b.wsManager.Remove(client, web.DisconnectReason(err))
 			break
 		}
+		// Handle reports sent by the player, such as download progress.
+		if messageType == websocket.TextMessage && b.handlePlayerReport(chatID, p) {
+			continue
+		}
 		// Echo the message back (optional, for keeping the connection alive).
-		if err := ws.WriteMessage(messageType, p); err != nil {
-			log.Println(err)
+		if err := client.Send(messageType, p); err != nil {
+			b.wsManager.Remove(client, fmt.Sprintf("echo failed: %v", err))
 			break
 		}
 	}
@@ -511,103 +1008,230 @@ func (b *TelegramBot) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 // handleStream handles the file streaming from Telegram.
 func (b *TelegramBot) handleStream(w http.ResponseWriter, r *http.Request) {
+	messageID, file, ok := b.fileFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	b.serveFile(w, r, messageID, file, false)
+}
+
+// handleDownload serves a file as an attachment for saving, with the same range and
+// conditional request support as the stream endpoint.
+func (b *TelegramBot) handleDownload(w http.ResponseWriter, r *http.Request) {
+	messageID, file, ok := b.fileFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	b.serveFile(w, r, messageID, file, true)
+}
+
+// fileFromRequest resolves the file addressed by the messageID and hash route variables. It
+// writes an error response and reports false if the file cannot be served.
+func (b *TelegramBot) fileFromRequest(w http.ResponseWriter, r *http.Request) (int, *types.DocumentFile, bool) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 	messageIDStr := vars["messageID"]
 	authHash := vars["hash"]
 
-	b.logger.Printf("Received request to stream file with message ID: %s from client %s", messageIDStr, r.RemoteAddr)
-
 	// Parse and validate message ID.
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
-		b.logger.Printf("Invalid message ID '%s' received from client %s", messageIDStr, r.RemoteAddr)
-		http.Error(w, "Invalid message ID format", http.StatusBadRequest)
-		return
+		b.logger.Printf("Invalid message ID '%s' received from client %s", messageIDStr, web.ClientIP(r, b.trustedProxies))
+		web.Error(w, "Invalid message ID format", http.StatusBadRequest)
+		return 0, nil, false
 	}
 
-	// Fetch the file from Telegram.
-	file, err := utils.FileFromMessage(ctx, b.tgClient, messageID)
+	// The stored file is used even if stale, since the reader refreshes expired file references.
+	file, err := utils.StoredFileFromMessage(ctx, b.tgClient, messageID)
 	if err != nil {
 		b.logger.Printf("Error fetching file for message ID %d: %v", messageID, err)
-		http.Error(w, "Unable to retrieve file for the specified message", http.StatusBadRequest)
-		return
+		web.Error(w, "Unable to retrieve file for the specified message", http.StatusBadRequest)
+		return 0, nil, false
 	}
 
-	expectedHash := utils.PackFile(file.FileName, file.FileSize, file.MimeType, file.ID)
-	if !utils.CheckHash(authHash, expectedHash, b.config.HashLength) {
-		b.logger.Printf("Hash verification failed for message ID %d from client %s", messageID, r.RemoteAddr)
-		http.Error(w, "Invalid authentication hash", http.StatusBadRequest)
-		return
+	// The hash selects the original file or one of its alternative qualities.
+	file, ok := b.findFileVariant(file, authHash)
+	if !ok {
+		b.logger.Printf("Hash verification failed for message ID %d from client %s", messageID, web.ClientIP(r, b.trustedProxies))
+		web.Error(w, "Invalid authentication hash", http.StatusBadRequest)
+		return 0, nil, false
 	}
 
+	return messageID, file, true
+}
+
+// serveFile streams a Telegram file to the client, honoring the Range header of the request.
+// With asAttachment, every response asks the browser to save the file, so download managers
+// can resume with range requests.
+func (b *TelegramBot) serveFile(w http.ResponseWriter, r *http.Request, messageID int, file *types.DocumentFile, asAttachment bool) {
+	var err error
+
 	contentLength := file.FileSize
+	if contentLength > reader.MaxSupportedFileSize {
+		b.logger.Printf("File for message ID %d is %d bytes, above the supported maximum", messageID, contentLength)
+		web.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	// Default range values for full content.
-	var start, end int64 = 0, contentLength - 1
+	etag := fileETag(file)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-	// Process range header if present.
+	// Process the Range header if present. With If-Range, the range is only honored if the
+	// client's copy is still current; otherwise the full file is sent.
 	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		b.logger.Printf("If-Range %s does not match %s for message ID %d, serving full content", ifRange, etag, messageID)
+		rangeHeader = ""
+	}
 	if rangeHeader != "" {
 		b.logger.Printf("Range header received for message ID %d: %s", messageID, rangeHeader)
-		if strings.HasPrefix(rangeHeader, "bytes=") {
-			ranges := strings.Split(rangeHeader[len("bytes="):], "-")
-			if len(ranges) == 2 {
-				if ranges[0] != "" {
-					start, err = strconv.ParseInt(ranges[0], 10, 64)
-					if err != nil {
-						b.logger.Printf("Invalid start range value for message ID %d: %v", messageID, err)
-						http.Error(w, "Invalid range start value", http.StatusBadRequest)
-						return
-					}
-				}
-				if ranges[1] != "" {
-					end, err = strconv.ParseInt(ranges[1], 10, 64)
-					if err != nil {
-						b.logger.Printf("Invalid end range value for message ID %d: %v", messageID, err)
-						http.Error(w, "Invalid range end value", http.StatusBadRequest)
-						return
-					}
-				}
-			}
+	}
+	ranges, err := web.ParseRange(rangeHeader, contentLength)
+	if err != nil && !errors.Is(err, web.ErrRangeNotSatisfiable) {
+		b.logger.Printf("Invalid range %q for message ID %d: %v", rangeHeader, messageID, err)
+		web.Error(w, "Invalid range", http.StatusBadRequest)
+		return
+	}
+	// Players never ask for several ranges at once, so multipart/byteranges responses are not
+	// supported and such requests are refused like unsatisfiable ones.
+	if err != nil || len(ranges) > 1 || contentLength == 0 {
+		b.logger.Printf("Requested range not satisfiable for message ID %d: %q, contentLength=%d", messageID, rangeHeader, contentLength)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", contentLength))
+		web.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	partial := len(ranges) == 1
+	var start, end int64 = 0, contentLength - 1
+	if partial {
+		start, end = ranges[0].Start, ranges[0].End
+	}
+
+	// The bytes are charged to the user who sent the media, and admins can stop the streams of
+	// their media, which cancels ctx. HEAD requests send no bytes and are not counted as streams.
+	ownerID := b.mediaOwner(messageID)
+	ctx := r.Context()
+	if r.Method != http.MethodHead {
+		var done func()
+		ctx, done = b.streams.Track(ctx, ownerID)
+		defer done()
+		if ownerID != 0 && !b.checkQuota(w, ownerID) {
+			return
+		}
+	}
+	// Limits lowered after the media was sent also stop it from streaming.
+	if ownerID != 0 {
+		if limit, err := b.fileSizeLimit(ownerID); err == nil && contentLength > limit {
+			b.logger.Printf("File for message ID %d is %d bytes, above the limit of %d bytes of user %d", messageID, contentLength, limit, ownerID)
+			web.Error(w, "File is larger than its owner may stream", http.StatusRequestEntityTooLarge)
+			return
 		}
 	}
 
-	// Validate the requested range.
-	if start > end || start < 0 || end >= contentLength {
-		b.logger.Printf("Requested range not satisfiable for message ID %d: start=%d, end=%d, contentLength=%d", messageID, start, end, contentLength)
-		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
-		return
+	// Create a TelegramReader to stream the content, once the stream is registered with the
+	// connection tracker, which refuses it if the owner has too many streams active. HEAD
+	// requests only probe the file, so no reader is opened for them.
+	var lr io.ReadCloser
+	var connID uint64
+	clientIP := web.ClientIP(r, b.trustedProxies)
+	if r.Method != http.MethodHead {
+		if b.connections.DetectReconnection(messageID, clientIP) {
+			b.logger.Printf("Client %s reconnected to message ID %d at byte %d", clientIP, messageID, start)
+		}
+		var ok bool
+		limit := b.live.Load().MaxStreamsPerUser
+		connID, ok = b.connections.RegisterUserConnection(ownerID, limit, messageID, clientIP, r.UserAgent(), start, end)
+		if !ok {
+			b.logger.Printf("Refused stream of message ID %d to %s: user %d has %d streams active", messageID, clientIP, ownerID, limit)
+			b.renderStreamLimit(w, r)
+			return
+		}
+		lr, err = b.newFileReader(ctx, messageID, file, start, end, contentLength,
+			logging.WithFields(b.logger, logging.Fields{"module": "reader", "message_id": messageID}))
+		if err != nil {
+			b.connections.MarkDisconnected(connID)
+			b.logger.Printf("Error creating Telegram reader for message ID %d: %v", messageID, err)
+			web.Error(w, "Failed to initialize file stream", http.StatusInternalServerError)
+			return
+		}
+		defer lr.Close()
 	}
 
-	// Create a TelegramReader to stream the content.
-	lr, err := reader.NewTelegramReader(ctx, b.tgClient, file.Location, start, end, contentLength, b.config.BinaryCache, b.logger)
-	if err != nil {
-		b.logger.Printf("Error creating Telegram reader for message ID %d: %v", messageID, err)
-		http.Error(w, "Failed to initialize file stream", http.StatusInternalServerError)
-		return
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
-	defer lr.Close()
 
 	// Send appropriate headers and stream the content.
-	if rangeHeader != "" {
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", contentType)
+	// nginx would otherwise buffer the stream, delaying playback and seeking.
+	w.Header().Set("X-Accel-Buffering", "no")
+	if asAttachment || !partial {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.FileName}))
+	}
+	if partial {
 		b.logger.Printf("Serving partial content for message ID %d: bytes %d-%d of %d", messageID, start, end, contentLength)
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, contentLength))
-		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
-		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Range", ranges[0].ContentRange(contentLength))
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].Length(), 10))
 		w.WriteHeader(http.StatusPartialContent)
 	} else {
 		b.logger.Printf("Serving full content for message ID %d", messageID)
 		w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, file.FileName))
+	}
+	if lr == nil {
+		return
 	}
 
-	// Stream the content to the client.
-	if _, err := io.Copy(w, lr); err != nil {
-		b.logger.Printf("Error streaming content for message ID %d: %v", messageID, err)
-		http.Error(w, "Error streaming content", http.StatusInternalServerError)
+	// Stream the content to the client, reporting the progress to the connection tracker.
+	live := b.live.Load()
+	out := web.ThrottledWriter(ctx, w, live.bandwidth, web.NewBandwidth(live.MaxStreamBandwidth))
+	written, err := reader.Copy(&trackedWriter{ctx: ctx, w: out, tracker: b.connections, id: connID}, lr)
+	if err == nil && written == end-start+1 {
+		b.connections.MarkCompleted(connID)
+		b.webhooks.Send(webhook.EventStreamCompleted, map[string]any{
+			"userId":    ownerID,
+			"messageId": messageID,
+			"fileName":  file.FileName,
+			"start":     start,
+			"end":       end,
+			"bytes":     written,
+			"download":  asAttachment,
+		})
+	} else {
+		b.connections.MarkDisconnected(connID)
+	}
+	b.recordStream(messageID, ownerID, file, start, written)
+	if err != nil && ctx.Err() != nil && r.Context().Err() == nil {
+		b.logger.Printf("Stream of message ID %d to %s was stopped by an admin", messageID, clientIP)
+		panic(http.ErrAbortHandler)
 	}
+	if err != nil {
+		b.abortStream(r, messageID, err)
+	}
+}
+
+// trackedWriter reports the bytes written to a stream connection to the connection tracker, and
+// stops writing once ctx is done.
+type trackedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	tracker *web.ConnectionTracker
+	id      uint64
+}
+
+func (tw *trackedWriter) Write(p []byte) (int, error) {
+	if err := tw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := tw.w.Write(p)
+	tw.tracker.UpdateActivity(tw.id, int64(n))
+	return n, err
 }
 
 func (b *TelegramBot) parseChatID(vars map[string]string) (int64, error) {
@@ -620,23 +1244,59 @@ func (b *TelegramBot) parseChatID(vars map[string]string) (int64, error) {
 }
 
 func (b *TelegramBot) handlePlayer(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request for player: %s", r.URL.Path)
-
 	chatID, err := b.parseChatID(mux.Vars(r))
 	if err != nil {
-		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+	if b.signInWithLink(w, r, chatID) {
+		return
+	}
+	session, ok := b.playerSession(r, chatID)
+	if !ok {
+		http.Redirect(w, r, b.basePath()+"login", http.StatusSeeOther)
 		return
 	}
+	// Every visit extends the session, so a player in use does not sign out.
+	session = b.playerSessions.Refresh(w, session)
+
+	// Without a language of their own, the player follows the browser.
+	settings, err := b.userRepository.GetUserSettings(playerUserID(chatID))
+	if err != nil {
+		b.logger.Printf("Failed to retrieve settings for chat %d: %v", chatID, err)
+		settings = data.DefaultUserSettings(playerUserID(chatID))
+	}
+	user, err := b.userRepository.GetUserInfo(playerUserID(chatID))
+	if err != nil {
+		user = nil
+	}
+	lang := languageOf(user, i18n.MatchAcceptLanguage(r.Header.Get("Accept-Language")))
 
-	t, err := template.ParseFiles(tmplPath)
+	t, err := template.New(path.Base(tmplPath)).Funcs(template.FuncMap{
+		"t": func(key string, args ...interface{}) string { return i18n.T(lang, key, args...) },
+	}).ParseFiles(tmplPath)
 	if err != nil {
 		b.logger.Printf("Error loading template: %v", err)
-		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		web.Error(w, "Failed to load template", http.StatusInternalServerError)
 		return
 	}
 
-	if err := t.Execute(w, map[string]interface{}{"ChatID": chatID}); err != nil {
+	branding := b.playerBranding()
+	if err := t.Execute(w, map[string]interface{}{
+		"ChatID":       chatID,
+		"BasePath":     b.basePath(),
+		"Theme":        settings.Theme,
+		"Density":      settings.Density,
+		"CSRFToken":    b.playerSessions.CSRFToken(session),
+		"Title":        branding.Title,
+		"AccentColor":  branding.AccentColor,
+		"LogoURL":      branding.LogoURL,
+		"CustomCSSURL": branding.CustomCSSURL,
+		"Language":     lang,
+		"Direction":    i18n.Direction(lang),
+		"Messages":     i18n.Messages(lang, "player."),
+	}); err != nil {
 		b.logger.Printf("Error rendering template: %v", err)
-		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		web.Error(w, "Failed to render template", http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/web"
+)
+
+const (
+	thumbnailWidth   = 480
+	thumbnailTimeout = 30 * time.Second
+	// Frames are grabbed a little into the video, since the first one is often black.
+	maxThumbnailOffset = 10 * time.Second
+)
+
+// errNoCoverArt is returned for MP3s whose tag has no picture.
+var errNoCoverArt = errors.New("no cover art")
+
+// generateThumbnailURL returns the preview image URL of a file.
+func (b *TelegramBot) generateThumbnailURL(messageID int, file *types.DocumentFile) string {
+	return fmt.Sprintf("%s/thumb/%d/%s", b.config.BaseURL, messageID, b.fileHash(file))
+}
+
+// hasThumbnail reports whether a preview image can be served for a file, either the one Telegram
+// keeps, a frame extracted with ffmpeg or the cover art of an MP3.
+func (b *TelegramBot) hasThumbnail(file *types.DocumentFile) bool {
+	return file.ThumbSize != "" || (b.config.FFmpegPath != "" && strings.HasPrefix(file.MimeType, "video")) || hasID3(file)
+}
+
+// thumbnailCacheKey returns the BinaryCache location of the thumbnail of a file, kept apart from
+// the location of the file itself.
+func thumbnailCacheKey(fileID int64) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "thumb:%d", fileID)
+	return int64(h.Sum64() >> 1)
+}
+
+// handleThumbnail serves the preview image of a file. Telegram's own thumbnail is preferred;
+// videos without one get a frame extracted by ffmpeg and MP3s their embedded cover art. Either
+// way the image is cached.
+func (b *TelegramBot) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	messageID, file, ok := b.fileFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !b.hasThumbnail(file) {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := thumbnailCacheKey(file.ID)
+	image, err := b.config.BinaryCache.GetChunk(key, 0)
+	if err != nil {
+		image, err = b.fetchThumbnail(r.Context(), messageID, file)
+		if errors.Is(err, errNoCoverArt) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			b.logger.Printf("Error producing thumbnail for message ID %d: %v", messageID, err)
+			web.Error(w, "Failed to produce a thumbnail", http.StatusBadGateway)
+			return
+		}
+		if err := b.config.BinaryCache.PutChunk(key, 0, image); err != nil {
+			b.logger.Printf("Failed to cache thumbnail for message ID %d: %v", messageID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(image))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(image)
+}
+
+// fetchThumbnail downloads the thumbnail of a file from Telegram, falling back to ffmpeg for
+// videos and to the ID3 tag for MP3s.
+func (b *TelegramBot) fetchThumbnail(ctx context.Context, messageID int, file *types.DocumentFile) ([]byte, error) {
+	isVideo := b.config.FFmpegPath != "" && strings.HasPrefix(file.MimeType, "video")
+	if file.ThumbSize != "" {
+		image, err := reader.DownloadThumbnail(ctx, b.tgClient, file.Location, file.ThumbSize)
+		if err == nil && len(image) > 0 {
+			return image, nil
+		}
+		if err == nil {
+			err = errors.New("empty thumbnail")
+		}
+		if !isVideo && !hasID3(file) {
+			return nil, fmt.Errorf("failed to download Telegram thumbnail: %w", err)
+		}
+		b.logger.Printf("Failed to download Telegram thumbnail for message ID %d, falling back to the file itself: %v", messageID, err)
+	}
+
+	if !isVideo {
+		tags, err := b.readAudioTags(ctx, messageID, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the ID3 tag: %w", err)
+		}
+		if tags == nil || len(tags.Picture) == 0 {
+			return nil, errNoCoverArt
+		}
+		return tags.Picture, nil
+	}
+
+	at := time.Duration(file.VideoAttr.Duration * float64(time.Second) / 10)
+	if at > maxThumbnailOffset {
+		at = maxThumbnailOffset
+	}
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+	return web.ExtractThumbnail(ctx, b.config.FFmpegPath, b.localStreamURL(messageID, file), at, thumbnailWidth)
+}
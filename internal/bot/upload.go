@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"webBridgeBot/internal/utils"
+	"webBridgeBot/internal/web"
+
+	"github.com/gorilla/mux"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	maxUploadSize       = 2000 * 1024 * 1024 // Telegram's upload limit for bots.
+	maxUploadMemorySize = 32 * 1024 * 1024   // Larger uploads are buffered on disk while parsing.
+)
+
+// handleUpload receives a file from the web player, sends it to the user's Telegram chat
+// and immediately makes it available for streaming on the player. The CSRF token is checked by
+// requirePlayerSession; the session is checked here again, so that anything rendered on a player
+// page, which may be shared, never lets others send files to the chat.
+func (b *TelegramBot) handleUpload(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.parseChatID(mux.Vars(r))
+	if err != nil {
+		web.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := b.playerSession(r, chatID); !ok {
+		web.Error(w, "Sign in to upload files", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := b.userRepository.GetUserInfo(playerUserID(chatID))
+	if err != nil || !user.IsAuthorized {
+		web.Error(w, "User is not authorized", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadMemorySize); err != nil {
+		b.logger.Printf("Error parsing upload from chat ID %d: %v", chatID, err)
+		web.Error(w, "Invalid or too large upload", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		web.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	b.logger.Printf("Uploading file %q (%d bytes) from web player to chat ID %d", header.Filename, header.Size, chatID)
+
+	ctx := r.Context()
+	inputFile, err := uploader.NewUploader(b.tgClient.API()).Upload(ctx, uploader.NewUpload(header.Filename, f, header.Size))
+	if err != nil {
+		b.logger.Printf("Error uploading file to Telegram for chat ID %d: %v", chatID, err)
+		web.Error(w, "Failed to upload file to Telegram", http.StatusBadGateway)
+		return
+	}
+
+	mimeType := header.Header.Get("Content-Type")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(header.Filename)); byExt != "" {
+			mimeType = byExt
+		} else {
+			mimeType = "application/octet-stream"
+		}
+	}
+
+	msg, err := b.tgCtx.SendMedia(chatID, &tg.MessagesSendMediaRequest{
+		Media: &tg.InputMediaUploadedDocument{
+			File:     inputFile,
+			MimeType: mimeType,
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: header.Filename},
+			},
+		},
+		Message: "Uploaded from the web player.",
+	})
+	if err != nil {
+		b.logger.Printf("Error sending uploaded file to chat ID %d: %v", chatID, err)
+		web.Error(w, "Failed to send file to Telegram", http.StatusBadGateway)
+		return
+	}
+
+	file, err := utils.FileFromMedia(msg.Media)
+	if err != nil {
+		b.logger.Printf("Error extracting uploaded media for chat ID %d, message ID %d: %v", chatID, msg.ID, err)
+		web.Error(w, "Failed to process uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	b.indexDocument(file)
+	fileURL := b.generateFileURL(msg.ID, file)
+	wsMsg := b.constructWebSocketMessage(msg.ID, fileURL, file)
+	b.publishMedia(chatID, msg.ID, wsMsg)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(wsMsg); err != nil {
+		b.logger.Printf("Error encoding upload response: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"webBridgeBot/internal/webhook"
+)
+
+const (
+	evictionStormInterval = time.Minute
+	// Evicting chunks that add up to this share of the cache within a minute is a storm: the
+	// cache is too small for what is being streamed and keeps downloading the same files again.
+	evictionStormShare = 0.1
+)
+
+// runEvictionStormWatch sends a cache.eviction_storm event when the cache starts evicting much
+// of itself every minute, and again only after it calmed down for a minute, until ctx is
+// cancelled.
+func (b *TelegramBot) runEvictionStormWatch(ctx context.Context) {
+	ticker := time.NewTicker(evictionStormInterval)
+	defer ticker.Stop()
+
+	var last int64 = -1
+	storm := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		stats, err := b.config.BinaryCache.GetStats()
+		if err != nil {
+			b.logger.Printf("Failed to read cache statistics: %v", err)
+			continue
+		}
+		evicted := stats.Evictions - last
+		first := last < 0
+		last = stats.Evictions
+		if first {
+			continue
+		}
+
+		evictedBytes := evicted * b.config.ChunkSize
+		if float64(evictedBytes) < evictionStormShare*float64(stats.MaxSize) {
+			storm = false
+			continue
+		}
+		if storm {
+			continue
+		}
+		storm = true
+		b.logger.Printf("Cache evicted %d chunks in the last %s", evicted, evictionStormInterval)
+		b.webhooks.Send(webhook.EventCacheEvictionStorm, map[string]any{
+			"evictions":       evicted,
+			"evictedBytes":    evictedBytes,
+			"intervalSeconds": int(evictionStormInterval.Seconds()),
+			"cacheSize":       stats.Size,
+			"maxCacheSize":    stats.MaxSize,
+		})
+	}
+}
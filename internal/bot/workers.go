@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/types"
+	"webBridgeBot/internal/utils"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/tg"
+)
+
+// errCopyMissing reports that the post carrying a document in the storage channel was deleted.
+var errCopyMissing = errors.New("copy is missing from the storage channel")
+
+// workerPool spreads the downloads of files over worker bots, each with its own session and
+// request budget, while the main bot handles the chats. Workers cannot read the chats of the main
+// bot, so it posts a copy of each file to a storage channel the workers are members of, once, and
+// the workers download the copy. A document has the same ID for every bot, so its chunks are
+// cached under the same key whichever bot downloads them.
+type workerPool struct {
+	main      *gotgproto.Client
+	channelID int64
+	channel   *tg.InputChannel // The storage channel as the main bot sees it
+	storage   *data.StorageRepository
+	workers   []*worker
+	next      atomic.Uint64
+	copyMu    sync.Mutex // Held while copying, so each document is copied once
+	logger    *log.Logger
+}
+
+// worker is a bot that downloads files from the storage channel.
+type worker struct {
+	client    *gotgproto.Client
+	session   *sessionGuard
+	lost      atomic.Bool      // Set once Telegram invalidated the session of the worker.
+	channel   *tg.InputChannel // The storage channel as the worker sees it
+	budget    *reader.Budget
+	locations sync.Map // *tg.InputDocumentFileLocation by document ID
+}
+
+// newWorkerPool starts the worker bots and checks that they and the main bot can access the
+// storage channel. It returns nil if no worker bots are configured.
+func newWorkerPool(ctx context.Context, cfg *config.Configuration, main *gotgproto.Client, storage *data.StorageRepository, logger *log.Logger) (*workerPool, error) {
+	tokens, err := cfg.WorkerTokens()
+	if err != nil {
+		return nil, fmt.Errorf("invalid WORKER_BOT_TOKENS: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	channelID := cfg.StorageChannel()
+	channel, err := utils.GetLogChannelPeer(ctx, main.API(), main.PeerStorage, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("bot cannot access storage channel %d: %w", cfg.StorageChannelID, err)
+	}
+	p := &workerPool{main: main, channelID: channelID, channel: channel, storage: storage, logger: logger}
+	for _, token := range tokens {
+		client, session, err := newWorkerClient(cfg, token)
+		if err != nil {
+			p.stop()
+			return nil, err
+		}
+		w := &worker{client: client, session: session}
+		p.workers = append(p.workers, w)
+		if w.channel, err = utils.GetLogChannelPeer(ctx, client.API(), client.PeerStorage, channelID); err != nil {
+			p.stop()
+			return nil, fmt.Errorf("worker bot @%s cannot access storage channel %d: %w", client.Self.Username, cfg.StorageChannelID, err)
+		}
+		if w.budget, err = reader.NewBudget(cfg.TelegramRequestsPerSecond, cfg.TelegramRequestBurst); err != nil {
+			p.stop()
+			return nil, err
+		}
+		logger.Printf("Worker bot @%s started", client.Self.Username)
+	}
+	return p, nil
+}
+
+// stop disconnects the worker bots.
+func (p *workerPool) stop() {
+	for _, w := range p.workers {
+		w.client.Stop()
+		w.session.release()
+	}
+}
+
+// pick returns the next worker in turn whose session is valid, or nil if there is none.
+func (p *workerPool) pick() *worker {
+	for range p.workers {
+		w := p.workers[(p.next.Add(1)-1)%uint64(len(p.workers))]
+		if !w.lost.Load() {
+			return w
+		}
+	}
+	return nil
+}
+
+// location returns the location of a document as a worker sees it.
+func (p *workerPool) location(ctx context.Context, w *worker, file *types.DocumentFile) (*tg.InputDocumentFileLocation, error) {
+	if location, ok := w.locations.Load(file.ID); ok {
+		return location.(*tg.InputDocumentFileLocation), nil
+	}
+	return p.refresh(ctx, w, file)
+}
+
+// refresh looks up the location of a document in its copy in the storage channel, such as when
+// its file reference expired. The document is copied there the first time, and again if the copy
+// was deleted.
+func (p *workerPool) refresh(ctx context.Context, w *worker, file *types.DocumentFile) (*tg.InputDocumentFileLocation, error) {
+	messageID, err := p.copy(ctx, file, 0)
+	if err != nil {
+		return nil, err
+	}
+	location, err := w.fetch(ctx, messageID, file.ID)
+	if errors.Is(err, errCopyMissing) {
+		if messageID, err = p.copy(ctx, file, messageID); err != nil {
+			return nil, err
+		}
+		location, err = w.fetch(ctx, messageID, file.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.locations.Store(file.ID, location)
+	return location, nil
+}
+
+// copy returns the ID of the post in the storage channel that carries a document, posting it as
+// the main bot unless it was posted before. A post recorded under the ID missing was deleted and
+// is replaced.
+func (p *workerPool) copy(ctx context.Context, file *types.DocumentFile, missing int) (int, error) {
+	p.copyMu.Lock()
+	defer p.copyMu.Unlock()
+	messageID, ok, err := p.storage.Copy(p.channelID, file.ID)
+	if err != nil {
+		return 0, err
+	}
+	if ok && messageID != missing {
+		return messageID, nil
+	}
+
+	updates, err := p.main.API().MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Silent: true,
+		Peer:   &tg.InputPeerChannel{ChannelID: p.channel.ChannelID, AccessHash: p.channel.AccessHash},
+		Media: &tg.InputMediaDocument{ID: &tg.InputDocument{
+			ID:            file.Location.ID,
+			AccessHash:    file.Location.AccessHash,
+			FileReference: file.Location.FileReference,
+		}},
+		RandomID: rand.Int63(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy document %d to the storage channel: %w", file.ID, err)
+	}
+	messageID, ok = newMessageID(updates)
+	if !ok {
+		return 0, fmt.Errorf("copy of document %d returned no message", file.ID)
+	}
+	if err := p.storage.RecordCopy(p.channelID, file.ID, messageID); err != nil {
+		return 0, err
+	}
+	p.logger.Printf("Copied document %d to post %d of the storage channel", file.ID, messageID)
+	return messageID, nil
+}
+
+// fetch returns the location of a document in a post of the storage channel.
+func (w *worker) fetch(ctx context.Context, messageID int, documentID int64) (*tg.InputDocumentFileLocation, error) {
+	result, err := w.client.API().ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+		Channel: w.channel,
+		ID:      []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post %d of the storage channel: %w", messageID, err)
+	}
+	messages, ok := result.AsModified()
+	if !ok {
+		return nil, errCopyMissing
+	}
+	for _, m := range messages.GetMessages() {
+		message, ok := m.(*tg.Message)
+		if !ok || message.ID != messageID {
+			continue
+		}
+		if file, err := utils.FileFromMedia(message.Media); err == nil && file.ID == documentID {
+			return file.Location, nil
+		}
+	}
+	return nil, errCopyMissing
+}
+
+// newFileReader opens a reader of the bytes start to end, inclusive, of a file of a message. With
+// worker bots, the next one in turn downloads the parts that are not cached; if none is left or it
+// cannot get the file, the main bot downloads it instead.
+func (b *TelegramBot) newFileReader(ctx context.Context, messageID int, file *types.DocumentFile, start, end, contentLength int64, logger *log.Logger) (io.ReadCloser, error) {
+	opts := b.readerOptions(messageID, file)
+	var w *worker
+	if b.workers != nil && !b.rangeCached(opts.CacheKey, start, end) {
+		w = b.workers.pick()
+	}
+	if w == nil {
+		return reader.NewTelegramReader(ctx, b.tgClient, file.Location, start, end, contentLength, b.config.BinaryCache, opts, logger)
+	}
+	location, err := b.workers.location(ctx, w, file)
+	if err != nil {
+		b.logger.Printf("Worker bot @%s cannot get document %d, downloading it as the bot: %v", w.client.Self.Username, file.ID, err)
+		return reader.NewTelegramReader(ctx, b.tgClient, file.Location, start, end, contentLength, b.config.BinaryCache, opts, logger)
+	}
+	opts.Budget = w.budget
+	opts.Refresh = func(ctx context.Context) (*tg.InputDocumentFileLocation, error) {
+		return b.workers.refresh(ctx, w, file)
+	}
+	return reader.NewTelegramReader(ctx, w.client, location, start, end, contentLength, b.config.BinaryCache, opts, logger)
+}
+
+// rangeCached reports whether the bytes start to end of the file cached under key are all cached.
+func (b *TelegramBot) rangeCached(key, start, end int64) bool {
+	for _, span := range b.config.BinaryCache.CachedSpans(key, b.config.ChunkSize) {
+		if span[0] <= start && span[1] > end {
+			return true
+		}
+	}
+	return false
+}
@@ -1,63 +1,78 @@
 package cache
 
 import (
-	"bytes"
-	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
-	"webBridgeBot/internal/types"
+	"time"
 
-	"github.com/coocood/freecache"
-	"github.com/gotd/td/tg"
+	"webBridgeBot/internal/data"
+	"webBridgeBot/internal/types"
 )
 
-var cache *Cache
+// ErrNotFound is returned for messages whose file is not cached.
+var ErrNotFound = errors.New("not found in cache")
+
+var cache = &Cache{}
 
+// Cache keeps the files of the bot's messages in the database, so they survive restarts. Files
+// are stored once per Telegram document. Without a repository, nothing is cached.
 type Cache struct {
-	cache *freecache.Cache
-	mu    sync.RWMutex
+	mu   sync.RWMutex
+	repo *data.MetadataRepository
+	ttl  time.Duration
 }
 
-func init() {
-	gob.Register(types.DocumentFile{})
-	gob.Register(tg.InputDocumentFileLocation{})
-	cache = &Cache{cache: freecache.NewCache(10 * 1024 * 1024)}
+// Init makes the cache keep files in repo. Files looked up longer than ttl ago are stale and
+// should be looked up again, since the file references in them expire.
+func Init(repo *data.MetadataRepository, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.repo = repo
+	cache.ttl = ttl
 }
 
 func GetCache() *Cache {
 	return cache
 }
 
-func (c *Cache) Get(key string, value *types.DocumentFile) error {
+// MessageFile returns the file of a message of the account selfID. fresh is false if it was
+// looked up longer than the TTL ago.
+func (c *Cache) MessageFile(selfID int64, messageID int) (file *types.DocumentFile, fresh bool, err error) {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	data, err := cache.cache.Get([]byte(key))
-	if err != nil {
-		return err
+	repo, ttl := c.repo, c.ttl
+	c.mu.RUnlock()
+	if repo == nil {
+		return nil, false, ErrNotFound
 	}
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err = dec.Decode(&value)
+
+	metadata, updatedAt, ok, err := repo.MessageDocument(selfID, messageID)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, ErrNotFound
 	}
-	return nil
+	file = &types.DocumentFile{}
+	if err := json.Unmarshal([]byte(metadata), file); err != nil {
+		return nil, false, fmt.Errorf("failed to decode the file of message %d: %w", messageID, err)
+	}
+	return file, time.Since(updatedAt) < ttl, nil
 }
 
-func (c *Cache) Set(key string, value *types.DocumentFile, expireSeconds int) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(value)
-	if err != nil {
-		return err
+// StoreMessageFile stores the file of a message of the account selfID, as just looked up.
+func (c *Cache) StoreMessageFile(selfID int64, messageID int, file *types.DocumentFile) error {
+	c.mu.RLock()
+	repo := c.repo
+	c.mu.RUnlock()
+	if repo == nil {
+		return nil
 	}
-	cache.cache.Set([]byte(key), buf.Bytes(), expireSeconds)
-	return nil
-}
 
-func (c *Cache) Delete(key string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	cache.cache.Del([]byte(key))
-	return nil
+	metadata, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to encode the file of message %d: %w", messageID, err)
+	}
+	return repo.StoreMessageDocument(selfID, messageID, file.ID, string(metadata), time.Now())
 }
@@ -0,0 +1,270 @@
+// Package cast sends media URLs to UPnP/DLNA renderers, such as smart TVs, on the local network.
+package cast
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ssdpAddress        = "239.255.255.250:1900"
+	avTransportService = "urn:schemas-upnp-org:service:AVTransport:1"
+	requestTimeout     = 10 * time.Second
+)
+
+// Renderer is a device that can play a media URL through its AVTransport service.
+type Renderer struct {
+	ID         string
+	Name       string
+	Location   string
+	ControlURL string
+}
+
+// Caster discovers renderers and remembers the last ones found, so they can be picked by ID.
+type Caster struct {
+	timeout time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	renderers map[string]Renderer
+}
+
+// NewCaster creates a caster that listens for discovery responses for the given time.
+func NewCaster(timeout time.Duration) *Caster {
+	return &Caster{
+		timeout:   timeout,
+		client:    &http.Client{Timeout: requestTimeout},
+		renderers: make(map[string]Renderer),
+	}
+}
+
+// Renderer returns a renderer found by an earlier discovery.
+func (c *Caster) Renderer(id string) (Renderer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	renderer, ok := c.renderers[id]
+	return renderer, ok
+}
+
+// Discover searches the local network for media renderers with SSDP.
+func (c *Caster) Discover(ctx context.Context) ([]Renderer, error) {
+	locations, err := c.search(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var renderers []Renderer
+	for _, location := range locations {
+		renderer, err := c.describe(ctx, location)
+		if err != nil {
+			continue // Devices that do not answer or lack AVTransport are not renderers.
+		}
+		renderers = append(renderers, renderer)
+	}
+
+	c.mu.Lock()
+	for _, renderer := range renderers {
+		c.renderers[renderer.ID] = renderer
+	}
+	c.mu.Unlock()
+	return renderers, nil
+}
+
+// search sends an M-SEARCH request and returns the description URLs of all devices that answer.
+func (c *Caster) search(ctx context.Context) ([]string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	target, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+	request := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + avTransportService + "\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(request), target); err != nil {
+		return nil, fmt.Errorf("failed to send discovery request: %w", err)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var locations []string
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// The read deadline ends the search.
+			break
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location != "" && !seen[location] {
+			seen[location] = true
+			locations = append(locations, location)
+		}
+	}
+	return locations, nil
+}
+
+type deviceDescription struct {
+	URLBase string `xml:"URLBase"`
+	Device  device `xml:"device"`
+}
+
+type device struct {
+	FriendlyName string    `xml:"friendlyName"`
+	Services     []service `xml:"serviceList>service"`
+	Devices      []device  `xml:"deviceList>device"`
+}
+
+type service struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findAVTransport returns the device that offers AVTransport and its control URL.
+func (d device) findAVTransport() (device, string, bool) {
+	for _, s := range d.Services {
+		if strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:AVTransport:") {
+			return d, s.ControlURL, true
+		}
+	}
+	for _, child := range d.Devices {
+		if found, controlURL, ok := child.findAVTransport(); ok {
+			return found, controlURL, true
+		}
+	}
+	return device{}, "", false
+}
+
+// describe reads the device description at location.
+func (c *Caster) describe(ctx context.Context, location string) (Renderer, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return Renderer{}, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Renderer{}, err
+	}
+	defer resp.Body.Close()
+
+	var description deviceDescription
+	if err := xml.NewDecoder(resp.Body).Decode(&description); err != nil {
+		return Renderer{}, fmt.Errorf("invalid device description: %w", err)
+	}
+	found, controlURL, ok := description.Device.findAVTransport()
+	if !ok {
+		return Renderer{}, fmt.Errorf("%s has no AVTransport service", location)
+	}
+
+	base := location
+	if description.URLBase != "" {
+		base = description.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return Renderer{}, err
+	}
+	control, err := baseURL.Parse(controlURL)
+	if err != nil {
+		return Renderer{}, err
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(location))
+	name := found.FriendlyName
+	if name == "" {
+		name = baseURL.Host
+	}
+	return Renderer{
+		ID:         fmt.Sprintf("%08x", h.Sum32()),
+		Name:       name,
+		Location:   location,
+		ControlURL: control.String(),
+	}, nil
+}
+
+// Play makes the renderer load and play a media URL.
+func (c *Caster) Play(ctx context.Context, renderer Renderer, mediaURL, title, mimeType string) error {
+	setURI := fmt.Sprintf("<InstanceID>0</InstanceID><CurrentURI>%s</CurrentURI><CurrentURIMetaData>%s</CurrentURIMetaData>",
+		escape(mediaURL), escape(didlMetadata(mediaURL, title, mimeType)))
+	if err := c.soap(ctx, renderer, "SetAVTransportURI", setURI); err != nil {
+		return err
+	}
+	return c.soap(ctx, renderer, "Play", "<InstanceID>0</InstanceID><Speed>1</Speed>")
+}
+
+// soap invokes an action of the renderer's AVTransport service.
+func (c *Caster) soap(ctx context.Context, renderer Renderer, action, arguments string) error {
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + avTransportService + `">` + arguments + `</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, renderer.ControlURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, avTransportService, action))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s failed on %s: %w", action, renderer.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fault, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s failed on %s with status %d: %s", action, renderer.Name, resp.StatusCode, strings.TrimSpace(string(fault)))
+	}
+	return nil
+}
+
+// didlMetadata describes the media item in DIDL-Lite, which many TVs need to accept a URL.
+func didlMetadata(mediaURL, title, mimeType string) string {
+	class := "object.item.videoItem"
+	switch {
+	case strings.HasPrefix(mimeType, "audio"):
+		class = "object.item.audioItem.musicTrack"
+	case strings.HasPrefix(mimeType, "image"):
+		class = "object.item.imageItem.photo"
+	}
+	return `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">` +
+		`<item id="0" parentID="-1" restricted="1">` +
+		`<dc:title>` + escape(title) + `</dc:title>` +
+		`<upnp:class>` + class + `</upnp:class>` +
+		`<res protocolInfo="http-get:*:` + escape(mimeType) + `:*">` + escape(mediaURL) + `</res>` +
+		`</item></DIDL-Lite>`
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
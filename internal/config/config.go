@@ -1,27 +1,173 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	logging "webBridgeBot/internal/logger"
 	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/web"
 
 	"github.com/spf13/viper"
 )
 
-const DefaultChunkSize int64 = 1024 * 1024 // 1 MB
+const (
+	// StreamErrorModeAbort aborts the HTTP response when a stream fails mid-way.
+	StreamErrorModeAbort = "abort"
+	// StreamErrorModeNotify aborts the HTTP response and also sends a streamError event to the player.
+	StreamErrorModeNotify = "notify"
+
+	// ClientTypeBot logs in to Telegram with BOT_TOKEN.
+	ClientTypeBot = "bot"
+	// ClientTypeUser logs in as a user account whose session was saved by the login command.
+	ClientTypeUser = "user"
+
+	// SessionModePersistent keeps the Telegram sessions on disk, so later runs reuse them.
+	SessionModePersistent = "persistent"
+	// SessionModeMemory keeps the bot session in memory only; the bot logs in again on every start.
+	SessionModeMemory = "memory"
+)
 
 type Configuration struct {
 	ApiID          int
 	ApiHash        string
 	BotToken       string
+	ClientType     string
+	PhoneNumber    string
+	SessionPath    string
 	BaseURL        string
 	Port           string
 	HashLength     int
 	CacheDirectory string
 	MaxCacheSize   int64
 	DatabasePath   string
+	DBDriver       string
+	DBDSN          string
 	DebugMode      bool
+	LogFormat      string
+	LogLevel       string
+	LogFile        string
+	LogMaxSize     int
+	LogMaxBackups  int
+	LogMaxAge      time.Duration
 	BinaryCache    *reader.BinaryCache
+
+	// SessionDirectory holds the sessions of the user account and of the worker bots.
+	SessionDirectory string
+	// SessionMode is SessionModePersistent or SessionModeMemory.
+	SessionMode string
+
+	// CacheCompactThreshold is the fragmentation of the cache files above which they are compacted.
+	CacheCompactThreshold float64
+	// CacheFsck verifies the checksums of the whole cache at startup.
+	CacheFsck bool
+	// CacheCompression is how new chunks are stored: "none" or "zstd".
+	CacheCompression string
+	// CacheLayout is how chunks are stored in files: "file" or "segments".
+	CacheLayout string
+	// CacheHotShare is the share of the cache kept for media being streamed; negative disables it.
+	CacheHotShare float64
+	// MinFreeDisk is the free space in bytes kept on the disk holding the cache; negative disables it.
+	MinFreeDisk int64
+
+	StreamErrorMode string
+
+	ChunkSize                 int64
+	TelegramRequestsPerSecond int
+	TelegramRequestBurst      int
+	TelegramMaxRetries        int
+	PrefetchDepth             int
+	// Comma-separated tokens of extra bots that download files, each within its own rate limits,
+	// and the channel the files are copied to so those bots can read them.
+	WorkerBotTokens  string
+	StorageChannelID int64
+	// Workers caching whole files, and the size up to which files are cached when sent.
+	PrefetchWorkers     int
+	PrefetchAutoMaxSize int64
+
+	ProxyMaxIdleConns        int
+	ProxyMaxIdleConnsPerHost int
+	ProxyIdleConnTimeout     time.Duration
+	ProxyDialTimeout         time.Duration
+	ProxyTLSHandshakeTimeout time.Duration
+	ProxyOutboundURL         string
+	// Domains the proxy may fetch from, including their subdomains. Empty allows any public host.
+	ProxyAllowedDomains string
+	// Largest external file the proxy serves, in bytes.
+	ProxyMaxResponseSize int64
+	// How long proxied media is served from the cache before it is checked for changes. Negative
+	// disables the cache.
+	ProxyCacheTTL time.Duration
+	// Largest external file that is cached, in bytes.
+	ProxyCacheMaxFileSize int64
+
+	FFmpegPath         string
+	HLSTranscode       bool
+	HLSSegmentDuration time.Duration
+	HLSIdleTimeout     time.Duration
+
+	ShutdownGracePeriod time.Duration
+
+	RateLimitPerIP      int
+	RateLimitPerChat    int
+	RateLimitBurst      int
+	RateLimitTrustProxy bool
+
+	// Caps in bytes per second on each stream and on all streams together. Zero is unlimited.
+	MaxStreamBandwidth int64
+	MaxTotalBandwidth  int64
+	// Streams of one user's media that may be active at once. Zero is unlimited.
+	MaxStreamsPerUser int
+
+	// Reverse proxies whose X-Forwarded-For and X-Real-IP headers are believed.
+	TrustedProxies string
+	// Other sites whose pages may use the streams, APIs and WebSockets, and the request headers
+	// they may send.
+	CORSAllowedOrigins string
+	CORSAllowedHeaders string
+	// Content codings of compressed pages and API responses, in order of preference, or "none".
+	HTTPCompression string
+
+	// Endpoints events are posted to, the secret their bodies are signed with and the events sent.
+	WebhookURLs   string
+	WebhookSecret string
+	WebhookEvents string
+	// Unix socket the admin API is served on; empty disables it.
+	AdminRPCSocket string
+	// Certificate and key to serve HTTPS, and with it HTTP/2, without a reverse proxy.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	CastEnabled          bool
+	CastDiscoveryTimeout time.Duration
+
+	// Branding of the web player.
+	PlayerTitle       string
+	PlayerAccentColor string
+	PlayerLogoURL     string
+	// Stylesheet the player loads after its own styles.
+	PlayerCustomCSS string
+
+	// How long an admin stays signed in to the admin panel.
+	AdminSessionTTL time.Duration
+	// PlayerLoginRequired makes visitors sign in with Telegram before a player and its WebSocket
+	// are served, instead of with the signed link sent by /start.
+	PlayerLoginRequired bool
+	PlayerSessionTTL    time.Duration
+
+	// How long the file of a message is used from the database before it is looked up again.
+	MetadataTTL time.Duration
+
+	// Default streaming quotas of users without one of their own, in bytes. Zero is unlimited.
+	QuotaDailyBytes   int64
+	QuotaMonthlyBytes int64
+	// MaxFileSize is the largest file users without a limit of their own may bridge, in bytes.
+	// Zero allows every file up to the supported maximum.
+	MaxFileSize int64
 }
 
 func LoadConfig(logger *log.Logger) Configuration {
@@ -31,6 +177,8 @@ func LoadConfig(logger *log.Logger) Configuration {
 	bindViperToConfig(&cfg)
 	validateMandatoryFields(cfg, logger)
 	setDefaultValues(&cfg)
+	validateReaderOptions(cfg, logger)
+	validatePlayerBranding(cfg, logger)
 	initializeBinaryCache(&cfg, logger)
 
 	if cfg.DebugMode {
@@ -40,6 +188,28 @@ func LoadConfig(logger *log.Logger) Configuration {
 	return cfg
 }
 
+// LoadCacheConfig loads the configuration needed for maintenance commands without validating the
+// Telegram credentials or opening the binary cache.
+func LoadCacheConfig(logger *log.Logger) Configuration {
+	initializeViper(logger)
+
+	var cfg Configuration
+	bindViperToConfig(&cfg)
+	setDefaultValues(&cfg)
+	return cfg
+}
+
+// Reload reads the environment and .env again and returns the configuration they describe,
+// together with every problem in it. Unlike LoadConfig, it does not open the binary cache.
+func Reload(logger *log.Logger) (Configuration, error) {
+	initializeViper(logger)
+
+	var cfg Configuration
+	bindViperToConfig(&cfg)
+	setDefaultValues(&cfg)
+	return cfg, cfg.Validate()
+}
+
 func initializeViper(logger *log.Logger) {
 	viper.SetConfigFile(".env")
 	viper.AutomaticEnv()
@@ -53,27 +223,129 @@ func bindViperToConfig(cfg *Configuration) {
 	cfg.ApiID = viper.GetInt("API_ID")
 	cfg.ApiHash = viper.GetString("API_HASH")
 	cfg.BotToken = viper.GetString("BOT_TOKEN")
+	cfg.ClientType = viper.GetString("CLIENT_TYPE")
+	cfg.PhoneNumber = viper.GetString("PHONE_NUMBER")
+	cfg.SessionPath = viper.GetString("SESSION_PATH")
+	cfg.SessionDirectory = viper.GetString("SESSION_DIRECTORY")
+	cfg.SessionMode = viper.GetString("SESSION_MODE")
 	cfg.BaseURL = viper.GetString("BASE_URL")
 	cfg.Port = viper.GetString("PORT")
 	cfg.HashLength = viper.GetInt("HASH_LENGTH")
 	cfg.CacheDirectory = viper.GetString("CACHE_DIRECTORY")
 	cfg.MaxCacheSize = viper.GetInt64("MAX_CACHE_SIZE")
+	cfg.CacheCompactThreshold = viper.GetFloat64("CACHE_COMPACT_THRESHOLD")
+	cfg.CacheFsck = viper.GetBool("CACHE_FSCK")
+	cfg.CacheCompression = viper.GetString("CACHE_COMPRESSION")
+	cfg.CacheLayout = viper.GetString("CACHE_LAYOUT")
+	cfg.CacheHotShare = viper.GetFloat64("CACHE_HOT_SHARE")
+	cfg.MinFreeDisk = viper.GetInt64("MIN_FREE_DISK")
 	cfg.DebugMode = viper.GetBool("DEBUG_MODE")
+	cfg.LogFormat = viper.GetString("LOG_FORMAT")
+	cfg.LogLevel = viper.GetString("LOG_LEVEL")
+	cfg.LogFile = viper.GetString("LOG_FILE")
+	cfg.LogMaxSize = viper.GetInt("LOG_MAX_SIZE")
+	cfg.LogMaxBackups = viper.GetInt("LOG_MAX_BACKUPS")
+	cfg.LogMaxAge = viper.GetDuration("LOG_MAX_AGE")
+	cfg.DBDriver = viper.GetString("DB_DRIVER")
+	cfg.DBDSN = viper.GetString("DB_DSN")
+	cfg.StreamErrorMode = viper.GetString("STREAM_ERROR_MODE")
+	cfg.ProxyMaxIdleConns = viper.GetInt("PROXY_MAX_IDLE_CONNS")
+	cfg.ProxyMaxIdleConnsPerHost = viper.GetInt("PROXY_MAX_IDLE_CONNS_PER_HOST")
+	cfg.ProxyIdleConnTimeout = viper.GetDuration("PROXY_IDLE_CONN_TIMEOUT")
+	cfg.ProxyDialTimeout = viper.GetDuration("PROXY_DIAL_TIMEOUT")
+	cfg.ProxyTLSHandshakeTimeout = viper.GetDuration("PROXY_TLS_HANDSHAKE_TIMEOUT")
+	cfg.ProxyOutboundURL = viper.GetString("PROXY_OUTBOUND_URL")
+	cfg.ProxyAllowedDomains = viper.GetString("PROXY_ALLOWED_DOMAINS")
+	cfg.ProxyMaxResponseSize = viper.GetInt64("PROXY_MAX_RESPONSE_SIZE")
+	cfg.ProxyCacheTTL = viper.GetDuration("PROXY_CACHE_TTL")
+	cfg.ProxyCacheMaxFileSize = viper.GetInt64("PROXY_CACHE_MAX_FILE_SIZE")
+	cfg.ChunkSize = viper.GetInt64("CHUNK_SIZE")
+	cfg.TelegramRequestsPerSecond = viper.GetInt("TELEGRAM_REQUESTS_PER_SECOND")
+	cfg.TelegramRequestBurst = viper.GetInt("TELEGRAM_REQUEST_BURST")
+	cfg.TelegramMaxRetries = viper.GetInt("TELEGRAM_MAX_RETRIES")
+	cfg.PrefetchDepth = viper.GetInt("PREFETCH_DEPTH")
+	cfg.PrefetchWorkers = viper.GetInt("PREFETCH_WORKERS")
+	cfg.PrefetchAutoMaxSize = viper.GetInt64("PREFETCH_AUTO_MAX_SIZE")
+	cfg.FFmpegPath = viper.GetString("FFMPEG_PATH")
+	cfg.HLSTranscode = viper.GetBool("HLS_TRANSCODE")
+	cfg.HLSSegmentDuration = viper.GetDuration("HLS_SEGMENT_DURATION")
+	cfg.HLSIdleTimeout = viper.GetDuration("HLS_IDLE_TIMEOUT")
+	cfg.ShutdownGracePeriod = viper.GetDuration("SHUTDOWN_GRACE_PERIOD")
+	cfg.RateLimitPerIP = viper.GetInt("RATE_LIMIT_PER_IP")
+	cfg.RateLimitPerChat = viper.GetInt("RATE_LIMIT_PER_CHAT")
+	cfg.RateLimitBurst = viper.GetInt("RATE_LIMIT_BURST")
+	cfg.RateLimitTrustProxy = viper.GetBool("RATE_LIMIT_TRUST_PROXY")
+	cfg.MaxStreamBandwidth = viper.GetInt64("MAX_STREAM_BANDWIDTH")
+	cfg.MaxTotalBandwidth = viper.GetInt64("MAX_TOTAL_BANDWIDTH")
+	cfg.MaxStreamsPerUser = viper.GetInt("MAX_STREAMS_PER_USER")
+	cfg.TrustedProxies = viper.GetString("TRUSTED_PROXIES")
+	cfg.CORSAllowedOrigins = viper.GetString("CORS_ALLOWED_ORIGINS")
+	cfg.CORSAllowedHeaders = viper.GetString("CORS_ALLOWED_HEADERS")
+	cfg.HTTPCompression = viper.GetString("HTTP_COMPRESSION")
+	cfg.WebhookURLs = viper.GetString("WEBHOOK_URLS")
+	cfg.WebhookSecret = viper.GetString("WEBHOOK_SECRET")
+	cfg.WebhookEvents = viper.GetString("WEBHOOK_EVENTS")
+	cfg.AdminRPCSocket = viper.GetString("ADMIN_RPC_SOCKET")
+	cfg.WorkerBotTokens = viper.GetString("WORKER_BOT_TOKENS")
+	cfg.StorageChannelID = viper.GetInt64("STORAGE_CHANNEL_ID")
+	cfg.TLSCertFile = viper.GetString("TLS_CERT_FILE")
+	cfg.TLSKeyFile = viper.GetString("TLS_KEY_FILE")
+	cfg.CastEnabled = viper.GetBool("CAST_ENABLED")
+	cfg.CastDiscoveryTimeout = viper.GetDuration("CAST_DISCOVERY_TIMEOUT")
+	cfg.PlayerTitle = viper.GetString("PLAYER_TITLE")
+	cfg.PlayerAccentColor = viper.GetString("PLAYER_ACCENT_COLOR")
+	cfg.PlayerLogoURL = viper.GetString("PLAYER_LOGO_URL")
+	cfg.PlayerCustomCSS = viper.GetString("PLAYER_CUSTOM_CSS")
+	cfg.AdminSessionTTL = viper.GetDuration("ADMIN_SESSION_TTL")
+	cfg.PlayerLoginRequired = viper.GetBool("PLAYER_LOGIN_REQUIRED")
+	cfg.PlayerSessionTTL = viper.GetDuration("PLAYER_SESSION_TTL")
+	cfg.MetadataTTL = viper.GetDuration("METADATA_TTL")
+	cfg.QuotaDailyBytes = viper.GetInt64("QUOTA_DAILY_BYTES")
+	cfg.QuotaMonthlyBytes = viper.GetInt64("QUOTA_MONTHLY_BYTES")
+	cfg.MaxFileSize = viper.GetInt64("MAX_FILE_SIZE")
 }
 
 func validateMandatoryFields(cfg Configuration, logger *log.Logger) {
+	if errs := mandatoryFieldErrors(cfg); len(errs) > 0 {
+		logger.Fatal(errs[0])
+	}
+}
+
+func mandatoryFieldErrors(cfg Configuration) []error {
+	var errs []error
 	if cfg.ApiID == 0 {
-		logger.Fatal("API_ID is required and not set")
+		errs = append(errs, errors.New("API_ID is required and not set"))
 	}
 	if cfg.ApiHash == "" {
-		logger.Fatal("API_HASH is required and not set")
+		errs = append(errs, errors.New("API_HASH is required and not set"))
+	}
+	switch cfg.ClientType {
+	case "", ClientTypeBot:
+		if cfg.BotToken == "" {
+			errs = append(errs, errors.New("BOT_TOKEN is required and not set"))
+		}
+	case ClientTypeUser:
+		// The login widget signs in with a bot.
+		if cfg.PlayerLoginRequired {
+			errs = append(errs, errors.New("PLAYER_LOGIN_REQUIRED needs CLIENT_TYPE bot"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("CLIENT_TYPE must be %q or %q", ClientTypeBot, ClientTypeUser))
 	}
-	if cfg.BotToken == "" {
-		logger.Fatal("BOT_TOKEN is required and not set")
+	switch cfg.SessionMode {
+	case "", SessionModePersistent:
+	case SessionModeMemory:
+		// The server cannot ask for a login code on every start.
+		if cfg.ClientType == ClientTypeUser {
+			errs = append(errs, errors.New("SESSION_MODE memory needs CLIENT_TYPE bot"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("SESSION_MODE must be %q or %q", SessionModePersistent, SessionModeMemory))
 	}
 	if cfg.BaseURL == "" {
-		logger.Fatal("BASE_URL is required and not set")
+		errs = append(errs, errors.New("BASE_URL is required and not set"))
 	}
+	return errs
 }
 
 func setDefaultValues(cfg *Configuration) {
@@ -86,19 +358,266 @@ func setDefaultValues(cfg *Configuration) {
 	if cfg.MaxCacheSize == 0 {
 		cfg.MaxCacheSize = 10 * 1024 * 1024 * 1024 // 10 GB default
 	}
+	if cfg.CacheCompactThreshold == 0 {
+		cfg.CacheCompactThreshold = 0.5
+	}
+	if cfg.CacheCompression == "" {
+		cfg.CacheCompression = reader.CompressionNone
+	}
+	if cfg.CacheLayout == "" {
+		cfg.CacheLayout = reader.LayoutFile
+	}
+	if cfg.CacheHotShare == 0 {
+		cfg.CacheHotShare = 0.5
+	}
+	if cfg.MinFreeDisk == 0 {
+		cfg.MinFreeDisk = 1024 * 1024 * 1024 // 1 GB default
+	}
 	if cfg.DatabasePath == "" {
 		cfg.DatabasePath = fmt.Sprintf("%s/webBridgeBot.db", cfg.CacheDirectory)
 	}
+	if cfg.ClientType == "" {
+		cfg.ClientType = ClientTypeBot
+	}
+	if cfg.SessionDirectory == "" {
+		cfg.SessionDirectory = cfg.CacheDirectory
+	}
+	if cfg.SessionMode == "" {
+		cfg.SessionMode = SessionModePersistent
+	}
+	if cfg.SessionPath == "" {
+		// The bot session has always been kept in the application database.
+		cfg.SessionPath = cfg.DatabasePath
+		if cfg.ClientType == ClientTypeUser {
+			cfg.SessionPath = fmt.Sprintf("%s/user.session", cfg.SessionDirectory)
+		}
+	}
+	if cfg.DBDriver == "" {
+		cfg.DBDriver = "sqlite"
+	}
+	if cfg.DBDSN == "" && cfg.DBDriver == "sqlite" {
+		cfg.DBDSN = fmt.Sprintf("file:%s?mode=rwc", cfg.DatabasePath)
+	}
+	if cfg.LogFormat != "json" {
+		cfg.LogFormat = "text"
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = logging.LevelInfo
+	}
+	if cfg.LogMaxSize <= 0 {
+		cfg.LogMaxSize = 100
+	}
+	if cfg.LogMaxBackups <= 0 {
+		cfg.LogMaxBackups = 5
+	}
+
+	if cfg.StreamErrorMode != StreamErrorModeAbort {
+		cfg.StreamErrorMode = StreamErrorModeNotify
+	}
+	if cfg.ProxyMaxIdleConns <= 0 {
+		cfg.ProxyMaxIdleConns = 100
+	}
+	if cfg.ProxyMaxIdleConnsPerHost <= 0 {
+		cfg.ProxyMaxIdleConnsPerHost = 10
+	}
+	if cfg.ProxyIdleConnTimeout <= 0 {
+		cfg.ProxyIdleConnTimeout = 90 * time.Second
+	}
+	if cfg.ProxyDialTimeout <= 0 {
+		cfg.ProxyDialTimeout = 10 * time.Second
+	}
+	if cfg.ProxyTLSHandshakeTimeout <= 0 {
+		cfg.ProxyTLSHandshakeTimeout = 10 * time.Second
+	}
+	if cfg.ProxyMaxResponseSize <= 0 {
+		cfg.ProxyMaxResponseSize = 4 << 30 // 4 GB
+	}
+	if cfg.ProxyCacheTTL == 0 {
+		cfg.ProxyCacheTTL = 24 * time.Hour
+	}
+	if cfg.ProxyCacheMaxFileSize <= 0 {
+		cfg.ProxyCacheMaxFileSize = 512 << 20 // 512 MB
+	}
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = reader.DefaultChunkSize
+	}
+	if cfg.TelegramRequestsPerSecond == 0 {
+		cfg.TelegramRequestsPerSecond = reader.DefaultRequestsPerSecond
+	}
+	if cfg.TelegramRequestBurst == 0 {
+		cfg.TelegramRequestBurst = reader.DefaultRequestBurst
+	}
+	if cfg.TelegramMaxRetries == 0 {
+		cfg.TelegramMaxRetries = reader.DefaultMaxRetries
+	}
+	if !viper.IsSet("PREFETCH_DEPTH") {
+		cfg.PrefetchDepth = reader.DefaultPrefetchDepth
+	}
+	if cfg.PrefetchWorkers <= 0 {
+		cfg.PrefetchWorkers = 2
+	}
+	if cfg.HLSSegmentDuration < time.Second {
+		cfg.HLSSegmentDuration = 6 * time.Second
+	}
+	if cfg.HLSIdleTimeout <= 0 {
+		cfg.HLSIdleTimeout = 10 * time.Minute
+	}
+	if cfg.ShutdownGracePeriod <= 0 {
+		cfg.ShutdownGracePeriod = 30 * time.Second
+	}
+	// Negative rate limits disable limiting.
+	if cfg.RateLimitPerIP == 0 {
+		cfg.RateLimitPerIP = 600
+	}
+	if cfg.RateLimitPerChat == 0 {
+		cfg.RateLimitPerChat = 120
+	}
+	if cfg.RateLimitBurst <= 0 {
+		cfg.RateLimitBurst = 60
+	}
+	// RATE_LIMIT_TRUST_PROXY predates TRUSTED_PROXIES and trusts whichever peer sends the headers.
+	if cfg.TrustedProxies == "" && cfg.RateLimitTrustProxy {
+		cfg.TrustedProxies = "*"
+	}
+	if cfg.CORSAllowedHeaders == "" {
+		cfg.CORSAllowedHeaders = "Range"
+	}
+	if cfg.HTTPCompression == "" {
+		cfg.HTTPCompression = web.EncodingZstd + "," + web.EncodingGzip
+	}
+
+	if cfg.CastDiscoveryTimeout <= 0 {
+		cfg.CastDiscoveryTimeout = 3 * time.Second
+	}
+
+	if cfg.AdminSessionTTL <= 0 {
+		cfg.AdminSessionTTL = 12 * time.Hour
+	}
+	if cfg.PlayerSessionTTL <= 0 {
+		cfg.PlayerSessionTTL = 30 * 24 * time.Hour
+	}
+	if cfg.MetadataTTL <= 0 {
+		cfg.MetadataTTL = time.Hour
+	}
+
+	if cfg.PlayerTitle == "" {
+		cfg.PlayerTitle = "WebBridgeBot"
+	}
+	if cfg.PlayerAccentColor == "" {
+		cfg.PlayerAccentColor = "#00aaff"
+	}
+}
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func validatePlayerBranding(cfg Configuration, logger *log.Logger) {
+	if err := playerBrandingError(cfg); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func playerBrandingError(cfg Configuration) error {
+	if !hexColorPattern.MatchString(cfg.PlayerAccentColor) {
+		return fmt.Errorf("PLAYER_ACCENT_COLOR must be a hex color such as #00aaff, got %q", cfg.PlayerAccentColor)
+	}
+	if cfg.PlayerCustomCSS != "" {
+		if _, err := os.Stat(cfg.PlayerCustomCSS); err != nil {
+			return fmt.Errorf("Invalid PLAYER_CUSTOM_CSS: %v", err)
+		}
+	}
+	return nil
+}
+
+// ReaderOptions returns the options used to fetch files from Telegram.
+func (cfg *Configuration) ReaderOptions() reader.Options {
+	return reader.Options{
+		ChunkSize:     cfg.ChunkSize,
+		MaxRetries:    cfg.TelegramMaxRetries,
+		PrefetchDepth: cfg.PrefetchDepth,
+	}
+}
+
+var botTokenPattern = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]+$`)
+
+// botAPIChannelOffset is added to channel IDs in the Bot API, which writes them as -100<ID>.
+const botAPIChannelOffset = 1000000000000
+
+// WorkerTokens returns the tokens of the worker bots, checking that they look like bot tokens
+// and that a storage channel is set for them.
+func (cfg *Configuration) WorkerTokens() ([]string, error) {
+	var tokens []string
+	seen := map[string]bool{cfg.BotToken: true}
+	for _, token := range strings.Split(cfg.WorkerBotTokens, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if !botTokenPattern.MatchString(token) {
+			return nil, fmt.Errorf("%q is not a bot token", redactToken(token))
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("bot token %s is listed twice or is the BOT_TOKEN", redactToken(token))
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+	if len(tokens) > 0 && cfg.StorageChannelID == 0 {
+		return nil, errors.New("STORAGE_CHANNEL_ID must be set for worker bots to read files")
+	}
+	return tokens, nil
+}
+
+// StorageChannel returns the ID of the storage channel as Telegram's API expects it, accepting
+// the -100 prefixed form of the Bot API.
+func (cfg *Configuration) StorageChannel() int64 {
+	if cfg.StorageChannelID < -botAPIChannelOffset {
+		return -cfg.StorageChannelID - botAPIChannelOffset
+	}
+	return cfg.StorageChannelID
+}
+
+// redactToken keeps the bot ID of a token, so errors can name it without revealing its secret.
+func redactToken(token string) string {
+	if id, _, ok := strings.Cut(token, ":"); ok {
+		return id + ":***"
+	}
+	return "***"
+}
+
+func validateReaderOptions(cfg Configuration, logger *log.Logger) {
+	if err := cfg.ReaderOptions().Validate(); err != nil {
+		logger.Fatalf("Invalid reader configuration: %v", err)
+	}
+	if err := reader.SetRequestBudget(cfg.TelegramRequestsPerSecond, cfg.TelegramRequestBurst); err != nil {
+		logger.Fatalf("Invalid reader configuration: %v", err)
+	}
 }
 
 func initializeBinaryCache(cfg *Configuration, logger *log.Logger) {
 	var err error
-	cfg.BinaryCache, err = reader.NewBinaryCache(
+	cfg.BinaryCache, err = reader.NewBinaryCacheWithLayout(
 		cfg.CacheDirectory,
 		cfg.MaxCacheSize,
-		DefaultChunkSize,
+		cfg.ChunkSize,
+		cfg.CacheLayout,
+		logging.WithFields(logger, logging.Fields{"module": "cache"}),
 	)
 	if err != nil {
 		logger.Fatalf("Error initializing BinaryCache: %v", err)
 	}
+	if err := cfg.BinaryCache.SetCompression(cfg.CacheCompression); err != nil {
+		logger.Fatalf("Invalid CACHE_COMPRESSION: %v", err)
+	}
+	if err := cfg.BinaryCache.SetHotShare(max(cfg.CacheHotShare, 0)); err != nil {
+		logger.Fatalf("Invalid CACHE_HOT_SHARE: %v", err)
+	}
+
+	if cfg.CacheFsck {
+		start := time.Now()
+		checked, dropped, err := cfg.BinaryCache.Verify()
+		if err != nil {
+			logger.Fatalf("Error verifying BinaryCache: %v", err)
+		}
+		logger.Printf("Verified %d cached chunks in %s, dropped %d corrupted ones", checked, time.Since(start).Round(time.Millisecond), dropped)
+	}
 }
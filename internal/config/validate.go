@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"webBridgeBot/internal/data"
+	logging "webBridgeBot/internal/logger"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/web"
+	"webBridgeBot/internal/webhook"
+)
+
+// Validate reports every problem in the configuration that would stop the bot from starting,
+// without connecting to Telegram or opening the cache and the database. LoadConfig stops at the
+// first problem instead.
+func (cfg *Configuration) Validate() error {
+	errs := mandatoryFieldErrors(*cfg)
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+		}
+	}
+
+	if err := playerBrandingError(*cfg); err != nil {
+		errs = append(errs, err)
+	}
+	check("LOG_LEVEL", logging.ValidateLevel(cfg.LogLevel))
+	check("reader configuration", cfg.ReaderOptions().Validate())
+	_, err := reader.NewBudget(cfg.TelegramRequestsPerSecond, cfg.TelegramRequestBurst)
+	check("reader configuration", err)
+	check("CACHE_LAYOUT", reader.ValidateLayout(cfg.CacheLayout))
+	// The cache settings are checked on a cache that is never opened.
+	scratch := &reader.BinaryCache{}
+	check("CACHE_COMPRESSION", scratch.SetCompression(cfg.CacheCompression))
+	check("CACHE_HOT_SHARE", scratch.SetHotShare(max(cfg.CacheHotShare, 0)))
+	switch cfg.DBDriver {
+	case data.DriverSQLite, data.DriverPostgres, data.DriverMySQL:
+	default:
+		errs = append(errs, fmt.Errorf("invalid DB_DRIVER: unsupported database driver %q", cfg.DBDriver))
+	}
+
+	_, err = web.ParseTrustedProxies(cfg.TrustedProxies)
+	check("TRUSTED_PROXIES", err)
+	_, err = web.ParseCORSPolicy(cfg.CORSAllowedOrigins, cfg.CORSAllowedHeaders)
+	check("CORS_ALLOWED_ORIGINS or CORS_ALLOWED_HEADERS", err)
+	_, err = web.ParseCompression(cfg.HTTPCompression)
+	check("HTTP_COMPRESSION", err)
+	_, err = webhook.ParseURLs(cfg.WebhookURLs)
+	check("WEBHOOK_URLS", err)
+	_, err = webhook.ParseEvents(cfg.WebhookEvents)
+	check("WEBHOOK_EVENTS", err)
+	_, err = cfg.WorkerTokens()
+	check("WORKER_BOT_TOKENS", err)
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if cfg.ProxyOutboundURL != "" && strings.Trim(cfg.ProxyAllowedDomains, " ,.") == "" {
+		errs = append(errs, errors.New("PROXY_ALLOWED_DOMAINS must be set when PROXY_OUTBOUND_URL is set"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/glebarez/go-sqlite"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// Queries and schemas are written in SQLite syntax and translated for the other drivers.
+var (
+	placeholderPattern    = regexp.MustCompile(`\?`)
+	excludedPattern       = regexp.MustCompile(`(?i)\bexcluded\.(\w+)`)
+	onConflictPattern     = regexp.MustCompile(`(?is)ON CONFLICT\s*\([^)]*\)\s*DO UPDATE SET`)
+	insertOrIgnorePattern = regexp.MustCompile(`(?i)INSERT OR IGNORE INTO`)
+	autoIncrementPattern  = regexp.MustCompile(`(?i)\bINTEGER PRIMARY KEY AUTOINCREMENT\b`)
+	integerPattern        = regexp.MustCompile(`\bINTEGER\b`)
+	datetimePattern       = regexp.MustCompile(`\bDATETIME\b`)
+)
+
+// DB wraps the application database and translates queries for the configured driver.
+type DB struct {
+	*sql.DB
+	driver string
+}
+
+// Open opens the application database with one of the supported drivers.
+func Open(driver, dsn string) (*DB, error) {
+	switch driver {
+	case DriverSQLite, DriverPostgres, DriverMySQL:
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %w", driver, err)
+	}
+	return &DB{DB: db, driver: driver}, nil
+}
+
+// Driver returns the name of the database driver.
+func (db *DB) Driver() string {
+	return db.driver
+}
+
+// translate rewrites a query written for SQLite into the dialect of the driver.
+func (db *DB) translate(query string) string {
+	switch db.driver {
+	case DriverPostgres:
+		query = autoIncrementPattern.ReplaceAllString(query, "BIGSERIAL PRIMARY KEY")
+		query = integerPattern.ReplaceAllString(query, "BIGINT")
+		query = datetimePattern.ReplaceAllString(query, "TIMESTAMP")
+		if insertOrIgnorePattern.MatchString(query) {
+			query = insertOrIgnorePattern.ReplaceAllString(query, "INSERT INTO")
+			query = strings.TrimSuffix(strings.TrimSpace(query), ";") + " ON CONFLICT DO NOTHING"
+		}
+		n := 0
+		query = placeholderPattern.ReplaceAllStringFunc(query, func(string) string {
+			n++
+			return "$" + strconv.Itoa(n)
+		})
+	case DriverMySQL:
+		query = autoIncrementPattern.ReplaceAllString(query, "BIGINT AUTO_INCREMENT PRIMARY KEY")
+		query = integerPattern.ReplaceAllString(query, "BIGINT")
+		query = insertOrIgnorePattern.ReplaceAllString(query, "INSERT IGNORE INTO")
+		query = onConflictPattern.ReplaceAllString(query, "ON DUPLICATE KEY UPDATE")
+		query = excludedPattern.ReplaceAllString(query, "VALUES($1)")
+	}
+	return query
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.translate(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.translate(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.translate(query), args...)
+}
+
+// CheckIntegrity runs the integrity check of SQLite and returns the problems it found. The
+// servers of the other drivers check their own storage, so for them it only checks the connection.
+func (db *DB) CheckIntegrity(ctx context.Context) error {
+	if db.driver != DriverSQLite {
+		return db.PingContext(ctx)
+	}
+	rows, err := db.DB.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("failed to check database integrity: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupted: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Begin starts a transaction whose queries are translated like those of the DB.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+// Tx is a transaction on a DB.
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.translate(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.translate(query), args...)
+}
+
+// createIndex creates an index unless it already exists. MySQL has no CREATE INDEX IF NOT EXISTS.
+func (db *DB) createIndex(name, table, columns string) error {
+	if db.driver == DriverMySQL {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`, table, name).Scan(&count)
+		if err != nil || count > 0 {
+			return err
+		}
+		_, err = db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, table, columns))
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, columns))
+	return err
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already present.
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	// Selecting the column fails on every driver if it does not exist.
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column, table))
+	if err == nil {
+		return rows.Close()
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s to table %s: %w", column, table, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_CheckIntegrity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(DriverSQLite, "file:"+path+"?mode=rwc")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if err := db.CheckIntegrity(context.Background()); err != nil {
+		t.Errorf("CheckIntegrity of a new database = %v", err)
+	}
+	db.Close()
+
+	// Overwrite every page after the header page, leaving the schema unreadable.
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(content[4096:], bytes.Repeat([]byte{0xff}, len(content)-4096))
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(DriverSQLite, "file:"+path+"?mode=rwc")
+	if err != nil {
+		return // Refusing to open is as good as failing the check.
+	}
+	defer db.Close()
+	if err := db.CheckIntegrity(context.Background()); err == nil {
+		t.Error("Expected CheckIntegrity to report the corrupted database")
+	}
+}
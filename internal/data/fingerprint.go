@@ -0,0 +1,59 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// FingerprintRepository indexes Telegram documents by the fingerprint of their content, so a file
+// uploaded more than once, which gets a new document each time, resolves to the document that
+// was indexed first: its canonical document.
+type FingerprintRepository struct {
+	db *DB
+}
+
+// NewFingerprintRepository creates a new instance of FingerprintRepository.
+func NewFingerprintRepository(db *DB) *FingerprintRepository {
+	return &FingerprintRepository{db: db}
+}
+
+// Record indexes a document by its fingerprint and returns its canonical document, which is the
+// document itself unless another one with the same fingerprint was indexed before. A document is
+// indexed once; recording it again returns the canonical document it got then.
+func (r *FingerprintRepository) Record(documentID int64, fingerprint string, fileSize int64) (int64, error) {
+	if canonicalID, ok, err := r.Canonical(documentID); err != nil || ok {
+		return canonicalID, err
+	}
+
+	canonicalID := documentID
+	err := r.db.QueryRow(`SELECT canonical_id FROM file_fingerprints WHERE fingerprint = ? AND file_size = ? LIMIT 1`, fingerprint, fileSize).Scan(&canonicalID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to look up fingerprint of document %d: %w", documentID, err)
+	}
+	_, err = r.db.Exec(`INSERT OR IGNORE INTO file_fingerprints (document_id, fingerprint, canonical_id, file_size) VALUES (?, ?, ?, ?)`,
+		documentID, fingerprint, canonicalID, fileSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record fingerprint of document %d: %w", documentID, err)
+	}
+	return canonicalID, nil
+}
+
+// Canonical returns the canonical document of an indexed document. ok is false if the document
+// is not indexed.
+func (r *FingerprintRepository) Canonical(documentID int64) (canonicalID int64, ok bool, err error) {
+	err = r.db.QueryRow(`SELECT canonical_id FROM file_fingerprints WHERE document_id = ?`, documentID).Scan(&canonicalID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to look up canonical document of %d: %w", documentID, err)
+	}
+	return canonicalID, true, nil
+}
+
+// Duplicates returns the number of indexed documents that resolve to another document.
+func (r *FingerprintRepository) Duplicates() (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM file_fingerprints WHERE canonical_id <> document_id`).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,35 @@
+package data
+
+import "testing"
+
+func TestFingerprintRepository(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewFingerprintRepository(db)
+
+	if _, ok, err := repo.Canonical(1); err != nil || ok {
+		t.Fatalf("Canonical of an unknown document = %v, %v; want not found", ok, err)
+	}
+	if id, err := repo.Record(10, "abc", 100); err != nil || id != 10 {
+		t.Fatalf("Record of the first document = %d, %v; want itself", id, err)
+	}
+	// A later upload of the same file resolves to the first one.
+	if id, err := repo.Record(5, "abc", 100); err != nil || id != 10 {
+		t.Errorf("Record of a duplicate = %d, %v; want 10", id, err)
+	}
+	if id, ok, err := repo.Canonical(5); err != nil || !ok || id != 10 {
+		t.Errorf("Canonical = %d, %v, %v; want 10", id, ok, err)
+	}
+	if id, err := repo.Record(11, "def", 100); err != nil || id != 11 {
+		t.Errorf("Record of another file = %d, %v; want itself", id, err)
+	}
+	// Recording again keeps the canonical document.
+	if id, err := repo.Record(5, "def", 100); err != nil || id != 10 {
+		t.Errorf("Record again = %d, %v; want 10", id, err)
+	}
+	if n, err := repo.Duplicates(); err != nil || n != 1 {
+		t.Errorf("Duplicates = %d, %v; want 1", n, err)
+	}
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// mediaSearchVector is the document PostgreSQL searches. Queries must repeat the expression of
+// the index exactly for it to be used.
+const mediaSearchVector = `to_tsvector('simple', COALESCE(file_name, '') || ' ' || COALESCE(title, '') || ' ' || COALESCE(performer, '') || ' ' || COALESCE(caption, ''))`
+
+// MediaItem is a media message a user has sent to the bot.
+type MediaItem struct {
+	MessageID int
+	FileName  string
+	FileSize  int64
+	MimeType  string
+	Hash      string
+	Title     string
+	Performer string
+	Caption   string
+	// DocumentID identifies the Telegram file, which is also its location in the binary cache.
+	DocumentID int64
+	SentAt     time.Time
+	PlayedAt   sql.NullTime
+}
+
+type MediaRepository struct {
+	db *DB
+}
+
+// NewMediaRepository creates a new instance of MediaRepository.
+func NewMediaRepository(db *DB) *MediaRepository {
+	return &MediaRepository{db: db}
+}
+
+// RecordMedia stores a media message sent by the user. Media is sent to the player as soon as it
+// arrives, so it also counts as played.
+func (r *MediaRepository) RecordMedia(userID int64, item *MediaItem) error {
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO media (user_id, message_id, file_name, file_size, mime_type, hash, title, performer, caption, document_id, sent_at, played_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(user_id, message_id) DO UPDATE SET
+	file_name=excluded.file_name,
+	file_size=excluded.file_size,
+	mime_type=excluded.mime_type,
+	hash=excluded.hash,
+	title=excluded.title,
+	performer=excluded.performer,
+	caption=excluded.caption,
+	document_id=excluded.document_id,
+	played_at=excluded.played_at;`
+
+	_, err := r.db.Exec(query, userID, item.MessageID, item.FileName, item.FileSize, item.MimeType, item.Hash,
+		item.Title, item.Performer, item.Caption, item.DocumentID, now, now)
+	return err
+}
+
+// MarkPlayed records that a media message of the user was sent to the player again.
+func (r *MediaRepository) MarkPlayed(userID int64, messageID int) error {
+	_, err := r.db.Exec(`UPDATE media SET played_at = ? WHERE user_id = ? AND message_id = ?`, time.Now().UTC(), userID, messageID)
+	return err
+}
+
+// ListHistory returns a page of the user's media, most recently sent first.
+func (r *MediaRepository) ListHistory(userID int64, limit, offset int) ([]MediaItem, error) {
+	return r.list(`
+	SELECT message_id, file_name, file_size, mime_type, hash, sent_at, played_at FROM media
+	WHERE user_id = ? ORDER BY sent_at DESC, message_id DESC LIMIT ? OFFSET ?`, userID, limit, offset)
+}
+
+// ListRecent returns a page of the user's media, most recently played first.
+func (r *MediaRepository) ListRecent(userID int64, limit, offset int) ([]MediaItem, error) {
+	return r.list(`
+	SELECT message_id, file_name, file_size, mime_type, hash, sent_at, played_at FROM media
+	WHERE user_id = ? AND played_at IS NOT NULL ORDER BY played_at DESC, message_id DESC LIMIT ? OFFSET ?`, userID, limit, offset)
+}
+
+// Search returns a page of the user's media whose file name, audio title, performer or caption
+// contain all words of term, best matches first. Words also match as prefixes.
+func (r *MediaRepository) Search(userID int64, term string, limit, offset int) ([]MediaItem, error) {
+	words := searchWords(term)
+	if len(words) == 0 {
+		return []MediaItem{}, nil
+	}
+
+	switch r.db.Driver() {
+	case DriverPostgres:
+		for i, word := range words {
+			words[i] = word + ":*"
+		}
+		return r.list(`
+		SELECT message_id, file_name, file_size, mime_type, hash, sent_at, played_at FROM media
+		WHERE user_id = ? AND `+mediaSearchVector+` @@ to_tsquery('simple', ?)
+		ORDER BY ts_rank(`+mediaSearchVector+`, to_tsquery('simple', ?)) DESC, sent_at DESC LIMIT ? OFFSET ?`,
+			userID, strings.Join(words, " & "), strings.Join(words, " & "), limit, offset)
+	case DriverMySQL:
+		for i, word := range words {
+			words[i] = "+" + word + "*"
+		}
+		return r.list(`
+		SELECT message_id, file_name, file_size, mime_type, hash, sent_at, played_at FROM media
+		WHERE user_id = ? AND MATCH (file_name, title, performer, caption) AGAINST (? IN BOOLEAN MODE)
+		ORDER BY MATCH (file_name, title, performer, caption) AGAINST (? IN BOOLEAN MODE) DESC, sent_at DESC LIMIT ? OFFSET ?`,
+			userID, strings.Join(words, " "), strings.Join(words, " "), limit, offset)
+	}
+
+	for i, word := range words {
+		words[i] = `"` + word + `"*`
+	}
+	return r.list(`
+	SELECT media.message_id, media.file_name, media.file_size, media.mime_type, media.hash, media.sent_at, media.played_at
+	FROM media_fts JOIN media ON media.rowid = media_fts.rowid
+	WHERE media_fts MATCH ? AND media.user_id = ?
+	ORDER BY media_fts.rank, media.sent_at DESC LIMIT ? OFFSET ?`, strings.Join(words, " "), userID, limit, offset)
+}
+
+// OwnerOf returns the user who sent a media message, and false if it was never recorded.
+func (r *MediaRepository) OwnerOf(messageID int) (int64, bool, error) {
+	var userID int64
+	err := r.db.QueryRow(`SELECT user_id FROM media WHERE message_id = ? ORDER BY sent_at LIMIT 1`, messageID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return userID, err == nil, err
+}
+
+// DocumentIDs returns the distinct Telegram files the user has sent.
+func (r *MediaRepository) DocumentIDs(userID int64) ([]int64, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT document_id FROM media WHERE user_id = ? AND document_id IS NOT NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// searchWords splits a search term into words of letters and digits. Everything else is
+// dropped, so the words are safe to use in the query syntax of every driver.
+func searchWords(term string) []string {
+	return strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (r *MediaRepository) list(query string, args ...interface{}) ([]MediaItem, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []MediaItem{}
+	for rows.Next() {
+		var item MediaItem
+		var fileName, mimeType sql.NullString
+		if err := rows.Scan(&item.MessageID, &fileName, &item.FileSize, &mimeType, &item.Hash, &item.SentAt, &item.PlayedAt); err != nil {
+			return nil, err
+		}
+		item.FileName = fileName.String
+		item.MimeType = mimeType.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
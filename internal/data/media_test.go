@@ -0,0 +1,59 @@
+package data
+
+import "testing"
+
+func TestMediaRepository_Search(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewMediaRepository(db)
+
+	items := []MediaItem{
+		{MessageID: 1, FileName: "Holiday.Video.2023.mkv", Hash: "a"},
+		{MessageID: 2, FileName: "track01.mp3", Title: "Blue Monday", Performer: "New Order", Hash: "b"},
+		{MessageID: 3, FileName: "clip.mp4", Caption: "Birthday party at the beach", Hash: "c"},
+	}
+	for i := range items {
+		if err := repo.RecordMedia(1, &items[i]); err != nil {
+			t.Fatalf("RecordMedia failed: %v", err)
+		}
+	}
+	// Another user's media is never returned.
+	if err := repo.RecordMedia(2, &MediaItem{MessageID: 4, FileName: "holiday.mp4", Hash: "d"}); err != nil {
+		t.Fatalf("RecordMedia failed: %v", err)
+	}
+
+	tests := []struct {
+		term string
+		want []int
+	}{
+		{"holiday", []int{1}},
+		{"new ord", []int{2}},
+		{"BEACH party", []int{3}},
+		{"birthday \"monday", nil},
+		{"*", nil},
+	}
+	for _, tt := range tests {
+		results, err := repo.Search(1, tt.term, 10, 0)
+		if err != nil {
+			t.Fatalf("Search(%q) failed: %v", tt.term, err)
+		}
+		var got []int
+		for _, item := range results {
+			got = append(got, item.MessageID)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("Search(%q) = %v, want %v", tt.term, got, tt.want)
+		}
+	}
+
+	// Updating a media record keeps the index in sync.
+	items[0].FileName = "renamed.mkv"
+	if err := repo.RecordMedia(1, &items[0]); err != nil {
+		t.Fatalf("RecordMedia failed: %v", err)
+	}
+	if results, _ := repo.Search(1, "holiday", 10, 0); len(results) != 0 {
+		t.Errorf("Search found the old file name after an update")
+	}
+}
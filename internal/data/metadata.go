@@ -0,0 +1,78 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MetadataRepository keeps the metadata of Telegram documents and which document each message
+// of the bot carries, so files can be served after a restart without asking Telegram again. The
+// metadata is stored as it is given, once per document.
+type MetadataRepository struct {
+	db *DB
+}
+
+// NewMetadataRepository creates a new instance of MetadataRepository.
+func NewMetadataRepository(db *DB) *MetadataRepository {
+	return &MetadataRepository{db: db}
+}
+
+// MessageDocument returns the metadata of the document a message of the account selfID carries
+// and when the message was last looked up. ok is false if the message is not stored.
+func (r *MetadataRepository) MessageDocument(selfID int64, messageID int) (metadata string, updatedAt time.Time, ok bool, err error) {
+	err = r.db.QueryRow(`
+	SELECT d.metadata, m.updated_at FROM message_documents m
+	JOIN document_metadata d ON d.document_id = m.document_id
+	WHERE m.self_id = ? AND m.message_id = ?`, selfID, messageID).Scan(&metadata, &updatedAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", time.Time{}, false, fmt.Errorf("failed to load the document of message %d: %w", messageID, err)
+	}
+	return metadata, updatedAt, true, nil
+}
+
+// StoreMessageDocument stores the metadata of a document and that a message carries it, as
+// looked up at updatedAt.
+func (r *MetadataRepository) StoreMessageDocument(selfID int64, messageID int, documentID int64, metadata string, updatedAt time.Time) error {
+	updatedAt = updatedAt.UTC().Truncate(time.Second)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO document_metadata (document_id, metadata, updated_at) VALUES (?, ?, ?)
+	ON CONFLICT(document_id) DO UPDATE SET
+	metadata=excluded.metadata,
+	updated_at=excluded.updated_at;`, documentID, metadata, updatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store the metadata of document %d: %w", documentID, err)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO message_documents (self_id, message_id, document_id, updated_at) VALUES (?, ?, ?, ?)
+	ON CONFLICT(self_id, message_id) DO UPDATE SET
+	document_id=excluded.document_id,
+	updated_at=excluded.updated_at;`, selfID, messageID, documentID, updatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store the document of message %d: %w", messageID, err)
+	}
+	return tx.Commit()
+}
+
+// Prune removes the messages not looked up since before, and the documents no message carries
+// any more, and returns how many messages were removed.
+func (r *MetadataRepository) Prune(before time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM message_documents WHERE updated_at < ?`, before.UTC().Truncate(time.Second))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune message documents: %w", err)
+	}
+	_, err = r.db.Exec(`DELETE FROM document_metadata WHERE document_id NOT IN (SELECT document_id FROM message_documents)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune document metadata: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetadataRepository(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewMetadataRepository(db)
+
+	if _, _, ok, err := repo.MessageDocument(1, 10); err != nil || ok {
+		t.Fatalf("MessageDocument of an unknown message = %v, %v; want not found", ok, err)
+	}
+
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	if err := repo.StoreMessageDocument(1, 10, 100, `{"v":1}`, now.Add(-48*time.Hour)); err != nil {
+		t.Fatalf("StoreMessageDocument failed: %v", err)
+	}
+	// Another message with the same document updates the one record of the document.
+	if err := repo.StoreMessageDocument(1, 11, 100, `{"v":2}`, now); err != nil {
+		t.Fatalf("StoreMessageDocument failed: %v", err)
+	}
+	metadata, updatedAt, ok, err := repo.MessageDocument(1, 10)
+	if err != nil || !ok || metadata != `{"v":2}` || !updatedAt.Equal(now.Add(-48*time.Hour)) {
+		t.Errorf("MessageDocument = %q, %v, %v, %v; want the latest metadata looked up 48h ago", metadata, updatedAt, ok, err)
+	}
+	// Messages are per account.
+	if _, _, ok, err := repo.MessageDocument(2, 10); err != nil || ok {
+		t.Errorf("MessageDocument of another account = %v, %v; want not found", ok, err)
+	}
+
+	if n, err := repo.Prune(now.Add(-24 * time.Hour)); err != nil || n != 1 {
+		t.Fatalf("Prune = %d, %v; want 1", n, err)
+	}
+	if _, _, ok, _ := repo.MessageDocument(1, 11); !ok {
+		t.Error("Expected the recent message to be kept")
+	}
+
+	if _, err := repo.Prune(now.Add(time.Hour)); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	var documents int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM document_metadata`).Scan(&documents); err != nil || documents != 0 {
+		t.Errorf("Documents left = %d, %v; want none", documents, err)
+	}
+}
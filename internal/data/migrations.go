@@ -0,0 +1,544 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a versioned schema change. Up applies it and Down reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db *DB) error
+	Down    func(db *DB) error
+}
+
+// MigrationStatus reports whether a migration has been applied to the database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations lists every schema change in version order. New changes are appended here and existing
+// entries are never edited once released. The first steps use IF NOT EXISTS so that databases created
+// before versioning adopt them without changes.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS users (
+			user_id INTEGER PRIMARY KEY,
+			chat_id INTEGER NOT NULL,
+			first_name TEXT,
+			last_name TEXT,
+			username TEXT,
+			is_authorized BOOLEAN DEFAULT FALSE,
+			is_admin BOOLEAN DEFAULT FALSE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS users;`),
+	},
+	{
+		Version: 2,
+		Name:    "create user_settings",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS user_settings (
+			user_id INTEGER PRIMARY KEY,
+			theme VARCHAR(16) NOT NULL DEFAULT 'dark',
+			density VARCHAR(16) NOT NULL DEFAULT 'comfortable',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS user_settings;`),
+	},
+	{
+		Version: 3,
+		Name:    "add user_settings.allow_pushes",
+		Up: func(db *DB) error {
+			return db.addColumnIfMissing("user_settings", "allow_pushes", "BOOLEAN NOT NULL DEFAULT FALSE")
+		},
+		Down: execAll(`ALTER TABLE user_settings DROP COLUMN allow_pushes;`),
+	},
+	{
+		Version: 4,
+		Name:    "create shares",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS shares (
+			token VARCHAR(64) PRIMARY KEY,
+			message_id INTEGER NOT NULL,
+			owner_id INTEGER NOT NULL,
+			title TEXT,
+			max_views INTEGER NOT NULL DEFAULT 0,
+			views INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME,
+			revoked BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS shares;`),
+	},
+	{
+		Version: 5,
+		Name:    "create daily statistics",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS daily_stats (
+				day VARCHAR(10) PRIMARY KEY,
+				streams INTEGER NOT NULL DEFAULT 0,
+				bytes INTEGER NOT NULL DEFAULT 0,
+				cache_hits INTEGER NOT NULL DEFAULT 0,
+				cache_misses INTEGER NOT NULL DEFAULT 0
+			);`,
+			`CREATE TABLE IF NOT EXISTS daily_users (
+				day VARCHAR(10) NOT NULL,
+				user_id INTEGER NOT NULL,
+				PRIMARY KEY (day, user_id)
+			);`,
+			`CREATE TABLE IF NOT EXISTS daily_files (
+				day VARCHAR(10) NOT NULL,
+				message_id INTEGER NOT NULL,
+				file_name TEXT,
+				streams INTEGER NOT NULL DEFAULT 0,
+				bytes INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (day, message_id)
+			);`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS daily_files;`,
+			`DROP TABLE IF EXISTS daily_users;`,
+			`DROP TABLE IF EXISTS daily_stats;`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "create playlists",
+		Up: func(db *DB) error {
+			err := execAll(`CREATE TABLE IF NOT EXISTS playlists (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				message_id INTEGER NOT NULL,
+				file_name TEXT,
+				position INTEGER NOT NULL,
+				added_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);`)(db)
+			if err != nil {
+				return err
+			}
+			return db.createIndex("idx_playlists_user_position", "playlists", "user_id, position")
+		},
+		Down: execAll(`DROP TABLE IF EXISTS playlists;`),
+	},
+	{
+		Version: 7,
+		Name:    "create media",
+		Up: func(db *DB) error {
+			err := execAll(`CREATE TABLE IF NOT EXISTS media (
+				user_id INTEGER NOT NULL,
+				message_id INTEGER NOT NULL,
+				file_name TEXT,
+				file_size INTEGER NOT NULL DEFAULT 0,
+				mime_type VARCHAR(255),
+				hash VARCHAR(64) NOT NULL,
+				sent_at DATETIME NOT NULL,
+				played_at DATETIME,
+				PRIMARY KEY (user_id, message_id)
+			);`)(db)
+			if err != nil {
+				return err
+			}
+			return db.createIndex("idx_media_user_played", "media", "user_id, played_at")
+		},
+		Down: execAll(`DROP TABLE IF EXISTS media;`),
+	},
+	{
+		Version: 8,
+		Name:    "add daily_stats.rate_limited",
+		Up: func(db *DB) error {
+			return db.addColumnIfMissing("daily_stats", "rate_limited", "INTEGER NOT NULL DEFAULT 0")
+		},
+		Down: execAll(`ALTER TABLE daily_stats DROP COLUMN rate_limited;`),
+	},
+	{
+		Version: 9,
+		Name:    "add media search fields",
+		Up: func(db *DB) error {
+			for _, column := range []string{"title", "performer", "caption"} {
+				if err := db.addColumnIfMissing("media", column, "TEXT"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: execAll(
+			`ALTER TABLE media DROP COLUMN title;`,
+			`ALTER TABLE media DROP COLUMN performer;`,
+			`ALTER TABLE media DROP COLUMN caption;`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "create media search index",
+		Up:      createMediaSearchIndex,
+		Down:    dropMediaSearchIndex,
+	},
+	{
+		Version: 11,
+		Name:    "create user usage and quotas",
+		Up: func(db *DB) error {
+			err := execAll(
+				`CREATE TABLE IF NOT EXISTS user_usage (
+					user_id INTEGER NOT NULL,
+					day VARCHAR(10) NOT NULL,
+					bytes INTEGER NOT NULL DEFAULT 0,
+					PRIMARY KEY (user_id, day)
+				);`,
+				`CREATE TABLE IF NOT EXISTS user_quotas (
+					user_id INTEGER PRIMARY KEY,
+					daily_bytes INTEGER NOT NULL DEFAULT 0,
+					monthly_bytes INTEGER NOT NULL DEFAULT 0
+				);`,
+			)(db)
+			if err != nil {
+				return err
+			}
+			if err := db.addColumnIfMissing("media", "document_id", "INTEGER"); err != nil {
+				return err
+			}
+			return db.createIndex("idx_media_message", "media", "message_id")
+		},
+		Down: func(db *DB) error {
+			dropIndex := `DROP INDEX IF EXISTS idx_media_message;`
+			if db.Driver() == DriverMySQL {
+				dropIndex = `DROP INDEX idx_media_message ON media;`
+			}
+			return execAll(
+				dropIndex,
+				`ALTER TABLE media DROP COLUMN document_id;`,
+				`DROP TABLE IF EXISTS user_quotas;`,
+				`DROP TABLE IF EXISTS user_usage;`,
+			)(db)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add user_settings.bridge_text",
+		Up: func(db *DB) error {
+			return db.addColumnIfMissing("user_settings", "bridge_text", "BOOLEAN NOT NULL DEFAULT FALSE")
+		},
+		Down: execAll(`ALTER TABLE user_settings DROP COLUMN bridge_text;`),
+	},
+	{
+		Version: 13,
+		Name:    "add users.language",
+		Up: func(db *DB) error {
+			return db.addColumnIfMissing("users", "language", "VARCHAR(8) NOT NULL DEFAULT ''")
+		},
+		Down: execAll(`ALTER TABLE users DROP COLUMN language;`),
+	},
+	{
+		Version: 14,
+		Name:    "create channel_subscriptions",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS channel_subscriptions (
+			channel_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			channel_title TEXT,
+			mime_types VARCHAR(255) NOT NULL DEFAULT '',
+			created_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel_id, user_id)
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS channel_subscriptions;`),
+	},
+	{
+		Version: 15,
+		Name:    "create scheduled_jobs",
+		Up: func(db *DB) error {
+			err := execAll(`CREATE TABLE IF NOT EXISTS scheduled_jobs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				chat_id INTEGER NOT NULL,
+				message_id INTEGER NOT NULL,
+				file_name TEXT,
+				run_at DATETIME NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);`)(db)
+			if err != nil {
+				return err
+			}
+			return db.createIndex("idx_scheduled_jobs_run_at", "scheduled_jobs", "run_at")
+		},
+		Down: execAll(`DROP TABLE IF EXISTS scheduled_jobs;`),
+	},
+	{
+		Version: 16,
+		Name:    "add user_settings.blocked_media_types",
+		Up: func(db *DB) error {
+			return db.addColumnIfMissing("user_settings", "blocked_media_types", "VARCHAR(64) NOT NULL DEFAULT ''")
+		},
+		Down: execAll(`ALTER TABLE user_settings DROP COLUMN blocked_media_types;`),
+	},
+	{
+		Version: 17,
+		Name:    "create user_file_limits",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS user_file_limits (
+			user_id INTEGER PRIMARY KEY,
+			max_file_size INTEGER NOT NULL
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS user_file_limits;`),
+	},
+	{
+		Version: 18,
+		Name:    "create file_fingerprints",
+		Up: func(db *DB) error {
+			err := execAll(`CREATE TABLE IF NOT EXISTS file_fingerprints (
+				document_id INTEGER PRIMARY KEY,
+				fingerprint VARCHAR(64) NOT NULL,
+				canonical_id INTEGER NOT NULL,
+				file_size INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);`)(db)
+			if err != nil {
+				return err
+			}
+			return db.createIndex("idx_file_fingerprints_fingerprint", "file_fingerprints", "fingerprint")
+		},
+		Down: execAll(`DROP TABLE IF EXISTS file_fingerprints;`),
+	},
+	{
+		Version: 19,
+		Name:    "create file metadata tables",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS document_metadata (
+			document_id INTEGER PRIMARY KEY,
+			metadata TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		);`, `CREATE TABLE IF NOT EXISTS message_documents (
+			self_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			document_id INTEGER NOT NULL,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (self_id, message_id)
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS message_documents;`, `DROP TABLE IF EXISTS document_metadata;`),
+	},
+	{
+		Version: 20,
+		Name:    "create storage_copies",
+		Up: execAll(`CREATE TABLE IF NOT EXISTS storage_copies (
+			channel_id INTEGER NOT NULL,
+			document_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel_id, document_id)
+		);`),
+		Down: execAll(`DROP TABLE IF EXISTS storage_copies;`),
+	},
+}
+
+// execAll returns a migration step that runs the given statements in order.
+func execAll(queries ...string) func(db *DB) error {
+	return func(db *DB) error {
+		for _, query := range queries {
+			if _, err := db.Exec(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// createMediaSearchIndex indexes the searchable media fields with the full-text search of the
+// driver: an FTS5 table kept in sync by triggers on SQLite, a GIN index on PostgreSQL and a
+// FULLTEXT index on MySQL.
+func createMediaSearchIndex(db *DB) error {
+	switch db.Driver() {
+	case DriverPostgres:
+		_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_media_search ON media USING GIN (%s)", mediaSearchVector))
+		return err
+	case DriverMySQL:
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'media' AND index_name = 'idx_media_search'`).Scan(&count)
+		if err != nil || count > 0 {
+			return err
+		}
+		_, err = db.Exec(`CREATE FULLTEXT INDEX idx_media_search ON media (file_name, title, performer, caption)`)
+		return err
+	}
+	return execAll(
+		`CREATE VIRTUAL TABLE IF NOT EXISTS media_fts USING fts5(
+			file_name, title, performer, caption, content='media', content_rowid='rowid'
+		);`,
+		`CREATE TRIGGER IF NOT EXISTS media_fts_insert AFTER INSERT ON media BEGIN
+			INSERT INTO media_fts (rowid, file_name, title, performer, caption)
+			VALUES (new.rowid, new.file_name, new.title, new.performer, new.caption);
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS media_fts_delete AFTER DELETE ON media BEGIN
+			INSERT INTO media_fts (media_fts, rowid, file_name, title, performer, caption)
+			VALUES ('delete', old.rowid, old.file_name, old.title, old.performer, old.caption);
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS media_fts_update AFTER UPDATE ON media BEGIN
+			INSERT INTO media_fts (media_fts, rowid, file_name, title, performer, caption)
+			VALUES ('delete', old.rowid, old.file_name, old.title, old.performer, old.caption);
+			INSERT INTO media_fts (rowid, file_name, title, performer, caption)
+			VALUES (new.rowid, new.file_name, new.title, new.performer, new.caption);
+		END;`,
+		// Index the media recorded before this migration.
+		`INSERT INTO media_fts (media_fts) VALUES ('rebuild');`,
+	)(db)
+}
+
+func dropMediaSearchIndex(db *DB) error {
+	switch db.Driver() {
+	case DriverPostgres:
+		_, err := db.Exec(`DROP INDEX IF EXISTS idx_media_search;`)
+		return err
+	case DriverMySQL:
+		_, err := db.Exec(`DROP INDEX idx_media_search ON media;`)
+		return err
+	}
+	return execAll(
+		`DROP TRIGGER IF EXISTS media_fts_insert;`,
+		`DROP TRIGGER IF EXISTS media_fts_delete;`,
+		`DROP TRIGGER IF EXISTS media_fts_update;`,
+		`DROP TABLE IF EXISTS media_fts;`,
+	)(db)
+}
+
+// Migrator applies and reverts the schema migrations of a database.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for the application schema.
+func NewMigrator(db *DB) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// LatestVersion returns the version the schema has once every migration is applied.
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) init() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at DATETIME NOT NULL
+	);`
+
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// CurrentVersion returns the highest applied migration, or 0 for an empty database.
+func (m *Migrator) CurrentVersion() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := m.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// Migrate applies all pending migrations and returns how many were applied.
+func (m *Migrator) Migrate() (int, error) {
+	return m.MigrateTo(m.LatestVersion())
+}
+
+// MigrateTo applies or reverts migrations until the schema is at the given version, and returns
+// how many steps were run. Each step is recorded once it succeeds, so a failed run can be resumed.
+func (m *Migrator) MigrateTo(target int) (int, error) {
+	if target < 0 || target > m.LatestVersion() {
+		return 0, fmt.Errorf("unknown schema version %d, latest is %d", target, m.LatestVersion())
+	}
+
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return 0, err
+	}
+	if current > m.LatestVersion() {
+		return 0, fmt.Errorf("database schema version %d is newer than this release supports (%d)", current, m.LatestVersion())
+	}
+
+	steps := 0
+	if target >= current {
+		for _, migration := range m.migrations {
+			if migration.Version <= current || migration.Version > target {
+				continue
+			}
+			if err := migration.Up(m.db); err != nil {
+				return steps, fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+			_, err := m.db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				migration.Version, migration.Name, time.Now().UTC())
+			if err != nil {
+				return steps, fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+			}
+			steps++
+		}
+		return steps, nil
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= target {
+			continue
+		}
+		if migration.Down == nil {
+			return steps, fmt.Errorf("migration %d (%s) cannot be reverted", migration.Version, migration.Name)
+		}
+		if err := migration.Down(m.db); err != nil {
+			return steps, fmt.Errorf("failed to revert migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if _, err := m.db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version); err != nil {
+			return steps, fmt.Errorf("failed to record reverted migration %d: %w", migration.Version, err)
+		}
+		steps++
+	}
+	return steps, nil
+}
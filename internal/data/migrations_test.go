@@ -0,0 +1,84 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(DriverSQLite, "file:"+filepath.Join(t.TempDir(), "test.db")+"?mode=rwc")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrator_UpAndDown(t *testing.T) {
+	db := openTestDB(t)
+	migrator := NewMigrator(db)
+
+	applied, err := migrator.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if applied != migrator.LatestVersion() {
+		t.Errorf("applied %d migrations, want %d", applied, migrator.LatestVersion())
+	}
+
+	// Running again is a no-op.
+	applied, err = migrator.Migrate()
+	if err != nil || applied != 0 {
+		t.Fatalf("second Migrate = %d, %v; want 0, nil", applied, err)
+	}
+
+	if _, err := migrator.MigrateTo(2); err != nil {
+		t.Fatalf("MigrateTo(2) failed: %v", err)
+	}
+	if version, _ := migrator.CurrentVersion(); version != 2 {
+		t.Errorf("CurrentVersion = %d, want 2", version)
+	}
+	if _, err := db.Exec(`SELECT token FROM shares LIMIT 0`); err == nil {
+		t.Error("shares table still exists after reverting")
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= 2) {
+			t.Errorf("migration %d applied = %v", status.Version, status.Applied)
+		}
+	}
+
+	if _, err := migrator.Migrate(); err != nil {
+		t.Fatalf("Migrate after revert failed: %v", err)
+	}
+	settings := DefaultUserSettings(1)
+	settings.AllowPushes = true
+	if err := NewUserRepository(db).StoreUserSettings(settings); err != nil {
+		t.Fatalf("StoreUserSettings failed: %v", err)
+	}
+}
+
+func TestMigrator_AdoptsExistingSchema(t *testing.T) {
+	db := openTestDB(t)
+
+	// A database created before versioning already has the column added by a later migration.
+	_, err := db.Exec(`CREATE TABLE user_settings (
+		user_id INTEGER PRIMARY KEY,
+		theme VARCHAR(16) NOT NULL DEFAULT 'dark',
+		density VARCHAR(16) NOT NULL DEFAULT 'comfortable',
+		allow_pushes BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+}
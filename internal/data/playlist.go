@@ -0,0 +1,95 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// QueueItem is a media message waiting in a user's play queue.
+type QueueItem struct {
+	ID        int64  `json:"-"`
+	MessageID int    `json:"messageId"`
+	FileName  string `json:"fileName"`
+}
+
+type PlaylistRepository struct {
+	db *DB
+}
+
+// NewPlaylistRepository creates a new instance of PlaylistRepository.
+func NewPlaylistRepository(db *DB) *PlaylistRepository {
+	return &PlaylistRepository{db: db}
+}
+
+// Enqueue adds a media message to the end of the user's queue.
+func (r *PlaylistRepository) Enqueue(userID int64, messageID int, fileName string) error {
+	query := `
+	INSERT INTO playlists (user_id, message_id, file_name, position)
+	SELECT ?, ?, ?, COALESCE(MAX(position), 0) + 1 FROM playlists WHERE user_id = ?`
+	_, err := r.db.Exec(query, userID, messageID, fileName, userID)
+	return err
+}
+
+// EnqueueNext adds a media message to the front of the user's queue.
+func (r *PlaylistRepository) EnqueueNext(userID int64, messageID int, fileName string) error {
+	query := `
+	INSERT INTO playlists (user_id, message_id, file_name, position)
+	SELECT ?, ?, ?, COALESCE(MIN(position), 1) - 1 FROM playlists WHERE user_id = ?`
+	_, err := r.db.Exec(query, userID, messageID, fileName, userID)
+	return err
+}
+
+// ListQueue returns the user's queue in play order.
+func (r *PlaylistRepository) ListQueue(userID int64) ([]QueueItem, error) {
+	rows, err := r.db.Query(`SELECT id, message_id, file_name FROM playlists WHERE user_id = ? ORDER BY position, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queue := []QueueItem{}
+	for rows.Next() {
+		var item QueueItem
+		var fileName sql.NullString
+		if err := rows.Scan(&item.ID, &item.MessageID, &fileName); err != nil {
+			return nil, err
+		}
+		item.FileName = fileName.String
+		queue = append(queue, item)
+	}
+	return queue, rows.Err()
+}
+
+// PopNext removes and returns the first item of the user's queue. It returns nil if the queue is empty.
+func (r *PlaylistRepository) PopNext(userID int64) (*QueueItem, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var item QueueItem
+	var fileName sql.NullString
+	row := tx.QueryRow(`SELECT id, message_id, file_name FROM playlists WHERE user_id = ? ORDER BY position, id LIMIT 1`, userID)
+	if err := row.Scan(&item.ID, &item.MessageID, &fileName); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	item.FileName = fileName.String
+
+	if _, err := tx.Exec(`DELETE FROM playlists WHERE id = ?`, item.ID); err != nil {
+		return nil, err
+	}
+	return &item, tx.Commit()
+}
+
+// ClearQueue removes all items from the user's queue and returns how many were removed.
+func (r *PlaylistRepository) ClearQueue(userID int64) (int64, error) {
+	res, err := r.db.Exec(`DELETE FROM playlists WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
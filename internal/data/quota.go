@@ -0,0 +1,100 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Quota limits the bytes a user may stream per UTC day and month. Zero means unlimited.
+type Quota struct {
+	DailyBytes   int64
+	MonthlyBytes int64
+}
+
+type QuotaRepository struct {
+	db *DB
+}
+
+// NewQuotaRepository creates a new instance of QuotaRepository.
+func NewQuotaRepository(db *DB) *QuotaRepository {
+	return &QuotaRepository{db: db}
+}
+
+// RecordUsage adds bytes streamed from the user's media to the day's total.
+func (r *QuotaRepository) RecordUsage(userID int64, day string, bytes int64) error {
+	_, err := r.db.Exec(`
+	INSERT INTO user_usage (user_id, day, bytes) VALUES (?, ?, ?)
+	ON CONFLICT(user_id, day) DO UPDATE SET
+	bytes=user_usage.bytes+excluded.bytes;`, userID, day, bytes)
+	return err
+}
+
+// Usage returns the bytes streamed from the user's media between from and to inclusive.
+func (r *QuotaRepository) Usage(userID int64, from, to string) (int64, error) {
+	var bytes sql.NullInt64
+	err := r.db.QueryRow(`SELECT SUM(bytes) FROM user_usage WHERE user_id = ? AND day BETWEEN ? AND ?`, userID, from, to).Scan(&bytes)
+	return bytes.Int64, err
+}
+
+// UsageToday returns the user's usage of the current UTC day.
+func (r *QuotaRepository) UsageToday(userID int64, now time.Time) (int64, error) {
+	return r.Usage(userID, StatsDay(now), StatsDay(now))
+}
+
+// UsageThisMonth returns the user's usage of the current UTC month.
+func (r *QuotaRepository) UsageThisMonth(userID int64, now time.Time) (int64, error) {
+	now = now.UTC()
+	return r.Usage(userID, StatsDay(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)), StatsDay(now))
+}
+
+// GetQuota returns the quota set for the user, and false if none is set.
+func (r *QuotaRepository) GetQuota(userID int64) (Quota, bool, error) {
+	var q Quota
+	err := r.db.QueryRow(`SELECT daily_bytes, monthly_bytes FROM user_quotas WHERE user_id = ?`, userID).Scan(&q.DailyBytes, &q.MonthlyBytes)
+	if err == sql.ErrNoRows {
+		return Quota{}, false, nil
+	}
+	return q, err == nil, err
+}
+
+// SetQuota stores the quota of a user, replacing the default.
+func (r *QuotaRepository) SetQuota(userID int64, q Quota) error {
+	_, err := r.db.Exec(`
+	INSERT INTO user_quotas (user_id, daily_bytes, monthly_bytes) VALUES (?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+	daily_bytes=excluded.daily_bytes,
+	monthly_bytes=excluded.monthly_bytes;`, userID, q.DailyBytes, q.MonthlyBytes)
+	return err
+}
+
+// GetFileSizeLimit returns the largest file the user may bridge, and false if no limit of
+// their own is set.
+func (r *QuotaRepository) GetFileSizeLimit(userID int64) (int64, bool, error) {
+	var limit int64
+	err := r.db.QueryRow(`SELECT max_file_size FROM user_file_limits WHERE user_id = ?`, userID).Scan(&limit)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return limit, err == nil, err
+}
+
+// SetFileSizeLimit stores the largest file the user may bridge, replacing the default.
+func (r *QuotaRepository) SetFileSizeLimit(userID, limit int64) error {
+	_, err := r.db.Exec(`
+	INSERT INTO user_file_limits (user_id, max_file_size) VALUES (?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+	max_file_size=excluded.max_file_size;`, userID, limit)
+	return err
+}
+
+// DeleteFileSizeLimit removes the file size limit of a user, so the default applies again.
+func (r *QuotaRepository) DeleteFileSizeLimit(userID int64) error {
+	_, err := r.db.Exec(`DELETE FROM user_file_limits WHERE user_id = ?`, userID)
+	return err
+}
+
+// DeleteQuota removes the quota of a user, so the default applies again.
+func (r *QuotaRepository) DeleteQuota(userID int64) error {
+	_, err := r.db.Exec(`DELETE FROM user_quotas WHERE user_id = ?`, userID)
+	return err
+}
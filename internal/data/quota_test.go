@@ -0,0 +1,72 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaRepository(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewQuotaRepository(db)
+
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	for _, usage := range []struct {
+		day   time.Time
+		bytes int64
+	}{
+		{now, 100},
+		{now, 50},
+		{now.AddDate(0, 0, -14), 1000}, // Earlier this month
+		{now.AddDate(0, -1, 0), 5000},  // Last month
+	} {
+		if err := repo.RecordUsage(1, StatsDay(usage.day), usage.bytes); err != nil {
+			t.Fatalf("RecordUsage failed: %v", err)
+		}
+	}
+
+	if today, err := repo.UsageToday(1, now); err != nil || today != 150 {
+		t.Errorf("UsageToday = %d, %v; want 150", today, err)
+	}
+	if month, err := repo.UsageThisMonth(1, now); err != nil || month != 1150 {
+		t.Errorf("UsageThisMonth = %d, %v; want 1150", month, err)
+	}
+	if other, err := repo.UsageThisMonth(2, now); err != nil || other != 0 {
+		t.Errorf("UsageThisMonth of another user = %d, %v; want 0", other, err)
+	}
+
+	if _, ok, err := repo.GetQuota(1); err != nil || ok {
+		t.Fatalf("GetQuota before SetQuota = %v, %v; want no quota", ok, err)
+	}
+	want := Quota{DailyBytes: 10, MonthlyBytes: 20}
+	if err := repo.SetQuota(1, want); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	if got, ok, err := repo.GetQuota(1); err != nil || !ok || got != want {
+		t.Errorf("GetQuota = %+v, %v, %v; want %+v", got, ok, err, want)
+	}
+	if err := repo.DeleteQuota(1); err != nil {
+		t.Fatalf("DeleteQuota failed: %v", err)
+	}
+	if _, ok, _ := repo.GetQuota(1); ok {
+		t.Errorf("Quota still set after DeleteQuota")
+	}
+
+	if _, ok, err := repo.GetFileSizeLimit(1); err != nil || ok {
+		t.Fatalf("GetFileSizeLimit before SetFileSizeLimit = %v, %v; want no limit", ok, err)
+	}
+	if err := repo.SetFileSizeLimit(1, 1<<30); err != nil {
+		t.Fatalf("SetFileSizeLimit failed: %v", err)
+	}
+	if limit, ok, err := repo.GetFileSizeLimit(1); err != nil || !ok || limit != 1<<30 {
+		t.Errorf("GetFileSizeLimit = %d, %v, %v; want %d", limit, ok, err, 1<<30)
+	}
+	if err := repo.DeleteFileSizeLimit(1); err != nil {
+		t.Fatalf("DeleteFileSizeLimit failed: %v", err)
+	}
+	if _, ok, _ := repo.GetFileSizeLimit(1); ok {
+		t.Errorf("File size limit still set after DeleteFileSizeLimit")
+	}
+}
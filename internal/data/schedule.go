@@ -0,0 +1,86 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ScheduledJob plays a media message in a user's player at a set time.
+type ScheduledJob struct {
+	ID        int64
+	UserID    int64
+	ChatID    int64
+	MessageID int
+	FileName  string
+	RunAt     time.Time
+}
+
+type ScheduleRepository struct {
+	db *DB
+}
+
+// NewScheduleRepository creates a new instance of ScheduleRepository.
+func NewScheduleRepository(db *DB) *ScheduleRepository {
+	return &ScheduleRepository{db: db}
+}
+
+// AddJob stores a scheduled job. Times are kept in UTC to the second, so they compare correctly
+// on every driver.
+func (r *ScheduleRepository) AddJob(job *ScheduledJob) error {
+	_, err := r.db.Exec(`INSERT INTO scheduled_jobs (user_id, chat_id, message_id, file_name, run_at) VALUES (?, ?, ?, ?, ?)`,
+		job.UserID, job.ChatID, job.MessageID, job.FileName, job.RunAt.UTC().Truncate(time.Second))
+	return err
+}
+
+// ListJobs returns the user's pending jobs, the next one first.
+func (r *ScheduleRepository) ListJobs(userID int64) ([]ScheduledJob, error) {
+	return r.list(`WHERE user_id = ? ORDER BY run_at, id`, userID)
+}
+
+// DueJobs returns the jobs whose time has come.
+func (r *ScheduleRepository) DueJobs(now time.Time) ([]ScheduledJob, error) {
+	return r.list(`WHERE run_at <= ? ORDER BY run_at, id`, now.UTC().Truncate(time.Second))
+}
+
+// CountJobs returns how many jobs the user has pending.
+func (r *ScheduleRepository) CountJobs(userID int64) (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM scheduled_jobs WHERE user_id = ?`, userID).Scan(&n)
+	return n, err
+}
+
+// CancelJob removes a job of the user and reports whether it existed.
+func (r *ScheduleRepository) CancelJob(userID, id int64) (bool, error) {
+	result, err := r.db.Exec(`DELETE FROM scheduled_jobs WHERE user_id = ? AND id = ?`, userID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteJob removes a job that has run.
+func (r *ScheduleRepository) DeleteJob(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM scheduled_jobs WHERE id = ?`, id)
+	return err
+}
+
+func (r *ScheduleRepository) list(where string, args ...interface{}) ([]ScheduledJob, error) {
+	rows, err := r.db.Query(`SELECT id, user_id, chat_id, message_id, file_name, run_at FROM scheduled_jobs `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []ScheduledJob
+	for rows.Next() {
+		var job ScheduledJob
+		var fileName sql.NullString
+		if err := rows.Scan(&job.ID, &job.UserID, &job.ChatID, &job.MessageID, &fileName, &job.RunAt); err != nil {
+			return nil, err
+		}
+		job.FileName = fileName.String
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleRepository(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewScheduleRepository(db)
+
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	for _, job := range []*ScheduledJob{
+		{UserID: 1, ChatID: 1, MessageID: 10, FileName: "later.mp4", RunAt: now.Add(time.Hour)},
+		{UserID: 1, ChatID: 1, MessageID: 11, FileName: "soon.mp4", RunAt: now.Add(-time.Minute + 500*time.Millisecond)},
+		{UserID: 2, ChatID: 2, MessageID: 12, FileName: "other.mp3", RunAt: now},
+	} {
+		if err := repo.AddJob(job); err != nil {
+			t.Fatalf("AddJob failed: %v", err)
+		}
+	}
+
+	jobs, err := repo.ListJobs(1)
+	if err != nil || len(jobs) != 2 || jobs[0].FileName != "soon.mp4" {
+		t.Fatalf("ListJobs = %v, %v; want soon.mp4 first of 2", jobs, err)
+	}
+	if n, err := repo.CountJobs(1); err != nil || n != 2 {
+		t.Errorf("CountJobs = %d, %v; want 2", n, err)
+	}
+
+	due, err := repo.DueJobs(now)
+	if err != nil || len(due) != 2 || due[0].MessageID != 11 || due[1].MessageID != 12 {
+		t.Fatalf("DueJobs = %v, %v; want message IDs 11 and 12", due, err)
+	}
+	if !due[0].RunAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("RunAt = %v, want %v", due[0].RunAt, now.Add(-time.Minute))
+	}
+	if err := repo.DeleteJob(due[0].ID); err != nil {
+		t.Fatalf("DeleteJob failed: %v", err)
+	}
+
+	// Users can only cancel their own jobs.
+	if cancelled, err := repo.CancelJob(1, due[1].ID); err != nil || cancelled {
+		t.Errorf("CancelJob of another user's job = %v, %v; want false", cancelled, err)
+	}
+	if cancelled, err := repo.CancelJob(2, due[1].ID); err != nil || !cancelled {
+		t.Errorf("CancelJob = %v, %v; want true", cancelled, err)
+	}
+	if due, err := repo.DueJobs(now); err != nil || len(due) != 0 {
+		t.Errorf("DueJobs after running = %v, %v; want none", due, err)
+	}
+}
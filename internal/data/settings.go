@@ -0,0 +1,159 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ThemeDark  = "dark"
+	ThemeLight = "light"
+
+	DensityComfortable = "comfortable"
+	DensityCompact     = "compact"
+
+	MediaTypeVideo    = "video"
+	MediaTypeAudio    = "audio"
+	MediaTypeVoice    = "voice"
+	MediaTypePhoto    = "photo"
+	MediaTypeDocument = "document"
+)
+
+// MediaTypes are the kinds of media users can choose not to bridge, in the order /settings
+// shows them.
+var MediaTypes = []string{MediaTypeVideo, MediaTypeAudio, MediaTypeVoice, MediaTypePhoto, MediaTypeDocument}
+
+// MediaTypeOf returns the kind of a file with the given MIME type. Voice messages are audio
+// files Telegram marks as voice.
+func MediaTypeOf(mimeType string, voice bool) string {
+	switch {
+	case voice:
+		return MediaTypeVoice
+	case strings.HasPrefix(mimeType, "video/"):
+		return MediaTypeVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return MediaTypeAudio
+	case strings.HasPrefix(mimeType, "image/"):
+		return MediaTypePhoto
+	}
+	return MediaTypeDocument
+}
+
+// UserSettings holds the player preferences of a user.
+type UserSettings struct {
+	UserID  int64
+	Theme   string
+	Density string
+	// AllowPushes records whether admins may push media to the user's player.
+	AllowPushes bool
+	// BridgeText records whether text messages and locations sent to the bot are shown in the player.
+	BridgeText bool
+	// BlockedMediaTypes are the kinds of media, from MediaTypes, that are not sent to the player.
+	BlockedMediaTypes []string
+}
+
+// Bridges reports whether media of the given kind is sent to the user's player.
+func (s *UserSettings) Bridges(mediaType string) bool {
+	for _, blocked := range s.BlockedMediaTypes {
+		if blocked == mediaType {
+			return false
+		}
+	}
+	return true
+}
+
+// ToggleMediaType blocks a kind of media if it is bridged, and bridges it again if it is blocked.
+func (s *UserSettings) ToggleMediaType(mediaType string) {
+	if s.Bridges(mediaType) {
+		s.BlockedMediaTypes = append(s.BlockedMediaTypes, mediaType)
+		return
+	}
+	var blocked []string
+	for _, t := range s.BlockedMediaTypes {
+		if t != mediaType {
+			blocked = append(blocked, t)
+		}
+	}
+	s.BlockedMediaTypes = blocked
+}
+
+// IsValidMediaType reports whether the given kind of media is one of MediaTypes.
+func IsValidMediaType(mediaType string) bool {
+	for _, t := range MediaTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultUserSettings returns the settings used when a user has not stored any preferences yet.
+func DefaultUserSettings(userID int64) *UserSettings {
+	return &UserSettings{
+		UserID:  userID,
+		Theme:   ThemeDark,
+		Density: DensityComfortable,
+	}
+}
+
+// IsValidTheme reports whether the given theme name is supported by the player.
+func IsValidTheme(theme string) bool {
+	return theme == ThemeDark || theme == ThemeLight
+}
+
+// IsValidDensity reports whether the given UI density is supported by the player.
+func IsValidDensity(density string) bool {
+	return density == DensityComfortable || density == DensityCompact
+}
+
+// GetUserSettings retrieves the player preferences of a user, falling back to the defaults if none are stored.
+func (r *UserRepository) GetUserSettings(userID int64) (*UserSettings, error) {
+	query := `SELECT user_id, theme, density, allow_pushes, bridge_text, blocked_media_types FROM user_settings WHERE user_id = ?`
+	row := r.db.QueryRow(query, userID)
+
+	var settings UserSettings
+	var blocked string
+	if err := row.Scan(&settings.UserID, &settings.Theme, &settings.Density, &settings.AllowPushes, &settings.BridgeText, &blocked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultUserSettings(userID), nil
+		}
+		return nil, err
+	}
+	if blocked != "" {
+		settings.BlockedMediaTypes = strings.Split(blocked, ",")
+	}
+
+	return &settings, nil
+}
+
+// StoreUserSettings stores or updates the player preferences of a user.
+func (r *UserRepository) StoreUserSettings(settings *UserSettings) error {
+	if !IsValidTheme(settings.Theme) {
+		return fmt.Errorf("invalid theme %q", settings.Theme)
+	}
+	if !IsValidDensity(settings.Density) {
+		return fmt.Errorf("invalid density %q", settings.Density)
+	}
+	for _, mediaType := range settings.BlockedMediaTypes {
+		if !IsValidMediaType(mediaType) {
+			return fmt.Errorf("invalid media type %q", mediaType)
+		}
+	}
+
+	query := `
+	INSERT INTO user_settings (user_id, theme, density, allow_pushes, bridge_text, blocked_media_types, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(user_id) DO UPDATE SET
+	theme=excluded.theme,
+	density=excluded.density,
+	allow_pushes=excluded.allow_pushes,
+	bridge_text=excluded.bridge_text,
+	blocked_media_types=excluded.blocked_media_types,
+	updated_at=excluded.updated_at;
+	`
+
+	_, err := r.db.Exec(query, settings.UserID, settings.Theme, settings.Density, settings.AllowPushes, settings.BridgeText, strings.Join(settings.BlockedMediaTypes, ","))
+	return err
+}
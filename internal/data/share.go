@@ -0,0 +1,94 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Share is a public landing page for a media message with optional view and time limits.
+type Share struct {
+	Token     string
+	MessageID int
+	OwnerID   int64
+	Title     string
+	MaxViews  int // Zero means unlimited.
+	Views     int
+	ExpiresAt sql.NullTime
+	Revoked   bool
+	CreatedAt time.Time
+}
+
+// IsAvailable reports whether the share is neither revoked nor expired at the given time.
+// View limits are enforced separately when a visit of the landing page starts streaming.
+func (s *Share) IsAvailable(now time.Time) bool {
+	if s.Revoked {
+		return false
+	}
+	return !s.ExpiresAt.Valid || now.Before(s.ExpiresAt.Time)
+}
+
+type ShareRepository struct {
+	db *DB
+}
+
+// NewShareRepository creates a new instance of ShareRepository.
+func NewShareRepository(db *DB) *ShareRepository {
+	return &ShareRepository{db: db}
+}
+
+// CreateShare stores a new share.
+func (r *ShareRepository) CreateShare(share *Share) error {
+	query := `
+	INSERT INTO shares (token, message_id, owner_id, title, max_views, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := r.db.Exec(query, share.Token, share.MessageID, share.OwnerID, share.Title, share.MaxViews, share.ExpiresAt)
+	return err
+}
+
+// GetShare retrieves a share by its token.
+func (r *ShareRepository) GetShare(token string) (*Share, error) {
+	query := `SELECT token, message_id, owner_id, title, max_views, views, expires_at, revoked, created_at FROM shares WHERE token = ?`
+	row := r.db.QueryRow(query, token)
+
+	var share Share
+	if err := row.Scan(&share.Token, &share.MessageID, &share.OwnerID, &share.Title, &share.MaxViews, &share.Views, &share.ExpiresAt, &share.Revoked, &share.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+// RecordView increments the view counter of a share if it is still active, and reports whether it was.
+func (r *ShareRepository) RecordView(token string) (bool, error) {
+	query := `
+	UPDATE shares SET views = views + 1
+	WHERE token = ? AND revoked = FALSE
+	AND (max_views = 0 OR views < max_views)
+	AND (expires_at IS NULL OR expires_at > ?)`
+
+	res, err := r.db.Exec(query, token, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// RevokeShare revokes a share owned by the given user.
+func (r *ShareRepository) RevokeShare(token string, ownerID int64) (bool, error) {
+	query := `UPDATE shares SET revoked = TRUE WHERE token = ? AND owner_id = ?`
+	res, err := r.db.Exec(query, token, ownerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke share %s: %w", token, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
@@ -0,0 +1,158 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StatsDayLayout is the layout of the day keys used by the daily statistics tables.
+const StatsDayLayout = "2006-01-02"
+
+// DailyStats holds the aggregated usage of a single UTC day.
+type DailyStats struct {
+	Day         string `json:"day"`
+	Streams     int64  `json:"streams"`
+	Bytes       int64  `json:"bytes"`
+	UniqueUsers int64  `json:"uniqueUsers"`
+	CacheHits   int64  `json:"cacheHits"`
+	CacheMisses int64  `json:"cacheMisses"`
+	RateLimited int64  `json:"rateLimited"`
+}
+
+// FileStats holds the aggregated usage of a single file over a period.
+type FileStats struct {
+	MessageID int    `json:"messageId"`
+	FileName  string `json:"fileName"`
+	Streams   int64  `json:"streams"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type StatsRepository struct {
+	db *DB
+}
+
+// NewStatsRepository creates a new instance of StatsRepository.
+func NewStatsRepository(db *DB) *StatsRepository {
+	return &StatsRepository{db: db}
+}
+
+// StatsDay returns the day key for the given time.
+func StatsDay(t time.Time) string {
+	return t.UTC().Format(StatsDayLayout)
+}
+
+// RecordStream adds the bytes served for a file to the day's totals. newStream is set
+// when the request starts playback from the beginning, so seeks are not counted as streams.
+func (r *StatsRepository) RecordStream(day string, messageID int, fileName string, bytes int64, newStream bool) error {
+	streams := 0
+	if newStream {
+		streams = 1
+	}
+
+	_, err := r.db.Exec(`
+	INSERT INTO daily_stats (day, streams, bytes) VALUES (?, ?, ?)
+	ON CONFLICT(day) DO UPDATE SET
+	streams=daily_stats.streams+excluded.streams,
+	bytes=daily_stats.bytes+excluded.bytes;`, day, streams, bytes)
+	if err != nil {
+		return fmt.Errorf("failed to record daily stream stats: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+	INSERT INTO daily_files (day, message_id, file_name, streams, bytes) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(day, message_id) DO UPDATE SET
+	file_name=excluded.file_name,
+	streams=daily_files.streams+excluded.streams,
+	bytes=daily_files.bytes+excluded.bytes;`, day, messageID, fileName, streams, bytes)
+	if err != nil {
+		return fmt.Errorf("failed to record daily file stats: %w", err)
+	}
+	return nil
+}
+
+// RecordUser marks the user as active on the given day.
+func (r *StatsRepository) RecordUser(day string, userID int64) error {
+	_, err := r.db.Exec(`INSERT OR IGNORE INTO daily_users (day, user_id) VALUES (?, ?)`, day, userID)
+	return err
+}
+
+// AddCacheStats adds cache hits and misses to the day's totals.
+func (r *StatsRepository) AddCacheStats(day string, hits, misses int64) error {
+	_, err := r.db.Exec(`
+	INSERT INTO daily_stats (day, cache_hits, cache_misses) VALUES (?, ?, ?)
+	ON CONFLICT(day) DO UPDATE SET
+	cache_hits=daily_stats.cache_hits+excluded.cache_hits,
+	cache_misses=daily_stats.cache_misses+excluded.cache_misses;`, day, hits, misses)
+	return err
+}
+
+// AddRateLimited adds requests rejected by the rate limiters to the totals of a day.
+func (r *StatsRepository) AddRateLimited(day string, count int64) error {
+	_, err := r.db.Exec(`
+	INSERT INTO daily_stats (day, rate_limited) VALUES (?, ?)
+	ON CONFLICT(day) DO UPDATE SET
+	rate_limited=daily_stats.rate_limited+excluded.rate_limited;`, day, count)
+	return err
+}
+
+// GetDailyStats returns the aggregates of the days between from and to inclusive, oldest first.
+// Days without any activity are omitted.
+func (r *StatsRepository) GetDailyStats(from, to string) ([]DailyStats, error) {
+	query := `
+	SELECT d.day, d.streams, d.bytes, d.cache_hits, d.cache_misses, d.rate_limited,
+		(SELECT COUNT(*) FROM daily_users u WHERE u.day = d.day)
+	FROM daily_stats d
+	WHERE d.day BETWEEN ? AND ?
+	ORDER BY d.day`
+	rows, err := r.db.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DailyStats
+	for rows.Next() {
+		var s DailyStats
+		if err := rows.Scan(&s.Day, &s.Streams, &s.Bytes, &s.CacheHits, &s.CacheMisses, &s.RateLimited, &s.UniqueUsers); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// CountUniqueUsers returns the number of distinct users active between from and to inclusive.
+func (r *StatsRepository) CountUniqueUsers(from, to string) (int64, error) {
+	var count int64
+	err := r.db.QueryRow(`SELECT COUNT(DISTINCT user_id) FROM daily_users WHERE day BETWEEN ? AND ?`, from, to).Scan(&count)
+	return count, err
+}
+
+// GetTopFiles returns the most streamed files between from and to inclusive.
+func (r *StatsRepository) GetTopFiles(from, to string, limit int) ([]FileStats, error) {
+	query := `
+	SELECT message_id, MAX(file_name), SUM(streams), SUM(bytes)
+	FROM daily_files
+	WHERE day BETWEEN ? AND ?
+	GROUP BY message_id
+	ORDER BY SUM(streams) DESC, SUM(bytes) DESC
+	LIMIT ?`
+	rows, err := r.db.Query(query, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileStats
+	for rows.Next() {
+		var f FileStats
+		var fileName sql.NullString
+		if err := rows.Scan(&f.MessageID, &fileName, &f.Streams, &f.Bytes); err != nil {
+			return nil, err
+		}
+		f.FileName = fileName.String
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// StorageRepository keeps the posts of a storage channel that carry a copy of a document. Worker
+// bots cannot read the chats of the main bot, so documents they download are copied to a channel
+// they are members of, once per document.
+type StorageRepository struct {
+	db *DB
+}
+
+// NewStorageRepository creates a new instance of StorageRepository.
+func NewStorageRepository(db *DB) *StorageRepository {
+	return &StorageRepository{db: db}
+}
+
+// Copy returns the ID of the post of a channel that carries a document. ok is false if the
+// document was not copied to the channel.
+func (r *StorageRepository) Copy(channelID, documentID int64) (messageID int, ok bool, err error) {
+	err = r.db.QueryRow(`SELECT message_id FROM storage_copies WHERE channel_id = ? AND document_id = ?`, channelID, documentID).Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to look up copy of document %d: %w", documentID, err)
+	}
+	return messageID, true, nil
+}
+
+// RecordCopy stores the post of a channel that carries a document, replacing an earlier one.
+func (r *StorageRepository) RecordCopy(channelID, documentID int64, messageID int) error {
+	_, err := r.db.Exec(`
+	INSERT INTO storage_copies (channel_id, document_id, message_id) VALUES (?, ?, ?)
+	ON CONFLICT(channel_id, document_id) DO UPDATE SET
+	message_id=excluded.message_id;`, channelID, documentID, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to record copy of document %d: %w", documentID, err)
+	}
+	return nil
+}
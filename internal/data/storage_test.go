@@ -0,0 +1,32 @@
+package data
+
+import "testing"
+
+func TestStorageRepository(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewStorageRepository(db)
+
+	if _, ok, err := repo.Copy(-100, 1); err != nil || ok {
+		t.Fatalf("Copy of an unknown document = %v, %v; want not found", ok, err)
+	}
+	if err := repo.RecordCopy(-100, 1, 7); err != nil {
+		t.Fatalf("RecordCopy failed: %v", err)
+	}
+	if id, ok, err := repo.Copy(-100, 1); err != nil || !ok || id != 7 {
+		t.Errorf("Copy = %d, %v, %v; want 7", id, ok, err)
+	}
+	// Copies are kept per channel.
+	if _, ok, err := repo.Copy(-200, 1); err != nil || ok {
+		t.Errorf("Copy in another channel = %v, %v; want not found", ok, err)
+	}
+	// A new copy replaces one that was deleted from the channel.
+	if err := repo.RecordCopy(-100, 1, 9); err != nil {
+		t.Fatalf("RecordCopy failed: %v", err)
+	}
+	if id, _, _ := repo.Copy(-100, 1); id != 9 {
+		t.Errorf("Copy after a new copy = %d, want 9", id)
+	}
+}
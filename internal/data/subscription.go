@@ -0,0 +1,103 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// Subscription sends the new media posts of a channel to a user's player and history.
+type Subscription struct {
+	ChannelID    int64
+	UserID       int64
+	ChannelTitle string
+	// MimeTypes are the media types sent to the user, such as "video/*" or "audio/mpeg". Empty
+	// means all media.
+	MimeTypes []string
+	CreatedBy int64
+	CreatedAt time.Time
+}
+
+// Matches reports whether media of the given MIME type passes the subscription's filter. A type
+// without a subtype, such as "video", matches like "video/*".
+func (s *Subscription) Matches(mimeType string) bool {
+	if len(s.MimeTypes) == 0 {
+		return true
+	}
+	mimeType = strings.ToLower(mimeType)
+	for _, pattern := range s.MimeTypes {
+		pattern = strings.ToLower(pattern)
+		if !strings.Contains(pattern, "/") {
+			pattern += "/*"
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mimeType, prefix+"/") {
+				return true
+			}
+		} else if pattern == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+type SubscriptionRepository struct {
+	db *DB
+}
+
+// NewSubscriptionRepository creates a new instance of SubscriptionRepository.
+func NewSubscriptionRepository(db *DB) *SubscriptionRepository {
+	return &SubscriptionRepository{db: db}
+}
+
+// Subscribe stores a subscription, replacing the filter of an existing one.
+func (r *SubscriptionRepository) Subscribe(s *Subscription) error {
+	_, err := r.db.Exec(`
+	INSERT INTO channel_subscriptions (channel_id, user_id, channel_title, mime_types, created_by) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(channel_id, user_id) DO UPDATE SET
+	channel_title=excluded.channel_title,
+	mime_types=excluded.mime_types,
+	created_by=excluded.created_by;`, s.ChannelID, s.UserID, s.ChannelTitle, strings.Join(s.MimeTypes, ","), s.CreatedBy)
+	return err
+}
+
+// Unsubscribe removes a subscription and reports whether it existed.
+func (r *SubscriptionRepository) Unsubscribe(channelID, userID int64) (bool, error) {
+	result, err := r.db.Exec(`DELETE FROM channel_subscriptions WHERE channel_id = ? AND user_id = ?`, channelID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ListByChannel returns the subscriptions to a channel.
+func (r *SubscriptionRepository) ListByChannel(channelID int64) ([]Subscription, error) {
+	return r.list(`WHERE channel_id = ? ORDER BY user_id`, channelID)
+}
+
+// ListAll returns every subscription, grouped by channel.
+func (r *SubscriptionRepository) ListAll() ([]Subscription, error) {
+	return r.list(`ORDER BY channel_id, user_id`)
+}
+
+func (r *SubscriptionRepository) list(where string, args ...interface{}) ([]Subscription, error) {
+	rows, err := r.db.Query(`SELECT channel_id, user_id, channel_title, mime_types, created_by, created_at FROM channel_subscriptions `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var s Subscription
+		var mimeTypes string
+		if err := rows.Scan(&s.ChannelID, &s.UserID, &s.ChannelTitle, &mimeTypes, &s.CreatedBy, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		if mimeTypes != "" {
+			s.MimeTypes = strings.Split(mimeTypes, ",")
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
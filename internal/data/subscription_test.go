@@ -0,0 +1,61 @@
+package data
+
+import "testing"
+
+func TestSubscriptionRepository(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewSubscriptionRepository(db)
+
+	for _, s := range []*Subscription{
+		{ChannelID: 100, UserID: 1, ChannelTitle: "News", CreatedBy: 1},
+		{ChannelID: 100, UserID: 2, ChannelTitle: "News", MimeTypes: []string{"audio/mpeg"}, CreatedBy: 1},
+		{ChannelID: 200, UserID: 1, ChannelTitle: "Music", CreatedBy: 1},
+	} {
+		if err := repo.Subscribe(s); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+	}
+	// Subscribing again replaces the filter.
+	if err := repo.Subscribe(&Subscription{ChannelID: 100, UserID: 2, ChannelTitle: "News", MimeTypes: []string{"video/*", "audio"}, CreatedBy: 1}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	subscriptions, err := repo.ListByChannel(100)
+	if err != nil || len(subscriptions) != 2 {
+		t.Fatalf("ListByChannel = %v, %v; want 2 subscriptions", subscriptions, err)
+	}
+	if got := subscriptions[1].MimeTypes; len(got) != 2 || got[0] != "video/*" || got[1] != "audio" {
+		t.Errorf("Unexpected filter %v", got)
+	}
+	if all, err := repo.ListAll(); err != nil || len(all) != 3 {
+		t.Errorf("ListAll = %v, %v; want 3 subscriptions", all, err)
+	}
+
+	if removed, err := repo.Unsubscribe(100, 2); err != nil || !removed {
+		t.Errorf("Unsubscribe = %v, %v; want true", removed, err)
+	}
+	if removed, err := repo.Unsubscribe(100, 2); err != nil || removed {
+		t.Errorf("Second Unsubscribe = %v, %v; want false", removed, err)
+	}
+}
+
+func TestSubscription_Matches(t *testing.T) {
+	s := Subscription{MimeTypes: []string{"video/*", "audio/mpeg", "image"}}
+	for mimeType, want := range map[string]bool{
+		"video/mp4":       true,
+		"audio/mpeg":      true,
+		"audio/ogg":       false,
+		"image/jpeg":      true,
+		"application/pdf": false,
+	} {
+		if got := s.Matches(mimeType); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", mimeType, got, want)
+		}
+	}
+	if !(&Subscription{}).Matches("application/pdf") {
+		t.Error("Expected a subscription without a filter to match all media")
+	}
+}
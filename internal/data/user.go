@@ -13,40 +13,20 @@ type User struct {
 	Username     string
 	IsAuthorized bool
 	IsAdmin      bool
-	CreatedAt    string
+	// Language chosen with /language, empty to follow the Telegram app.
+	Language  string
+	CreatedAt string
 }
 
 type UserRepository struct {
-	db *sql.DB
+	db *DB
 }
 
 // NewUserRepository creates a new instance of UserRepository.
-func NewUserRepository(db *sql.DB) *UserRepository {
+func NewUserRepository(db *DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-// InitDB initializes the database by creating necessary tables.
-func (r *UserRepository) InitDB() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		user_id INTEGER PRIMARY KEY,
-		chat_id INTEGER NOT NULL,
-		first_name TEXT,
-		last_name TEXT,
-		username TEXT,
-		is_authorized BOOLEAN DEFAULT FALSE,
-		is_admin BOOLEAN DEFAULT FALSE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	_, err := r.db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
-	}
-
-	return nil
-}
-
 // StoreUserInfo stores or updates user information in the database.
 func (r *UserRepository) StoreUserInfo(userID, chatID int64, firstName, lastName, username string, isAuthorized, isAdmin bool) error {
 	query := `
@@ -67,11 +47,11 @@ func (r *UserRepository) StoreUserInfo(userID, chatID int64, firstName, lastName
 
 // GetUserInfo retrieves user information from the database by user ID.
 func (r *UserRepository) GetUserInfo(userID int64) (*User, error) {
-	query := `SELECT user_id, chat_id, first_name, last_name, username, is_authorized, is_admin, created_at FROM users WHERE user_id = ?`
+	query := `SELECT user_id, chat_id, first_name, last_name, username, is_authorized, is_admin, language, created_at FROM users WHERE user_id = ?`
 	row := r.db.QueryRow(query, userID)
 
 	var user User
-	if err := row.Scan(&user.UserID, &user.ChatID, &user.FirstName, &user.LastName, &user.Username, &user.IsAuthorized, &user.IsAdmin, &user.CreatedAt); err != nil {
+	if err := row.Scan(&user.UserID, &user.ChatID, &user.FirstName, &user.LastName, &user.Username, &user.IsAuthorized, &user.IsAdmin, &user.Language, &user.CreatedAt); err != nil {
 		return nil, err
 	}
 
@@ -105,9 +85,70 @@ func (r *UserRepository) DeauthorizeUser(userID int64) error {
 	return nil
 }
 
+// SetUserLanguage stores the language of a user. An empty language follows the Telegram app again.
+func (r *UserRepository) SetUserLanguage(userID int64, language string) error {
+	_, err := r.db.Exec(`UPDATE users SET language = ? WHERE user_id = ?`, language, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set language of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// ListUsers returns all users, the earliest first.
+func (r *UserRepository) ListUsers() ([]User, error) {
+	rows, err := r.db.Query(`SELECT user_id, chat_id, first_name, last_name, username, is_authorized, is_admin, language, created_at FROM users ORDER BY created_at, user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		var firstName, lastName, username sql.NullString
+		if err := rows.Scan(&user.UserID, &user.ChatID, &firstName, &lastName, &username, &user.IsAuthorized, &user.IsAdmin, &user.Language, &user.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		user.FirstName, user.LastName, user.Username = firstName.String, lastName.String, username.String
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUser removes a user together with their settings, quota, usage, queue, media history and
+// shares. The daily statistics keep counting them.
+func (r *UserRepository) DeleteUser(userID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to delete user %d: %w", userID, err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM user_settings WHERE user_id = ?`,
+		`DELETE FROM user_quotas WHERE user_id = ?`,
+		`DELETE FROM user_usage WHERE user_id = ?`,
+		`DELETE FROM user_file_limits WHERE user_id = ?`,
+		`DELETE FROM channel_subscriptions WHERE user_id = ?`,
+		`DELETE FROM scheduled_jobs WHERE user_id = ?`,
+		`DELETE FROM playlists WHERE user_id = ?`,
+		`DELETE FROM media WHERE user_id = ?`,
+		`DELETE FROM shares WHERE owner_id = ?`,
+		`DELETE FROM users WHERE user_id = ?`,
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to delete user %d: %w", userID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete user %d: %w", userID, err)
+	}
+	return nil
+}
+
 // GetAllAdmins retrieves a list of all admin users.
 func (r *UserRepository) GetAllAdmins() ([]User, error) {
-	query := `SELECT user_id, chat_id, first_name, last_name, username FROM users WHERE is_admin = TRUE`
+	query := `SELECT user_id, chat_id, first_name, last_name, username, language FROM users WHERE is_admin = TRUE`
 	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -117,7 +158,7 @@ func (r *UserRepository) GetAllAdmins() ([]User, error) {
 	var admins []User
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.UserID, &user.ChatID, &user.FirstName, &user.LastName, &user.Username); err != nil {
+		if err := rows.Scan(&user.UserID, &user.ChatID, &user.FirstName, &user.LastName, &user.Username, &user.Language); err != nil {
 			return nil, err
 		}
 		admins = append(admins, user)
@@ -0,0 +1,83 @@
+package data
+
+import "testing"
+
+func TestUserRepository_ListAndDelete(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewUserRepository(db)
+
+	if err := repo.StoreUserInfo(1, 1, "Ada", "", "ada", true, true); err != nil {
+		t.Fatalf("StoreUserInfo failed: %v", err)
+	}
+	if err := repo.StoreUserInfo(2, 2, "Bob", "", "", false, false); err != nil {
+		t.Fatalf("StoreUserInfo failed: %v", err)
+	}
+	if err := repo.StoreUserSettings(&UserSettings{UserID: 2, Theme: "light", Density: "compact"}); err != nil {
+		t.Fatalf("StoreUserSettings failed: %v", err)
+	}
+
+	users, err := repo.ListUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("ListUsers = %v, %v; want 2 users", users, err)
+	}
+	if users[0].UserID != 1 || !users[0].IsAdmin || users[1].IsAuthorized {
+		t.Errorf("Unexpected users %+v", users)
+	}
+
+	if err := repo.DeleteUser(2); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if _, err := repo.GetUserInfo(2); err == nil {
+		t.Errorf("Expected the deleted user to be gone")
+	}
+	if settings, err := repo.GetUserSettings(2); err != nil || settings.Theme != DefaultUserSettings(2).Theme {
+		t.Errorf("Expected the settings of the deleted user to be gone, got %+v, %v", settings, err)
+	}
+}
+
+func TestUserSettings_MediaTypes(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := NewMigrator(db).Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	repo := NewUserRepository(db)
+
+	settings := DefaultUserSettings(1)
+	settings.ToggleMediaType(MediaTypePhoto)
+	settings.ToggleMediaType(MediaTypeVoice)
+	settings.ToggleMediaType(MediaTypeVoice)
+	if err := repo.StoreUserSettings(settings); err != nil {
+		t.Fatalf("StoreUserSettings failed: %v", err)
+	}
+	stored, err := repo.GetUserSettings(1)
+	if err != nil {
+		t.Fatalf("GetUserSettings failed: %v", err)
+	}
+	if stored.Bridges(MediaTypePhoto) || !stored.Bridges(MediaTypeVoice) || !stored.Bridges(MediaTypeVideo) {
+		t.Errorf("Unexpected blocked media types %v", stored.BlockedMediaTypes)
+	}
+
+	settings.BlockedMediaTypes = []string{"sticker"}
+	if err := repo.StoreUserSettings(settings); err == nil {
+		t.Errorf("Expected an unknown media type to be rejected")
+	}
+
+	for _, c := range []struct {
+		mimeType string
+		voice    bool
+		want     string
+	}{
+		{"video/mp4", false, MediaTypeVideo},
+		{"audio/mpeg", false, MediaTypeAudio},
+		{"audio/ogg", true, MediaTypeVoice},
+		{"image/jpeg", false, MediaTypePhoto},
+		{"application/pdf", false, MediaTypeDocument},
+	} {
+		if got := MediaTypeOf(c.mimeType, c.voice); got != c.want {
+			t.Errorf("MediaTypeOf(%q, %v) = %q, want %q", c.mimeType, c.voice, got, c.want)
+		}
+	}
+}
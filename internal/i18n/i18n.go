@@ -0,0 +1,132 @@
+// Package i18n translates the messages of the bot and the web player. The catalogs are JSON files
+// in locales, one per language, that map message keys to fmt format strings. Missing messages fall
+// back to English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultLanguage is used for users without a supported language and for missing messages.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Languages written from right to left.
+var rtlLanguages = map[string]bool{"fa": true}
+
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: failed to list catalogs: %v", err))
+	}
+	loaded := make(map[string]map[string]string)
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: failed to read %s: %v", entry.Name(), err))
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", entry.Name(), err))
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	if _, ok := loaded[DefaultLanguage]; !ok {
+		panic("i18n: missing the catalog of the default language")
+	}
+	return loaded
+}
+
+// Languages returns the codes of the supported languages, sorted.
+func Languages() []string {
+	codes := make([]string, 0, len(catalogs))
+	for code := range catalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Supported reports whether there is a catalog for a language code.
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Match returns the supported language of a code such as "de" or "de-AT", or "" if there is none.
+func Match(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if Supported(code) {
+		return code
+	}
+	return ""
+}
+
+// MatchAcceptLanguage returns the first supported language of an Accept-Language header, or "" if
+// there is none. Quality values are ignored, as browsers list languages in order of preference.
+func MatchAcceptLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			part = part[:i]
+		}
+		if lang := Match(part); lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Name returns the name of a language in that language.
+func Name(lang string) string {
+	return T(lang, "language.name")
+}
+
+// Direction returns the text direction of a language for the dir attribute of HTML.
+func Direction(lang string) string {
+	if rtlLanguages[lang] {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// T formats the message of a key in a language. Messages missing in the language are taken from
+// the default language, and unknown keys are returned as they are.
+func T(lang, key string, args ...interface{}) string {
+	format, ok := catalogs[lang][key]
+	if !ok {
+		format, ok = catalogs[DefaultLanguage][key]
+		if !ok {
+			return key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Messages returns the unformatted messages of a language whose keys start with prefix, with the
+// prefix removed. The web player formats them itself.
+func Messages(lang, prefix string) map[string]string {
+	messages := make(map[string]string)
+	for _, code := range []string{DefaultLanguage, lang} {
+		for key, format := range catalogs[code] {
+			if strings.HasPrefix(key, prefix) {
+				messages[strings.TrimPrefix(key, prefix)] = format
+			}
+		}
+	}
+	return messages
+}
@@ -0,0 +1,74 @@
+package i18n
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+)
+
+var verbPattern = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+
+// Every catalog must translate every message with the same format verbs, in the same order.
+func TestCatalogsComplete(t *testing.T) {
+	for _, lang := range Languages() {
+		for key, format := range catalogs[DefaultLanguage] {
+			translated, ok := catalogs[lang][key]
+			if !ok {
+				t.Errorf("%s: missing %q", lang, key)
+				continue
+			}
+			want := verbPattern.FindAllString(format, -1)
+			got := verbPattern.FindAllString(translated, -1)
+			if len(want) != len(got) {
+				t.Errorf("%s: %q has verbs %v, want %v", lang, key, got, want)
+				continue
+			}
+			for i := range want {
+				if want[i] != got[i] {
+					t.Errorf("%s: %q has verbs %v, want %v", lang, key, got, want)
+					break
+				}
+			}
+		}
+		for key := range catalogs[lang] {
+			if _, ok := catalogs[DefaultLanguage][key]; !ok {
+				t.Errorf("%s: %q is not in the default catalog", lang, key)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := map[string]string{
+		"de":    "de",
+		"de-AT": "de",
+		"RU_ru": "ru",
+		"xx":    "",
+		"":      "",
+	}
+	for code, want := range tests {
+		if got := Match(code); got != want {
+			t.Errorf("Match(%q) = %q, want %q", code, got, want)
+		}
+	}
+	if got := MatchAcceptLanguage("xx-YY, fa;q=0.9, en;q=0.8"); got != "fa" {
+		t.Errorf("MatchAcceptLanguage = %q, want fa", got)
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := T("de", "media.sentToPlayer", "a.mp4"); got == T("en", "media.sentToPlayer", "a.mp4") {
+		t.Errorf("Expected a German message, got %q", got)
+	}
+	if got := T("xx", "media.sentToPlayer", "a.mp4"); got != "The a.mp4 file has been sent to the web player." {
+		t.Errorf("Expected the English fallback, got %q", got)
+	}
+	if got := T("en", "no.such.key"); got != "no.such.key" {
+		t.Errorf("Expected the key of an unknown message, got %q", got)
+	}
+
+	languages := Languages()
+	if !sort.StringsAreSorted(languages) || len(languages) < 4 {
+		t.Errorf("Unexpected languages %v", languages)
+	}
+}
@@ -0,0 +1,29 @@
+package logger
+
+import (
+	"context"
+	"log"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of the HTTP request it belongs to.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns l with the request ID of ctx as a field, so lines written while serving
+// a request can be found by the ID in its access log. Without a request ID it returns l.
+func FromContext(ctx context.Context, l *log.Logger) *log.Logger {
+	id := RequestID(ctx)
+	if id == "" {
+		return l
+	}
+	return WithFields(l, Fields{"request_id": id})
+}
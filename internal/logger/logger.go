@@ -0,0 +1,204 @@
+// Package logger builds the *log.Logger instances used throughout the bot. In JSON mode every
+// line is written as one JSON object with structured fields, ready to be shipped to Loki or ELK.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+
+	textPrefix = "webBridgeBot: "
+	textFlags  = log.Ldate | log.Ltime | log.Lshortfile
+)
+
+// Fields are structured values attached to every line of a logger.
+type Fields map[string]interface{}
+
+var (
+	callerPattern     = regexp.MustCompile(`^([\w.-]+\.go:\d+): `)
+	chatIDPattern     = regexp.MustCompile(`(?i)\bchat(?: ID)? (-?\d+)`)
+	messageIDPattern  = regexp.MustCompile(`(?i)\bmessage ID (\d+)`)
+	errorPattern      = regexp.MustCompile(`(?i)\b(error|failed|failure|panic)\b`)
+	warningPattern    = regexp.MustCompile(`(?i)\b(warning|retrying|unknown|invalid)\b`)
+	levelFieldPattern = regexp.MustCompile(`\blevel=(\w+) `)
+)
+
+// minLevel is the rank of the least severe level written by every logger.
+var minLevel atomic.Int32
+
+var levelRanks = map[string]int32{LevelInfo: 0, LevelWarn: 1, LevelError: 2}
+
+// SetLevel makes every logger drop lines less severe than level: info, warn or error. It may be
+// called at any time, for example when the configuration is reloaded.
+func SetLevel(level string) error {
+	if err := ValidateLevel(level); err != nil {
+		return err
+	}
+	minLevel.Store(levelRanks[level])
+	return nil
+}
+
+// ValidateLevel reports whether level can be passed to SetLevel.
+func ValidateLevel(level string) error {
+	if _, ok := levelRanks[level]; !ok {
+		return fmt.Errorf("unknown log level %q, expected %q, %q or %q", level, LevelInfo, LevelWarn, LevelError)
+	}
+	return nil
+}
+
+func enabled(level string) bool {
+	return levelRanks[level] >= minLevel.Load()
+}
+
+// New creates a logger writing to out in the given format. Unknown formats fall back to text.
+func New(out io.Writer, format string) *log.Logger {
+	if format == FormatJSON {
+		return log.New(&jsonWriter{mu: &sync.Mutex{}, out: out}, "", log.Lshortfile)
+	}
+	return log.New(&textWriter{out: out}, textPrefix, textFlags)
+}
+
+// textWriter drops the lines of a text logger below the level set by SetLevel.
+type textWriter struct {
+	out io.Writer
+}
+
+func (w *textWriter) Write(p []byte) (int, error) {
+	level := levelOf(string(p))
+	// A level given as a field, like that of access logs, wins over the guess.
+	if match := levelFieldPattern.FindSubmatch(p); match != nil {
+		level = string(match[1])
+	}
+	if !enabled(level) {
+		return len(p), nil
+	}
+	return w.out.Write(p)
+}
+
+// SetDefault routes the standard library's default logger through l, so packages that still
+// call log.Printf directly use the same output and format.
+func SetDefault(l *log.Logger) {
+	log.SetOutput(l.Writer())
+	log.SetPrefix(l.Prefix())
+	log.SetFlags(l.Flags())
+}
+
+// WithFields returns a logger that adds fields to every line of l. JSON loggers emit them as
+// properties; text loggers write them as key=value pairs after the prefix.
+func WithFields(l *log.Logger, fields Fields) *log.Logger {
+	if w, ok := l.Writer().(*jsonWriter); ok {
+		return log.New(w.with(fields), l.Prefix(), l.Flags())
+	}
+	prefix := l.Prefix()
+	for k := range fields {
+		if strings.Contains(prefix, k+"=") {
+			prefix = regexp.MustCompile(regexp.QuoteMeta(k)+`=\S* `).ReplaceAllString(prefix, "")
+		}
+	}
+	return log.New(l.Writer(), prefix+formatFields(fields), l.Flags())
+}
+
+// jsonWriter turns each line written by a log.Logger into a JSON object.
+type jsonWriter struct {
+	mu     *sync.Mutex // Shared by derived writers, so lines are never interleaved.
+	out    io.Writer
+	fields Fields
+}
+
+func (w *jsonWriter) with(fields Fields) *jsonWriter {
+	merged := make(Fields, len(w.fields)+len(fields))
+	for k, v := range w.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &jsonWriter{mu: w.mu, out: w.out, fields: merged}
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+
+	entry := make(map[string]interface{}, len(w.fields)+6)
+	for k, v := range w.fields {
+		entry[k] = v
+	}
+	entry["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	if match := callerPattern.FindStringSubmatch(msg); match != nil {
+		entry["caller"] = match[1]
+		msg = msg[len(match[0]):]
+	}
+	entry["msg"] = msg
+	// A level given as a field, like that of access logs, wins over the guess.
+	if _, ok := entry["level"]; !ok {
+		entry["level"] = levelOf(msg)
+	}
+	if level, _ := entry["level"].(string); !enabled(level) {
+		return len(p), nil
+	}
+
+	// Most messages name the chat and message they are about; expose them as fields.
+	if _, ok := entry["chat_id"]; !ok {
+		if match := chatIDPattern.FindStringSubmatch(msg); match != nil {
+			entry["chat_id"], _ = strconv.ParseInt(match[1], 10, 64)
+		}
+	}
+	if _, ok := entry["message_id"]; !ok {
+		if match := messageIDPattern.FindStringSubmatch(msg); match != nil {
+			entry["message_id"], _ = strconv.Atoi(match[1])
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// levelOf guesses the level of a message, since log.Logger has no levels.
+func levelOf(msg string) string {
+	switch {
+	case errorPattern.MatchString(msg):
+		return "error"
+	case warningPattern.MatchString(msg):
+		return "warn"
+	}
+	return "info"
+}
+
+func formatFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%s=%v ", k, fields[k])
+	}
+	return sb.String()
+}
@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	l := WithFields(New(&out, FormatJSON), Fields{"module": "bot"})
+	l = WithFields(l, Fields{"module": "reader"})
+	l.Printf("Error fetching file for message ID %d in chat ID %d: %v", 42, 7, "timeout")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("output is not JSON: %v: %s", err, out.String())
+	}
+	want := map[string]interface{}{
+		"module":     "reader",
+		"level":      "error",
+		"message_id": float64(42),
+		"chat_id":    float64(7),
+		"msg":        "Error fetching file for message ID 42 in chat ID 7: timeout",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+	if entry["ts"] == nil || !strings.HasPrefix(entry["caller"].(string), "logger_test.go:") {
+		t.Errorf("missing ts or caller: %v", entry)
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	var out bytes.Buffer
+	l := WithFields(WithFields(New(&out, FormatText), Fields{"module": "bot"}), Fields{"module": "reader", "message_id": 5})
+	l.Print("Serving chunk")
+
+	line := out.String()
+	if !strings.HasPrefix(line, "webBridgeBot: message_id=5 module=reader ") || strings.Contains(line, "module=bot") {
+		t.Errorf("unexpected line %q", line)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	if err := SetLevel("debug"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if err := SetLevel(LevelWarn); err != nil {
+		t.Fatal(err)
+	}
+	defer SetLevel(LevelInfo)
+
+	for _, format := range []string{FormatText, FormatJSON} {
+		var out bytes.Buffer
+		l := New(&out, format)
+		l.Print("Serving chunk")
+		WithFields(l, Fields{"level": "warn"}).Print("GET /stream 404")
+		l.Print("Failed to fetch chunk")
+		if lines := strings.Count(out.String(), "\n"); lines != 2 || strings.Contains(out.String(), "Serving chunk") {
+			t.Errorf("%s output at warn level = %q, want only the warning and the error", format, out.String())
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.log")
+	f, err := OpenRotatingFile(path, 10, 2, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	// Every line exceeds the limit together with the previous one, and only two backups are kept.
+	for name, want := range map[string]string{"bot.log": "fourth\n", "bot.log.1": "third\n", "bot.log.2": "second\n"} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("a third backup was kept")
+	}
+}
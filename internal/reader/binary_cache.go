@@ -1,14 +1,22 @@
 package reader
 
 import (
+	"bytes"
 	"container/heap"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type chunkMetadata struct {
@@ -17,8 +25,14 @@ type chunkMetadata struct {
 	Offset     int64
 	Size       int64 // Actual size of the data in this chunk, not the padded size
 	Timestamp  int64
+	Checksum   uint32 // CRC-32 of the stored data, without padding
+	Codec      uint8  // How the chunk the part belongs to is compressed
 }
 
+// ErrChunkCorrupted is returned when cached data no longer matches its checksum. The chunk is
+// dropped from the cache, so it is downloaded again.
+var ErrChunkCorrupted = errors.New("cached chunk is corrupted")
+
 // Helper methods for converting the `Timestamp` to/from `time.Time`
 func (meta *chunkMetadata) SetTimestamp(t time.Time) {
 	meta.Timestamp = t.Unix()
@@ -29,16 +43,38 @@ func (meta *chunkMetadata) GetTimestamp() time.Time {
 }
 
 type BinaryCache struct {
-	cashFile       *os.File
-	metadataFile   *os.File
-	metadata       map[int64]map[int64][]chunkMetadata // Map of location ID to chunk ID to metadata
-	metadataLock   sync.Mutex
-	chunkLock      sync.Mutex
+	dir          string
+	layout       string
+	segments     map[int64]*segment // Files holding the chunks, by segment ID
+	metadataFile *os.File
+	metadataPath string
+	metadata     map[int64]map[int64][]chunkMetadata // Map of location ID to chunk ID to metadata
+	metadataLock sync.Mutex
+	// chunkLock guards the metadata, the segments, the LRU queue and the counters. It is not
+	// held while chunks are read from or written to the cache files, so streams of different
+	// files only wait for each other to update the bookkeeping.
+	chunkLock sync.Mutex
+	// ioLock is held shared while chunks are read or written without chunkLock, and exclusively
+	// by operations that move parts around in the cache files, such as Compact.
+	ioLock         sync.RWMutex
 	cacheSize      int64
 	maxCacheSize   int64
-	lruQueue       *PriorityQueue
-	evictionList   []*chunkMetadata
+	lruQueues      [2]*PriorityQueue // LRU queues of the cold and hot tiers
+	lruItems       map[chunkKey]*LRUItem
+	tierSize       [2]int64 // Bytes taken by the chunks of each tier
+	hotShare       float64  // Share of maxCacheSize kept for the hot tier
 	fixedChunkSize int64
+	hits           int64
+	misses         int64
+	evictions      int64
+	corrupted      int64
+	logger         *log.Logger
+	encoder        *zstd.Encoder // Nil unless new chunks are compressed
+	partBuffers    sync.Pool     // Buffers of fixedChunkSize bytes for padding parts
+
+	// Consecutive failed metadata saves, reported to onSaveError.
+	saveFailures int64
+	onSaveError  func(err error, failures int)
 }
 
 // LRUItem represents an item in the LRU cache with its priority.
@@ -46,7 +82,9 @@ type LRUItem struct {
 	locationID int64
 	chunkID    int64
 	timestamp  int64
-	index      int // The index of the item in the heap.
+	tier       tier
+	size       int64 // Bytes taken by the parts of the chunk
+	index      int   // The index of the item in the heap.
 }
 
 // PriorityQueue implements a min-heap for LRU eviction.
@@ -86,78 +124,194 @@ func (pq *PriorityQueue) update(item *LRUItem, timestamp int64) {
 	heap.Fix(pq, item.index)
 }
 
-// NewBinaryCache initializes a new binary cache
-func NewBinaryCache(cacheDir string, maxCacheSize int64, fixedChunkSize int64) (*BinaryCache, error) {
-	// Create the cache directory if it doesn't exist
-	err := os.MkdirAll(cacheDir, 0755)
-	if err != nil {
+// NewBinaryCache initializes a new binary cache in the file layout. A nil logger uses the
+// standard logger.
+func NewBinaryCache(cacheDir string, maxCacheSize int64, fixedChunkSize int64, logger *log.Logger) (*BinaryCache, error) {
+	return NewBinaryCacheWithLayout(cacheDir, maxCacheSize, fixedChunkSize, LayoutFile, logger)
+}
+
+// NewBinaryCacheWithLayout initializes a new binary cache that stores its chunks in the given
+// layout. A cache written in another layout has to be migrated first.
+func NewBinaryCacheWithLayout(cacheDir string, maxCacheSize int64, fixedChunkSize int64, layout string, logger *log.Logger) (*BinaryCache, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+	if err := ValidateLayout(layout); err != nil {
 		return nil, err
 	}
 
-	// Define the file paths for cache and metadata
-	cacheFilename := filepath.Join(cacheDir, "cache.dat")
-	metadataFilename := filepath.Join(cacheDir, "metadata.dat")
-
-	// Open or create the cache file
-	file, err := os.OpenFile(cacheFilename, os.O_CREATE|os.O_RDWR, 0644)
+	// Create the cache directory if it doesn't exist
+	err := os.MkdirAll(cacheDir, 0755)
 	if err != nil {
 		return nil, err
 	}
 
 	// Open or create the metadata file
+	metadataFilename := filepath.Join(cacheDir, "metadata.dat")
 	metadataFile, err := os.OpenFile(metadataFilename, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
-		file.Close()
 		return nil, err
 	}
 
 	// Initialize the BinaryCache struct
 	bc := &BinaryCache{
-		cashFile:       file,
+		dir:            cacheDir,
+		layout:         layout,
+		segments:       make(map[int64]*segment),
 		metadataFile:   metadataFile,
+		metadataPath:   metadataFilename,
 		metadata:       make(map[int64]map[int64][]chunkMetadata),
 		maxCacheSize:   maxCacheSize,
-		lruQueue:       &PriorityQueue{},
+		lruQueues:      [2]*PriorityQueue{{}, {}},
+		lruItems:       make(map[chunkKey]*LRUItem),
 		fixedChunkSize: fixedChunkSize,
+		logger:         logger,
+	}
+
+	// The file layout keeps cache.dat open
+	if layout == LayoutFile {
+		file, err := os.OpenFile(bc.segmentPath(0), os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			metadataFile.Close()
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			metadataFile.Close()
+			return nil, err
+		}
+		seg := bc.segment(0)
+		seg.file = file
+		seg.end = info.Size()
 	}
 
 	// Load metadata from the metadata file if it exists
 	err = bc.loadMetadata()
+	if err == nil {
+		err = bc.loadSegments()
+	}
 	if err != nil {
+		bc.Close()
 		return nil, err
 	}
 
-	// Initialize the priority queue (LRU queue)
-	heap.Init(bc.lruQueue)
-
 	return bc, nil
 }
 
-// Write a chunk to the binary cashFile
+// Write a chunk to the binary cache
 func (bc *BinaryCache) writeChunk(locationID int64, chunkID int64, chunk []byte) error {
-	bc.chunkLock.Lock()
-	defer bc.chunkLock.Unlock()
+	err := bc.storeChunk(locationID, chunkID, chunk, time.Now().Unix())
+	if err != nil {
+		return err
+	}
 
-	if _, exists := bc.metadata[locationID]; !exists {
-		bc.metadata[locationID] = make(map[int64][]chunkMetadata)
+	// Save the metadata to the metadata file
+	if err := bc.saveMetadata(); err != nil {
+		failures := atomic.AddInt64(&bc.saveFailures, 1)
+		bc.logger.Printf("Failed to save cache metadata (%d consecutive failures): %v", failures, err)
+		if bc.onSaveError != nil {
+			bc.onSaveError(err, int(failures))
+		}
+		return fmt.Errorf("failed to save cache metadata: %w", err)
 	}
+	atomic.StoreInt64(&bc.saveFailures, 0)
+	return nil
+}
 
-	// Evict if cache size exceeds max size before writing new data
-	bc.evictIfNeeded()
+// SetSaveErrorHandler registers fn to be called whenever the metadata cannot be saved, with
+// the number of consecutive failures so far. It must be set before the cache is used.
+func (bc *BinaryCache) SetSaveErrorHandler(fn func(err error, failures int)) {
+	bc.onSaveError = fn
+}
+
+// storeChunk writes a chunk to the cache file without persisting the metadata. A chunk that is
+// already cached is replaced.
+func (bc *BinaryCache) storeChunk(locationID int64, chunkID int64, chunk []byte, timestamp int64) error {
+	data, codec := bc.compress(chunk)
 
 	// Split the chunk into fixed-sized chunks
-	chunkParts := bc.splitChunk(chunk)
+	chunkParts := bc.splitChunk(data)
+
+	bc.ioLock.RLock()
+	defer bc.ioLock.RUnlock()
 
-	// Write each part
+	// Evict if cache size exceeds max size before writing new data, and reserve the slots of
+	// the parts so other writers do not use them.
+	bc.chunkLock.Lock()
+	bc.evictIfNeeded()
+	seg := bc.segment(locationID)
+	offsets := bc.allocateSlots(seg, len(chunkParts))
+	seg.users++
+	bc.chunkLock.Unlock()
+
+	if err := bc.writeChunkParts(seg, locationID, chunkParts, offsets); err != nil {
+		bc.chunkLock.Lock()
+		seg.users--
+		bc.freeSlots(seg, offsets)
+		bc.releaseSegment(locationID)
+		bc.chunkLock.Unlock()
+		return err
+	}
+	metas := make([]chunkMetadata, len(chunkParts))
 	for i, part := range chunkParts {
-		err := bc.writeChunkPart(locationID, chunkID, int64(i), part)
-		if err != nil {
-			return err
+		metas[i] = chunkMetadata{
+			LocationID: locationID,
+			ChunkIndex: int64(i),
+			Offset:     offsets[i],
+			Size:       int64(len(part)), // Store the actual size of the part, not the padded size
+			Timestamp:  timestamp,        // Unix time used for LRU ordering
+			Checksum:   crc32.ChecksumIEEE(part),
+			Codec:      codec,
 		}
 	}
 
-	// Save the metadata to the metadata file
-	return bc.saveMetadata()
+	bc.chunkLock.Lock()
+	defer bc.chunkLock.Unlock()
+	// Dropping an older copy of the chunk must not remove the segment, which is still in use.
+	if _, exists := bc.metadata[locationID][chunkID]; exists {
+		bc.dropChunk(locationID, chunkID)
+	}
+	if _, exists := bc.metadata[locationID]; !exists {
+		bc.metadata[locationID] = make(map[int64][]chunkMetadata)
+	}
+	bc.metadata[locationID][chunkID] = metas
+	seg.users--
+
+	// Add to LRU queue
+	t := cold
+	if bc.admitHot(chunkID, metas) {
+		t = hot
+	}
+	bc.addLRU(locationID, chunkID, timestamp, t)
+	return nil
+}
+
+// allocateSlots reserves n slots of a segment, reusing evicted parts that are not being read
+// before growing the file. It must be called with bc.chunkLock held.
+func (bc *BinaryCache) allocateSlots(seg *segment, n int) []int64 {
+	offsets := make([]int64, 0, n)
+	for i := len(seg.free) - 1; i >= 0 && len(offsets) < n; i-- {
+		offset := seg.free[i]
+		if seg.reading[offset] > 0 {
+			continue
+		}
+		offsets = append(offsets, offset)
+		seg.free = append(seg.free[:i], seg.free[i+1:]...)
+	}
+	for len(offsets) < n {
+		offsets = append(offsets, seg.end)
+		seg.end += bc.fixedChunkSize
+	}
+	bc.cacheSize += int64(n) * bc.fixedChunkSize
+	return offsets
+}
+
+// freeSlots makes slots of a segment available for reuse. It must be called with bc.chunkLock
+// held.
+func (bc *BinaryCache) freeSlots(seg *segment, offsets []int64) {
+	seg.free = append(seg.free, offsets...)
+	bc.cacheSize -= int64(len(offsets)) * bc.fixedChunkSize
 }
 
 // Helper method to split the chunk into fixed-size parts
@@ -174,168 +328,364 @@ func (bc *BinaryCache) splitChunk(chunk []byte) [][]byte {
 	return parts
 }
 
-// Helper method to write a part of the chunk
-func (bc *BinaryCache) writeChunkPart(locationID, chunkID, partIndex int64, part []byte) error {
-	var offset int64
-	var err error
-
-	// Check if we can overwrite an evicted chunk
-	if len(bc.evictionList) > 0 {
-		evictedMeta := bc.evictionList[len(bc.evictionList)-1]
-		bc.evictionList = bc.evictionList[:len(bc.evictionList)-1] // Remove the last element
-		offset = evictedMeta.Offset
-	} else {
-		offset, err = bc.cashFile.Seek(0, os.SEEK_END)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Pad the part to the fixed chunk size if necessary
-	paddedPart := make([]byte, bc.fixedChunkSize)
-	copy(paddedPart, part)
-
-	// Write the padded part to the file
-	_, err = bc.cashFile.WriteAt(paddedPart, offset)
+// writeChunkParts writes the parts of a chunk to the slots at offsets of a location's segment.
+func (bc *BinaryCache) writeChunkParts(seg *segment, locationID int64, parts [][]byte, offsets []int64) error {
+	file, release, err := bc.openSegment(bc.segmentID(locationID), seg)
 	if err != nil {
 		return err
 	}
-
-	timestamp := time.Now().Unix()
-	meta := chunkMetadata{
-		LocationID: locationID,
-		ChunkIndex: partIndex,
-		Offset:     offset,
-		Size:       int64(len(part)), // Store the actual size of the part, not the padded size
-		Timestamp:  timestamp,        // Store the current timestamp as int64
+	defer release()
+	for i, part := range parts {
+		if err := bc.writeChunkPart(file, part, offsets[i]); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Update the metadata
-	bc.metadata[locationID][chunkID] = append(bc.metadata[locationID][chunkID], meta)
-	bc.cacheSize += bc.fixedChunkSize
-
-	// Add to LRU queue
-	bc.addLRU(locationID, chunkID, timestamp)
+// writeChunkPart writes a part of a chunk to the slot at offset, padded to the fixed chunk size.
+func (bc *BinaryCache) writeChunkPart(file *os.File, part []byte, offset int64) error {
+	buf := bc.partBuffer()
+	defer bc.putPartBuffer(buf)
+	paddedPart := *buf
+	n := copy(paddedPart, part)
+	clear(paddedPart[n:])
 
-	return nil
+	// Write the padded part to the file
+	_, err := file.WriteAt(paddedPart, offset)
+	return err
 }
 
-// Read a specific chunk from the binary cashFile
+// Read a specific chunk from the binary cache
 func (bc *BinaryCache) readChunk(locationID int64, chunkID int64) ([]byte, error) {
-	bc.chunkLock.Lock()
-	defer bc.chunkLock.Unlock()
+	bc.ioLock.RLock()
+	defer bc.ioLock.RUnlock()
 
+	bc.chunkLock.Lock()
 	locationMetadata, exists := bc.metadata[locationID]
 	if !exists {
+		bc.misses++
+		bc.chunkLock.Unlock()
 		return nil, fmt.Errorf("location ID %d not found", locationID)
 	}
 
-	chunkMetadata, exists := locationMetadata[chunkID]
+	stored, exists := locationMetadata[chunkID]
 	if !exists {
+		bc.misses++
+		bc.chunkLock.Unlock()
 		return nil, fmt.Errorf("chunk %d not found for location ID %d", chunkID, locationID)
 	}
+	// Keep the parts from being reused by writers until they are read.
+	metas := append([]chunkMetadata(nil), stored...)
+	seg := bc.segment(locationID)
+	seg.users++
+	for _, meta := range metas {
+		seg.reading[meta.Offset]++
+	}
+	bc.chunkLock.Unlock()
 
-	// Combine all parts
-	var chunk []byte
-	for _, meta := range chunkMetadata {
-		part, err := bc.readChunkPart(meta)
-		if err != nil {
-			return nil, err
+	chunk, readErr := bc.readChunkParts(seg, locationID, metas)
+
+	bc.chunkLock.Lock()
+	seg.users--
+	for _, meta := range metas {
+		if seg.reading[meta.Offset]--; seg.reading[meta.Offset] == 0 {
+			delete(seg.reading, meta.Offset)
 		}
-		chunk = append(chunk, part...)
 	}
-
-	// Update the timestamp for LRU
-	timestamp := time.Now().Unix()
-	for _, meta := range chunkMetadata {
-		meta.SetTimestamp(time.Now())
+	bc.releaseSegment(locationID)
+	if errors.Is(readErr, ErrChunkCorrupted) {
+		// The chunk may have been replaced while it was read.
+		if current := bc.metadata[locationID][chunkID]; len(current) > 0 && current[0] == metas[0] {
+			bc.logger.Printf("Chunk %d of location ID %d is corrupted, dropping it", chunkID, locationID)
+			bc.dropChunk(locationID, chunkID)
+		}
+		bc.corrupted++
+		bc.misses++
+		bc.chunkLock.Unlock()
+		return nil, fmt.Errorf("%w: chunk %d of location ID %d", ErrChunkCorrupted, chunkID, locationID)
+	}
+	if readErr != nil {
+		bc.chunkLock.Unlock()
+		return nil, readErr
 	}
+	bc.hits++
 
 	// Update the LRU queue
-	bc.updateLRU(locationID, chunkID, timestamp)
+	bc.touchLRU(locationID, chunkID, time.Now().Unix())
+	bc.chunkLock.Unlock()
 
-	return chunk, nil
+	return bc.decompress(chunk, metas[0].Codec)
 }
 
-// Helper method to read a part of the chunk
-func (bc *BinaryCache) readChunkPart(meta chunkMetadata) ([]byte, error) {
-	// Seek to the chunk's offset
-	_, err := bc.cashFile.Seek(meta.Offset, os.SEEK_SET)
+// readChunkParts reads the parts of a chunk from a location's segment and joins them, checking
+// each against its checksum.
+func (bc *BinaryCache) readChunkParts(seg *segment, locationID int64, metas []chunkMetadata) ([]byte, error) {
+	file, release, err := bc.openSegment(bc.segmentID(locationID), seg)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	// Read the chunk's data
-	paddedPart := make([]byte, bc.fixedChunkSize)
-	_, err = bc.cashFile.Read(paddedPart)
-	if err != nil {
-		return nil, err
+	// Read each part straight into its place in the chunk
+	var size int64
+	for _, meta := range metas {
+		size += meta.Size
+	}
+	chunk := make([]byte, size)
+	var offset int64
+	for _, meta := range metas {
+		part, err := readChunkPart(file, meta, chunk[offset:offset+meta.Size])
+		if err != nil {
+			return nil, err
+		}
+		offset += meta.Size
+		if crc32.ChecksumIEEE(part) != meta.Checksum {
+			return nil, ErrChunkCorrupted
+		}
 	}
+	return chunk, nil
+}
+
+// GetChunk returns data stored with PutChunk. The key must not collide with the location IDs
+// of Telegram files, which share the same namespace.
+func (bc *BinaryCache) GetChunk(key int64, chunkID int64) ([]byte, error) {
+	return bc.readChunk(key, chunkID)
+}
+
+// PutChunk stores derived data, such as transcoded segments, under an arbitrary key.
+func (bc *BinaryCache) PutChunk(key int64, chunkID int64, data []byte) error {
+	return bc.writeChunk(key, chunkID, data)
+}
 
-	// Return only the actual size of the data, trimming any padding
-	return paddedPart[:meta.Size], nil
+// CacheStats describes the usage and layout of a BinaryCache.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`      // Chunk lookups served from the cache since it was opened
+	Misses    int64 `json:"misses"`    // Chunk lookups that were not cached
+	Evictions int64 `json:"evictions"` // Chunks evicted to make room since the cache was opened
+	Corrupted int64 `json:"corrupted"` // Chunks dropped because their checksum did not match
+	// Compressed is the number of cached chunks stored compressed.
+	Compressed int   `json:"compressed"`
+	Locations  int   `json:"locations"` // Files and derived items with cached chunks
+	Chunks     int   `json:"chunks"`
+	Size       int64 `json:"size"`     // Bytes taken by the cached chunks, including padding
+	MaxSize    int64 `json:"maxSize"`  // Configured limit of Size
+	HotSize    int64 `json:"hotSize"`  // Part of Size taken by chunks of media being streamed
+	FileSize   int64 `json:"fileSize"` // Size of the cache files on disk
+	Segments   int   `json:"segments"` // Number of cache files
+	FreeParts  int   `json:"freeParts"`
+	// Fragmentation is the share of the cache files not holding cached chunks, either evicted
+	// parts waiting to be reused or space that is lost to the files.
+	Fragmentation float64 `json:"fragmentation"`
 }
 
-// Add a chunk to the LRU queue
-func (bc *BinaryCache) addLRU(locationID int64, chunkID int64, timestamp int64) {
-	item := &LRUItem{
-		locationID: locationID,
-		chunkID:    chunkID,
-		timestamp:  timestamp,
+// GetStats returns the current statistics of the cache.
+func (bc *BinaryCache) GetStats() (CacheStats, error) {
+	bc.chunkLock.Lock()
+	defer bc.chunkLock.Unlock()
+
+	stats := CacheStats{
+		Hits:      bc.hits,
+		Misses:    bc.misses,
+		Evictions: bc.evictions,
+		Corrupted: bc.corrupted,
+		Locations: len(bc.metadata),
+		Size:      bc.cacheSize,
+		MaxSize:   bc.maxCacheSize,
+		Segments:  len(bc.segments),
+		HotSize:   bc.tierSize[hot],
+	}
+	for _, seg := range bc.segments {
+		stats.FileSize += seg.end
+		stats.FreeParts += len(seg.free)
+	}
+	for _, chunks := range bc.metadata {
+		stats.Chunks += len(chunks)
+		for _, metas := range chunks {
+			if len(metas) > 0 && metas[0].Codec != codecNone {
+				stats.Compressed++
+			}
+		}
+	}
+
+	if stats.FileSize > 0 && stats.FileSize > stats.Size {
+		stats.Fragmentation = float64(stats.FileSize-stats.Size) / float64(stats.FileSize)
 	}
-	heap.Push(bc.lruQueue, item)
+	return stats, nil
 }
 
-// Update a chunk's position in the LRU queue
-func (bc *BinaryCache) updateLRU(locationID int64, chunkID int64, timestamp int64) {
-	for _, item := range *bc.lruQueue {
-		if item.locationID == locationID && item.chunkID == chunkID {
-			bc.lruQueue.update(item, timestamp)
-			return
+// CachedSpans returns the byte ranges of a location that are fully present in the cache, given
+// the chunk size used to key the chunks. Each span is returned as [start, end) and adjacent
+// chunks are merged into a single span.
+func (bc *BinaryCache) CachedSpans(locationID int64, chunkSize int64) [][2]int64 {
+	bc.chunkLock.Lock()
+	defer bc.chunkLock.Unlock()
+
+	locationMetadata, exists := bc.metadata[locationID]
+	if !exists {
+		return nil
+	}
+
+	chunkIDs := make([]int64, 0, len(locationMetadata))
+	for chunkID := range locationMetadata {
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	sort.Slice(chunkIDs, func(i, j int) bool { return chunkIDs[i] < chunkIDs[j] })
+
+	var spans [][2]int64
+	for _, chunkID := range chunkIDs {
+		var size int64
+		for _, meta := range locationMetadata[chunkID] {
+			size += meta.Size
+		}
+		start := chunkID * chunkSize
+		end := start + size
+		if n := len(spans); n > 0 && spans[n-1][1] == start {
+			spans[n-1][1] = end
+			continue
 		}
+		spans = append(spans, [2]int64{start, end})
+	}
+	return spans
+}
+
+// hasChunk reports whether a chunk is present in the cache without reading it.
+func (bc *BinaryCache) hasChunk(locationID int64, chunkID int64) bool {
+	bc.chunkLock.Lock()
+	defer bc.chunkLock.Unlock()
+
+	_, exists := bc.metadata[locationID][chunkID]
+	return exists
+}
+
+// readChunkPart reads a part of a chunk into dst, which must hold at least meta.Size bytes, and
+// returns the part. The padding after it is not read.
+func readChunkPart(file *os.File, meta chunkMetadata, dst []byte) ([]byte, error) {
+	part := dst[:meta.Size]
+	if _, err := file.ReadAt(part, meta.Offset); err != nil {
+		return nil, err
 	}
+	return part, nil
 }
 
 // Evict chunks until the cache size is within the limit
 func (bc *BinaryCache) evictIfNeeded() {
-	for bc.cacheSize >= bc.maxCacheSize && bc.lruQueue.Len() > 0 { // Changed from '>' to '>='
-
-		// Evict the least recently used chunk
-		item := heap.Pop(bc.lruQueue).(*LRUItem)
-		metas := bc.metadata[item.locationID][item.chunkID]
-		for _, meta := range metas {
-			bc.evictionList = append(bc.evictionList, &meta) // Add to the list of evicted chunks
-			bc.cacheSize -= bc.fixedChunkSize
-		}
-		delete(bc.metadata[item.locationID], item.chunkID)
-		if len(bc.metadata[item.locationID]) == 0 {
-			delete(bc.metadata, item.locationID)
-		}
+	for bc.cacheSize >= bc.maxCacheSize && len(bc.lruItems) > 0 { // Changed from '>' to '>='
+		bc.evictLRU()
+	}
+}
+
+// evictLRU evicts the least recently used chunk, cold ones first. It must be called with
+// bc.chunkLock held.
+func (bc *BinaryCache) evictLRU() {
+	item := bc.popLRU()
+	bc.evictions++
+	bc.removeChunk(item.locationID, item.chunkID)
+}
+
+// SetMaxSize changes the size limit of the cache and evicts the least recently used chunks until
+// the cache fits in it. It returns the number of chunks evicted. The space of evicted chunks is
+// only given back to the disk once the cache is compacted.
+func (bc *BinaryCache) SetMaxSize(size int64) (int, error) {
+	bc.chunkLock.Lock()
+	bc.maxCacheSize = size
+	evicted := 0
+	for bc.cacheSize > size && len(bc.lruItems) > 0 {
+		bc.evictLRU()
+		evicted++
+	}
+	bc.balanceTiers()
+	bc.chunkLock.Unlock()
+
+	if evicted == 0 {
+		return 0, nil
+	}
+	if err := bc.saveMetadata(); err != nil {
+		return evicted, fmt.Errorf("failed to save cache metadata: %w", err)
+	}
+	return evicted, nil
+}
+
+// removeChunk deletes a chunk from the metadata and makes its parts available for reuse. The
+// segment of the location is removed with its last chunk. It must be called with bc.chunkLock
+// held, or while the cache is being opened.
+func (bc *BinaryCache) removeChunk(locationID, chunkID int64) {
+	seg := bc.segment(locationID)
+	for _, meta := range bc.metadata[locationID][chunkID] {
+		bc.freeSlots(seg, []int64{meta.Offset})
+	}
+	delete(bc.metadata[locationID], chunkID)
+	if len(bc.metadata[locationID]) == 0 {
+		delete(bc.metadata, locationID)
 	}
+	bc.releaseSegment(locationID)
 }
 
-// Save metadata to the metadata cashFile
+// saveMetadata writes the metadata to a temporary file and renames it over metadata.dat, so a
+// crash or power loss leaves either the previous or the new metadata in place, never a partly
+// written file. Only encoding the metadata holds bc.chunkLock, so reads go on while it is written.
 func (bc *BinaryCache) saveMetadata() error {
 	bc.metadataLock.Lock()
 	defer bc.metadataLock.Unlock()
 
-	_, err := bc.metadataFile.Seek(0, os.SEEK_SET)
+	var buf bytes.Buffer
+	bc.chunkLock.Lock()
+	err := bc.writeMetadata(&buf)
+	bc.chunkLock.Unlock()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write metadata: %w", err)
 	}
+	return bc.writeMetadataFile(buf.Bytes())
+}
 
-	// Clear the metadata cashFile before saving new data
-	err = bc.metadataFile.Truncate(0)
+// writeMetadataFile replaces metadata.dat with data. It must be called with bc.metadataLock held,
+// which orders the saves.
+func (bc *BinaryCache) writeMetadataFile(data []byte) error {
+	tmpPath := bc.metadataPath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create metadata file: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, bc.metadataPath)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	// Persist the rename itself.
+	if dir, err := os.Open(filepath.Dir(bc.metadataPath)); err == nil {
+		dir.Sync()
+		dir.Close()
 	}
 
-	totalChunks := int64(0)
+	bc.metadataFile.Close()
+	bc.metadataFile = tmp
+	return nil
+}
+
+// writeMetadata writes the header and all chunk entries to w.
+func (bc *BinaryCache) writeMetadata(w io.Writer) error {
+	// The entry count covers every part, since a chunk may be stored in several parts.
+	totalEntries := int64(0)
 	for _, locationChunks := range bc.metadata {
-		totalChunks += int64(len(locationChunks))
+		for _, metas := range locationChunks {
+			totalEntries += int64(len(metas))
+		}
+	}
+
+	err := writeMetadataHeader(w, bc.fixedChunkSize, bc.layout)
+	if err != nil {
+		return err
 	}
 
-	err = binary.Write(bc.metadataFile, binary.LittleEndian, totalChunks)
+	err = binary.Write(w, binary.LittleEndian, totalEntries)
 	if err != nil {
 		return err
 	}
@@ -343,31 +693,39 @@ func (bc *BinaryCache) saveMetadata() error {
 	for locationID, locationChunks := range bc.metadata {
 		for chunkID, metas := range locationChunks {
 			for _, meta := range metas {
-				err := binary.Write(bc.metadataFile, binary.LittleEndian, locationID)
+				err := binary.Write(w, binary.LittleEndian, locationID)
+				if err != nil {
+					return err
+				}
+				err = binary.Write(w, binary.LittleEndian, chunkID)
+				if err != nil {
+					return err
+				}
+				err = binary.Write(w, binary.LittleEndian, meta.LocationID)
 				if err != nil {
 					return err
 				}
-				err = binary.Write(bc.metadataFile, binary.LittleEndian, chunkID)
+				err = binary.Write(w, binary.LittleEndian, meta.ChunkIndex)
 				if err != nil {
 					return err
 				}
-				err = binary.Write(bc.metadataFile, binary.LittleEndian, meta.LocationID)
+				err = binary.Write(w, binary.LittleEndian, meta.Offset)
 				if err != nil {
 					return err
 				}
-				err = binary.Write(bc.metadataFile, binary.LittleEndian, meta.ChunkIndex)
+				err = binary.Write(w, binary.LittleEndian, meta.Size)
 				if err != nil {
 					return err
 				}
-				err = binary.Write(bc.metadataFile, binary.LittleEndian, meta.Offset)
+				err = binary.Write(w, binary.LittleEndian, meta.Timestamp)
 				if err != nil {
 					return err
 				}
-				err = binary.Write(bc.metadataFile, binary.LittleEndian, meta.Size)
+				err = binary.Write(w, binary.LittleEndian, meta.Checksum)
 				if err != nil {
 					return err
 				}
-				err = binary.Write(bc.metadataFile, binary.LittleEndian, meta.Timestamp)
+				err = binary.Write(w, binary.LittleEndian, meta.Codec)
 				if err != nil {
 					return err
 				}
@@ -375,7 +733,7 @@ func (bc *BinaryCache) saveMetadata() error {
 		}
 	}
 
-	return bc.metadataFile.Sync()
+	return nil
 }
 
 // Load metadata from the metadata cashFile
@@ -390,6 +748,12 @@ func (bc *BinaryCache) loadMetadata() error {
 	}
 	fileSize := fileInfo.Size()
 
+	// A temporary file is left behind by a save that was interrupted. It may be incomplete,
+	// while metadata.dat still holds the last complete save.
+	if err := os.Remove(bc.metadataPath + ".tmp"); err == nil {
+		bc.logger.Printf("Discarded metadata of an interrupted save, using the last complete one")
+	}
+
 	// Check if the metadata cashFile is empty or corrupted
 	if fileSize == 0 {
 		return bc.initializeFile()
@@ -400,6 +764,22 @@ func (bc *BinaryCache) loadMetadata() error {
 		return err
 	}
 
+	// Metadata written before format versioning has no header and always used the
+	// configured chunk size, so it is read as is and upgraded on the next save.
+	format, err := readMetadataHeader(bc.metadataFile)
+	if err != nil {
+		return bc.initializeFile()
+	}
+	// Version 2 only lacks the checksums, which are computed below, and version 3 the codec.
+	if !format.Legacy && (format.Version < 2 || format.Version > metadataVersion || format.ChunkSize != bc.fixedChunkSize) {
+		return fmt.Errorf("%w: cache has version %d with %d-byte chunks, expected version %d with %d-byte chunks; run 'webBridgeBot cache migrate'",
+			ErrCacheFormatMismatch, format.Version, format.ChunkSize, metadataVersion, bc.fixedChunkSize)
+	}
+	if format.Layout != bc.layout {
+		return fmt.Errorf("%w: cache has the %s layout, expected the %s layout; run 'webBridgeBot cache migrate'",
+			ErrCacheFormatMismatch, format.Layout, bc.layout)
+	}
+
 	// Read number of chunks
 	var numChunks int64
 	err = binary.Read(bc.metadataFile, binary.LittleEndian, &numChunks)
@@ -461,6 +841,24 @@ func (bc *BinaryCache) loadMetadata() error {
 			}
 			return err
 		}
+		if format.Version >= checksumMetadataVersion {
+			err = binary.Read(bc.metadataFile, binary.LittleEndian, &meta.Checksum)
+			if err != nil {
+				if err == io.EOF {
+					break // Gracefully handle unexpected EOF
+				}
+				return err
+			}
+		}
+		if format.Version >= codecMetadataVersion {
+			err = binary.Read(bc.metadataFile, binary.LittleEndian, &meta.Codec)
+			if err != nil {
+				if err == io.EOF {
+					break // Gracefully handle unexpected EOF
+				}
+				return err
+			}
+		}
 
 		if _, exists := bc.metadata[locationID]; !exists {
 			bc.metadata[locationID] = make(map[int64][]chunkMetadata)
@@ -468,11 +866,18 @@ func (bc *BinaryCache) loadMetadata() error {
 
 		bc.metadata[locationID][chunkID] = append(bc.metadata[locationID][chunkID], meta)
 		bc.cacheSize += bc.fixedChunkSize
+	}
 
-		// Add the chunk to the LRU queue
-		bc.addLRU(locationID, chunkID, meta.Timestamp)
+	// Add the chunks to the LRU queue. Nothing is streamed yet, so they are all cold.
+	for locationID, chunks := range bc.metadata {
+		for chunkID, metas := range chunks {
+			bc.addLRU(locationID, chunkID, metas[0].Timestamp, cold)
+		}
 	}
 
+	if format.Version < checksumMetadataVersion {
+		bc.computeChecksums()
+	}
 	return nil
 }
 
@@ -489,6 +894,11 @@ func (bc *BinaryCache) initializeFile() error {
 		return err
 	}
 
+	err = writeMetadataHeader(bc.metadataFile, bc.fixedChunkSize, bc.layout)
+	if err != nil {
+		return err
+	}
+
 	// Initialize with zero chunks
 	var numChunks int64 = 0
 	err = binary.Write(bc.metadataFile, binary.LittleEndian, numChunks)
@@ -2,9 +2,14 @@ package reader
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -13,7 +18,7 @@ func TestNewBinaryCache(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Initialize a new BinaryCache with a max cache size of 1024 bytes and a fixed chunk size of 256 bytes
-	cache, err := NewBinaryCache(tempDir, 1024, 256)
+	cache, err := NewBinaryCache(tempDir, 1024, 256, nil)
 	if err != nil {
 		t.Fatalf("Failed to initialize BinaryCache: %v", err)
 	}
@@ -31,8 +36,7 @@ func TestNewBinaryCache(t *testing.T) {
 	}
 
 	// Close the cache files
-	cache.cashFile.Close()
-	cache.metadataFile.Close()
+	cache.Close()
 }
 
 func TestBinaryCache_WriteReadChunk(t *testing.T) {
@@ -40,7 +44,7 @@ func TestBinaryCache_WriteReadChunk(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Initialize a new BinaryCache
-	cache, err := NewBinaryCache(tempDir, 1024, 256)
+	cache, err := NewBinaryCache(tempDir, 1024, 256, nil)
 	if err != nil {
 		t.Fatalf("Failed to initialize BinaryCache: %v", err)
 	}
@@ -67,8 +71,74 @@ func TestBinaryCache_WriteReadChunk(t *testing.T) {
 	}
 
 	// Close the cache files
-	cache.cashFile.Close()
-	cache.metadataFile.Close()
+	cache.Close()
+}
+
+func TestBinaryCache_Rewrite(t *testing.T) {
+	cache, err := NewBinaryCache(t.TempDir(), 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	defer cache.Close()
+
+	if err := cache.writeChunk(1, 1, bytes.Repeat([]byte("a"), 300)); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	if err := cache.writeChunk(1, 1, []byte("b")); err != nil {
+		t.Fatalf("Failed to rewrite chunk: %v", err)
+	}
+	readData, err := cache.readChunk(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to read chunk: %v", err)
+	}
+	if string(readData) != "b" {
+		t.Errorf("Expected the rewritten chunk, got %q", readData)
+	}
+	if cache.cacheSize != 256 || len(cache.segments[0].free) != 2 {
+		t.Errorf("Expected one part in use and the two old ones free, got size %d and %d free parts", cache.cacheSize, len(cache.segments[0].free))
+	}
+}
+
+// Streams of different files read and write at once while chunks are evicted, so the slots of
+// parts being read are freed and reused.
+func TestBinaryCache_Concurrent(t *testing.T) {
+	cache, err := NewBinaryCache(t.TempDir(), 16*256, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for locationID := int64(1); locationID <= 4; locationID++ {
+		wg.Add(1)
+		go func(locationID int64) {
+			defer wg.Done()
+			for i := int64(0); i < 200; i++ {
+				chunkID := i % 8
+				data := bytes.Repeat([]byte{byte(locationID), byte(chunkID)}, 200)
+				if err := cache.storeChunk(locationID, chunkID, data, i); err != nil {
+					t.Errorf("Failed to store chunk: %v", err)
+					return
+				}
+				readData, err := cache.readChunk(locationID, (i+7)%8)
+				if err != nil {
+					continue // Evicted by another stream
+				}
+				if readData[0] != byte(locationID) || readData[1] != byte((i+7)%8) {
+					t.Errorf("Read chunk %d of location %d, got data of chunk %d of location %d", (i+7)%8, locationID, readData[1], readData[0])
+					return
+				}
+			}
+		}(locationID)
+	}
+	wg.Wait()
+
+	if cache.cacheSize > cache.maxCacheSize+4*2*256 {
+		t.Errorf("Expected the cache to stay near its limit, got %d bytes", cache.cacheSize)
+	}
+	if checked, dropped, err := cache.Verify(); err != nil || dropped != 0 {
+		t.Errorf("Expected all %d chunks to be intact, %d dropped: %v", checked, dropped, err)
+	}
 }
 
 func TestBinaryCache_LRU_Eviction(t *testing.T) {
@@ -76,7 +146,7 @@ func TestBinaryCache_LRU_Eviction(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Initialize a new BinaryCache with a small max cache size to force eviction
-	cache, err := NewBinaryCache(tempDir, 512, 256)
+	cache, err := NewBinaryCache(tempDir, 512, 256, nil)
 	if err != nil {
 		t.Fatalf("Failed to initialize BinaryCache: %v", err)
 	}
@@ -128,8 +198,39 @@ func TestBinaryCache_LRU_Eviction(t *testing.T) {
 	}
 
 	// Close the cache files
-	cache.cashFile.Close()
-	cache.metadataFile.Close()
+	cache.Close()
+}
+
+func TestBinaryCache_SetMaxSize(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCache(tempDir, 1024, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	for chunkID := int64(0); chunkID < 3; chunkID++ {
+		if err := cache.storeChunk(1, chunkID, make([]byte, 256), chunkID); err != nil {
+			t.Fatalf("Failed to store chunk: %v", err)
+		}
+	}
+
+	evicted, err := cache.SetMaxSize(256)
+	if err != nil || evicted != 2 {
+		t.Fatalf("SetMaxSize = %d, %v; want 2 chunks evicted", evicted, err)
+	}
+	if cache.hasChunk(1, 1) || !cache.hasChunk(1, 2) {
+		t.Error("Expected the least recently used chunks to be evicted")
+	}
+	cache.Close()
+
+	// The evictions are saved.
+	cache, err = NewBinaryCache(tempDir, 1024, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen BinaryCache: %v", err)
+	}
+	defer cache.Close()
+	if cache.hasChunk(1, 0) || !cache.hasChunk(1, 2) {
+		t.Error("Expected the evictions to be saved")
+	}
 }
 
 func TestBinaryCache_MetadataPersistence(t *testing.T) {
@@ -137,7 +238,7 @@ func TestBinaryCache_MetadataPersistence(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Initialize a new BinaryCache
-	cache, err := NewBinaryCache(tempDir, 1024, 256)
+	cache, err := NewBinaryCache(tempDir, 1024, 256, nil)
 	if err != nil {
 		t.Fatalf("Failed to initialize BinaryCache: %v", err)
 	}
@@ -153,10 +254,9 @@ func TestBinaryCache_MetadataPersistence(t *testing.T) {
 	}
 
 	// Close and re-open the cache to simulate a restart
-	cache.cashFile.Close()
-	cache.metadataFile.Close()
+	cache.Close()
 
-	cache, err = NewBinaryCache(tempDir, 1024, 256)
+	cache, err = NewBinaryCache(tempDir, 1024, 256, nil)
 	if err != nil {
 		t.Fatalf("Failed to reinitialize BinaryCache: %v", err)
 	}
@@ -173,8 +273,91 @@ func TestBinaryCache_MetadataPersistence(t *testing.T) {
 	}
 
 	// Close the cache files
-	cache.cashFile.Close()
-	cache.metadataFile.Close()
+	cache.Close()
+}
+
+func TestBinaryCache_SaveErrorHandler(t *testing.T) {
+	cache, err := NewBinaryCache(t.TempDir(), 1024, 256, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+
+	var reported []int
+	cache.SetSaveErrorHandler(func(err error, failures int) {
+		reported = append(reported, failures)
+	})
+
+	// Saving fails when the metadata cannot be created.
+	cache.metadataPath = filepath.Join(t.TempDir(), "missing", "metadata.dat")
+	for chunkID := int64(0); chunkID < 2; chunkID++ {
+		if err := cache.writeChunk(1, chunkID, []byte("data")); err == nil {
+			t.Fatalf("writeChunk succeeded without a place to save the metadata")
+		}
+	}
+	if len(reported) != 2 || reported[0] != 1 || reported[1] != 2 {
+		t.Errorf("Expected failures 1 and 2 to be reported, got %v", reported)
+	}
+}
+
+func TestBinaryCache_GetStats(t *testing.T) {
+	cache, err := NewBinaryCache(t.TempDir(), 512, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+
+	// The third chunk evicts the first and takes over its part of the file.
+	for chunkID := int64(0); chunkID < 3; chunkID++ {
+		if err := cache.writeChunk(1, chunkID, []byte("data")); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+	}
+	if _, err := cache.readChunk(1, 0); err == nil {
+		t.Fatalf("Expected chunk 0 to be evicted")
+	}
+	if _, err := cache.readChunk(1, 2); err != nil {
+		t.Fatalf("Failed to read chunk: %v", err)
+	}
+
+	stats, err := cache.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	want := CacheStats{Hits: 1, Misses: 1, Evictions: 1, Locations: 1, Chunks: 2, Size: 512, MaxSize: 512, FileSize: 512, Segments: 1}
+	if stats != want {
+		t.Errorf("GetStats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestBinaryCache_InterruptedSave(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCache(tempDir, 1024, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if err := cache.writeChunk(1, 0, []byte("chunk data")); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	cache.Close()
+
+	// A save that stopped halfway leaves a partial temporary file next to the saved metadata.
+	tmpPath := filepath.Join(tempDir, "metadata.dat.tmp")
+	if err := os.WriteFile(tmpPath, []byte{1, 2, 3}, 0644); err != nil {
+		t.Fatalf("Failed to write partial metadata: %v", err)
+	}
+
+	cache, err = NewBinaryCache(tempDir, 1024, 256, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer cache.Close()
+	if data, err := cache.readChunk(1, 0); err != nil || string(data) != "chunk data" {
+		t.Errorf("Chunk = %q, %v; want the saved data", data, err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("Partial metadata was not removed")
+	}
 }
 
 func TestSplitChunk(t *testing.T) {
@@ -233,3 +416,179 @@ func TestSplitChunk(t *testing.T) {
 		}
 	})
 }
+
+func TestBinaryCache_CachedSpans(t *testing.T) {
+	// Create a temporary directory for the test
+	tempDir := t.TempDir()
+
+	cache, err := NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+
+	locationID := int64(1)
+	// Chunks 0, 1 and 3 are cached; chunk 3 is the short last chunk of the file.
+	for chunkID, size := range map[int64]int{0: 256, 1: 256, 3: 100} {
+		if err := cache.writeChunk(locationID, chunkID, make([]byte, size)); err != nil {
+			t.Fatalf("Failed to write chunk %d: %v", chunkID, err)
+		}
+	}
+
+	spans := cache.CachedSpans(locationID, 256)
+	expected := [][2]int64{{0, 512}, {768, 868}}
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected spans %v, got %v", expected, spans)
+	}
+	for i := range expected {
+		if spans[i] != expected[i] {
+			t.Errorf("Span %d: expected %v, got %v", i, expected[i], spans[i])
+		}
+	}
+
+	if cache.CachedSpans(2, 256) != nil {
+		t.Errorf("Expected no spans for an uncached location")
+	}
+
+	// Close the cache files
+	cache.Close()
+}
+
+func TestBinaryCache_ChunkSizeMismatch(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cache, err := NewBinaryCache(tempDir, 1024, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	if err := cache.writeChunk(1, 0, []byte("chunk data")); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	cache.Close()
+
+	_, err = NewBinaryCache(tempDir, 1024, 128, nil)
+	if !errors.Is(err, ErrCacheFormatMismatch) {
+		t.Fatalf("Expected ErrCacheFormatMismatch when reopening with another chunk size, got %v", err)
+	}
+}
+
+func TestMigrateCache(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cache, err := NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	chunks := map[int64][]byte{
+		0: bytes.Repeat([]byte{'a'}, 256),
+		1: bytes.Repeat([]byte{'b'}, 100),
+	}
+	for chunkID, data := range chunks {
+		if err := cache.writeChunk(7, chunkID, data); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+	}
+	cache.Close()
+
+	// Halving the chunk size splits the full chunk in two and keeps the short last chunk.
+	kept, err := MigrateCache(tempDir, 256, 128, 4096, LayoutFile)
+	if err != nil {
+		t.Fatalf("Failed to migrate cache: %v", err)
+	}
+	if kept != 3 {
+		t.Errorf("Expected 3 chunks after migration, got %d", kept)
+	}
+
+	format, err := ReadCacheFormat(tempDir, 0)
+	if err != nil {
+		t.Fatalf("Failed to read cache format: %v", err)
+	}
+	if format.Legacy || format.Version != metadataVersion || format.ChunkSize != 128 {
+		t.Errorf("Unexpected format after migration: %+v", format)
+	}
+
+	cache, err = NewBinaryCache(tempDir, 4096, 128, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen migrated cache: %v", err)
+	}
+	defer cache.Close()
+	var migrated []byte
+	for chunkID := int64(0); chunkID < 3; chunkID++ {
+		readData, err := cache.readChunk(7, chunkID)
+		if err != nil {
+			t.Fatalf("Failed to read migrated chunk %d: %v", chunkID, err)
+		}
+		migrated = append(migrated, readData...)
+	}
+	if expected := append(chunks[0], chunks[1]...); !bytes.Equal(expected, migrated) {
+		t.Errorf("Data mismatch after migration: expected %d bytes, got %d", len(expected), len(migrated))
+	}
+}
+
+func TestMigrateCache_Legacy(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cache, err := NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	data := []byte("legacy chunk data")
+	if err := cache.writeChunk(3, 2, data); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	meta := cache.metadata[3][2][0]
+	cache.Close()
+
+	// Rewrite the metadata in the layout used before format versioning: an entry count
+	// followed by the entries, without a header.
+	var legacy bytes.Buffer
+	for _, v := range []int64{1, 3, 2, meta.LocationID, meta.ChunkIndex, meta.Offset, meta.Size, meta.Timestamp} {
+		binary.Write(&legacy, binary.LittleEndian, v)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "metadata.dat"), legacy.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write legacy metadata: %v", err)
+	}
+
+	if _, err := MigrateCache(tempDir, 256, 256, 4096, LayoutFile); err != nil {
+		t.Fatalf("Failed to migrate legacy cache: %v", err)
+	}
+
+	cache, err = NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen migrated cache: %v", err)
+	}
+	defer cache.Close()
+	readData, err := cache.readChunk(3, 2)
+	if err != nil {
+		t.Fatalf("Failed to read migrated chunk: %v", err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Errorf("Data mismatch after legacy migration: expected %q, got %q", data, readData)
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	for _, layout := range []string{LayoutFile, LayoutSegments} {
+		tempDir := t.TempDir()
+		cache, err := NewBinaryCacheWithLayout(tempDir, 4096, 256, layout, nil)
+		if err != nil {
+			t.Fatalf("Failed to initialize BinaryCache: %v", err)
+		}
+		if err := cache.writeChunk(1, 0, []byte("purged")); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+		cache.Close()
+
+		freed, err := PurgeCache(tempDir)
+		if err != nil || freed < 256 {
+			t.Fatalf("PurgeCache in the %s layout = %d, %v; want at least 256 bytes freed", layout, freed, err)
+		}
+		cache, err = NewBinaryCacheWithLayout(tempDir, 4096, 256, layout, nil)
+		if err != nil {
+			t.Fatalf("Failed to reopen purged cache: %v", err)
+		}
+		if _, err := cache.readChunk(1, 0); err == nil {
+			t.Errorf("Expected no chunks after purging the %s layout", layout)
+		}
+		cache.Close()
+	}
+}
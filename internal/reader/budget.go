@@ -0,0 +1,184 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// priority orders requests waiting for the request budget.
+type priority int
+
+const (
+	// interactive requests are waited on by a viewer, such as the first chunk after a seek.
+	interactive priority = iota
+	// prefetch requests fetch chunks ahead of playback or in the background.
+	prefetch
+)
+
+// Budget is a token bucket of requests to Telegram shared by every reader. Burst requests may be
+// sent at once, then perSecond per second. While an interactive request waits, prefetch requests
+// do not get tokens, so a new stream is not stuck behind the prefetching of others.
+type Budget struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	// Number of interactive requests waiting for a token.
+	waitingInteractive int
+
+	requests [2]int64
+	waited   [2]time.Duration
+	maxWait  [2]time.Duration
+}
+
+// BudgetStats reports the requests sent to Telegram since start and how long they waited for
+// the budget, by priority.
+type BudgetStats struct {
+	RequestsPerSecond   int           `json:"requestsPerSecond"`
+	Burst               int           `json:"burst"`
+	InteractiveRequests int64         `json:"interactiveRequests"`
+	InteractiveWait     time.Duration `json:"interactiveWaitNs"`
+	InteractiveMaxWait  time.Duration `json:"interactiveMaxWaitNs"`
+	PrefetchRequests    int64         `json:"prefetchRequests"`
+	PrefetchWait        time.Duration `json:"prefetchWaitNs"`
+	PrefetchMaxWait     time.Duration `json:"prefetchMaxWaitNs"`
+}
+
+// NewBudget creates a budget of perSecond requests per second with bursts of up to burst.
+func NewBudget(perSecond, burst int) (*Budget, error) {
+	if perSecond < 1 {
+		return nil, fmt.Errorf("requests per second must be at least 1, got %d", perSecond)
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("request burst must be at least 1, got %d", burst)
+	}
+	return &Budget{perSecond: float64(perSecond), burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+// SetRate changes the rate and burst of the budget. Requests already waiting get tokens at the
+// new rate, and the requests counted so far are kept.
+func (b *Budget) SetRate(perSecond, burst int) error {
+	if _, err := NewBudget(perSecond, burst); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	b.last = now
+	b.perSecond, b.burst = float64(perSecond), float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	return nil
+}
+
+// wait blocks until a request of priority p may be sent, or until ctx is done.
+func (b *Budget) wait(ctx context.Context, p priority) error {
+	start := time.Now()
+	var timer *time.Timer
+	registered := false
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 && (p == interactive || b.waitingInteractive == 0) {
+			b.tokens--
+			if registered {
+				b.waitingInteractive--
+			}
+			b.record(p, now.Sub(start))
+			b.mu.Unlock()
+			return nil
+		}
+		if p == interactive && !registered {
+			b.waitingInteractive++
+			registered = true
+		}
+		delay := time.Duration((1 - b.tokens) / b.perSecond * float64(time.Second))
+		if delay <= 0 {
+			// A token is available but reserved for waiting interactive requests.
+			delay = time.Duration(float64(time.Second) / b.perSecond)
+		}
+		b.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(delay)
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			if registered {
+				b.mu.Lock()
+				b.waitingInteractive--
+				b.mu.Unlock()
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// record counts a request and its wait. It must be called with b.mu held.
+func (b *Budget) record(p priority, wait time.Duration) {
+	b.requests[p]++
+	b.waited[p] += wait
+	if wait > b.maxWait[p] {
+		b.maxWait[p] = wait
+	}
+}
+
+// Stats returns the requests sent so far and how long they waited.
+func (b *Budget) Stats() BudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BudgetStats{
+		RequestsPerSecond:   int(b.perSecond),
+		Burst:               int(b.burst),
+		InteractiveRequests: b.requests[interactive],
+		InteractiveWait:     b.waited[interactive],
+		InteractiveMaxWait:  b.maxWait[interactive],
+		PrefetchRequests:    b.requests[prefetch],
+		PrefetchWait:        b.waited[prefetch],
+		PrefetchMaxWait:     b.maxWait[prefetch],
+	}
+}
+
+var budget = mustBudget(NewBudget(DefaultRequestsPerSecond, DefaultRequestBurst))
+
+func mustBudget(b *Budget, err error) *Budget {
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// SetRequestBudget sets the rate and burst of the budget of requests to Telegram shared by all
+// readers.
+func SetRequestBudget(perSecond, burst int) error {
+	return RequestBudget().SetRate(perSecond, burst)
+}
+
+// RequestBudget returns the budget of requests to Telegram shared by all readers.
+func RequestBudget() *Budget {
+	return budget
+}
+
+// waitForBudget waits until a request of priority p may be sent to Telegram.
+func waitForBudget(ctx context.Context, p priority) error {
+	return RequestBudget().wait(ctx, p)
+}
@@ -0,0 +1,89 @@
+package reader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudget_InteractiveFirst(t *testing.T) {
+	b, err := NewBudget(20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.wait(context.Background(), interactive); err != nil {
+		t.Fatalf("Expected the burst to be available: %v", err)
+	}
+
+	order := make(chan priority, 2)
+	go func() {
+		b.wait(context.Background(), prefetch)
+		order <- prefetch
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		b.wait(context.Background(), interactive)
+		order <- interactive
+	}()
+
+	if first := <-order; first != interactive {
+		t.Error("Expected the interactive request to get the next token before the prefetch request")
+	}
+	<-order
+
+	stats := b.Stats()
+	if stats.InteractiveRequests != 2 || stats.PrefetchRequests != 1 {
+		t.Errorf("Expected 2 interactive and 1 prefetch requests, got %d and %d", stats.InteractiveRequests, stats.PrefetchRequests)
+	}
+	if stats.PrefetchWait < 50*time.Millisecond {
+		t.Errorf("Expected the prefetch request to wait for two tokens, waited %v", stats.PrefetchWait)
+	}
+}
+
+func TestBudget_Cancel(t *testing.T) {
+	b, _ := NewBudget(1, 1)
+	b.wait(context.Background(), interactive)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx, interactive); err == nil {
+		t.Fatal("Expected waiting to stop with the context")
+	}
+	// The cancelled request no longer holds back prefetching.
+	if b.waitingInteractive != 0 {
+		t.Errorf("Expected no waiting interactive requests, got %d", b.waitingInteractive)
+	}
+}
+
+func TestBudget_SetRate(t *testing.T) {
+	b, _ := NewBudget(1, 1)
+	b.wait(context.Background(), interactive)
+
+	if err := b.SetRate(0, 1); err == nil {
+		t.Error("Expected a rate of zero to be rejected")
+	}
+	if err := b.SetRate(100, 5); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := b.wait(context.Background(), interactive); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("Expected the request to get a token at the new rate, waited %v", waited)
+	}
+
+	stats := b.Stats()
+	if stats.RequestsPerSecond != 100 || stats.Burst != 5 || stats.InteractiveRequests != 2 {
+		t.Errorf("Expected 100 requests per second, a burst of 5 and 2 requests, got %+v", stats)
+	}
+}
+
+func TestNewBudget_Invalid(t *testing.T) {
+	if _, err := NewBudget(0, 1); err == nil {
+		t.Error("Expected a rate of zero to be rejected")
+	}
+	if _, err := NewBudget(1, 0); err == nil {
+		t.Error("Expected a burst of zero to be rejected")
+	}
+}
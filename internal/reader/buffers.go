@@ -0,0 +1,35 @@
+package reader
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers streams are copied with, as io.Copy uses.
+const copyBufferSize = 32 * 1024
+
+var copyBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, copyBufferSize)
+	return &buf
+}}
+
+// Copy copies src to dst like io.Copy, but with a buffer shared between streams instead of one
+// allocated for every request.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// partBuffer returns a buffer of one part of the cache file, to be returned with putPartBuffer.
+func (bc *BinaryCache) partBuffer() *[]byte {
+	if buf, ok := bc.partBuffers.Get().(*[]byte); ok && int64(len(*buf)) == bc.fixedChunkSize {
+		return buf
+	}
+	buf := make([]byte, bc.fixedChunkSize)
+	return &buf
+}
+
+func (bc *BinaryCache) putPartBuffer(buf *[]byte) {
+	bc.partBuffers.Put(buf)
+}
@@ -0,0 +1,112 @@
+package reader
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// benchChunkSize is the size of the chunks and cache parts in the benchmarks, the default chunk
+// size split into the parts of a cache with 256 KB slots.
+const (
+	benchChunkSize = 1024 * 1024
+	benchPartSize  = 256 * 1024
+)
+
+func newBenchCache(b *testing.B) *BinaryCache {
+	b.Helper()
+	cache, err := NewBinaryCache(b.TempDir(), 64*benchChunkSize, benchPartSize, nil)
+	if err != nil {
+		b.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	b.Cleanup(func() {
+		cache.Close()
+	})
+	return cache
+}
+
+func BenchmarkBinaryCache_StoreChunk(b *testing.B) {
+	cache := newBenchCache(b)
+	chunk := make([]byte, benchChunkSize)
+	b.ReportAllocs()
+	b.SetBytes(benchChunkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cache.storeChunk(1, int64(i%32), chunk, int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBinaryCache_ReadChunk(b *testing.B) {
+	cache := newBenchCache(b)
+	if err := cache.storeChunk(1, 0, make([]byte, benchChunkSize), 0); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(benchChunkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.readChunk(1, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// writerOnly hides the ReadFrom method of a writer, as the writers of HTTP streams do.
+type writerOnly struct {
+	io.Writer
+}
+
+func BenchmarkTelegramReader_Stream(b *testing.B) {
+	cache := newBenchCache(b)
+	const chunks = 4
+	for i := int64(0); i < chunks; i++ {
+		if err := cache.storeChunk(1, i, make([]byte, benchChunkSize), 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.SetBytes(chunks * benchChunkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := newCachedReader(cache, 1, 0, chunks*benchChunkSize-1, chunks*benchChunkSize, benchChunkSize)
+		if _, err := Copy(writerOnly{io.Discard}, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBinaryCache_Parallel streams different files from the cache at once, each reader
+// storing a new chunk for every three it reads, as concurrent streams filling the cache do.
+func BenchmarkBinaryCache_Parallel(b *testing.B) {
+	cache := newBenchCache(b)
+	cache.maxCacheSize = 256 * benchChunkSize
+	chunk := make([]byte, benchChunkSize)
+	var locations atomic.Int64
+	b.ReportAllocs()
+	b.SetBytes(benchChunkSize)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		locationID := locations.Add(1)
+		for i := int64(0); i < 4; i++ {
+			if err := cache.storeChunk(locationID, i, chunk, 0); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+		for i := int64(0); pb.Next(); i++ {
+			if i%4 == 3 {
+				if err := cache.storeChunk(locationID, i%16, chunk, i); err != nil {
+					b.Error(err)
+					return
+				}
+				continue
+			}
+			if _, err := cache.readChunk(locationID, i%4); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
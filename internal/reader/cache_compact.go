@@ -0,0 +1,137 @@
+package reader
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Compact reclaims the space of evicted chunks. In every cache file, parts stored past the end
+// of its live data are moved into the holes before it, and the file is truncated to the live
+// data. It returns the number of bytes the files shrank by. Reads and writes wait while the
+// cache is compacted.
+//
+// Parts are only copied into slots that no metadata refers to, and the new metadata replaces the
+// old one atomically, so the cache stays consistent if the process stops at any point.
+func (bc *BinaryCache) Compact() (int64, error) {
+	bc.ioLock.Lock()
+	defer bc.ioLock.Unlock()
+	bc.metadataLock.Lock()
+	defer bc.metadataLock.Unlock()
+	bc.chunkLock.Lock()
+	defer bc.chunkLock.Unlock()
+
+	// Every part takes one slot of fixedChunkSize bytes.
+	type partRef struct {
+		locationID, chunkID int64
+		index               int
+	}
+	parts := make(map[int64][]partRef)
+	for locationID, chunks := range bc.metadata {
+		id := bc.segmentID(locationID)
+		for chunkID, metas := range chunks {
+			for i := range metas {
+				parts[id] = append(parts[id], partRef{locationID, chunkID, i})
+			}
+		}
+	}
+	offset := func(p partRef) int64 {
+		return bc.metadata[p.locationID][p.chunkID][p.index].Offset
+	}
+
+	type move struct {
+		part                 partRef
+		oldOffset, newOffset int64
+	}
+	var moves []move
+	newSizes := make(map[int64]int64)
+	buf := make([]byte, bc.fixedChunkSize)
+	for id, seg := range bc.segments {
+		liveSlots := int64(len(parts[id]))
+		newSize := liveSlots * bc.fixedChunkSize
+		if seg.end <= newSize {
+			continue
+		}
+		newSizes[id] = newSize
+
+		used := make(map[int64]bool)
+		for _, p := range parts[id] {
+			used[offset(p)/bc.fixedChunkSize] = true
+		}
+		var holes []int64
+		for slot := int64(0); slot < liveSlots; slot++ {
+			if !used[slot] {
+				holes = append(holes, slot)
+			}
+		}
+		var moving []partRef
+		for _, p := range parts[id] {
+			if offset(p) >= newSize {
+				moving = append(moving, p)
+			}
+		}
+		if len(moving) == 0 {
+			continue
+		}
+		// Copy in file order, so the file is read sequentially.
+		sort.Slice(moving, func(i, j int) bool { return offset(moving[i]) < offset(moving[j]) })
+
+		file, release, err := bc.openSegment(id, seg)
+		if err != nil {
+			return 0, err
+		}
+		for i, p := range moving {
+			m := move{part: p, oldOffset: offset(p), newOffset: holes[i] * bc.fixedChunkSize}
+			if _, err := file.ReadAt(buf, m.oldOffset); err != nil {
+				release()
+				return 0, fmt.Errorf("failed to read cached part at offset %d: %w", m.oldOffset, err)
+			}
+			if _, err := file.WriteAt(buf, m.newOffset); err != nil {
+				release()
+				return 0, fmt.Errorf("failed to move cached part to offset %d: %w", m.newOffset, err)
+			}
+			moves = append(moves, m)
+		}
+		err = file.Sync()
+		release()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(newSizes) == 0 {
+		return 0, nil
+	}
+
+	for _, m := range moves {
+		bc.metadata[m.part.locationID][m.part.chunkID][m.part.index].Offset = m.newOffset
+	}
+	var encoded bytes.Buffer
+	err := bc.writeMetadata(&encoded)
+	if err == nil {
+		err = bc.writeMetadataFile(encoded.Bytes())
+	}
+	if err != nil {
+		// The moved copies are not referenced yet, so the old offsets remain valid.
+		for _, m := range moves {
+			bc.metadata[m.part.locationID][m.part.chunkID][m.part.index].Offset = m.oldOffset
+		}
+		return 0, err
+	}
+
+	var reclaimed int64
+	for id, newSize := range newSizes {
+		seg := bc.segments[id]
+		seg.free = nil
+		file, release, err := bc.openSegment(id, seg)
+		if err == nil {
+			err = file.Truncate(newSize)
+			release()
+		}
+		if err != nil {
+			return reclaimed, fmt.Errorf("failed to truncate cache file: %w", err)
+		}
+		reclaimed += seg.end - newSize
+		seg.end = newSize
+	}
+	return reclaimed, nil
+}
@@ -0,0 +1,59 @@
+package reader
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBinaryCache_Compact(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCache(tempDir, 768, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	// Chunk 0 takes two parts. Evicting it for chunk 2 leaves one of them free, while chunk 1
+	// stays at the end of the file.
+	chunks := map[int64][]byte{
+		0: bytes.Repeat([]byte("a"), 512),
+		1: []byte("chunk one"),
+		2: []byte("chunk two"),
+	}
+	for chunkID := int64(0); chunkID < 3; chunkID++ {
+		if err := cache.storeChunk(1, chunkID, chunks[chunkID], chunkID+1); err != nil {
+			t.Fatalf("Failed to store chunk %d: %v", chunkID, err)
+		}
+	}
+	if err := cache.saveMetadata(); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+
+	reclaimed, err := cache.Compact()
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if reclaimed != 256 {
+		t.Errorf("Expected 256 bytes to be reclaimed, got %d", reclaimed)
+	}
+	stats, err := cache.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.FileSize != 512 || stats.FreeParts != 0 {
+		t.Errorf("Expected a 512-byte file without free parts, got %+v", stats)
+	}
+	cache.Close()
+
+	// The moved chunk must be found through the saved metadata.
+	cache, err = NewBinaryCache(tempDir, 768, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer cache.Close()
+	for _, chunkID := range []int64{1, 2} {
+		data, err := cache.readChunk(1, chunkID)
+		if err != nil || !bytes.Equal(data, chunks[chunkID]) {
+			t.Errorf("Chunk %d = %q, %v; want %q", chunkID, data, err, chunks[chunkID])
+		}
+	}
+}
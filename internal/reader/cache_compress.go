@@ -0,0 +1,76 @@
+package reader
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionNone stores chunks as they are.
+	CompressionNone = "none"
+	// CompressionZstd stores chunks compressed with zstd when that saves space.
+	CompressionZstd = "zstd"
+)
+
+// Codecs recorded in the metadata of every part.
+const (
+	codecNone uint8 = iota
+	codecZstd
+)
+
+// SetCompression selects how new chunks are stored. Chunks already in the cache keep the codec
+// they were written with, so the setting can be changed at any time. It must be called before
+// the cache is used.
+func (bc *BinaryCache) SetCompression(name string) error {
+	switch name {
+	case "", CompressionNone:
+		bc.encoder = nil
+		return nil
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		bc.encoder = encoder
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, expected %q or %q", name, CompressionNone, CompressionZstd)
+}
+
+// compress returns the data to store for a chunk and its codec. Since every part takes a full
+// slot of fixedChunkSize bytes, compressed data is only kept if it needs fewer parts; otherwise
+// the chunk is stored as is and reading it costs no decompression.
+func (bc *BinaryCache) compress(chunk []byte) ([]byte, uint8) {
+	if bc.encoder == nil || int64(len(chunk)) <= bc.fixedChunkSize {
+		return chunk, codecNone
+	}
+	compressed := bc.encoder.EncodeAll(chunk, nil)
+	if bc.partCount(compressed) >= bc.partCount(chunk) {
+		return chunk, codecNone
+	}
+	return compressed, codecZstd
+}
+
+// decompress restores a chunk stored with the given codec.
+func (bc *BinaryCache) decompress(data []byte, codec uint8) ([]byte, error) {
+	switch codec {
+	case codecNone:
+		return data, nil
+	case codecZstd:
+		chunk, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+		}
+		return chunk, nil
+	}
+	return nil, fmt.Errorf("unknown codec %d", codec)
+}
+
+func (bc *BinaryCache) partCount(data []byte) int64 {
+	return (int64(len(data)) + bc.fixedChunkSize - 1) / bc.fixedChunkSize
+}
+
+// zstdDecoder decodes chunks of every cache. Reading compressed chunks does not depend on the
+// configured compression, so they stay readable after it is turned off.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
@@ -0,0 +1,47 @@
+package reader
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBinaryCache_Compression(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if err := cache.SetCompression(CompressionZstd); err != nil {
+		t.Fatalf("SetCompression failed: %v", err)
+	}
+
+	// The text fits in one part once compressed; the single-part chunk is stored as is.
+	text := bytes.Repeat([]byte("WEBVTT subtitles "), 64)
+	if err := cache.writeChunk(1, 0, text); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	if err := cache.writeChunk(1, 1, []byte("short")); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	if parts := cache.metadata[1][0]; len(parts) != 1 || parts[0].Codec != codecZstd {
+		t.Errorf("Expected one zstd part, got %+v", parts)
+	}
+	if codec := cache.metadata[1][1][0].Codec; codec != codecNone {
+		t.Errorf("Expected the short chunk to be stored as is, got codec %d", codec)
+	}
+	cache.Close()
+
+	// Compressed chunks are read back after a restart with compression turned off.
+	cache, err = NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer cache.Close()
+	if data, err := cache.readChunk(1, 0); err != nil || !bytes.Equal(data, text) {
+		t.Errorf("Compressed chunk = %d bytes, %v; want the original %d bytes", len(data), err, len(text))
+	}
+
+	if err := cache.SetCompression("lz4"); err == nil {
+		t.Errorf("Expected an error for an unknown compression")
+	}
+}
@@ -0,0 +1,311 @@
+package reader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// metadataMagic marks metadata files written with a format header ("WBBC").
+	metadataMagic uint32 = 0x43424257
+	// metadataVersion is the current layout of metadata.dat. Bump it whenever the layout changes.
+	metadataVersion uint32 = 5
+	// checksumMetadataVersion is the first version that stores a checksum for every part.
+	checksumMetadataVersion uint32 = 3
+	// codecMetadataVersion is the first version that stores how every part is compressed.
+	codecMetadataVersion uint32 = 4
+	// layoutMetadataVersion is the first version that records how the chunks are stored in
+	// files. Earlier versions use the file layout.
+	layoutMetadataVersion uint32 = 5
+	// legacyMetadataVersion is reported for metadata files written before the header existed.
+	legacyMetadataVersion uint32 = 1
+)
+
+// ErrCacheFormatMismatch is returned when the cache on disk was written with a different
+// format version or chunk size than the one configured, and has to be migrated first.
+var ErrCacheFormatMismatch = errors.New("cache format mismatch")
+
+// CacheFormat describes the layout of a cache directory on disk.
+type CacheFormat struct {
+	Version   uint32
+	ChunkSize int64 // Zero for legacy caches, which do not record it.
+	Layout    string
+	Legacy    bool
+}
+
+// writeMetadataHeader writes the magic number, format version, chunk size and layout.
+func writeMetadataHeader(w io.Writer, chunkSize int64, layout string) error {
+	code := layoutCodeFile
+	if layout == LayoutSegments {
+		code = layoutCodeSegments
+	}
+	for _, v := range []interface{}{metadataMagic, metadataVersion, chunkSize, code} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMetadataHeader reads the format header from the start of a metadata file. For legacy
+// files without a header, the reader is rewound so the entry count can be read next.
+func readMetadataHeader(r io.ReadSeeker) (CacheFormat, error) {
+	var magic uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return CacheFormat{}, err
+	}
+	if magic != metadataMagic {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return CacheFormat{}, err
+		}
+		return CacheFormat{Version: legacyMetadataVersion, Layout: LayoutFile, Legacy: true}, nil
+	}
+
+	var format CacheFormat
+	if err := binary.Read(r, binary.LittleEndian, &format.Version); err != nil {
+		return CacheFormat{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &format.ChunkSize); err != nil {
+		return CacheFormat{}, err
+	}
+	format.Layout = LayoutFile
+	if format.Version >= layoutMetadataVersion {
+		var code uint8
+		if err := binary.Read(r, binary.LittleEndian, &code); err != nil {
+			return CacheFormat{}, err
+		}
+		switch code {
+		case layoutCodeFile:
+		case layoutCodeSegments:
+			format.Layout = LayoutSegments
+		default:
+			return CacheFormat{}, fmt.Errorf("unknown cache layout %d", code)
+		}
+	}
+	return format, nil
+}
+
+// ReadCacheFormat returns the format of the cache stored in cacheDir. An empty or missing
+// cache is reported as the current format with the given chunk size in the file layout.
+func ReadCacheFormat(cacheDir string, chunkSize int64) (CacheFormat, error) {
+	current := CacheFormat{Version: metadataVersion, ChunkSize: chunkSize, Layout: LayoutFile}
+
+	f, err := os.Open(filepath.Join(cacheDir, "metadata.dat"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return current, nil
+		}
+		return CacheFormat{}, err
+	}
+	defer f.Close()
+
+	format, err := readMetadataHeader(f)
+	if errors.Is(err, io.EOF) {
+		return current, nil
+	}
+	return format, err
+}
+
+// Close closes the cache and metadata files.
+func (bc *BinaryCache) Close() error {
+	var errCache error
+	for _, seg := range bc.segments {
+		if seg.file != nil {
+			if err := seg.file.Close(); err != nil {
+				errCache = err
+			}
+		}
+	}
+	errMetadata := bc.metadataFile.Close()
+	if errCache != nil {
+		return errCache
+	}
+	return errMetadata
+}
+
+// MigrateCache rewrites the cache in cacheDir to the current metadata format with the given
+// chunk size and layout, keeping the cached data. legacyChunkSize is the chunk size the cache was written
+// with if it predates format versioning. When the chunk size changes, the data is regrouped
+// into chunks of the new size, and new chunks that are only partly cached are dropped.
+// Chunks are copied from least to most recently used, so if the new layout needs more space
+// than maxCacheSize the oldest chunks are dropped. It returns the number of chunks kept.
+func MigrateCache(cacheDir string, legacyChunkSize, chunkSize, maxCacheSize int64, layout string) (int, error) {
+	format, err := ReadCacheFormat(cacheDir, chunkSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache format: %w", err)
+	}
+	sourceChunkSize := format.ChunkSize
+	if format.Legacy {
+		sourceChunkSize = legacyChunkSize
+	}
+
+	source, err := NewBinaryCacheWithLayout(cacheDir, math.MaxInt64, sourceChunkSize, format.Layout, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open cache: %w", err)
+	}
+	defer source.Close()
+
+	tmpDir := filepath.Join(cacheDir, "migrate.tmp")
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return 0, err
+	}
+	target, err := NewBinaryCacheWithLayout(tmpDir, maxCacheSize, chunkSize, layout, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create migrated cache: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	type chunkKey struct {
+		locationID, chunkID, timestamp int64
+	}
+	var keys []chunkKey
+	for locationID, chunks := range source.metadata {
+		for _, chunkID := range targetChunkIDs(chunks, sourceChunkSize, chunkSize) {
+			keys = append(keys, chunkKey{locationID, chunkID, source.latestTimestamp(locationID, chunkID*chunkSize, chunkSize, sourceChunkSize)})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].timestamp < keys[j].timestamp })
+
+	for _, key := range keys {
+		chunk, ok, err := source.readRange(key.locationID, key.chunkID*chunkSize, chunkSize, sourceChunkSize)
+		if err != nil {
+			target.Close()
+			return 0, fmt.Errorf("failed to read chunk %d of location %d: %w", key.chunkID, key.locationID, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := target.storeChunk(key.locationID, key.chunkID, chunk, key.timestamp); err != nil {
+			target.Close()
+			return 0, fmt.Errorf("failed to write chunk %d of location %d: %w", key.chunkID, key.locationID, err)
+		}
+	}
+
+	kept := 0
+	for _, chunks := range target.metadata {
+		kept += len(chunks)
+	}
+	if err := target.saveMetadata(); err != nil {
+		target.Close()
+		return 0, err
+	}
+	if err := target.Close(); err != nil {
+		return 0, err
+	}
+
+	// Files of the other layout are removed only after the new metadata is in place, which no
+	// longer refers to them.
+	data := "cache.dat"
+	stale := segmentsDir
+	if layout == LayoutSegments {
+		data, stale = segmentsDir, "cache.dat"
+		if err := os.RemoveAll(filepath.Join(cacheDir, segmentsDir)); err != nil {
+			return 0, err
+		}
+	}
+	for _, name := range []string{data, "metadata.dat"} {
+		if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(cacheDir, name)); err != nil {
+			return 0, fmt.Errorf("failed to replace %s: %w", name, err)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(cacheDir, stale)); err != nil {
+		return 0, err
+	}
+	return kept, nil
+}
+
+// targetChunkIDs returns the IDs, in the target chunk size, of the chunks overlapping the cached
+// source chunks.
+func targetChunkIDs(chunks map[int64][]chunkMetadata, sourceChunkSize, targetChunkSize int64) []int64 {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for chunkID := range chunks {
+		first := chunkID * sourceChunkSize / targetChunkSize
+		last := ((chunkID+1)*sourceChunkSize - 1) / targetChunkSize
+		for id := first; id <= last; id++ {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// latestTimestamp returns the most recent access time of the chunks covering the given range.
+func (bc *BinaryCache) latestTimestamp(locationID, offset, size, chunkSize int64) int64 {
+	var latest int64
+	for chunkID := offset / chunkSize; chunkID <= (offset+size-1)/chunkSize; chunkID++ {
+		for _, meta := range bc.metadata[locationID][chunkID] {
+			latest = max(latest, meta.Timestamp)
+		}
+	}
+	return latest
+}
+
+// readRange assembles size bytes starting at offset from chunks of chunkSize. It reports false
+// if part of the range is not cached. A range running past the end of the file is returned up
+// to the end, which is recognised by a chunk shorter than chunkSize.
+func (bc *BinaryCache) readRange(locationID, offset, size, chunkSize int64) ([]byte, bool, error) {
+	var data []byte
+	end := offset + size
+	for pos := offset; pos < end; {
+		chunkID := pos / chunkSize
+		if !bc.hasChunk(locationID, chunkID) {
+			return nil, false, nil
+		}
+		chunk, err := bc.readChunk(locationID, chunkID)
+		if err != nil {
+			return nil, false, err
+		}
+
+		chunkStart := chunkID * chunkSize
+		chunkEnd := chunkStart + int64(len(chunk))
+		if pos >= chunkEnd {
+			// The range starts past the end of the file.
+			return nil, false, nil
+		}
+		data = append(data, chunk[pos-chunkStart:min(chunkEnd, end)-chunkStart]...)
+		if int64(len(chunk)) < chunkSize {
+			break
+		}
+		pos = chunkStart + chunkSize
+	}
+	return data, len(data) > 0, nil
+}
+
+// PurgeCache deletes every cached chunk in cacheDir, in either layout, and returns the number of
+// bytes freed. The cache must not be open. The metadata is removed first, so if the process
+// stops midway the cache opens empty and the remaining segment files are removed as orphans.
+func PurgeCache(cacheDir string) (int64, error) {
+	var freed int64
+	for _, name := range []string{"metadata.dat", "metadata.dat.tmp", "cache.dat", segmentsDir} {
+		path := filepath.Join(cacheDir, name)
+		err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				freed += info.Size()
+			}
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return freed, err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return freed, fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return freed, nil
+}
@@ -0,0 +1,159 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LayoutFile stores every cached chunk in cache.dat.
+	LayoutFile = "file"
+	// LayoutSegments stores the chunks of every file in a segment file of its own, so evicting
+	// the last chunk of a file deletes its segment and gives the space back to the disk at once.
+	LayoutSegments = "segments"
+)
+
+// Layouts recorded in the metadata header.
+const (
+	layoutCodeFile uint8 = iota
+	layoutCodeSegments
+)
+
+// segmentsDir is the directory of the segment files in the segments layout.
+const segmentsDir = "segments"
+
+// ValidateLayout checks that layout names a cache layout.
+func ValidateLayout(layout string) error {
+	if layout != LayoutFile && layout != LayoutSegments {
+		return fmt.Errorf("unknown cache layout %q, expected %q or %q", layout, LayoutFile, LayoutSegments)
+	}
+	return nil
+}
+
+// segment is a file holding the parts of cached chunks in slots of fixedChunkSize bytes.
+type segment struct {
+	// file is kept open in the file layout. Segment files are opened for every read and write
+	// instead, so caching thousands of files does not take thousands of file descriptors.
+	file *os.File
+	end  int64   // End of the slots allocated in the file
+	free []int64 // Offsets of slots that no chunk uses, to be reused
+	// reading counts the reads of each slot in progress, which must not be reused yet.
+	reading map[int64]int
+	// users counts the reads and writes in progress, which keep the segment from being removed.
+	users int
+}
+
+// segmentID returns the segment the chunks of a location are stored in.
+func (bc *BinaryCache) segmentID(locationID int64) int64 {
+	if bc.layout == LayoutSegments {
+		return locationID
+	}
+	return 0
+}
+
+func (bc *BinaryCache) segmentPath(id int64) string {
+	if bc.layout == LayoutSegments {
+		return filepath.Join(bc.dir, segmentsDir, strconv.FormatInt(id, 10)+".dat")
+	}
+	return filepath.Join(bc.dir, "cache.dat")
+}
+
+// segment returns the segment of a location, adding an empty one if it has none. It must be
+// called with bc.chunkLock held.
+func (bc *BinaryCache) segment(locationID int64) *segment {
+	id := bc.segmentID(locationID)
+	seg, ok := bc.segments[id]
+	if !ok {
+		seg = &segment{reading: make(map[int64]int)}
+		bc.segments[id] = seg
+	}
+	return seg
+}
+
+// openSegment returns the file of segment id and a function to release it when done.
+func (bc *BinaryCache) openSegment(id int64, seg *segment) (*os.File, func(), error) {
+	if seg.file != nil {
+		return seg.file, func() {}, nil
+	}
+	file, err := os.OpenFile(bc.segmentPath(id), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// releaseSegment deletes the segment of a location once it stores no chunk and no read or write
+// uses it. The cache.dat of the file layout is kept. It must be called with bc.chunkLock held.
+func (bc *BinaryCache) releaseSegment(locationID int64) {
+	if bc.layout != LayoutSegments || len(bc.metadata[locationID]) > 0 {
+		return
+	}
+	seg, ok := bc.segments[locationID]
+	if !ok || seg.users > 0 {
+		return
+	}
+	delete(bc.segments, locationID)
+	if err := os.Remove(bc.segmentPath(locationID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		bc.logger.Printf("Failed to remove cache segment of location ID %d: %v", locationID, err)
+	}
+}
+
+// loadSegments finds the segment files of the loaded metadata. Chunks whose parts are missing
+// from their segment, after a crash between deleting a segment and saving the metadata, are
+// dropped, and segment files no chunk refers to are removed.
+func (bc *BinaryCache) loadSegments() error {
+	if bc.layout != LayoutSegments {
+		return nil
+	}
+	dir := filepath.Join(bc.dir, segmentsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for locationID, chunks := range bc.metadata {
+		var end int64
+		if info, err := os.Stat(bc.segmentPath(locationID)); err == nil {
+			end = info.Size()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		seg := bc.segment(locationID)
+		seg.end = end
+		for chunkID, metas := range chunks {
+			for _, meta := range metas {
+				if meta.Offset+meta.Size > end {
+					bc.logger.Printf("Chunk %d of location ID %d is missing from its segment, dropping it", chunkID, locationID)
+					bc.dropChunk(locationID, chunkID)
+					break
+				}
+			}
+		}
+		// Slots past the end of the file are allocated again from the end.
+		free := seg.free[:0]
+		for _, offset := range seg.free {
+			if offset < end {
+				free = append(free, offset)
+			}
+		}
+		seg.free = free
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".dat"), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".dat") {
+			continue
+		}
+		if _, ok := bc.metadata[id]; !ok {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBinaryCache_Segments(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCacheWithLayout(tempDir, 1024, 256, LayoutSegments, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	segmentFile := func(locationID int64) string {
+		return filepath.Join(tempDir, segmentsDir, fmt.Sprintf("%d.dat", locationID))
+	}
+
+	// Location 1 takes three slots; storing two chunks of location 2 evicts all of it.
+	if err := cache.writeChunk(1, 0, bytes.Repeat([]byte("a"), 600)); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	if info, err := os.Stat(segmentFile(1)); err != nil || info.Size() != 768 {
+		t.Fatalf("Expected a 768-byte segment for location 1, got %v, %v", info, err)
+	}
+	for chunkID := int64(0); chunkID < 2; chunkID++ {
+		if err := cache.writeChunk(2, chunkID, []byte("two")); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+	}
+	if _, err := os.Stat(segmentFile(1)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the segment of the evicted location to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "cache.dat")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no cache.dat in the segments layout, got %v", err)
+	}
+	stats, err := cache.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.Segments != 1 || stats.FileSize != 512 {
+		t.Errorf("Expected one 512-byte segment, got %+v", stats)
+	}
+	cache.Close()
+
+	if _, err := NewBinaryCache(tempDir, 1024, 256, nil); !errors.Is(err, ErrCacheFormatMismatch) {
+		t.Fatalf("Expected opening in the file layout to fail with a format mismatch, got %v", err)
+	}
+	// A segment left behind by a crash before the metadata was saved is removed.
+	if err := os.WriteFile(segmentFile(1), make([]byte, 256), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err = NewBinaryCacheWithLayout(tempDir, 1024, 256, LayoutSegments, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen BinaryCache: %v", err)
+	}
+	defer cache.Close()
+	if _, err := os.Stat(segmentFile(1)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the orphaned segment to be removed, got %v", err)
+	}
+	if data, err := cache.readChunk(2, 1); err != nil || string(data) != "two" {
+		t.Errorf("readChunk = %q, %v; want %q", data, err, "two")
+	}
+}
+
+func TestBinaryCache_SegmentsCompact(t *testing.T) {
+	cache, err := NewBinaryCacheWithLayout(t.TempDir(), 4096, 256, LayoutSegments, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	defer cache.Close()
+
+	for chunkID := int64(0); chunkID < 3; chunkID++ {
+		if err := cache.storeChunk(1, chunkID, []byte{byte(chunkID)}, chunkID); err != nil {
+			t.Fatalf("Failed to store chunk: %v", err)
+		}
+	}
+	cache.chunkLock.Lock()
+	cache.dropChunk(1, 0)
+	cache.chunkLock.Unlock()
+
+	reclaimed, err := cache.Compact()
+	if err != nil || reclaimed != 256 {
+		t.Fatalf("Compact = %d, %v; want 256 bytes reclaimed", reclaimed, err)
+	}
+	for chunkID := int64(1); chunkID < 3; chunkID++ {
+		if data, err := cache.readChunk(1, chunkID); err != nil || data[0] != byte(chunkID) {
+			t.Errorf("readChunk(1, %d) = %v, %v", chunkID, data, err)
+		}
+	}
+}
+
+func TestMigrateCache_Layout(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	if err := cache.writeChunk(5, 0, []byte("kept")); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+	cache.Close()
+
+	for _, layout := range []string{LayoutSegments, LayoutFile} {
+		if _, err := MigrateCache(tempDir, 256, 256, 4096, layout); err != nil {
+			t.Fatalf("Failed to migrate to the %s layout: %v", layout, err)
+		}
+		cache, err := NewBinaryCacheWithLayout(tempDir, 4096, 256, layout, nil)
+		if err != nil {
+			t.Fatalf("Failed to open the %s layout: %v", layout, err)
+		}
+		data, err := cache.readChunk(5, 0)
+		cache.Close()
+		if err != nil || string(data) != "kept" {
+			t.Errorf("readChunk in the %s layout = %q, %v; want %q", layout, data, err, "kept")
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, segmentsDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the segments to be removed after migrating back, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package reader
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// tier is the part of the cache a chunk is kept in. Chunks of media being streamed are hot and
+// the rest are cold. Cold chunks are evicted first, so photos and voice notes do not push out
+// the chunks of a video that is playing.
+type tier int
+
+const (
+	cold tier = iota
+	hot
+)
+
+// SetHotShare sets the share of the cache kept for hot chunks. Chunks of media larger than a
+// part enter the hot tier when they are stored or read again; once the hot tier outgrows its
+// share, its least recently used chunks move to the cold tier. Smaller items always stay cold.
+// A share of zero keeps every chunk cold, which evicts in plain LRU order. It must be called
+// before the cache is used.
+func (bc *BinaryCache) SetHotShare(share float64) error {
+	if share < 0 || share >= 1 {
+		return fmt.Errorf("hot share must be at least 0 and less than 1, got %v", share)
+	}
+	bc.hotShare = share
+	return nil
+}
+
+// admitHot reports whether a chunk may enter the hot tier. An item that fits in a single part,
+// such as a photo or a thumbnail, is not streamed and stays cold.
+func (bc *BinaryCache) admitHot(chunkID int64, metas []chunkMetadata) bool {
+	small := chunkID == 0 && len(metas) == 1 && metas[0].Size < bc.fixedChunkSize
+	return bc.hotShare > 0 && !small
+}
+
+// addLRU adds a chunk to the LRU queue of tier t.
+func (bc *BinaryCache) addLRU(locationID, chunkID, timestamp int64, t tier) {
+	item := &LRUItem{
+		locationID: locationID,
+		chunkID:    chunkID,
+		timestamp:  timestamp,
+		tier:       t,
+		size:       int64(len(bc.metadata[locationID][chunkID])) * bc.fixedChunkSize,
+	}
+	heap.Push(bc.lruQueues[t], item)
+	bc.lruItems[chunkKey{locationID, chunkID}] = item
+	bc.tierSize[t] += item.size
+	bc.balanceTiers()
+}
+
+// touchLRU marks a chunk as used at timestamp, moving it to the hot tier if it is admitted there.
+func (bc *BinaryCache) touchLRU(locationID, chunkID, timestamp int64) {
+	item, ok := bc.lruItems[chunkKey{locationID, chunkID}]
+	if !ok {
+		return
+	}
+	if item.tier == cold && bc.admitHot(chunkID, bc.metadata[locationID][chunkID]) {
+		bc.removeLRU(locationID, chunkID)
+		bc.addLRU(locationID, chunkID, timestamp, hot)
+		return
+	}
+	bc.lruQueues[item.tier].update(item, timestamp)
+}
+
+// removeLRU removes a chunk from its LRU queue.
+func (bc *BinaryCache) removeLRU(locationID, chunkID int64) {
+	key := chunkKey{locationID, chunkID}
+	item, ok := bc.lruItems[key]
+	if !ok {
+		return
+	}
+	heap.Remove(bc.lruQueues[item.tier], item.index)
+	delete(bc.lruItems, key)
+	bc.tierSize[item.tier] -= item.size
+}
+
+// popLRU removes and returns the chunk to evict next: the least recently used cold chunk, or the
+// least recently used hot one if no chunk is cold.
+func (bc *BinaryCache) popLRU() *LRUItem {
+	q := bc.lruQueues[cold]
+	if q.Len() == 0 {
+		q = bc.lruQueues[hot]
+	}
+	item := heap.Pop(q).(*LRUItem)
+	delete(bc.lruItems, chunkKey{item.locationID, item.chunkID})
+	bc.tierSize[item.tier] -= item.size
+	return item
+}
+
+// balanceTiers moves the least recently used hot chunks to the cold tier while the hot tier
+// takes more than its share of the cache.
+func (bc *BinaryCache) balanceTiers() {
+	limit := int64(bc.hotShare * float64(bc.maxCacheSize))
+	for bc.tierSize[hot] > limit && bc.lruQueues[hot].Len() > 0 {
+		item := heap.Pop(bc.lruQueues[hot]).(*LRUItem)
+		bc.tierSize[hot] -= item.size
+		item.tier = cold
+		heap.Push(bc.lruQueues[cold], item)
+		bc.tierSize[cold] += item.size
+	}
+}
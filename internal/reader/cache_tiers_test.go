@@ -0,0 +1,77 @@
+package reader
+
+import "testing"
+
+// fillTiers stores two chunks of a video, then three photos that each fit in a part, in a cache
+// with room for four parts.
+func fillTiers(t *testing.T, hotShare float64) *BinaryCache {
+	t.Helper()
+	cache, err := NewBinaryCache(t.TempDir(), 1024, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	if err := cache.SetHotShare(hotShare); err != nil {
+		t.Fatal(err)
+	}
+
+	video := make([]byte, 256)
+	for chunkID := int64(0); chunkID < 2; chunkID++ {
+		if err := cache.storeChunk(1, chunkID, video, chunkID+1); err != nil {
+			t.Fatalf("Failed to store video chunk: %v", err)
+		}
+	}
+	for locationID := int64(2); locationID < 5; locationID++ {
+		if err := cache.storeChunk(locationID, 0, []byte("photo"), locationID+1); err != nil {
+			t.Fatalf("Failed to store photo: %v", err)
+		}
+	}
+	return cache
+}
+
+func TestBinaryCache_HotTier(t *testing.T) {
+	cache := fillTiers(t, 0.5)
+	for chunkID := int64(0); chunkID < 2; chunkID++ {
+		if !cache.hasChunk(1, chunkID) {
+			t.Errorf("Expected video chunk %d to stay cached", chunkID)
+		}
+	}
+	if cache.hasChunk(2, 0) {
+		t.Error("Expected the oldest photo to be evicted")
+	}
+	stats, err := cache.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.HotSize != 512 {
+		t.Errorf("Expected the video to take 512 bytes of the hot tier, got %d", stats.HotSize)
+	}
+
+	// A third video chunk outgrows the hot tier, so the oldest one moves to the cold tier and
+	// is evicted next, before the newer photo.
+	if err := cache.storeChunk(1, 2, make([]byte, 256), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.storeChunk(5, 0, []byte("photo"), 11); err != nil {
+		t.Fatal(err)
+	}
+	if cache.hasChunk(1, 0) || !cache.hasChunk(1, 1) || !cache.hasChunk(4, 0) {
+		t.Error("Expected the video chunk demoted to the cold tier to be evicted first")
+	}
+}
+
+func TestBinaryCache_HotTierDisabled(t *testing.T) {
+	cache := fillTiers(t, 0)
+	if cache.hasChunk(1, 0) || !cache.hasChunk(2, 0) {
+		t.Error("Expected plain LRU eviction without a hot tier")
+	}
+}
+
+func TestBinaryCache_SetHotShare(t *testing.T) {
+	cache := &BinaryCache{}
+	for _, share := range []float64{-0.1, 1} {
+		if err := cache.SetHotShare(share); err == nil {
+			t.Errorf("Expected a hot share of %v to be rejected", share)
+		}
+	}
+}
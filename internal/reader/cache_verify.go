@@ -0,0 +1,74 @@
+package reader
+
+import (
+	"hash/crc32"
+)
+
+// Verify reads the whole cache and drops the chunks whose data does not match its checksum, so
+// they are downloaded again. It returns the number of chunks checked and dropped.
+func (bc *BinaryCache) Verify() (checked, dropped int, err error) {
+	buf := bc.partBuffer()
+	defer bc.putPartBuffer(buf)
+	bc.chunkLock.Lock()
+	for locationID, chunks := range bc.metadata {
+		file, release, openErr := bc.openSegment(bc.segmentID(locationID), bc.segment(locationID))
+		if openErr != nil {
+			bc.chunkLock.Unlock()
+			return checked, dropped, openErr
+		}
+		for chunkID, metas := range chunks {
+			checked++
+			for _, meta := range metas {
+				part, err := readChunkPart(file, meta, *buf)
+				if err != nil || crc32.ChecksumIEEE(part) != meta.Checksum {
+					bc.logger.Printf("Chunk %d of location ID %d is corrupted at offset %d, dropping it", chunkID, locationID, meta.Offset)
+					bc.dropChunk(locationID, chunkID)
+					bc.corrupted++
+					dropped++
+					break
+				}
+			}
+		}
+		release()
+	}
+	bc.chunkLock.Unlock()
+
+	if dropped > 0 {
+		err = bc.saveMetadata()
+	}
+	return checked, dropped, err
+}
+
+// computeChecksums fills in the checksums of metadata written before they were stored. Chunks
+// that cannot be read are dropped.
+func (bc *BinaryCache) computeChecksums() {
+	buf := bc.partBuffer()
+	defer bc.putPartBuffer(buf)
+	for locationID, chunks := range bc.metadata {
+		file, release, openErr := bc.openSegment(bc.segmentID(locationID), bc.segment(locationID))
+		for chunkID, metas := range chunks {
+			for i := range metas {
+				part, err := []byte(nil), openErr
+				if err == nil {
+					part, err = readChunkPart(file, metas[i], *buf)
+				}
+				if err != nil {
+					bc.logger.Printf("Cannot read chunk %d of location ID %d, dropping it: %v", chunkID, locationID, err)
+					bc.dropChunk(locationID, chunkID)
+					break
+				}
+				metas[i].Checksum = crc32.ChecksumIEEE(part)
+			}
+		}
+		if release != nil {
+			release()
+		}
+	}
+}
+
+// dropChunk removes a chunk from the cache and the LRU queue. It must be called with
+// bc.chunkLock held, or while the cache is being opened.
+func (bc *BinaryCache) dropChunk(locationID, chunkID int64) {
+	bc.removeLRU(locationID, chunkID)
+	bc.removeChunk(locationID, chunkID)
+}
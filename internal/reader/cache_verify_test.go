@@ -0,0 +1,53 @@
+package reader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBinaryCache_Corruption(t *testing.T) {
+	tempDir := t.TempDir()
+	cache, err := NewBinaryCache(tempDir, 4096, 256, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+
+	for chunkID := int64(0); chunkID < 3; chunkID++ {
+		if err := cache.writeChunk(1, chunkID, []byte("chunk data")); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+	}
+
+	// Flip a byte of chunks 0 and 1 behind the cache's back.
+	f, err := os.OpenFile(filepath.Join(tempDir, "cache.dat"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open cache file: %v", err)
+	}
+	for chunkID := int64(0); chunkID < 2; chunkID++ {
+		if _, err := f.WriteAt([]byte("X"), cache.metadata[1][chunkID][0].Offset); err != nil {
+			t.Fatalf("Failed to corrupt chunk: %v", err)
+		}
+	}
+	f.Close()
+
+	if _, err := cache.readChunk(1, 0); !errors.Is(err, ErrChunkCorrupted) {
+		t.Fatalf("Expected ErrChunkCorrupted, got %v", err)
+	}
+	if cache.hasChunk(1, 0) {
+		t.Errorf("Corrupted chunk was not dropped")
+	}
+
+	checked, dropped, err := cache.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if checked != 2 || dropped != 1 {
+		t.Errorf("Verify() = %d checked, %d dropped; want 2, 1", checked, dropped)
+	}
+	if data, err := cache.readChunk(1, 2); err != nil || string(data) != "chunk data" {
+		t.Errorf("Intact chunk = %q, %v", data, err)
+	}
+}
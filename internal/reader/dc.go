@@ -0,0 +1,129 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// dcConnections is the number of connections opened to each other data center. Downloads from
+// there are rate limited like all others, so a few are enough.
+const dcConnections = 4
+
+// dcDialer opens authorized connections to other Telegram data centers, as the API client does
+// by exporting its authorization to them.
+type dcDialer interface {
+	API() *tg.Client
+	DC(ctx context.Context, dc int, max int64) (telegram.CloseInvoker, error)
+}
+
+// dcRouter sends upload.getFile requests to the data center that stores a document. Telegram
+// answers requests for files stored elsewhere with FILE_MIGRATE_X; the router then connects to
+// data center X once and remembers it for the document.
+type dcRouter struct {
+	dialMu    sync.Mutex // Held while connecting, so each data center is connected to once
+	mu        sync.Mutex
+	clients   map[int]*tg.Client // By data center
+	documents map[int64]int      // Data center of documents stored outside the home one
+}
+
+// routers holds a router per client, since connections to other data centers are authorized as
+// the account of the client.
+var routers sync.Map // *dcRouter by *gotgproto.Client
+
+func newDCRouter() *dcRouter {
+	return &dcRouter{clients: map[int]*tg.Client{}, documents: map[int64]int{}}
+}
+
+// routerFor returns the router of a client, creating it the first time.
+func routerFor(client *gotgproto.Client) *dcRouter {
+	if router, ok := routers.Load(client); ok {
+		return router.(*dcRouter)
+	}
+	router, _ := routers.LoadOrStore(client, newDCRouter())
+	return router.(*dcRouter)
+}
+
+// clientDialer adapts the bot's client, whose DC field hides the method of the embedded client.
+type clientDialer struct {
+	*gotgproto.Client
+}
+
+func (c clientDialer) DC(ctx context.Context, dc int, max int64) (telegram.CloseInvoker, error) {
+	return c.Client.Client.DC(ctx, dc, max)
+}
+
+// getFile requests part of a file from the data center that stores it.
+func getFile(ctx context.Context, client *gotgproto.Client, req *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	return routerFor(client).getFile(ctx, clientDialer{client}, req)
+}
+
+func (d *dcRouter) getFile(ctx context.Context, client dcDialer, req *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	var documentID int64
+	if location, ok := req.Location.(*tg.InputDocumentFileLocation); ok {
+		documentID = location.ID
+	}
+	api, err := d.api(ctx, client, d.documentDC(documentID))
+	if err != nil {
+		return nil, err
+	}
+	res, err := api.UploadGetFile(ctx, req)
+	dc, ok := fileMigrateDC(err)
+	if !ok {
+		return res, err
+	}
+
+	if api, err = d.api(ctx, client, dc); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.documents[documentID] = dc
+	d.mu.Unlock()
+	return api.UploadGetFile(ctx, req)
+}
+
+func (d *dcRouter) documentDC(documentID int64) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.documents[documentID]
+}
+
+// api returns a client for a data center, connecting to it the first time. Zero is the home data
+// center. Connections close with the client.
+func (d *dcRouter) api(ctx context.Context, client dcDialer, dc int) (*tg.Client, error) {
+	if dc == 0 {
+		return client.API(), nil
+	}
+	d.dialMu.Lock()
+	defer d.dialMu.Unlock()
+	d.mu.Lock()
+	api, ok := d.clients[dc]
+	d.mu.Unlock()
+	if ok {
+		return api, nil
+	}
+
+	invoker, err := client.DC(ctx, dc, dcConnections)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to data center %d: %w", dc, err)
+	}
+	api = tg.NewClient(invoker)
+	d.mu.Lock()
+	d.clients[dc] = api
+	d.mu.Unlock()
+	return api, nil
+}
+
+// fileMigrateDC returns the data center a FILE_MIGRATE_X error points to.
+func fileMigrateDC(err error) (int, bool) {
+	rpcErr, ok := tgerr.As(err)
+	if !ok || !rpcErr.IsType("FILE_MIGRATE") || rpcErr.Argument <= 0 {
+		return 0, false
+	}
+	return rpcErr.Argument, true
+}
@@ -0,0 +1,97 @@
+package reader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// dcInvoker answers upload.getFile with the name of its data center, or with an error.
+type dcInvoker struct {
+	name     string
+	err      error
+	requests int
+}
+
+func (i *dcInvoker) Invoke(_ context.Context, _ bin.Encoder, output bin.Decoder) error {
+	i.requests++
+	if i.err != nil {
+		return i.err
+	}
+	var b bin.Buffer
+	if err := (&tg.UploadFile{Type: &tg.StorageFilePartial{}, Bytes: []byte(i.name)}).Encode(&b); err != nil {
+		return err
+	}
+	return output.Decode(&b)
+}
+
+func (i *dcInvoker) Close() error { return nil }
+
+// fakeDialer stores documents on data center 4 and counts the connections made to it.
+type fakeDialer struct {
+	home  *dcInvoker
+	other *dcInvoker
+	dials int
+}
+
+func (f *fakeDialer) API() *tg.Client { return tg.NewClient(f.home) }
+
+func (f *fakeDialer) DC(_ context.Context, dc int, _ int64) (telegram.CloseInvoker, error) {
+	f.dials++
+	return f.other, nil
+}
+
+func TestDCRouter_FileMigrate(t *testing.T) {
+	d := &dcRouter{clients: map[int]*tg.Client{}, documents: map[int64]int{}}
+	dialer := &fakeDialer{
+		home:  &dcInvoker{err: tgerr.New(303, "FILE_MIGRATE_4")},
+		other: &dcInvoker{name: "dc4"},
+	}
+	get := func() string {
+		t.Helper()
+		res, err := d.getFile(context.Background(), dialer, &tg.UploadGetFileRequest{Location: &tg.InputDocumentFileLocation{ID: 7}})
+		if err != nil {
+			t.Fatalf("getFile failed: %v", err)
+		}
+		return string(res.(*tg.UploadFile).Bytes)
+	}
+
+	if got := get(); got != "dc4" {
+		t.Fatalf("Expected the file from data center 4, got %q", got)
+	}
+	// The document is now requested from data center 4 directly, over the same connection.
+	if got := get(); got != "dc4" {
+		t.Fatalf("Expected the file from data center 4, got %q", got)
+	}
+	if dialer.home.requests != 1 || dialer.dials != 1 {
+		t.Errorf("Expected one request to the home data center and one connection, got %d and %d", dialer.home.requests, dialer.dials)
+	}
+}
+
+func TestFileMigrateDC(t *testing.T) {
+	if dc, ok := fileMigrateDC(tgerr.New(303, "FILE_MIGRATE_5")); !ok || dc != 5 {
+		t.Errorf("fileMigrateDC = %d, %v, want 5, true", dc, ok)
+	}
+	if _, ok := fileMigrateDC(tgerr.New(400, "FILE_REFERENCE_EXPIRED")); ok {
+		t.Error("Expected other errors not to be migrations")
+	}
+	if _, ok := fileMigrateDC(nil); ok {
+		t.Error("Expected no migration without an error")
+	}
+}
+
+func TestRouterFor(t *testing.T) {
+	// Connections to other data centers are authorized per account, so clients do not share them.
+	a, b := &gotgproto.Client{}, &gotgproto.Client{}
+	if routerFor(a) != routerFor(a) {
+		t.Error("Expected a client to keep its router")
+	}
+	if routerFor(a) == routerFor(b) {
+		t.Error("Expected each client to get a router of its own")
+	}
+}
@@ -0,0 +1,65 @@
+package reader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// FileHasher returns the SHA-256 hashes Telegram keeps of the parts of a file, as the API
+// client does.
+type FileHasher interface {
+	UploadGetFileHashes(ctx context.Context, request *tg.UploadGetFileHashesRequest) ([]tg.FileHash, error)
+}
+
+var errNoFileHashes = errors.New("no file hashes returned")
+
+// Fingerprint identifies the content of a file by its size and the hashes Telegram keeps of all
+// of its parts. The same file uploaded twice gets two documents with different IDs but the same
+// fingerprint, while no file has to be downloaded to compute it. Telegram returns the hashes of a
+// few parts per request, so a large file takes many requests.
+func Fingerprint(ctx context.Context, api FileHasher, location *tg.InputDocumentFileLocation, size int64) (string, error) {
+	h := sha256.New()
+	_ = binary.Write(h, binary.LittleEndian, size)
+	offset := int64(0)
+	for {
+		hashes, err := fileHashes(ctx, api, location, offset)
+		if err != nil {
+			return "", err
+		}
+		for _, part := range hashes {
+			_ = binary.Write(h, binary.LittleEndian, part.Offset)
+			_ = binary.Write(h, binary.LittleEndian, int64(part.Limit))
+			h.Write(part.Hash)
+		}
+		last := hashes[len(hashes)-1]
+		next := last.Offset + int64(last.Limit)
+		if next >= size {
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+		if next <= offset {
+			return "", fmt.Errorf("hashes of document %d stop at offset %d of %d", location.ID, next, size)
+		}
+		offset = next
+	}
+}
+
+func fileHashes(ctx context.Context, api FileHasher, location *tg.InputDocumentFileLocation, offset int64) ([]tg.FileHash, error) {
+	if err := waitForBudget(ctx, prefetch); err != nil {
+		return nil, err
+	}
+
+	hashes, err := api.UploadGetFileHashes(ctx, &tg.UploadGetFileHashesRequest{Location: location, Offset: offset})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hashes of document %d at offset %d: %w", location.ID, offset, err)
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("%w for document %d at offset %d", errNoFileHashes, location.ID, offset)
+	}
+	return hashes, nil
+}
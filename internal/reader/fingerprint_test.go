@@ -0,0 +1,63 @@
+package reader
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+const hashPartSize = 128 * 1024
+
+// partHasher hashes the parts of in-memory files by document ID, four parts per request.
+type partHasher map[int64][]byte
+
+func (p partHasher) UploadGetFileHashes(_ context.Context, req *tg.UploadGetFileHashesRequest) ([]tg.FileHash, error) {
+	content := p[req.Location.(*tg.InputDocumentFileLocation).ID]
+	var hashes []tg.FileHash
+	for offset := req.Offset; offset < int64(len(content)) && len(hashes) < 4; offset += hashPartSize {
+		end := offset + hashPartSize
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		sum := sha256.Sum256(content[offset:end])
+		hashes = append(hashes, tg.FileHash{Offset: offset, Limit: hashPartSize, Hash: sum[:]})
+	}
+	return hashes, nil
+}
+
+func TestFingerprint(t *testing.T) {
+	content := make([]byte, 10*hashPartSize+100)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	changedEnd := append([]byte{}, content...)
+	changedEnd[len(changedEnd)-1]++
+	changedMiddle := append([]byte{}, content...)
+	changedMiddle[6*hashPartSize]++
+
+	api := partHasher{1: content, 2: append([]byte{}, content...), 3: changedEnd, 4: changedMiddle}
+	fingerprint := func(id int64) string {
+		t.Helper()
+		f, err := Fingerprint(context.Background(), api, &tg.InputDocumentFileLocation{ID: id}, int64(len(api[id])))
+		if err != nil {
+			t.Fatalf("Fingerprint of document %d failed: %v", id, err)
+		}
+		return f
+	}
+
+	if fingerprint(1) != fingerprint(2) {
+		t.Error("Expected documents with the same content to have the same fingerprint")
+	}
+	if fingerprint(1) == fingerprint(3) {
+		t.Error("Expected a different last part to change the fingerprint")
+	}
+	if fingerprint(1) == fingerprint(4) {
+		t.Error("Expected a different middle part to change the fingerprint")
+	}
+
+	if _, err := Fingerprint(context.Background(), partHasher{}, &tg.InputDocumentFileLocation{ID: 5}, 100); err == nil {
+		t.Error("Expected an error without hashes")
+	}
+}
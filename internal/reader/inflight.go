@@ -0,0 +1,60 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// chunkKey identifies a chunk of a cached file.
+type chunkKey struct {
+	key     int64
+	chunkID int64
+}
+
+// chunkCall is a download of a chunk that other readers can wait for.
+type chunkCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// chunkFlights shares the downloads of chunks between readers, so clients streaming the same
+// file at once send one request to Telegram for each chunk and write it to the cache once.
+type chunkFlights struct {
+	mu    sync.Mutex
+	calls map[chunkKey]*chunkCall
+}
+
+var flights = &chunkFlights{calls: make(map[chunkKey]*chunkCall)}
+
+// do returns the result of download for the chunk k, or of the download of k another reader
+// already started. Waiting stops when ctx is done. If the other reader's download was cancelled
+// with its request, the chunk is downloaded again rather than failing this reader too.
+func (f *chunkFlights) do(ctx context.Context, k chunkKey, download func() ([]byte, error)) (data []byte, shared bool, err error) {
+	for {
+		f.mu.Lock()
+		if call, ok := f.calls[k]; ok {
+			f.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, true, ctx.Err()
+			}
+			if errors.Is(call.err, context.Canceled) && ctx.Err() == nil {
+				continue
+			}
+			return call.data, true, call.err
+		}
+		call := &chunkCall{done: make(chan struct{})}
+		f.calls[k] = call
+		f.mu.Unlock()
+
+		call.data, call.err = download()
+		f.mu.Lock()
+		delete(f.calls, k)
+		f.mu.Unlock()
+		close(call.done)
+		return call.data, false, call.err
+	}
+}
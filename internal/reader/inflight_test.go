@@ -0,0 +1,69 @@
+package reader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChunkFlights_Shared(t *testing.T) {
+	f := &chunkFlights{calls: make(map[chunkKey]*chunkCall)}
+	var downloads atomic.Int32
+	release := make(chan struct{})
+	download := func() ([]byte, error) {
+		downloads.Add(1)
+		<-release
+		return []byte("chunk"), nil
+	}
+
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, shared, err := f.do(context.Background(), chunkKey{key: 1, chunkID: 2}, download)
+			if err != nil || string(data) != "chunk" {
+				t.Errorf("do = %q, %v", data, err)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("Expected one download, got %d", n)
+	}
+	if n := sharedCount.Load(); n != 2 {
+		t.Errorf("Expected two readers to share the download, got %d", n)
+	}
+}
+
+func TestChunkFlights_CancelledLeader(t *testing.T) {
+	f := &chunkFlights{calls: make(map[chunkKey]*chunkCall)}
+	k := chunkKey{key: 1, chunkID: 0}
+	started := make(chan struct{})
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		f.do(context.Background(), k, func() ([]byte, error) {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			return nil, context.Canceled
+		})
+	}()
+	<-started
+
+	// The follower's own request is still alive, so it downloads the chunk itself.
+	data, _, err := f.do(context.Background(), k, func() ([]byte, error) { return []byte("retried"), nil })
+	if err != nil || string(data) != "retried" {
+		t.Errorf("Expected the follower to download again, got %q, %v", data, err)
+	}
+	<-leaderDone
+}
@@ -13,23 +13,78 @@ import (
 	"syscall"
 	"time"
 
+	logging "webBridgeBot/internal/logger"
+
 	"github.com/celestix/gotgproto"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 )
 
+// MaxSupportedFileSize is the largest file that can be streamed, matching Telegram's 4 GB limit
+// for premium uploads. All offsets and lengths are handled as int64.
+const MaxSupportedFileSize int64 = 4 * 1024 * 1024 * 1024
+
 const (
-	chunkSize            = int64(1024 * 1024)
-	maxRequestsPerSecond = 30               // Max number of requests per second.
-	maxRetries           = 5                // Maximum number of retries.
-	baseDelay            = time.Second      // Initial delay for exponential backoff.
-	maxDelay             = 60 * time.Second // Maximum delay for backoff.
-)
+	DefaultChunkSize         = int64(1024 * 1024) // Preferred size of upload.getFile requests.
+	DefaultRequestsPerSecond = 30                 // Max number of Telegram requests per second.
+	DefaultRequestBurst      = 10                 // Requests that may be sent at once after a pause.
+	DefaultMaxRetries        = 5                  // Maximum number of retries.
+	DefaultPrefetchDepth     = 1                  // Number of chunks requested ahead of the one being served.
+	MaxPrefetchDepth         = 16
 
-var (
-	rateLimiter = time.NewTicker(time.Second / maxRequestsPerSecond)
-	mu          sync.Mutex
+	minChunkSize = int64(4096)        // upload.getFile limits must be multiples of 4 KB...
+	maxChunkSize = int64(1024 * 1024) // ...and divide 1 MB.
+	baseDelay    = time.Second        // Initial delay for exponential backoff.
+	maxDelay     = 60 * time.Second   // Maximum delay for backoff.
 )
 
+// Options tunes how files are fetched from Telegram.
+type Options struct {
+	ChunkSize     int64
+	MaxRetries    int
+	PrefetchDepth int
+	// CacheKey is the ID the chunks of the file are cached under. Zero uses the document ID of
+	// the location; files with the same content can share the key of one of them.
+	CacheKey int64
+	// Refresh looks up the location of the file again when Telegram reports that its file
+	// reference expired. Without it, the download fails.
+	Refresh func(ctx context.Context) (*tg.InputDocumentFileLocation, error)
+	// Budget is the request budget of the client. Nil uses the budget shared by all readers.
+	Budget *Budget
+}
+
+// DefaultOptions returns the options used when nothing is configured.
+func DefaultOptions() Options {
+	return Options{
+		ChunkSize:     DefaultChunkSize,
+		MaxRetries:    DefaultMaxRetries,
+		PrefetchDepth: DefaultPrefetchDepth,
+	}
+}
+
+// Validate checks the options against Telegram's constraints for upload.getFile.
+func (o Options) Validate() error {
+	if err := ValidateChunkSize(o.ChunkSize); err != nil {
+		return err
+	}
+	if o.MaxRetries < 1 {
+		return fmt.Errorf("max retries must be at least 1, got %d", o.MaxRetries)
+	}
+	if o.PrefetchDepth < 0 || o.PrefetchDepth > MaxPrefetchDepth {
+		return fmt.Errorf("prefetch depth must be between 0 and %d, got %d", MaxPrefetchDepth, o.PrefetchDepth)
+	}
+	return nil
+}
+
+// ValidateChunkSize checks that a chunk size is a power of two between 4 KB and 1 MB, which
+// makes it a multiple of 4096 that divides 1 MB as Telegram requires.
+func ValidateChunkSize(size int64) error {
+	if size < minChunkSize || size > maxChunkSize || size&(size-1) != 0 {
+		return fmt.Errorf("chunk size must be a power of two between %d and %d bytes, got %d", minChunkSize, maxChunkSize, size)
+	}
+	return nil
+}
+
 type telegramReader struct {
 	ctx           context.Context
 	log           *log.Logger
@@ -41,24 +96,41 @@ type telegramReader struct {
 	buffer        []byte
 	bytesread     int64
 	chunkSize     int64
+	maxRetries    int
+	prefetchDepth int
 	i             int64
 	contentLength int64
 	cache         *BinaryCache
+	cacheKey      int64
+	refresh       func(ctx context.Context) (*tg.InputDocumentFileLocation, error)
+	budget        *Budget
+	locationMu    sync.Mutex // Guards location, which prefetching goroutines refresh
 }
 
 // NewTelegramReader initializes a new telegramReader with the given parameters, including a BinaryCache.
-func NewTelegramReader(ctx context.Context, client *gotgproto.Client, location *tg.InputDocumentFileLocation, start int64, end int64, contentLength int64, cache *BinaryCache, logger *log.Logger) (io.ReadCloser, error) {
+// The chunk size in opts must match the chunk size of the cache, since chunks are keyed by it.
+// If ctx carries a request ID, it is added to the lines logged by the reader.
+func NewTelegramReader(ctx context.Context, client *gotgproto.Client, location *tg.InputDocumentFileLocation, start int64, end int64, contentLength int64, cache *BinaryCache, opts Options, logger *log.Logger) (io.ReadCloser, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 	r := &telegramReader{
 		ctx:           ctx,
-		log:           logger,
+		log:           logging.FromContext(ctx, logger),
 		location:      location,
 		client:        client,
 		start:         start,
 		end:           end,
-		chunkSize:     chunkSize,
+		chunkSize:     opts.ChunkSize,
+		maxRetries:    opts.MaxRetries,
+		prefetchDepth: opts.PrefetchDepth,
 		contentLength: contentLength,
 		cache:         cache,
+		cacheKey:      opts.CacheKey,
+		refresh:       opts.Refresh,
+		budget:        opts.Budget,
 	}
+	r.logCachedCoverage()
 	r.log.Println("Initialization complete.")
 	r.next = r.partStream()
 	return r, nil
@@ -72,8 +144,8 @@ func (*telegramReader) Close() error {
 // Read reads the next chunk of data into the provided byte slice.
 func (r *telegramReader) Read(p []byte) (n int, err error) {
 
-	if r.bytesread == r.contentLength {
-		r.log.Println("Reached end of cacheFile (bytesread == contentLength).")
+	if r.bytesread == r.end-r.start+1 {
+		r.log.Println("Reached end of the requested range.")
 		return 0, io.EOF
 	}
 
@@ -100,37 +172,72 @@ func (r *telegramReader) Read(p []byte) (n int, err error) {
 }
 
 // chunk requests a cacheFile chunk from the Telegram API starting at the specified offset or retrieves it from the cache.
-func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
-	// Check if the chunk is already in the cache
+func (r *telegramReader) chunk(offset int64, limit int64, p priority) ([]byte, error) {
+	// Check if the chunk is already in the cache. Chunks are keyed by the document ID of the
+	// location, so every message carrying the same file shares the same cached data.
 	chunkID := offset / r.chunkSize
-	cachedChunk, err := r.cache.readChunk(r.location.ID, chunkID)
+	cachedChunk, err := r.cache.readChunk(r.key(), chunkID)
 	if err == nil {
 		r.log.Printf("Cache hit for chunk %d.", chunkID)
 		return cachedChunk, nil
 	}
 
-	r.log.Printf("Cache miss for chunk %d, requesting from Telegram API.", chunkID)
+	// If not in cache, request it from Telegram, unless another reader already does.
+	data, shared, err := flights.do(r.ctx, chunkKey{key: r.key(), chunkID: chunkID}, func() ([]byte, error) {
+		// The chunk may have been cached by a download that just finished.
+		if r.cache.hasChunk(r.key(), chunkID) {
+			if cachedChunk, err := r.cache.readChunk(r.key(), chunkID); err == nil {
+				return cachedChunk, nil
+			}
+		}
+		r.log.Printf("Cache miss for chunk %d, requesting from Telegram API.", chunkID)
+		req := &tg.UploadGetFileRequest{
+			Offset:   offset,
+			Limit:    int(limit),
+			Location: r.currentLocation(),
+		}
+		return r.downloadAndCacheChunk(req, chunkID, p)
+	})
+	if shared {
+		r.log.Printf("Chunk %d shared with a download of another stream.", chunkID)
+	}
+	return data, err
+}
 
-	// If not in cache, request it from Telegram
-	req := &tg.UploadGetFileRequest{
-		Offset:   offset,
-		Limit:    int(limit),
-		Location: r.location,
+func (r *telegramReader) currentLocation() *tg.InputDocumentFileLocation {
+	r.locationMu.Lock()
+	defer r.locationMu.Unlock()
+	return r.location
+}
+
+// refreshLocation replaces an expired location, unless another chunk did so since the request
+// with expired was sent, and returns the new one.
+func (r *telegramReader) refreshLocation(expired *tg.InputDocumentFileLocation) (*tg.InputDocumentFileLocation, error) {
+	r.locationMu.Lock()
+	defer r.locationMu.Unlock()
+	if r.location != expired {
+		return r.location, nil
 	}
-	return r.downloadAndCacheChunk(req, chunkID)
+	location, err := r.refresh(r.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh the file reference: %w", err)
+	}
+	r.location = location
+	return location, nil
 }
 
 // downloadAndCacheChunk combines rate limiting and exponential backoff.
-func (r *telegramReader) downloadAndCacheChunk(req *tg.UploadGetFileRequest, chunkID int64) ([]byte, error) {
+func (r *telegramReader) downloadAndCacheChunk(req *tg.UploadGetFileRequest, chunkID int64, p priority) ([]byte, error) {
 	delay := baseDelay // Start with the base delay for exponential backoff.
+	refreshed := false
 
-	for retryCount := 0; retryCount < maxRetries; retryCount++ {
-		// Rate limiting: Wait for the rate limiter to allow a new request.
-		mu.Lock()
-		<-rateLimiter.C
-		mu.Unlock()
+	for retryCount := 0; retryCount < r.maxRetries; retryCount++ {
+		// Rate limiting: Wait for the request budget of the client.
+		if err := r.waitForBudget(p); err != nil {
+			return nil, err
+		}
 
-		res, err := r.client.API().UploadGetFile(r.ctx, req)
+		res, err := getFile(r.ctx, r.client, req)
 		if err != nil {
 			// Handle FLOOD_WAIT error by sleeping for the specified time and retrying.
 			if floodWait, ok := isFloodWaitError(err); ok {
@@ -139,11 +246,23 @@ func (r *telegramReader) downloadAndCacheChunk(req *tg.UploadGetFileRequest, chu
 				continue
 			}
 
+			// File references expire after a while; a fresh one is fetched once per chunk.
+			if tgerr.Is(err, "FILE_REFERENCE_EXPIRED") && r.refresh != nil && !refreshed {
+				r.log.Printf("File reference expired, refreshing it.")
+				location, refreshErr := r.refreshLocation(req.Location.(*tg.InputDocumentFileLocation))
+				if refreshErr != nil {
+					return nil, refreshErr
+				}
+				req.Location = location
+				refreshed = true
+				continue
+			}
+
 			// Handle transient errors with exponential backoff.
 			if isTransientError(err) {
 				r.log.Printf("Transient error: %v, retrying in %v", err, delay)
 				time.Sleep(delay)
-				delay = min(delay*2, maxDelay) // Increase delay with exponential backoff, capping at maxDelay.
+				delay = minDuration(delay*2, maxDelay) // Increase delay with exponential backoff, capping at maxDelay.
 				continue
 			}
 
@@ -155,7 +274,7 @@ func (r *telegramReader) downloadAndCacheChunk(req *tg.UploadGetFileRequest, chu
 		switch result := res.(type) {
 		case *tg.UploadFile:
 			chunkData := result.Bytes
-			err = r.cache.writeChunk(r.location.ID, chunkID, chunkData)
+			err = r.cache.writeChunk(r.key(), chunkID, chunkData)
 			if err != nil {
 				r.log.Printf("Error writing chunk to cache: %v", err)
 			}
@@ -166,10 +285,65 @@ func (r *telegramReader) downloadAndCacheChunk(req *tg.UploadGetFileRequest, chu
 	}
 
 	// If all retries are exhausted, return an error.
-	return nil, fmt.Errorf("failed to download chunk %d after %d retries", chunkID, maxRetries)
+	return nil, fmt.Errorf("failed to download chunk %d after %d retries", chunkID, r.maxRetries)
+}
+
+// waitForBudget waits for the budget of the reader, or the shared one if it has none.
+func (r *telegramReader) waitForBudget(p priority) error {
+	if r.budget != nil {
+		return r.budget.wait(r.ctx, p)
+	}
+	return waitForBudget(r.ctx, p)
+}
+
+// key returns the ID the chunks of the file are cached under.
+func (r *telegramReader) key() int64 {
+	if r.cacheKey != 0 {
+		return r.cacheKey
+	}
+	return r.location.ID
+}
+
+// logCachedCoverage logs how much of the requested range is already cached. Cached chunks are
+// served from disk wherever they fall in the range, so only the missing spans reach Telegram.
+func (r *telegramReader) logCachedCoverage() {
+	var cached int64
+	for _, span := range r.cache.CachedSpans(r.key(), r.chunkSize) {
+		start := max(span[0], r.start)
+		end := min(span[1], r.end+1)
+		if end > start {
+			cached += end - start
+		}
+	}
+	r.log.Printf("Range %d-%d of location %d: %d of %d bytes cached.", r.start, r.end, r.location.ID, cached, r.end-r.start+1)
+}
+
+// chunkResult carries the outcome of an asynchronous chunk fetch.
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// fetchAsync starts fetching the chunk at the given offset in the background, so the network
+// round trip overlaps with serving the previous chunk to the client.
+func (r *telegramReader) fetchAsync(offset int64, p priority) <-chan chunkResult {
+	result := make(chan chunkResult, 1)
+	go func() {
+		data, err := r.chunk(offset, r.chunkSize, p)
+		result <- chunkResult{data: data, err: err}
+	}()
+	return result
 }
 
-// partStream returns a function that reads cacheFile chunks sequentially.
+// pendingChunk is a chunk scheduled to be served. result is nil for chunks that were cached
+// when scheduled; they are read on demand instead of being prefetched.
+type pendingChunk struct {
+	offset int64
+	result <-chan chunkResult
+}
+
+// partStream returns a function that reads cacheFile chunks sequentially. While a chunk is being
+// served, up to prefetchDepth following chunks are already requested.
 func (r *telegramReader) partStream() func() ([]byte, error) {
 	start := r.start
 	end := r.end
@@ -180,14 +354,44 @@ func (r *telegramReader) partStream() func() ([]byte, error) {
 	partCount := int((end - offset + r.chunkSize) / r.chunkSize)
 	currentPart := 1
 
+	lastOffset := offset + int64(partCount-1)*r.chunkSize
+	nextOffset := offset
+	var queue []pendingChunk
+
+	// schedule queues the chunk being served plus the prefetch window. Only chunks that have to
+	// come from Telegram are fetched ahead; cached ones are read on demand. The first chunk of
+	// the range is what the viewer waits for, so it goes ahead of other readers' prefetching.
+	schedule := func() {
+		for len(queue) <= r.prefetchDepth && nextOffset <= lastOffset {
+			p := pendingChunk{offset: nextOffset}
+			if !r.cache.hasChunk(r.key(), nextOffset/r.chunkSize) {
+				p.result = r.fetchAsync(nextOffset, chunkPriority(nextOffset, offset))
+			}
+			queue = append(queue, p)
+			nextOffset += r.chunkSize
+		}
+	}
+
 	readData := func() ([]byte, error) {
 		if currentPart > partCount {
 			return make([]byte, 0), nil
 		}
-		res, err := r.chunk(offset, r.chunkSize)
-		if err != nil {
-			return nil, err
+		schedule()
+		p := queue[0]
+		queue = queue[1:]
+
+		var result chunkResult
+		if p.result != nil {
+			result = <-p.result
+		} else {
+			result.data, result.err = r.chunk(p.offset, r.chunkSize, chunkPriority(p.offset, offset))
 		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		schedule()
+
+		res := result.data
 		if len(res) == 0 {
 			return res, nil
 		} else if partCount == 1 {
@@ -199,12 +403,20 @@ func (r *telegramReader) partStream() func() ([]byte, error) {
 		}
 
 		currentPart++
-		offset += r.chunkSize
 		return res, nil
 	}
 	return readData
 }
 
+// chunkPriority returns the priority of the chunk at offset of a range whose first chunk is at
+// first.
+func chunkPriority(offset, first int64) priority {
+	if offset == first {
+		return interactive
+	}
+	return prefetch
+}
+
 // isFloodWaitError checks if the error is a FLOOD_WAIT error and returns the wait time if true.
 func isFloodWaitError(err error) (int, bool) {
 	// Identify FLOOD_WAIT errors and extract wait time if applicable.
@@ -248,8 +460,8 @@ func isTransientError(err error) bool {
 	return false
 }
 
-// min returns the minimum of two time.Duration values.
-func min(a, b time.Duration) time.Duration {
+// minDuration returns the minimum of two time.Duration values.
+func minDuration(a, b time.Duration) time.Duration {
 	if a < b {
 		return a
 	}
@@ -0,0 +1,137 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+// newCachedReader returns a telegramReader whose chunks are all served from the given cache.
+func newCachedReader(cache *BinaryCache, locationID, start, end, contentLength, size int64) *telegramReader {
+	r := &telegramReader{
+		log:           log.New(io.Discard, "", 0),
+		location:      &tg.InputDocumentFileLocation{ID: locationID},
+		start:         start,
+		end:           end,
+		chunkSize:     size,
+		contentLength: contentLength,
+		cache:         cache,
+	}
+	r.next = r.partStream()
+	return r
+}
+
+func TestTelegramReader_RangeAbove2GB(t *testing.T) {
+	tempDir := t.TempDir()
+
+	const size = int64(256)
+	cache, err := NewBinaryCache(tempDir, 1024*1024, size, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	defer cache.Close()
+
+	// A range starting past 2^32 in a file close to the maximum supported size.
+	contentLength := MaxSupportedFileSize
+	start := int64(1)<<32 + 100
+	end := start + 2*size
+
+	locationID := int64(42)
+	var expected []byte
+	for offset := start - start%size; offset <= end; offset += size {
+		chunk := bytes.Repeat([]byte{byte(offset / size)}, int(size))
+		if err := cache.writeChunk(locationID, offset/size, chunk); err != nil {
+			t.Fatalf("Failed to write chunk at offset %d: %v", offset, err)
+		}
+		expected = append(expected, chunk...)
+	}
+	firstCut := start % size
+	expected = expected[firstCut : firstCut+end-start+1]
+
+	r := newCachedReader(cache, locationID, start, end, contentLength, size)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read range: %v", err)
+	}
+
+	if int64(len(data)) != end-start+1 {
+		t.Fatalf("Expected %d bytes, got %d", end-start+1, len(data))
+	}
+	if !bytes.Equal(expected, data) {
+		t.Errorf("Data mismatch for range %d-%d", start, end)
+	}
+}
+
+func TestTelegramReader_LastByteOfMaxFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	const size = int64(256)
+	cache, err := NewBinaryCache(tempDir, 1024*1024, size, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize BinaryCache: %v", err)
+	}
+	defer cache.Close()
+
+	contentLength := MaxSupportedFileSize
+	lastChunkID := (contentLength - 1) / size
+	chunk := make([]byte, size)
+	chunk[size-1] = 0xAB
+	if err := cache.writeChunk(1, lastChunkID, chunk); err != nil {
+		t.Fatalf("Failed to write last chunk: %v", err)
+	}
+
+	r := newCachedReader(cache, 1, contentLength-1, contentLength-1, contentLength, size)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read last byte: %v", err)
+	}
+	if len(data) != 1 || data[0] != 0xAB {
+		t.Errorf("Expected last byte 0xAB, got %v", data)
+	}
+}
+
+func TestValidateChunkSize(t *testing.T) {
+	for _, size := range []int64{4096, 65536, 512 * 1024, 1024 * 1024} {
+		if err := ValidateChunkSize(size); err != nil {
+			t.Errorf("Expected chunk size %d to be valid, got %v", size, err)
+		}
+	}
+	for _, size := range []int64{0, 1024, 12288, 1024*1024 + 4096, 2 * 1024 * 1024} {
+		if err := ValidateChunkSize(size); err == nil {
+			t.Errorf("Expected chunk size %d to be rejected", size)
+		}
+	}
+}
+
+func TestTelegramReader_RefreshLocation(t *testing.T) {
+	expired := &tg.InputDocumentFileLocation{ID: 1, FileReference: []byte("old")}
+	refreshes := 0
+	r := &telegramReader{
+		ctx:      context.Background(),
+		location: expired,
+		refresh: func(context.Context) (*tg.InputDocumentFileLocation, error) {
+			refreshes++
+			return &tg.InputDocumentFileLocation{ID: 1, FileReference: []byte("new")}, nil
+		},
+	}
+
+	first, err := r.refreshLocation(expired)
+	if err != nil {
+		t.Fatalf("refreshLocation failed: %v", err)
+	}
+	if string(first.FileReference) != "new" || r.currentLocation() != first {
+		t.Fatalf("Expected the location to be replaced, got %q", first.FileReference)
+	}
+	// A chunk that was sent with the expired location too uses the one already refreshed.
+	second, err := r.refreshLocation(expired)
+	if err != nil {
+		t.Fatalf("refreshLocation failed: %v", err)
+	}
+	if second != first || refreshes != 1 {
+		t.Errorf("Expected one refresh, got %d", refreshes)
+	}
+}
@@ -0,0 +1,34 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/tg"
+)
+
+// DownloadThumbnail fetches the thumbnail of the given type of a document. Telegram thumbnails
+// are small, so a single request of the maximum size returns all of it.
+func DownloadThumbnail(ctx context.Context, client *gotgproto.Client, location *tg.InputDocumentFileLocation, thumbSize string) ([]byte, error) {
+	thumbLocation := *location
+	thumbLocation.ThumbSize = thumbSize
+
+	if err := waitForBudget(ctx, interactive); err != nil {
+		return nil, err
+	}
+
+	res, err := getFile(ctx, client, &tg.UploadGetFileRequest{
+		Location: &thumbLocation,
+		Offset:   0,
+		Limit:    int(maxChunkSize),
+	})
+	if err != nil {
+		return nil, err
+	}
+	file, ok := res.(*tg.UploadFile)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type: %T", res)
+	}
+	return file.Bytes, nil
+}
@@ -0,0 +1,269 @@
+// Package rpc serves JSON-RPC 2.0 on a Unix socket, so local tools can manage a running bot.
+// Requests and responses are sent one per line. Only the owner of the socket may connect.
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Error codes defined by JSON-RPC 2.0, and the code of errors returned by methods.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+// maxRequestSize is the longest request line the server reads.
+const maxRequestSize = 1 << 20
+
+// Error is a JSON-RPC error. Methods return it to choose the code; other errors are sent with
+// CodeServerError.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Handler runs a method with its raw params, which are null or missing if none were sent.
+type Handler func(ctx context.Context, params json.RawMessage) (any, error)
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Server dispatches requests to the registered methods.
+type Server struct {
+	methods map[string]Handler
+	logger  *log.Logger
+}
+
+// NewServer creates a server without methods, apart from rpc.methods, which lists them.
+func NewServer(logger *log.Logger) *Server {
+	s := &Server{methods: make(map[string]Handler), logger: logger}
+	s.Register("rpc.methods", func(context.Context, json.RawMessage) (any, error) {
+		names := make([]string, 0, len(s.methods))
+		for name := range s.methods {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	})
+	return s
+}
+
+// Register adds a method. It must be called before the server is started.
+func (s *Server) Register(method string, handler Handler) {
+	s.methods[method] = handler
+}
+
+// DecodeParams decodes the params of a request into v, reporting malformed params with
+// CodeInvalidParams.
+func DecodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		params = []byte("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	return nil
+}
+
+// ListenAndServe serves connections on a Unix socket at path until ctx is cancelled, then
+// removes the socket. A socket left behind by an earlier run is replaced.
+func (s *Server) ListenAndServe(ctx context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove old socket: %w", err)
+	}
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ServeConn(ctx, conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// listenPrivate listens on a Unix socket at path that only its owner may use, since it grants full
+// control over the bot. The socket is created in a private directory, restricted and only then
+// moved to path, so no one else can connect before it is restricted.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".rpc-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed from path by ListenAndServe; closing must not unlink the old name.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict the socket: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to move the socket into place: %w", err)
+	}
+	return listener, nil
+}
+
+// ServeConn answers the requests of a connection until it is closed.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		resp, ok := s.handle(ctx, scanner.Bytes())
+		if !ok {
+			continue
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle runs a request and returns its response, or false for a notification, which is not
+// answered.
+func (s *Server) handle(ctx context.Context, line []byte) (response, bool) {
+	resp := response{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp.Error = &Error{Code: CodeParseError, Message: "parse error"}
+		return resp, true
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &Error{Code: CodeInvalidRequest, Message: "invalid request"}
+		return resp, true
+	}
+	notification := len(req.ID) == 0
+	if !notification {
+		resp.ID = req.ID
+	}
+
+	handler, ok := s.methods[req.Method]
+	if !ok {
+		resp.Error = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+		return resp, !notification
+	}
+	start := time.Now()
+	result, err := handler(ctx, req.Params)
+	var rpcErr *Error
+	switch {
+	case errors.As(err, &rpcErr):
+		resp.Error = rpcErr
+	case err != nil:
+		resp.Error = &Error{Code: CodeServerError, Message: err.Error()}
+	default:
+		resp.Result = result
+	}
+	s.logger.Printf("RPC %s in %s, error: %v", req.Method, time.Since(start).Round(time.Millisecond), err)
+	return resp, !notification
+}
+
+// Call sends a request to the server listening on the Unix socket at path and decodes the
+// result into result, unless it is nil. Errors returned by the server are *Error.
+func Call(ctx context.Context, path, method string, params any, result any) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req := request{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: rawParams}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read the response: %w", err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	// Unix socket paths are limited to about a hundred bytes, which t.TempDir may exceed.
+	dir, err := os.MkdirTemp("", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s := NewServer(log.New(io.Discard, "", 0))
+	s.Register("echo", func(_ context.Context, params json.RawMessage) (any, error) {
+		var p struct {
+			Text string `json:"text"`
+		}
+		if err := DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Text == "" {
+			return nil, errors.New("text is required")
+		}
+		return p, nil
+	})
+	return s, filepath.Join(dir, "admin.sock")
+}
+
+func TestServer(t *testing.T) {
+	s, path := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe(ctx, path) }()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a socket only its owner may use, got %v, %v", info, err)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := Call(ctx, path, "echo", map[string]string{"text": "hi"}, &result); err != nil || result.Text != "hi" {
+		t.Errorf("echo = %+v, %v; want hi", result, err)
+	}
+	var methods []string
+	if err := Call(ctx, path, "rpc.methods", nil, &methods); err != nil || len(methods) != 2 || methods[0] != "echo" {
+		t.Errorf("rpc.methods = %v, %v; want [echo rpc.methods]", methods, err)
+	}
+
+	tests := []struct {
+		method string
+		params any
+		code   int
+	}{
+		{"missing", nil, CodeMethodNotFound},
+		{"echo", []int{1}, CodeInvalidParams},
+		{"echo", map[string]string{}, CodeServerError},
+	}
+	for _, tt := range tests {
+		var rpcErr *Error
+		err := Call(ctx, path, tt.method, tt.params, nil)
+		if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code {
+			t.Errorf("%s(%v) error = %v, want code %d", tt.method, tt.params, err, tt.code)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ListenAndServe returned %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	s, _ := newTestServer(t)
+	client, server := net.Pipe()
+	go s.ServeConn(context.Background(), server)
+	defer client.Close()
+
+	// The notification is not answered, so the first response is to the malformed line.
+	go io.WriteString(client, `{"jsonrpc":"2.0","method":"echo","params":{"text":"x"}}`+"\n"+
+		"{not json\n"+
+		`{"jsonrpc":"1.0","id":2,"method":"echo"}`+"\n")
+	decoder := json.NewDecoder(client)
+	for _, want := range []struct {
+		id   string
+		code int
+	}{{"null", CodeParseError}, {"null", CodeInvalidRequest}} {
+		var resp struct {
+			ID    json.RawMessage `json:"id"`
+			Error *Error          `json:"error"`
+		}
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.ID) != want.id || resp.Error == nil || resp.Error.Code != want.code {
+			t.Errorf("Response = %s, %v; want id %s and code %d", resp.ID, resp.Error, want.id, want.code)
+		}
+	}
+}
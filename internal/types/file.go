@@ -16,6 +16,32 @@ type DocumentFile struct {
 	FileName  string
 	MimeType  string
 	VideoAttr tg.DocumentAttributeVideo
+	AudioAttr tg.DocumentAttributeAudio
+	// ThumbSize is the type of the largest thumbnail Telegram keeps for the file, or empty if
+	// there is none.
+	ThumbSize string
+	// Alternatives holds other encodings of the same media provided by Telegram, such as a
+	// lower-resolution H.264 version of a video.
+	Alternatives []DocumentFile
+}
+
+// QualityLabel returns a short human-readable label describing the resolution of the file.
+func (f *DocumentFile) QualityLabel() string {
+	if f.VideoAttr.H > 0 {
+		return strconv.Itoa(f.VideoAttr.H) + "p"
+	}
+	return "original"
+}
+
+// Variant returns the file itself or one of its alternatives by index, where index 0 is the original.
+func (f *DocumentFile) Variant(index int) (*DocumentFile, bool) {
+	if index == 0 {
+		return f, true
+	}
+	if index < 0 || index > len(f.Alternatives) {
+		return nil, false
+	}
+	return &f.Alternatives[index-1], true
 }
 
 type FileMetadata struct {
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
 	"webBridgeBot/internal/types"
 )
 
@@ -23,6 +27,30 @@ func GetShortHash(fullHash string, hashLength int) string {
 	return fullHash[:hashLength]
 }
 
+// CheckHash reports whether the input hash matches the shortened expected hash, in constant time.
 func CheckHash(inputHash string, expectedHash string, hashLength int) bool {
-	return inputHash == GetShortHash(expectedHash, hashLength)
+	return hmac.Equal([]byte(inputHash), []byte(GetShortHash(expectedHash, hashLength)))
+}
+
+// GenerateToken derives a token bound to the given value from a server-side secret.
+func GenerateToken(secret string, value string, hashLength int) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return GetShortHash(hex.EncodeToString(mac.Sum(nil)), hashLength)
+}
+
+// CheckToken reports whether the given token matches the token derived for the value.
+func CheckToken(token string, secret string, value string, hashLength int) bool {
+	expected := GenerateToken(secret, value, hashLength)
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// GenerateChatToken derives a token bound to the given chat ID from a server-side secret.
+func GenerateChatToken(secret string, chatID int64, hashLength int) string {
+	return GenerateToken(secret, strconv.FormatInt(chatID, 10), hashLength)
+}
+
+// CheckChatToken reports whether the given token matches the token derived for the chat ID.
+func CheckChatToken(token string, secret string, chatID int64, hashLength int) bool {
+	return CheckToken(token, secret, strconv.FormatInt(chatID, 10), hashLength)
 }
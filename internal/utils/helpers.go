@@ -54,32 +54,19 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.DocumentFile, error) {
 		if !ok {
 			return nil, fmt.Errorf("unexpected type %T", media)
 		}
-		var fileName string
-		for _, attribute := range document.Attributes {
-			if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
-				fileName = name.FileName
-				break
-			}
-		}
+		file := fileFromDocument(document)
 
-		var videoAttr tg.DocumentAttributeVideo
-		for _, attribute := range document.Attributes {
-			if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
-				fileName = name.FileName
-			}
-			if documentAttributeVideo, ok := attribute.(*tg.DocumentAttributeVideo); ok {
-				videoAttr = *documentAttributeVideo
+		if alt, ok := media.GetAltDocument(); ok {
+			if altDocument, ok := alt.AsNotEmpty(); ok {
+				altFile := fileFromDocument(altDocument)
+				if altFile.FileName == "" {
+					altFile.FileName = file.FileName
+				}
+				file.Alternatives = append(file.Alternatives, *altFile)
 			}
 		}
 
-		return &types.DocumentFile{
-			Location:  document.AsInputDocumentFileLocation(),
-			FileSize:  document.Size,
-			FileName:  fileName,
-			MimeType:  document.MimeType,
-			ID:        document.ID,
-			VideoAttr: videoAttr,
-		}, nil
+		return file, nil
 
 	case *tg.MessageMediaPhoto:
 		// TODO: add photo support
@@ -88,13 +75,84 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.DocumentFile, error) {
 	return nil, fmt.Errorf("unexpected type %T", media)
 }
 
+// fileFromDocument extracts the file details of a Telegram document.
+func fileFromDocument(document *tg.Document) *types.DocumentFile {
+	var fileName string
+	var videoAttr tg.DocumentAttributeVideo
+	var audioAttr tg.DocumentAttributeAudio
+	for _, attribute := range document.Attributes {
+		if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
+			fileName = name.FileName
+		}
+		if documentAttributeVideo, ok := attribute.(*tg.DocumentAttributeVideo); ok {
+			videoAttr = *documentAttributeVideo
+		}
+		if documentAttributeAudio, ok := attribute.(*tg.DocumentAttributeAudio); ok {
+			audioAttr = *documentAttributeAudio
+		}
+	}
+
+	return &types.DocumentFile{
+		Location:  document.AsInputDocumentFileLocation(),
+		FileSize:  document.Size,
+		FileName:  fileName,
+		MimeType:  document.MimeType,
+		ID:        document.ID,
+		VideoAttr: videoAttr,
+		AudioAttr: audioAttr,
+		ThumbSize: largestThumbSize(document.Thumbs),
+	}
+}
+
+// largestThumbSize returns the type of the largest downloadable thumbnail. Stripped and path
+// sizes are embedded in the document and cannot be fetched on their own, so they are skipped.
+func largestThumbSize(thumbs []tg.PhotoSizeClass) string {
+	var thumbType string
+	var largest int
+	for _, thumb := range thumbs {
+		switch size := thumb.(type) {
+		case *tg.PhotoSize:
+			if size.W*size.H > largest {
+				thumbType, largest = size.Type, size.W*size.H
+			}
+		case *tg.PhotoSizeProgressive:
+			if size.W*size.H > largest {
+				thumbType, largest = size.Type, size.W*size.H
+			}
+		}
+	}
+	return thumbType
+}
+
+// FileFromMessage returns the file contained in a message. Files are cached in the database once
+// per Telegram document, so the same file sent in several messages shares a single record, and
+// looked up again once stale. If that fails, the stale file is returned.
 func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.DocumentFile, error) {
-	key := fmt.Sprintf("file:%d:%d", messageID, client.Self.ID)
-	var cachedMedia types.DocumentFile
-	err := cache.GetCache().Get(key, &cachedMedia)
-	if err == nil {
-		return &cachedMedia, nil
+	cached, fresh, err := cache.GetCache().MessageFile(client.Self.ID, messageID)
+	if err == nil && fresh {
+		return cached, nil
+	}
+	file, refreshErr := RefreshFileFromMessage(ctx, client, messageID)
+	if refreshErr != nil && err == nil {
+		return cached, nil
 	}
+	return file, refreshErr
+	// TODO: add photo support
+}
+
+// StoredFileFromMessage returns the stored file of a message even if it is stale, and only looks
+// it up like FileFromMessage if none is stored. It suits streaming, which refreshes expired file
+// references itself, so chunks cached before a restart are served without asking Telegram.
+func StoredFileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.DocumentFile, error) {
+	if cached, _, err := cache.GetCache().MessageFile(client.Self.ID, messageID); err == nil {
+		return cached, nil
+	}
+	return FileFromMessage(ctx, client, messageID)
+}
+
+// RefreshFileFromMessage looks up the file of a message in Telegram and caches it, such as when
+// the file reference of the cached file expired.
+func RefreshFileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.DocumentFile, error) {
 	message, err := GetMessage(ctx, client, messageID)
 	if err != nil {
 		return nil, err
@@ -103,16 +161,10 @@ func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID in
 	if err != nil {
 		return nil, err
 	}
-	err = cache.GetCache().Set(
-		key,
-		file,
-		3600,
-	)
-	if err != nil {
+	if err := cache.GetCache().StoreMessageFile(client.Self.ID, messageID, file); err != nil {
 		return nil, err
 	}
 	return file, nil
-	// TODO: add photo support
 }
 
 func ForwardMessages(ctx *ext.Context, fromChatId, logChannelID int64, messageID int) (*tg.Updates, error) {
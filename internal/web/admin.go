@@ -0,0 +1,244 @@
+package web
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"webBridgeBot/internal/data"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	adminTmplPath      = "templates/admin.html"
+	adminLoginTmplPath = "templates/admin_login.html"
+
+	adminSessionCookie = "webbridgebot_admin"
+	// How old the data of the login widget may be when it reaches the auth URL.
+	adminLoginMaxAge = 10 * time.Minute
+)
+
+var (
+	errOwnAccount    = errors.New("admins cannot remove their own rights")
+	errUnknownAction = errors.New("unknown action")
+)
+
+// AdminPanelConfig configures an AdminPanel.
+type AdminPanelConfig struct {
+	// BotToken verifies logins; BotUsername is shown in the login widget.
+	BotToken    string
+	BotUsername string
+	// BaseURL is the public URL of the bot, BasePath its path with a trailing slash.
+	BaseURL    string
+	BasePath   string
+	SessionTTL time.Duration
+	// SessionKey signs the session cookies.
+	SessionKey []byte
+}
+
+// AdminPanel serves /admin, where admins sign in with the Telegram Login Widget to list,
+// authorize, deauthorize, promote, demote and delete users, and close their streams.
+type AdminPanel struct {
+	cfg      AdminPanelConfig
+	users    *data.UserRepository
+	streams  *StreamRegistry
+	sessions *SessionManager
+	logger   *log.Logger
+}
+
+// NewAdminPanel creates the admin panel for the users of a repository and their streams.
+func NewAdminPanel(cfg AdminPanelConfig, users *data.UserRepository, streams *StreamRegistry, logger *log.Logger) *AdminPanel {
+	return &AdminPanel{
+		cfg:     cfg,
+		users:   users,
+		streams: streams,
+		sessions: NewSessionManager(cfg.SessionKey, SessionConfig{
+			CookieName: adminSessionCookie,
+			Path:       cfg.BasePath + "admin",
+			TTL:        cfg.SessionTTL,
+			Secure:     strings.HasPrefix(cfg.BaseURL, "https://"),
+		}),
+		logger: logger,
+	}
+}
+
+// Register adds the routes of the panel to a server, each wrapped by the given middleware.
+func (p *AdminPanel) Register(server *Server, middleware ...Middleware) {
+	server.HandleFunc("/admin", p.handleUsers, middleware...).Methods(http.MethodGet)
+	server.HandleFunc("/admin/login", p.handleLogin, middleware...).Methods(http.MethodGet)
+	server.HandleFunc("/admin/auth", p.handleAuth, middleware...).Methods(http.MethodGet)
+	server.HandleFunc("/admin/logout", p.handleLogout, middleware...).Methods(http.MethodPost)
+	server.HandleFunc("/admin/users/{userID:[0-9]+}/{action}", p.handleUserAction, middleware...).Methods(http.MethodPost)
+}
+
+// admin returns the signed-in admin of a request and their session. Admin rights are checked on
+// every request, so revoking them takes effect before the session expires.
+func (p *AdminPanel) admin(r *http.Request) (*data.User, Session, bool) {
+	session, err := p.sessions.Get(r)
+	if err != nil {
+		return nil, Session{}, false
+	}
+	user, err := p.users.GetUserInfo(session.UserID)
+	if err != nil || !user.IsAdmin {
+		return nil, Session{}, false
+	}
+	return user, session, true
+}
+
+func (p *AdminPanel) redirect(w http.ResponseWriter, r *http.Request, path string) {
+	http.Redirect(w, r, p.cfg.BasePath+path, http.StatusSeeOther)
+}
+
+func (p *AdminPanel) render(w http.ResponseWriter, tmplPath string, values map[string]interface{}) {
+	t, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		p.logger.Printf("Error loading template: %v", err)
+		Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+	values["BasePath"] = p.cfg.BasePath
+	if err := t.Execute(w, values); err != nil {
+		p.logger.Printf("Error rendering template: %v", err)
+		Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+func (p *AdminPanel) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := p.admin(r); ok {
+		p.redirect(w, r, "admin")
+		return
+	}
+	p.render(w, adminLoginTmplPath, map[string]interface{}{
+		"BotUsername": p.cfg.BotUsername,
+		"AuthURL":     p.cfg.BaseURL + "/admin/auth",
+		"Error":       r.URL.Query().Get("error"),
+	})
+}
+
+// handleAuth is the auth URL of the login widget, which Telegram redirects to with the signed
+// data of the user.
+func (p *AdminPanel) handleAuth(w http.ResponseWriter, r *http.Request) {
+	login, err := VerifyTelegramLogin(r.URL.Query(), p.cfg.BotToken, adminLoginMaxAge)
+	if err != nil {
+		p.logger.Printf("Rejected admin login: %v", err)
+		p.redirect(w, r, "admin/login?error=invalid")
+		return
+	}
+	user, err := p.users.GetUserInfo(login.ID)
+	if err != nil || !user.IsAdmin {
+		p.logger.Printf("Rejected admin login of user %d, who is not an admin", login.ID)
+		p.redirect(w, r, "admin/login?error=forbidden")
+		return
+	}
+
+	p.sessions.Create(w, login.ID)
+	p.logger.Printf("Admin %d signed in to the admin panel", login.ID)
+	p.redirect(w, r, "admin")
+}
+
+func (p *AdminPanel) handleLogout(w http.ResponseWriter, r *http.Request) {
+	// Without the token, another site could sign the admin out.
+	if session, err := p.sessions.Get(r); err == nil {
+		if err := p.sessions.CheckCSRF(r, session); err != nil {
+			Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+	}
+	p.sessions.Clear(w)
+	p.redirect(w, r, "admin/login")
+}
+
+func (p *AdminPanel) handleUsers(w http.ResponseWriter, r *http.Request) {
+	admin, session, ok := p.admin(r)
+	if !ok {
+		p.redirect(w, r, "admin/login")
+		return
+	}
+	users, err := p.users.ListUsers()
+	if err != nil {
+		p.logger.Printf("Failed to list users: %v", err)
+		Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	activeStreams := make(map[int64]int)
+	for _, user := range users {
+		if n := p.streams.Count(user.UserID); n > 0 {
+			activeStreams[user.UserID] = n
+		}
+	}
+	p.render(w, adminTmplPath, map[string]interface{}{
+		"Admin":         admin,
+		"Users":         users,
+		"ActiveStreams": activeStreams,
+		"CSRFField":     CSRFField,
+		"CSRFToken":     p.sessions.CSRFToken(session),
+	})
+}
+
+// handleUserAction applies an action of the panel to a user.
+func (p *AdminPanel) handleUserAction(w http.ResponseWriter, r *http.Request) {
+	admin, session, ok := p.admin(r)
+	if !ok {
+		Error(w, "Not signed in as an admin", http.StatusForbidden)
+		return
+	}
+	if err := p.sessions.CheckCSRF(r, session); err != nil {
+		Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["userID"], 10, 64)
+	if err != nil {
+		Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, err := p.users.GetUserInfo(userID)
+	if err != nil {
+		Error(w, "Unknown user", http.StatusNotFound)
+		return
+	}
+
+	action := vars["action"]
+	err = p.applyAction(admin, user, action)
+	switch {
+	case errors.Is(err, errOwnAccount), errors.Is(err, errUnknownAction):
+		Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		p.logger.Printf("Failed to %s user %d: %v", action, userID, err)
+		Error(w, "Failed to update the user", http.StatusInternalServerError)
+		return
+	}
+	p.logger.Printf("Admin %d applied %s to user %d in the admin panel", admin.UserID, action, userID)
+	p.redirect(w, r, "admin")
+}
+
+func (p *AdminPanel) applyAction(admin, user *data.User, action string) error {
+	// An admin locking themselves out would leave nobody to undo it.
+	if user.UserID == admin.UserID && action != "authorize" && action != "promote" && action != "killstreams" {
+		return errOwnAccount
+	}
+	switch action {
+	case "killstreams":
+		closed := p.streams.Cancel(user.UserID)
+		p.logger.Printf("Closed %d streams and connections of user %d", closed, user.UserID)
+		return nil
+	case "authorize":
+		return p.users.AuthorizeUser(user.UserID, user.IsAdmin)
+	case "deauthorize":
+		return p.users.DeauthorizeUser(user.UserID)
+	case "promote":
+		return p.users.AuthorizeUser(user.UserID, true)
+	case "demote":
+		return p.users.AuthorizeUser(user.UserID, false)
+	case "delete":
+		return p.users.DeleteUser(user.UserID)
+	default:
+		return errUnknownAction
+	}
+}
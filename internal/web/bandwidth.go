@@ -0,0 +1,107 @@
+package web
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunkSize is the most a throttled writer writes at once, so a limit shared by many
+// streams is handed out in small portions.
+const throttleChunkSize = 32 * 1024
+
+// Bandwidth is a token bucket limiting the bytes per second written through it, shared by every
+// writer it throttles. A second's worth of bytes can be written at once after a pause.
+type Bandwidth struct {
+	perSecond float64
+	burst     float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidth creates a limit of bytesPerSecond. It returns nil, which does not limit, if
+// bytesPerSecond is zero or less.
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(bytesPerSecond)
+	if burst < throttleChunkSize {
+		burst = throttleChunkSize
+	}
+	return &Bandwidth{perSecond: float64(bytesPerSecond), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait before writing them. The
+// bucket may go into debt, so waiting writers are served in the order they reserved.
+func (b *Bandwidth) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.perSecond * float64(time.Second))
+}
+
+// ThrottledWriter returns a writer that writes to w no faster than each of the limits allows.
+// Nil limits are ignored. Writes stop with the error of ctx once it is done.
+func ThrottledWriter(ctx context.Context, w io.Writer, limits ...*Bandwidth) io.Writer {
+	var active []*Bandwidth
+	for _, limit := range limits {
+		if limit != nil {
+			active = append(active, limit)
+		}
+	}
+	if len(active) == 0 {
+		return w
+	}
+	return &throttledWriter{ctx: ctx, w: w, limits: active}
+}
+
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	limits []*Bandwidth
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunkSize {
+			chunk = chunk[:throttleChunkSize]
+		}
+		var wait time.Duration
+		for _, limit := range tw.limits {
+			if d := limit.reserve(len(chunk)); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-tw.ctx.Done():
+				timer.Stop()
+				return written, tw.ctx.Err()
+			}
+		}
+		n, err := tw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	// The first 64 KB are the burst; the next 32 KB take half a second at 64 KB/s.
+	limit := NewBandwidth(64 * 1024)
+	var out bytes.Buffer
+	w := ThrottledWriter(context.Background(), &out, limit, nil)
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 96*1024))
+	elapsed := time.Since(start)
+	if err != nil || n != 96*1024 || out.Len() != n {
+		t.Fatalf("Write = %d, %v; wrote %d bytes", n, err, out.Len())
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Writing 96 KB at 64 KB/s took %s, want about 500ms", elapsed)
+	}
+
+	// A cancelled request stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = ThrottledWriter(ctx, &out, limit)
+	if _, err := w.Write(make([]byte, 64*1024)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the write to stop with the context, got %v", err)
+	}
+
+	if NewBandwidth(0) != nil {
+		t.Error("Expected no limit for a bandwidth of 0")
+	}
+	if w := ThrottledWriter(context.Background(), &out, nil); w != &out {
+		t.Error("Expected the writer itself without limits")
+	}
+}
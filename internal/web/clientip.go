@@ -0,0 +1,108 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+// believed. A nil *TrustedProxies trusts no one.
+type TrustedProxies struct {
+	all  bool
+	nets []*net.IPNet
+}
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges, such as
+// "127.0.0.1,10.0.0.0/8". "*" trusts every peer, which is only safe if the server cannot be
+// reached other than through the proxy. An empty list returns nil.
+func ParseTrustedProxies(list string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			p.all = true
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range %q: %w", entry, err)
+			}
+			p.nets = append(p.nets, ipNet)
+		default:
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	if !p.all && len(p.nets) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// Trusts reports whether addr is a trusted proxy.
+func (p *TrustedProxies) Trusts(addr string) bool {
+	if p == nil {
+		return false
+	}
+	if p.all {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client. Forwarding headers are only used if the request
+// comes from a trusted proxy. X-Forwarded-For is read from the right, skipping the trusted
+// proxies the request passed through, since clients can put anything at its start. When every
+// peer is trusted, no hop can be told from a spoofed one, so the rightmost entry is used, which
+// is the one added by the proxy in front of the server.
+func ClientIP(r *http.Request, proxies *TrustedProxies) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !proxies.Trusts(peer) {
+		return peer
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			client = hop
+			if proxies.all || !proxies.Trusts(hop) {
+				break
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
@@ -0,0 +1,193 @@
+package web
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings Compress can use.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// encoder is a compressor that can be reused for another response.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools keep the encoders of finished responses, which are costly to allocate, by coding.
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any { return gzip.NewWriter(nil) }},
+	EncodingZstd: {New: func() any {
+		// A single goroutine per response, and the smallest window browsers have to support.
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	}},
+}
+
+// ParseCompression parses a comma-separated list of content codings, such as "zstd,gzip", in
+// the order they are preferred when a client accepts several equally. "none" or an empty list
+// disables compression.
+func ParseCompression(list string) ([]string, error) {
+	var encodings []string
+	for _, coding := range strings.Split(list, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch {
+		case coding == "" || coding == "none":
+			continue
+		case encoderPools[coding] == nil:
+			return nil, fmt.Errorf("unsupported compression %q, expected %s or %s", coding, EncodingZstd, EncodingGzip)
+		}
+		encodings = append(encodings, coding)
+	}
+	return encodings, nil
+}
+
+// compressibleTypes are the content types Compress compresses besides text. Media is compressed
+// already and served in ranges, so it is left alone.
+var compressibleTypes = map[string]bool{
+	"application/javascript":        true,
+	"application/json":              true,
+	"application/manifest+json":     true,
+	"application/vnd.apple.mpegurl": true,
+	"image/svg+xml":                 true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// compressWriter compresses the response once its headers show it is worth it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         encoder
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if status == http.StatusOK && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.enc = encoderPools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() error {
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	// The encoder must not keep the response alive in the pool.
+	w.enc.Reset(nil)
+	encoderPools[w.encoding].Put(w.enc)
+	w.enc = nil
+	if err != nil {
+		return fmt.Errorf("failed to finish %s stream: %w", w.encoding, err)
+	}
+	return nil
+}
+
+// Compress compresses text, JSON, HLS playlist and SVG responses with the first of encodings
+// that the client accepts best. Without encodings, responses are sent as they are.
+func Compress(encodings []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(encodings) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), encodings)
+			if r.Method == http.MethodHead || encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding returns the coding of encodings with the highest weight in an
+// Accept-Encoding header, the earlier one on a tie, or "" if the client accepts none of them.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = q
+			}
+		}
+		weights[coding] = weight
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range encodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestGzip(t *testing.T) {
+	body := strings.Repeat("<p>player</p>", 100)
+	handler := Compress([]string{EncodingGzip})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/media" {
+			w.Header().Set("Content-Type", "video/mp4")
+		}
+		io.WriteString(w, body)
+	}))
+
+	request := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := request("/page", "br, gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a compressed page, got headers %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip stream: %v", err)
+	}
+	if data, err := io.ReadAll(gz); err != nil || string(data) != body {
+		t.Errorf("Unexpected decompressed body: %v", err)
+	}
+
+	// Media, and clients that do not accept gzip, get the response as it is.
+	if rec := request("/media", "gzip"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body {
+		t.Errorf("Expected media not to be compressed")
+	}
+	if rec := request("/page", "gzip;q=0"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body {
+		t.Errorf("Expected no compression for a client that refuses gzip")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"title":"player"}`, 100)
+	handler := Compress([]string{EncodingZstd, EncodingGzip})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	// The encoders are reused, so every response must decode on its own.
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Header().Get("Content-Encoding") != EncodingZstd {
+			t.Fatalf("Expected a zstd response, got headers %v", rec.Header())
+		}
+		dec, err := zstd.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(dec)
+		dec.Close()
+		if err != nil || string(data) != body {
+			t.Errorf("Unexpected decompressed body: %v", err)
+		}
+	}
+
+	if handler := Compress(nil)(http.NotFoundHandler()); handler == nil {
+		t.Error("Expected a handler without encodings")
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	both := []string{EncodingZstd, EncodingGzip}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", EncodingGzip},
+		{"gzip, zstd", EncodingZstd},
+		{"zstd;q=0.5, gzip", EncodingGzip},
+		{"zstd;q=0, gzip;q=0", ""},
+		{"*", EncodingZstd},
+		{"*;q=0.1, zstd;q=0", EncodingGzip},
+		{"br, identity", ""},
+		{"GZIP ; Q=0.8", EncodingGzip},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, both); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	if got, err := ParseCompression(" Zstd , gzip "); err != nil || strings.Join(got, ",") != "zstd,gzip" {
+		t.Errorf("ParseCompression = %v, %v", got, err)
+	}
+	if got, err := ParseCompression("none"); err != nil || len(got) != 0 {
+		t.Errorf("Expected none to disable compression, got %v, %v", got, err)
+	}
+	if _, err := ParseCompression("br"); err == nil {
+		t.Error("Expected an unsupported coding to be rejected")
+	}
+}
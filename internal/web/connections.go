@@ -0,0 +1,233 @@
+package web
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFinishedConnections is how many ended connections are kept for the dashboard.
+const maxFinishedConnections = 50
+
+// Connection states.
+const (
+	ConnectionActive       = "active"
+	ConnectionCompleted    = "completed"
+	ConnectionDisconnected = "disconnected"
+)
+
+// Connection is a stream response being sent to a client.
+type Connection struct {
+	ID           uint64    `json:"id"`
+	MessageID    int       `json:"messageId"`
+	UserID       int64     `json:"userId,omitempty"` // Owner of the media, if known
+	ClientIP     string    `json:"clientIp"`
+	UserAgent    string    `json:"userAgent"`
+	RangeStart   int64     `json:"rangeStart"`
+	RangeEnd     int64     `json:"rangeEnd"`
+	BytesSent    int64     `json:"bytesSent"`
+	State        string    `json:"state"`
+	StartedAt    time.Time `json:"startedAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	// Reconnects counts how often the client came back for the same media shortly after a
+	// connection ended, as players do when seeking or after a network drop. The ended
+	// connections are coalesced into this one.
+	Reconnects int `json:"reconnects"`
+}
+
+// ConnectionStats is a snapshot of the tracked connections.
+type ConnectionStats struct {
+	Active        []Connection `json:"active"`
+	Finished      []Connection `json:"finished"` // Most recently ended first
+	TotalBytes    int64        `json:"totalBytes"`
+	Completed     int64        `json:"completed"`
+	Disconnected  int64        `json:"disconnected"`
+	Reconnections int64        `json:"reconnections"`
+}
+
+// ConnectionTracker keeps track of the stream connections for monitoring.
+type ConnectionTracker struct {
+	reconnectWindow time.Duration
+
+	mu           sync.Mutex
+	nextID       uint64
+	active       map[uint64]*Connection
+	finished     []Connection
+	totalBytes   int64
+	completed    int64
+	disconnected int64
+	reconnects   int64
+}
+
+// NewConnectionTracker creates a tracker that considers a connection a reconnect if the same
+// client requested the same media within reconnectWindow.
+func NewConnectionTracker(reconnectWindow time.Duration) *ConnectionTracker {
+	return &ConnectionTracker{
+		reconnectWindow: reconnectWindow,
+		active:          make(map[uint64]*Connection),
+	}
+}
+
+// RegisterConnection starts tracking a connection and returns its ID.
+func (t *ConnectionTracker) RegisterConnection(messageID int, clientIP, userAgent string, start, end int64) uint64 {
+	id, _ := t.RegisterUserConnection(0, 0, messageID, clientIP, userAgent, start, end)
+	return id
+}
+
+// RegisterUserConnection starts tracking a connection to media of a user, unless the user has
+// limit streams active already, and returns its ID. Connections of the same client to the same
+// media count as one stream, as players open a new one when seeking. A limit of zero or less is
+// unlimited.
+func (t *ConnectionTracker) RegisterUserConnection(userID int64, limit int, messageID int, clientIP, userAgent string, start, end int64) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if userID != 0 && limit > 0 && t.userStreams(userID, messageID, clientIP) >= limit {
+		return 0, false
+	}
+
+	now := time.Now()
+	t.nextID++
+	c := &Connection{
+		ID:           t.nextID,
+		MessageID:    messageID,
+		UserID:       userID,
+		ClientIP:     clientIP,
+		UserAgent:    userAgent,
+		RangeStart:   start,
+		RangeEnd:     end,
+		State:        ConnectionActive,
+		StartedAt:    now,
+		LastActivity: now,
+	}
+	if t.recentConnection(messageID, clientIP, now) {
+		c.Reconnects = 1
+		t.reconnects++
+		if i := t.recentlyFinished(messageID, clientIP, now); i >= 0 {
+			c.Reconnects += t.finished[i].Reconnects
+			t.finished = append(t.finished[:i], t.finished[i+1:]...)
+		}
+	}
+	t.active[c.ID] = c
+	return c.ID, true
+}
+
+// userStreams counts the active streams of a user's media, leaving out those of the given client
+// and media. It must be called with t.mu held.
+func (t *ConnectionTracker) userStreams(userID int64, messageID int, clientIP string) int {
+	streams := make(map[string]bool)
+	for _, c := range t.active {
+		if c.UserID != userID || (c.MessageID == messageID && c.ClientIP == clientIP) {
+			continue
+		}
+		streams[fmt.Sprintf("%d/%s", c.MessageID, c.ClientIP)] = true
+	}
+	return len(streams)
+}
+
+// UserConnections returns the active connections to media of a user, oldest first.
+func (t *ConnectionTracker) UserConnections(userID int64) []Connection {
+	var connections []Connection
+	for _, c := range t.Stats().Active {
+		if c.UserID == userID {
+			connections = append(connections, c)
+		}
+	}
+	return connections
+}
+
+// DetectReconnection reports whether the client has, or just had, a connection for the media.
+func (t *ConnectionTracker) DetectReconnection(messageID int, clientIP string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.recentConnection(messageID, clientIP, time.Now())
+}
+
+// recentConnection must be called with t.mu held.
+func (t *ConnectionTracker) recentConnection(messageID int, clientIP string, now time.Time) bool {
+	for _, c := range t.active {
+		if c.MessageID == messageID && c.ClientIP == clientIP {
+			return true
+		}
+	}
+	return t.recentlyFinished(messageID, clientIP, now) >= 0
+}
+
+// recentlyFinished returns the index of the client's last connection for the media if it ended
+// within the reconnect window, or -1. It must be called with t.mu held.
+func (t *ConnectionTracker) recentlyFinished(messageID int, clientIP string, now time.Time) int {
+	for i, c := range t.finished {
+		if now.Sub(c.LastActivity) > t.reconnectWindow {
+			break
+		}
+		if c.MessageID == messageID && c.ClientIP == clientIP {
+			return i
+		}
+	}
+	return -1
+}
+
+// UpdateActivity adds bytes sent on a connection.
+func (t *ConnectionTracker) UpdateActivity(id uint64, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.active[id]; ok {
+		c.BytesSent += bytes
+		c.LastActivity = time.Now()
+		t.totalBytes += bytes
+	}
+}
+
+// MarkCompleted ends a connection whose response was sent in full.
+func (t *ConnectionTracker) MarkCompleted(id uint64) {
+	t.finish(id, ConnectionCompleted)
+}
+
+// MarkDisconnected ends a connection that the client or an error cut short.
+func (t *ConnectionTracker) MarkDisconnected(id uint64) {
+	t.finish(id, ConnectionDisconnected)
+}
+
+func (t *ConnectionTracker) finish(id uint64, state string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.active[id]
+	if !ok {
+		return
+	}
+	delete(t.active, id)
+	c.State = state
+	c.LastActivity = time.Now()
+	if state == ConnectionCompleted {
+		t.completed++
+	} else {
+		t.disconnected++
+	}
+
+	t.finished = append([]Connection{*c}, t.finished...)
+	if len(t.finished) > maxFinishedConnections {
+		t.finished = t.finished[:maxFinishedConnections]
+	}
+}
+
+// Stats returns a snapshot of the connections, active ones oldest first.
+func (t *ConnectionTracker) Stats() ConnectionStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := ConnectionStats{
+		Active:        make([]Connection, 0, len(t.active)),
+		Finished:      append([]Connection{}, t.finished...),
+		TotalBytes:    t.totalBytes,
+		Completed:     t.completed,
+		Disconnected:  t.disconnected,
+		Reconnections: t.reconnects,
+	}
+	for _, c := range t.active {
+		stats.Active = append(stats.Active, *c)
+	}
+	sort.Slice(stats.Active, func(i, j int) bool { return stats.Active[i].ID < stats.Active[j].ID })
+	return stats
+}
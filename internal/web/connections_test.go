@@ -0,0 +1,69 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionTracker(t *testing.T) {
+	tracker := NewConnectionTracker(time.Minute)
+
+	first := tracker.RegisterConnection(7, "10.0.0.1", "player", 0, 99)
+	tracker.UpdateActivity(first, 40)
+	tracker.MarkDisconnected(first)
+
+	// The same client resuming the same media is a reconnect; another client is not.
+	if !tracker.DetectReconnection(7, "10.0.0.1") {
+		t.Errorf("Expected a reconnection to be detected")
+	}
+	second := tracker.RegisterConnection(7, "10.0.0.1", "player", 40, 99)
+	other := tracker.RegisterConnection(7, "10.0.0.2", "player", 0, 99)
+	tracker.UpdateActivity(second, 60)
+	tracker.MarkCompleted(second)
+
+	// A second reconnect is coalesced with the connections before it.
+	third := tracker.RegisterConnection(7, "10.0.0.1", "player", 0, 99)
+	tracker.MarkDisconnected(third)
+
+	stats := tracker.Stats()
+	if len(stats.Active) != 1 || stats.Active[0].ID != other || stats.Active[0].Reconnects != 0 {
+		t.Errorf("Unexpected active connections: %+v", stats.Active)
+	}
+	if len(stats.Finished) != 1 || stats.Finished[0].ID != third || stats.Finished[0].Reconnects != 2 {
+		t.Errorf("Unexpected finished connections: %+v", stats.Finished)
+	}
+	if stats.TotalBytes != 100 || stats.Completed != 1 || stats.Disconnected != 2 || stats.Reconnections != 2 {
+		t.Errorf("Unexpected totals: %+v", stats)
+	}
+}
+
+func TestConnectionTracker_UserLimit(t *testing.T) {
+	tracker := NewConnectionTracker(time.Minute)
+	first, ok := tracker.RegisterUserConnection(1, 2, 7, "10.0.0.1", "player", 0, 99)
+	if !ok {
+		t.Fatal("Expected the first stream to be allowed")
+	}
+	// A seek by the same client opens another connection to the same media, which is no new stream.
+	if _, ok := tracker.RegisterUserConnection(1, 2, 7, "10.0.0.1", "player", 50, 99); !ok {
+		t.Error("Expected a seek to be allowed")
+	}
+	if _, ok := tracker.RegisterUserConnection(1, 2, 8, "10.0.0.1", "player", 0, 99); !ok {
+		t.Error("Expected the second stream to be allowed")
+	}
+	if _, ok := tracker.RegisterUserConnection(1, 2, 7, "10.0.0.2", "player", 0, 99); ok {
+		t.Error("Expected a third stream to be refused")
+	}
+	// Other users have their own limit.
+	if _, ok := tracker.RegisterUserConnection(2, 2, 9, "10.0.0.2", "player", 0, 99); !ok {
+		t.Error("Expected another user's stream to be allowed")
+	}
+
+	if got := len(tracker.UserConnections(1)); got != 3 {
+		t.Errorf("UserConnections = %d connections, want 3", got)
+	}
+
+	tracker.MarkDisconnected(first)
+	if _, ok := tracker.RegisterUserConnection(1, 2, 10, "10.0.0.3", "player", 0, 99); ok {
+		t.Error("Expected streams still open after the first one ended to count")
+	}
+}
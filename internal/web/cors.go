@@ -0,0 +1,144 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers pages of other sites may read, which players
+// need to seek.
+const corsExposedHeaders = "Content-Length, Content-Range, Accept-Ranges"
+
+// CORSPolicy is the set of other sites whose pages may read the responses of a route, such as
+// sites embedding the player, and open WebSockets. A nil *CORSPolicy allows no other site.
+type CORSPolicy struct {
+	all     bool
+	origins map[string]bool
+	headers string
+}
+
+// ParseCORSPolicy parses a comma-separated list of origins, such as
+// "https://example.com,http://localhost:3000", and of the request headers their pages may send.
+// "*" allows every site. An empty list of origins returns nil.
+func ParseCORSPolicy(origins, headers string) (*CORSPolicy, error) {
+	p := &CORSPolicy{origins: make(map[string]bool)}
+	for _, entry := range strings.Split(origins, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			p.all = true
+		default:
+			origin, ok := normalizeOrigin(entry)
+			if !ok {
+				return nil, fmt.Errorf("invalid CORS origin %q, expected a scheme and host such as https://example.com", entry)
+			}
+			p.origins[origin] = true
+		}
+	}
+	if !p.all && len(p.origins) == 0 {
+		return nil, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(headers, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	p.headers = strings.Join(names, ", ")
+	return p, nil
+}
+
+// normalizeOrigin returns the scheme and host of an origin in lower case, and false if it is
+// not an http or https origin without a path.
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// Allows reports whether pages of origin may read the responses of routes using p.
+func (p *CORSPolicy) Allows(origin string) bool {
+	if p == nil {
+		return false
+	}
+	if p.all {
+		return true
+	}
+	origin, ok := normalizeOrigin(origin)
+	return ok && p.origins[origin]
+}
+
+// Middleware adds the CORS headers to the responses of a route for requests from allowed
+// origins and answers OPTIONS requests, which browsers send as preflight requests, itself.
+// Routes using it must accept OPTIONS.
+func (p *CORSPolicy) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			allowed := origin != "" && p.Allows(origin)
+			if allowed {
+				if p.all {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			}
+			if p != nil && !p.all {
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if r.Method == http.MethodOptions {
+				if allowed && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+					if p.headers != "" {
+						w.Header().Set("Access-Control-Allow-Headers", p.headers)
+					}
+					w.Header().Set("Access-Control-Max-Age", "600")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CheckOrigin reports whether a WebSocket may be opened by the page that sent r: a page of the
+// same host, of baseURL, the address the server is reached at, or of an allowed origin. Clients
+// other than browsers send no Origin and are accepted.
+func (p *CORSPolicy) CheckOrigin(r *http.Request, baseURL string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if base, ok := normalizeOrigin(baseOrigin(baseURL)); ok {
+		if normalized, ok := normalizeOrigin(origin); ok && normalized == base {
+			return true
+		}
+	}
+	return p.Allows(origin)
+}
+
+// baseOrigin returns the scheme and host of a URL, dropping its path.
+func baseOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
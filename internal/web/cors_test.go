@@ -0,0 +1,107 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCORSPolicy(t *testing.T) {
+	if p, err := ParseCORSPolicy(" , ", "Range"); p != nil || err != nil {
+		t.Errorf("Expected no policy for an empty list, got %v, %v", p, err)
+	}
+	for _, origin := range []string{"example.com", "ftp://example.com", "https://example.com/player"} {
+		if _, err := ParseCORSPolicy(origin, ""); err == nil {
+			t.Errorf("Expected %q to be rejected", origin)
+		}
+	}
+
+	p, err := ParseCORSPolicy("https://Example.com/, http://localhost:3000", "range, x-requested-with")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"https://example.com":   true,
+		"HTTPS://EXAMPLE.COM":   true,
+		"http://localhost:3000": true,
+		"http://example.com":    false,
+		"https://evil.com":      false,
+		"null":                  false,
+	} {
+		if got := p.Allows(origin); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", origin, got, want)
+		}
+	}
+	if p.headers != "Range, X-Requested-With" {
+		t.Errorf("headers = %q", p.headers)
+	}
+	if (*CORSPolicy)(nil).Allows("https://example.com") {
+		t.Error("Expected a nil policy to allow no origin")
+	}
+}
+
+func TestCORSPolicy_Middleware(t *testing.T) {
+	p, err := ParseCORSPolicy("https://example.com", "Range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	handler := p.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/1/abc", nil)
+		r.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "https://example.com")
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "https://example.com" || rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected an allowed GET to be served with CORS headers, got %v", rec.Header())
+	}
+	called = false
+	rec = request(http.MethodGet, "https://evil.com")
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected a GET from another origin to be served without CORS headers, got %v", rec.Header())
+	}
+
+	called = false
+	rec = request(http.MethodOptions, "https://example.com")
+	if called || rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Headers") != "Range" {
+		t.Errorf("Expected the preflight to be answered, got %d %v", rec.Code, rec.Header())
+	}
+	rec = request(http.MethodOptions, "https://evil.com")
+	if called || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("Expected the preflight of another origin to be refused, got %v", rec.Header())
+	}
+}
+
+func TestCORSPolicy_CheckOrigin(t *testing.T) {
+	p, err := ParseCORSPolicy("https://embed.example.org", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"":                          true,
+		"http://bot.internal:8080":  true,
+		"https://media.example.com": true,
+		"https://embed.example.org": true,
+		"https://evil.com":          false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://bot.internal:8080/ws/1", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := p.CheckOrigin(r, "https://media.example.com/bot"); got != want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", origin, got, want)
+		}
+		if got := (*CORSPolicy)(nil).CheckOrigin(r, "https://media.example.com/bot"); got != (want && origin != "https://embed.example.org") {
+			t.Errorf("CheckOrigin(%q) without a policy = %v", origin, got)
+		}
+	}
+}
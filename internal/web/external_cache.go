@@ -0,0 +1,257 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webBridgeBot/internal/reader"
+)
+
+// externalEntryChunkID holds the description of a cached file. Its data is stored under a
+// separate location, so a changed file does not mix with chunks of the old one.
+const externalEntryChunkID = 0
+
+// ErrNotCacheable is returned for external files the cache does not store: files without a
+// known size, from servers that do not support range requests, or larger than the limit.
+var ErrNotCacheable = errors.New("external file cannot be cached")
+
+// ExternalCacheConfig configures the external URL cache.
+type ExternalCacheConfig struct {
+	// ChunkSize is the size of the range requests made to the external server and of the
+	// cached chunks.
+	ChunkSize int64
+	// TTL is how long a cached file is served before the server is asked whether it changed.
+	TTL time.Duration
+	// MaxFileSize is the size of the largest file that is cached.
+	MaxFileSize int64
+}
+
+// ExternalCache keeps external media fetched by the proxy in the BinaryCache, so repeat plays
+// do not download it again. Files are fetched in chunks as they are read, so seeking only
+// downloads the part that is played.
+type ExternalCache struct {
+	client *http.Client
+	cache  *reader.BinaryCache
+	cfg    ExternalCacheConfig
+	logger *log.Logger
+}
+
+// NewExternalCache creates an external URL cache that fetches with client.
+func NewExternalCache(client *http.Client, cache *reader.BinaryCache, cfg ExternalCacheConfig, logger *log.Logger) *ExternalCache {
+	return &ExternalCache{client: client, cache: cache, cfg: cfg, logger: logger}
+}
+
+// externalEntry describes a cached external file.
+type externalEntry struct {
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	CheckedAt    time.Time `json:"checkedAt"`
+	// DataKey is the BinaryCache location of the file's chunks.
+	DataKey int64 `json:"dataKey"`
+}
+
+// externalCacheKey returns the BinaryCache location of the entry of a URL.
+func externalCacheKey(rawURL string) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "url:%s", rawURL)
+	return int64(h.Sum64() >> 1)
+}
+
+// externalDataKey returns the location of the chunks of one version of a file. Files without a
+// validator are keyed by the time they were fetched, so they are downloaded again after the TTL.
+func externalDataKey(rawURL string, e *externalEntry) int64 {
+	h := fnv.New64a()
+	if e.ETag == "" && e.LastModified == "" {
+		fmt.Fprintf(h, "url-data:%s\x00%d\x00%d", rawURL, e.Size, e.CheckedAt.UnixNano())
+	} else {
+		fmt.Fprintf(h, "url-data:%s\x00%d\x00%s\x00%s", rawURL, e.Size, e.ETag, e.LastModified)
+	}
+	return int64(h.Sum64() >> 1)
+}
+
+// Open returns the cached file of a URL. The description of the file is cached as well and
+// checked again with a HEAD request once it is older than the TTL; the data is only fetched
+// again if the file changed. It returns ErrNotCacheable for files the cache does not store.
+func (c *ExternalCache) Open(ctx context.Context, rawURL string) (*ExternalFile, error) {
+	key := externalCacheKey(rawURL)
+	var entry *externalEntry
+	if data, err := c.cache.GetChunk(key, externalEntryChunkID); err == nil {
+		var cached externalEntry
+		if err := json.Unmarshal(data, &cached); err == nil {
+			entry = &cached
+		}
+	}
+
+	if entry == nil || time.Since(entry.CheckedAt) > c.cfg.TTL {
+		fresh, err := c.head(ctx, rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && entry.ETag == fresh.ETag && entry.LastModified == fresh.LastModified &&
+			entry.Size == fresh.Size && (fresh.ETag != "" || fresh.LastModified != "") {
+			fresh.DataKey = entry.DataKey
+		} else {
+			fresh.DataKey = externalDataKey(rawURL, fresh)
+		}
+		entry = fresh
+
+		if data, err := json.Marshal(entry); err == nil {
+			if err := c.cache.PutChunk(key, externalEntryChunkID, data); err != nil {
+				c.logger.Printf("Failed to cache the description of %s: %v", rawURL, err)
+			}
+		}
+	}
+
+	return &ExternalFile{cache: c, url: rawURL, entry: entry, ctx: ctx}, nil
+}
+
+// head describes an external file from the response to a HEAD request.
+func (c *ExternalCache) head(ctx context.Context, rawURL string) (*externalEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD request returned %s", resp.Status)
+	}
+	if resp.ContentLength <= 0 || resp.ContentLength > c.cfg.MaxFileSize || !strings.Contains(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return nil, ErrNotCacheable
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		contentType = "application/octet-stream"
+	}
+	etag := resp.Header.Get("ETag")
+	if strings.HasPrefix(etag, "W/") {
+		// Weak validators cannot be used to combine ranges of the file.
+		etag = ""
+	}
+	return &externalEntry{
+		Size:         resp.ContentLength,
+		ContentType:  contentType,
+		ETag:         etag,
+		LastModified: resp.Header.Get("Last-Modified"),
+		CheckedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// ExternalFile reads a cached external file, downloading the chunks that are not cached yet.
+// It implements io.ReadSeeker for http.ServeContent.
+type ExternalFile struct {
+	cache  *ExternalCache
+	url    string
+	entry  *externalEntry
+	ctx    context.Context
+	offset int64
+}
+
+// Size returns the size of the file in bytes.
+func (f *ExternalFile) Size() int64 { return f.entry.Size }
+
+// ContentType returns the MIME type the external server gave the file.
+func (f *ExternalFile) ContentType() string { return f.entry.ContentType }
+
+// ETag returns the strong entity tag of the file, or an empty string if it has none.
+func (f *ExternalFile) ETag() string { return f.entry.ETag }
+
+// ModTime returns when the file was last modified, or the zero time if that is unknown.
+func (f *ExternalFile) ModTime() time.Time {
+	t, err := http.ParseTime(f.entry.LastModified)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Seek implements io.Seeker.
+func (f *ExternalFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Read implements io.Reader.
+func (f *ExternalFile) Read(p []byte) (int, error) {
+	if f.offset >= f.entry.Size {
+		return 0, io.EOF
+	}
+	chunkSize := f.cache.cfg.ChunkSize
+	index := f.offset / chunkSize
+	chunk, err := f.chunk(index)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, chunk[f.offset-index*chunkSize:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// chunk returns a chunk of the file from the cache, downloading and caching it if needed.
+func (f *ExternalFile) chunk(index int64) ([]byte, error) {
+	c := f.cache
+	start := index * c.cfg.ChunkSize
+	end := min(start+c.cfg.ChunkSize, f.entry.Size) - 1
+	if data, err := c.cache.GetChunk(f.entry.DataKey, index); err == nil && int64(len(data)) == end-start+1 {
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if validator := f.entry.ETag; validator != "" {
+		req.Header.Set("If-Range", validator)
+	} else if f.entry.LastModified != "" {
+		req.Header.Set("If-Range", f.entry.LastModified)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// A 200 answers a range request whose validator no longer matches: the file changed.
+		return nil, fmt.Errorf("range request for bytes %d-%d returned %s", start, end, resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(start, 10)+"-") {
+		return nil, fmt.Errorf("range request for bytes %d-%d returned range %q", start, end, resp.Header.Get("Content-Range"))
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to read bytes %d-%d: %w", start, end, err)
+	}
+	if err := c.cache.PutChunk(f.entry.DataKey, index, data); err != nil {
+		c.logger.Printf("Failed to cache bytes %d-%d of %s: %v", start, end, f.url, err)
+	}
+	return data, nil
+}
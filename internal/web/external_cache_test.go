@@ -0,0 +1,102 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"webBridgeBot/internal/reader"
+)
+
+func TestExternalCache(t *testing.T) {
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	etag := `"v1"`
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "media.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	binaryCache, err := reader.NewBinaryCache(t.TempDir(), 1<<20, 4096, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache := NewExternalCache(server.Client(), binaryCache, ExternalCacheConfig{
+		ChunkSize:   4096,
+		TTL:         time.Hour,
+		MaxFileSize: 1 << 20,
+	}, log.New(io.Discard, "", 0))
+
+	read := func(offset int64) []byte {
+		t.Helper()
+		file, err := cache.Open(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if file.Size() != int64(len(content)) || file.ETag() != etag {
+			t.Fatalf("Unexpected file: size %d, ETag %q", file.Size(), file.ETag())
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return data
+	}
+
+	// Reading from the middle fetches only the chunks from there on.
+	if data := read(5000); !bytes.Equal(data, content[5000:]) {
+		t.Errorf("Read from 5000 returned %d unexpected bytes", len(data))
+	}
+	if n := atomic.LoadInt32(&gets); n != 2 {
+		t.Errorf("Expected 2 range requests, got %d", n)
+	}
+
+	if data := read(0); !bytes.Equal(data, content) {
+		t.Errorf("Read from 0 returned %d unexpected bytes", len(data))
+	}
+	if n := atomic.LoadInt32(&gets); n != 3 {
+		t.Errorf("Expected only the first chunk to be fetched, got %d requests", n)
+	}
+
+	// Once the entry is stale and the file changed, its data is fetched again.
+	cache.cfg.TTL = -1
+	etag = `"v2"`
+	read(0)
+	if n := atomic.LoadInt32(&gets); n != 6 {
+		t.Errorf("Expected the changed file to be fetched again, got %d requests", n)
+	}
+}
+
+func TestExternalCache_NotCacheable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	binaryCache, err := reader.NewBinaryCache(t.TempDir(), 1<<20, 4096, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache := NewExternalCache(server.Client(), binaryCache, ExternalCacheConfig{ChunkSize: 4096, TTL: time.Hour, MaxFileSize: 1 << 20}, nil)
+
+	// Without Accept-Ranges the file cannot be fetched in chunks.
+	if _, err := cache.Open(context.Background(), server.URL); err != ErrNotCacheable {
+		t.Errorf("Expected ErrNotCacheable, got %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webBridgeBot/internal/reader"
+)
+
+const (
+	playlistName    = "playlist.m3u8"
+	playlistChunkID = 0 // Segment n is cached as chunk n+1.
+	pollInterval    = 250 * time.Millisecond
+)
+
+var segmentPattern = regexp.MustCompile(`^seg(\d{5})\.ts$`)
+
+// ErrSegmentNotFound is returned for segment names that are invalid or not part of the playlist.
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// HLSConfig configures the HLS transcoder.
+type HLSConfig struct {
+	FFmpegPath string
+	WorkDir    string
+	// Transcode re-encodes to H.264/AAC. Otherwise the streams are only remuxed, which is cheap
+	// but only helps with containers the browser cannot play, not with codecs.
+	Transcode       bool
+	SegmentDuration time.Duration
+	IdleTimeout     time.Duration
+}
+
+// HLSTranscoder converts files into HLS playlists and segments with ffmpeg. Each file is
+// processed by at most one ffmpeg job at a time; finished output is kept in the BinaryCache.
+type HLSTranscoder struct {
+	cfg    HLSConfig
+	cache  *reader.BinaryCache
+	logger *log.Logger
+
+	mu   sync.Mutex
+	jobs map[int64]*hlsJob
+}
+
+type hlsJob struct {
+	dir        string
+	cancel     context.CancelFunc
+	done       chan struct{}
+	err        error
+	lastAccess time.Time
+}
+
+// NewHLSTranscoder creates a transcoder and starts stopping jobs that are no longer watched.
+func NewHLSTranscoder(cfg HLSConfig, cache *reader.BinaryCache, logger *log.Logger) *HLSTranscoder {
+	t := &HLSTranscoder{
+		cfg:    cfg,
+		cache:  cache,
+		logger: logger,
+		jobs:   make(map[int64]*hlsJob),
+	}
+	go t.reapIdleJobs()
+	return t
+}
+
+// cacheKey returns the BinaryCache location used for the HLS output of a file, kept apart from
+// the location of the file itself.
+func cacheKey(fileID int64) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "hls:%d", fileID)
+	return int64(h.Sum64() >> 1)
+}
+
+// Playlist returns the playlist of a file, starting ffmpeg on sourceURL if it is not cached.
+// While a job is running the playlist grows as segments are produced.
+func (t *HLSTranscoder) Playlist(ctx context.Context, fileID int64, sourceURL string) ([]byte, error) {
+	if data, err := t.cache.GetChunk(cacheKey(fileID), playlistChunkID); err == nil {
+		return data, nil
+	}
+
+	job := t.job(fileID, sourceURL)
+	for {
+		if data, err := os.ReadFile(filepath.Join(job.dir, playlistName)); err == nil && bytes.Contains(data, []byte("#EXTINF")) {
+			return data, nil
+		}
+		if done, err := t.wait(ctx, job); done {
+			if data, cacheErr := t.cache.GetChunk(cacheKey(fileID), playlistChunkID); cacheErr == nil {
+				return data, nil
+			}
+			if err == nil {
+				err = errors.New("ffmpeg produced no playlist")
+			}
+			return nil, err
+		}
+	}
+}
+
+// Segment returns a segment of a file's playlist, starting ffmpeg on sourceURL if it is not cached.
+func (t *HLSTranscoder) Segment(ctx context.Context, fileID int64, name, sourceURL string) ([]byte, error) {
+	match := segmentPattern.FindStringSubmatch(name)
+	if match == nil {
+		return nil, ErrSegmentNotFound
+	}
+	index, _ := strconv.ParseInt(match[1], 10, 64)
+	if data, err := t.cache.GetChunk(cacheKey(fileID), index+1); err == nil {
+		return data, nil
+	}
+
+	job := t.job(fileID, sourceURL)
+	for {
+		// Segments are complete once ffmpeg lists them in the playlist.
+		if listed(filepath.Join(job.dir, playlistName), name) {
+			data, err := os.ReadFile(filepath.Join(job.dir, name))
+			if err == nil {
+				if err := t.cache.PutChunk(cacheKey(fileID), index+1, data); err != nil {
+					t.logger.Printf("Failed to cache HLS segment %s of file %d: %v", name, fileID, err)
+				}
+				return data, nil
+			}
+		}
+		if done, err := t.wait(ctx, job); done {
+			if data, cacheErr := t.cache.GetChunk(cacheKey(fileID), index+1); cacheErr == nil {
+				return data, nil
+			}
+			if err == nil {
+				err = ErrSegmentNotFound
+			}
+			return nil, err
+		}
+	}
+}
+
+// wait blocks for one poll interval. It reports true with the job's error once the job has
+// finished, or with the context's error if the request is gone.
+func (t *HLSTranscoder) wait(ctx context.Context, job *hlsJob) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return true, ctx.Err()
+	case <-job.done:
+		return true, job.err
+	case <-time.After(pollInterval):
+		return false, nil
+	}
+}
+
+// job returns the running job of a file, starting one if needed.
+func (t *HLSTranscoder) job(fileID int64, sourceURL string) *hlsJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job, ok := t.jobs[fileID]; ok {
+		job.lastAccess = time.Now()
+		return job
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &hlsJob{
+		dir:        filepath.Join(t.cfg.WorkDir, strconv.FormatInt(fileID, 10)),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		lastAccess: time.Now(),
+	}
+	t.jobs[fileID] = job
+	go t.run(ctx, fileID, job, sourceURL)
+	return job
+}
+
+// run executes ffmpeg for a job and moves its output into the cache once it completes.
+func (t *HLSTranscoder) run(ctx context.Context, fileID int64, job *hlsJob, sourceURL string) {
+	defer func() {
+		t.mu.Lock()
+		if t.jobs[fileID] == job {
+			delete(t.jobs, fileID)
+		}
+		t.mu.Unlock()
+		close(job.done)
+		os.RemoveAll(job.dir)
+	}()
+
+	if err := os.RemoveAll(job.dir); err != nil {
+		job.err = err
+		return
+	}
+	if err := os.MkdirAll(job.dir, 0755); err != nil {
+		job.err = err
+		return
+	}
+
+	t.logger.Printf("Starting HLS job for file %d", fileID)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.cfg.FFmpegPath, t.ffmpegArgs(sourceURL, job.dir)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		job.err = fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		t.logger.Printf("HLS job for file %d failed: %v", fileID, job.err)
+		return
+	}
+
+	if err := t.cacheOutput(fileID, job.dir); err != nil {
+		job.err = err
+		t.logger.Printf("Failed to cache HLS output of file %d: %v", fileID, err)
+		return
+	}
+	t.logger.Printf("HLS job for file %d completed", fileID)
+}
+
+// ffmpegArgs builds the command line that writes an HLS event playlist into dir.
+func (t *HLSTranscoder) ffmpegArgs(sourceURL, dir string) []string {
+	args := []string{"-nostdin", "-loglevel", "error", "-i", sourceURL, "-map", "0:v:0?", "-map", "0:a:0?", "-sn"}
+	if t.cfg.Transcode {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	return append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(int(t.cfg.SegmentDuration.Seconds())),
+		"-hls_playlist_type", "event",
+		"-hls_segment_filename", filepath.Join(dir, "seg%05d.ts"),
+		filepath.Join(dir, playlistName),
+	)
+}
+
+// cacheOutput stores all segments and then the final playlist, so a cached playlist never
+// refers to segments that were not cached.
+func (t *HLSTranscoder) cacheOutput(fileID int64, dir string) error {
+	key := cacheKey(fileID)
+	playlist, err := os.ReadFile(filepath.Join(dir, playlistName))
+	if err != nil {
+		return err
+	}
+	for _, name := range segmentNames(playlist) {
+		match := segmentPattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		index, _ := strconv.ParseInt(match[1], 10, 64)
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := t.cache.PutChunk(key, index+1, data); err != nil {
+			return err
+		}
+	}
+	return t.cache.PutChunk(key, playlistChunkID, playlist)
+}
+
+// reapIdleJobs stops jobs whose output has not been requested for the idle timeout.
+func (t *HLSTranscoder) reapIdleJobs() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		for fileID, job := range t.jobs {
+			if time.Since(job.lastAccess) > t.cfg.IdleTimeout {
+				t.logger.Printf("Stopping idle HLS job for file %d", fileID)
+				job.cancel()
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Stop cancels all running jobs.
+func (t *HLSTranscoder) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range t.jobs {
+		job.cancel()
+	}
+}
+
+// segmentNames returns the segment URIs listed in a playlist.
+func segmentNames(playlist []byte) []string {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+	return names
+}
+
+// listed reports whether a playlist file on disk lists the given segment.
+func listed(playlistPath, name string) bool {
+	playlist, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return false
+	}
+	for _, segment := range segmentNames(playlist) {
+		if segment == name {
+			return true
+		}
+	}
+	return false
+}
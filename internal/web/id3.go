@@ -0,0 +1,245 @@
+package web
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3HeaderSize is the size of the header at the start of an ID3v2 tag.
+const ID3HeaderSize = 10
+
+// AudioTags are the tags embedded in an audio file.
+type AudioTags struct {
+	Title  string
+	Artist string
+	Album  string
+	Year   string
+	// Picture is the embedded cover art, preferably the front cover, and PictureType its MIME
+	// type if the tag names one.
+	Picture     []byte
+	PictureType string
+}
+
+// ID3Size returns the size of the ID3v2 tag that starts with header, including the header, or
+// 0 if the file does not start with a tag.
+func ID3Size(header []byte) int64 {
+	if len(header) < ID3HeaderSize || string(header[:3]) != "ID3" || header[3] < 2 || header[3] > 4 {
+		return 0
+	}
+	size := int64(syncsafe(header[6:10])) + ID3HeaderSize
+	if header[3] == 4 && header[5]&0x10 != 0 {
+		size += ID3HeaderSize // Footer
+	}
+	return size
+}
+
+// ParseID3 reads the title, artist, album, year and cover art of an ID3v2.2, v2.3 or v2.4 tag.
+func ParseID3(tag []byte) (*AudioTags, error) {
+	size := ID3Size(tag)
+	if size == 0 {
+		return nil, errors.New("not an ID3v2 tag")
+	}
+	if int64(len(tag)) < size {
+		return nil, fmt.Errorf("ID3 tag is truncated: %d of %d bytes", len(tag), size)
+	}
+	version, flags := tag[3], tag[5]
+	body := tag[ID3HeaderSize:size]
+	if version < 4 && flags&0x80 != 0 {
+		body = removeUnsync(body)
+	}
+	if version > 2 && flags&0x40 != 0 {
+		// Skip the extended header.
+		if len(body) < 4 {
+			return nil, errors.New("ID3 extended header is truncated")
+		}
+		extended := int(binary.BigEndian.Uint32(body)) + 4
+		if version == 4 {
+			extended = syncsafe(body[:4])
+		}
+		if extended > len(body) {
+			return nil, errors.New("ID3 extended header is truncated")
+		}
+		body = body[extended:]
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	tags := &AudioTags{}
+	pictureKind := -1
+	for len(body) >= headerSize && body[0] != 0 {
+		id := string(body[:idSize])
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		default:
+			frameSize = syncsafe(body[4:8])
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		}
+		if frameSize < 0 || headerSize+frameSize > len(body) {
+			break
+		}
+		frame := body[headerSize : headerSize+frameSize]
+		body = body[headerSize+frameSize:]
+
+		if version == 4 {
+			if frameFlags&0x000c != 0 { // Compressed or encrypted
+				continue
+			}
+			if frameFlags&0x0001 != 0 && len(frame) >= 4 { // Data length indicator
+				frame = frame[4:]
+			}
+			if frameFlags&0x0002 != 0 {
+				frame = removeUnsync(frame)
+			}
+		} else if version == 3 && frameFlags&0x00c0 != 0 { // Compressed or encrypted
+			continue
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = decodeID3Text(frame)
+		case "TPE1", "TP1":
+			tags.Artist = decodeID3Text(frame)
+		case "TALB", "TAL":
+			tags.Album = decodeID3Text(frame)
+		case "TYER", "TDRC", "TYE":
+			tags.Year = decodeID3Text(frame)
+		case "APIC", "PIC":
+			kind, mimeType, data, ok := parseID3Picture(frame, version == 2)
+			// The front cover wins over any other picture.
+			if ok && pictureKind != 3 && (pictureKind < 0 || kind == 3) {
+				pictureKind = kind
+				tags.Picture, tags.PictureType = data, mimeType
+			}
+		}
+	}
+	return tags, nil
+}
+
+// parseID3Picture returns the picture type, MIME type and image of an APIC or, for ID3v2.2, a
+// PIC frame.
+func parseID3Picture(frame []byte, v22 bool) (int, string, []byte, bool) {
+	if len(frame) < 2 {
+		return 0, "", nil, false
+	}
+	encoding, rest := frame[0], frame[1:]
+	var mimeType string
+	if v22 {
+		if len(rest) < 3 {
+			return 0, "", nil, false
+		}
+		switch strings.ToUpper(string(rest[:3])) {
+		case "JPG":
+			mimeType = "image/jpeg"
+		case "PNG":
+			mimeType = "image/png"
+		}
+		rest = rest[3:]
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return 0, "", nil, false
+		}
+		mimeType = string(rest[:end])
+		rest = rest[end+1:]
+	}
+	if len(rest) < 1 {
+		return 0, "", nil, false
+	}
+	kind, rest := int(rest[0]), rest[1:]
+
+	// Skip the description, which ends with a terminator of the frame's text encoding.
+	end := id3TextEnd(rest, encoding)
+	if end < 0 {
+		return 0, "", nil, false
+	}
+	data := rest[end:]
+	if len(data) == 0 {
+		return 0, "", nil, false
+	}
+	return kind, mimeType, data, true
+}
+
+// id3TextEnd returns the offset just past the terminator of the text at the start of b, or -1.
+func id3TextEnd(b []byte, encoding byte) int {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return i + 2
+			}
+		}
+		return -1
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return i + 1
+	}
+	return -1
+}
+
+// decodeID3Text decodes a text frame. Frames holding several values keep the first.
+func decodeID3Text(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+	encoding, text := frame[0], frame[1:]
+	if end := id3TextEnd(text, encoding); end >= 0 {
+		text = text[:end]
+	}
+
+	var s string
+	switch encoding {
+	case 0: // ISO-8859-1
+		runes := make([]rune, len(text))
+		for i, c := range text {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	case 1, 2: // UTF-16 with a byte order mark, or big-endian without
+		bigEndian := encoding == 2
+		if len(text) >= 2 && text[0] == 0xfe && text[1] == 0xff {
+			bigEndian, text = true, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xff && text[1] == 0xfe {
+			bigEndian, text = false, text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(text[2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(text[2*i:])
+			}
+		}
+		s = string(utf16.Decode(units))
+	default: // UTF-8
+		s = string(text)
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// syncsafe decodes a 28-bit integer stored in the low 7 bits of four bytes.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// removeUnsync undoes the unsynchronisation scheme, which inserts a zero byte after every 0xff.
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func id3Frame(id string, data []byte) []byte {
+	frame := append([]byte(id), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func TestParseID3(t *testing.T) {
+	var body []byte
+	body = append(body, id3Frame("TIT2", append([]byte{3}, "Song"...))...)
+	// UTF-16 with a byte order mark.
+	body = append(body, id3Frame("TPE1", []byte{1, 0xff, 0xfe, 'A', 0, 'r', 0, 't', 0, 0, 0})...)
+	body = append(body, id3Frame("TALB", append([]byte{0}, "Album\x00"...))...)
+	body = append(body, id3Frame("TYER", append([]byte{0}, "1999"...))...)
+	// A back cover before the front cover.
+	body = append(body, id3Frame("APIC", append([]byte{0}, "image/png\x00\x04back\x00BACK"...))...)
+	body = append(body, id3Frame("APIC", append([]byte{0}, "image/jpeg\x00\x03front\x00\xff\xd8FRONT"...))...)
+	body = append(body, make([]byte, 16)...) // Padding
+
+	size := len(body)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	tag = append(tag, body...)
+	// The audio data that follows the tag is ignored.
+	file := append(append([]byte{}, tag...), 0xff, 0xfb, 0x90, 0x00)
+
+	if got := ID3Size(file); got != int64(len(tag)) {
+		t.Fatalf("ID3Size = %d, want %d", got, len(tag))
+	}
+	if got := ID3Size([]byte("\xff\xfb\x90\x00xxxxxx")); got != 0 {
+		t.Errorf("ID3Size of a file without tag = %d", got)
+	}
+
+	tags, err := ParseID3(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Title != "Song" || tags.Artist != "Art" || tags.Album != "Album" || tags.Year != "1999" {
+		t.Errorf("Unexpected tags: %+v", tags)
+	}
+	if tags.PictureType != "image/jpeg" || !bytes.Equal(tags.Picture, []byte("\xff\xd8FRONT")) {
+		t.Errorf("Expected the front cover, got %q (%s)", tags.Picture, tags.PictureType)
+	}
+
+	if _, err := ParseID3(tag[:len(tag)-20]); err == nil {
+		t.Error("Expected a truncated tag to be rejected")
+	}
+}
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidLogin is returned for login data that was not signed by Telegram for the bot.
+	ErrInvalidLogin = errors.New("invalid Telegram login")
+	// ErrLoginExpired is returned for login data older than the allowed age.
+	ErrLoginExpired = errors.New("Telegram login expired")
+)
+
+// TelegramLogin is a user authenticated by the Telegram Login Widget.
+type TelegramLogin struct {
+	ID        int64
+	FirstName string
+	LastName  string
+	Username  string
+	PhotoURL  string
+	AuthDate  time.Time
+}
+
+// VerifyTelegramLogin checks the fields the Telegram Login Widget passes to its auth URL. They are
+// signed with the SHA-256 of the bot token, so only Telegram can produce them for the bot. Logins
+// older than maxAge are rejected to limit the replay of leaked URLs.
+func VerifyTelegramLogin(values url.Values, botToken string, maxAge time.Duration) (*TelegramLogin, error) {
+	hash := values.Get("hash")
+	if hash == "" || botToken == "" {
+		return nil, ErrInvalidLogin
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key != "hash" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + values.Get(key)
+	}
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(hash))) {
+		return nil, ErrInvalidLogin
+	}
+
+	id, err := strconv.ParseInt(values.Get("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidLogin
+	}
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidLogin
+	}
+	login := &TelegramLogin{
+		ID:        id,
+		FirstName: values.Get("first_name"),
+		LastName:  values.Get("last_name"),
+		Username:  values.Get("username"),
+		PhotoURL:  values.Get("photo_url"),
+		AuthDate:  time.Unix(authDate, 0),
+	}
+	if time.Since(login.AuthDate) > maxAge {
+		return nil, ErrLoginExpired
+	}
+	return login, nil
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signLogin signs login fields like Telegram does for the widget.
+func signLogin(values url.Values, botToken string) {
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	var lines []string
+	for _, key := range []string{"auth_date", "first_name", "id", "username"} {
+		lines = append(lines, key+"="+values.Get(key))
+	}
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifyTelegramLogin(t *testing.T) {
+	const botToken = "123:abc"
+	values := url.Values{
+		"id":         {"42"},
+		"first_name": {"Ada"},
+		"username":   {"ada"},
+		"auth_date":  {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	signLogin(values, botToken)
+
+	login, err := VerifyTelegramLogin(values, botToken, time.Hour)
+	if err != nil {
+		t.Fatalf("VerifyTelegramLogin failed: %v", err)
+	}
+	if login.ID != 42 || login.Username != "ada" {
+		t.Errorf("Unexpected login %+v", login)
+	}
+
+	if _, err := VerifyTelegramLogin(values, "456:def", time.Hour); err != ErrInvalidLogin {
+		t.Errorf("Expected ErrInvalidLogin for another bot, got %v", err)
+	}
+
+	tampered := url.Values{}
+	for key, value := range values {
+		tampered[key] = value
+	}
+	tampered.Set("id", "43")
+	if _, err := VerifyTelegramLogin(tampered, botToken, time.Hour); err != ErrInvalidLogin {
+		t.Errorf("Expected ErrInvalidLogin for a changed ID, got %v", err)
+	}
+
+	values.Set("auth_date", strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10))
+	signLogin(values, botToken)
+	if _, err := VerifyTelegramLogin(values, botToken, time.Hour); err != ErrLoginExpired {
+		t.Errorf("Expected ErrLoginExpired, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	logging "webBridgeBot/internal/logger"
+)
+
+// Middleware wraps a handler with behavior shared by many routes, such as logging or rate
+// limiting.
+type Middleware func(http.Handler) http.Handler
+
+// Chain returns a middleware that applies the given ones in order, the first one outermost.
+func Chain(middleware ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+// responseRecorder remembers the status and size of a response. It keeps the optional
+// interfaces WebSockets and streams rely on.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	// A hijacked connection answers with 101 Switching Protocols.
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RequestIDHeader carries the ID of a request, in the request from a proxy that assigned one and
+// in every response.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives each request an ID, or keeps the one a proxy assigned, and puts it in the
+// context of the request and in the X-Request-ID response header. Users can report the ID of a
+// failed request to find its logs.
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !requestIDPattern.MatchString(id) {
+				b := make([]byte, 8)
+				_, _ = rand.Read(b)
+				id = hex.EncodeToString(b)
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
+// Error replies like http.Error, adding the ID of the request so users can report it.
+func Error(w http.ResponseWriter, message string, code int) {
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		message = fmt.Sprintf("%s (request ID %s)", message, id)
+	}
+	http.Error(w, message, code)
+}
+
+// Logging writes an access log line for each request, with its method, path, status, size,
+// duration, client IP as returned by clientIP, and request ID as fields. Server errors are
+// logged at the error level and client errors at the warning level.
+func Logging(logger *log.Logger, clientIP func(r *http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			duration := time.Since(start)
+
+			level := "info"
+			switch {
+			case status >= 500:
+				level = "error"
+			case status >= 400:
+				level = "warn"
+			}
+			fields := logging.Fields{
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"status":      status,
+				"bytes":       rec.written,
+				"duration_ms": duration.Milliseconds(),
+				"client_ip":   clientIP(r),
+				"level":       level,
+			}
+			if id := logging.RequestID(r.Context()); id != "" {
+				fields["request_id"] = id
+			}
+			logging.WithFields(logger, fields).Printf("%s %s %d %d bytes in %s", r.Method, r.URL.Path, status, rec.written, duration.Round(time.Millisecond))
+		})
+	}
+}
+
+// Recovery answers 500 Internal Server Error instead of dropping the connection when a handler
+// panics, and logs the panic with its stack.
+func Recovery(logger *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// Handlers abort streams on purpose with http.ErrAbortHandler.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				logging.FromContext(r.Context(), logger).Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				// The status cannot be changed if the handler has written already.
+				Error(w, "Internal server error", http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORS lets pages of any site, or of origin if it is not "*", read the responses of a route,
+// for example media fetched by a player elsewhere.
+func CORS(origin string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
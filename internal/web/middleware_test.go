@@ -0,0 +1,113 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	logging "webBridgeBot/internal/logger"
+)
+
+func TestServer_Middleware(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	s := NewServer(":0", nil)
+	s.Use(mark("first"), mark("second"))
+	s.HandleFunc("/route", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}, mark("route"))
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/route", nil))
+	if got := strings.Join(order, ","); got != "first,second,route,handler" {
+		t.Errorf("Unexpected order %q", got)
+	}
+
+	// Middleware added with Use also wraps requests no route matches.
+	order = nil
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if got := strings.Join(order, ","); got != "first,second" || rec.Code != http.StatusNotFound {
+		t.Errorf("Unexpected order %q and status %d for an unknown path", got, rec.Code)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var logged strings.Builder
+	handler := Recovery(log.New(&logged, "", 0))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
+	if !strings.Contains(logged.String(), "boom") {
+		t.Errorf("Expected the panic to be logged, got %q", logged.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logged bytes.Buffer
+	var seen string
+	handler := Chain(RequestID(), Logging(logging.New(&logged, logging.FormatJSON), func(*http.Request) string { return "192.0.2.1" }))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = logging.RequestID(r.Context())
+			Error(w, "Not found", http.StatusNotFound)
+		}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	id := rec.Header().Get(RequestIDHeader)
+	if id == "" || id != seen {
+		t.Fatalf("Expected the handler to see the ID %q of the response, got %q", id, seen)
+	}
+	if !strings.Contains(rec.Body.String(), id) {
+		t.Errorf("Expected the error to name the request ID, got %q", rec.Body.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logged.Bytes(), &entry); err != nil {
+		t.Fatalf("Access log is not JSON: %v: %s", err, logged.String())
+	}
+	want := map[string]interface{}{
+		"request_id": id,
+		"method":     "GET",
+		"path":       "/missing",
+		"status":     float64(http.StatusNotFound),
+		"client_ip":  "192.0.2.1",
+		"level":      "warn",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+
+	// An ID assigned by a proxy is kept; a malformed one is replaced.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(RequestIDHeader, "proxy-id.1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if got := rec.Header().Get(RequestIDHeader); got != "proxy-id.1" {
+		t.Errorf("Expected the proxy's request ID, got %q", got)
+	}
+	r.Header.Set(RequestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if got := rec.Header().Get(RequestIDHeader); got == "bad id\n" || got == "" {
+		t.Errorf("Expected a malformed request ID to be replaced, got %q", got)
+	}
+}
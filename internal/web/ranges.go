@@ -0,0 +1,121 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidRange is returned for a Range header that cannot be parsed.
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrRangeNotSatisfiable is returned when none of the requested ranges overlaps the file.
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// ByteRange is a range of bytes of a file, from Start to End inclusive.
+type ByteRange struct {
+	Start, End int64
+}
+
+// Length returns the number of bytes in the range.
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// ContentRange returns the Content-Range header of the range in a file of size bytes.
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)
+}
+
+// ParseRange parses a Range header for a file of size bytes as described in RFC 7233. It returns
+// no ranges if the header is empty or uses another unit than bytes, in which case the whole file
+// is sent. Ranges such as "500-" run to the end of the file, suffix ranges such as "-500" take
+// its last bytes, ranges past the end are cut off at the end, and overlapping or adjacent ranges
+// are merged, so the ranges are returned in order.
+func ParseRange(header string, size int64) ([]ByteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok {
+		return nil, ErrInvalidRange
+	}
+	if !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	var ranges []ByteRange
+	specs := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		specs++
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			n, err := parseRangePos(last)
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 && size > 0 {
+				ranges = append(ranges, ByteRange{Start: max(size-n, 0), End: size - 1})
+			}
+			continue
+		}
+		start, err := parseRangePos(first)
+		if err != nil {
+			return nil, err
+		}
+		end := size - 1
+		if last != "" {
+			if end, err = parseRangePos(last); err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, ErrInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start < size {
+			ranges = append(ranges, ByteRange{Start: start, End: end})
+		}
+	}
+	if specs == 0 {
+		return nil, ErrInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		if prev := &merged[len(merged)-1]; r.Start <= prev.End+1 {
+			prev.End = max(prev.End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// parseRangePos parses a byte position of a range, which is a non-empty run of digits.
+func parseRangePos(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrInvalidRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRange
+	}
+	return n, nil
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []ByteRange
+		err    error
+	}{
+		{"", nil, nil},
+		{"items=0-5", nil, nil},
+		{"bytes=0-499", []ByteRange{{0, 499}}, nil},
+		{"bytes=500-", []ByteRange{{500, 999}}, nil},
+		{"bytes=-500", []ByteRange{{500, 999}}, nil},
+		{"bytes=-5000", []ByteRange{{0, 999}}, nil},
+		{"bytes=900-5000", []ByteRange{{900, 999}}, nil},
+		{"Bytes = 0-0 , -1", []ByteRange{{0, 0}, {999, 999}}, nil},
+		{"bytes=500-600,0-99,100-199,550-", []ByteRange{{0, 199}, {500, 999}}, nil},
+		{"bytes=1000-,5000-6000", nil, ErrRangeNotSatisfiable},
+		{"bytes=-0", nil, ErrRangeNotSatisfiable},
+		{"bytes=1000-,0-1", []ByteRange{{0, 1}}, nil},
+		{"bytes=5-4", nil, ErrInvalidRange},
+		{"bytes=a-b", nil, ErrInvalidRange},
+		{"bytes=+1-2", nil, ErrInvalidRange},
+		{"bytes=-", nil, ErrInvalidRange},
+		{"bytes=12", nil, ErrInvalidRange},
+		{"bytes=", nil, ErrInvalidRange},
+		{"bytes", nil, ErrInvalidRange},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.header, 1000)
+		if !errors.Is(err, tt.err) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRange(%q) = %v, %v; want %v, %v", tt.header, got, err, tt.want, tt.err)
+		}
+	}
+
+	if _, err := ParseRange("bytes=0-", 0); !errors.Is(err, ErrRangeNotSatisfiable) {
+		t.Errorf("Expected no range of an empty file to be satisfiable, got %v", err)
+	}
+	if got := (ByteRange{10, 19}).ContentRange(100); got != "bytes 10-19/100" {
+		t.Errorf("ContentRange = %q", got)
+	}
+}
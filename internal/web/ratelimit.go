@@ -0,0 +1,119 @@
+package web
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bucketIdleTimeout is how long an unused bucket is kept. A bucket idle for this long is full
+// again for any sensible rate, so forgetting it does not change the outcome.
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimiter is a token bucket rate limiter keyed by client, such as an IP address or chat ID.
+// Each key may make Burst requests at once and then PerMinute requests per minute.
+type RateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	limited atomic.Int64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter that allows perMinute requests per minute with bursts of up to
+// burst requests for each key. A perMinute of zero or less disables the limiter.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	l := &RateLimiter{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+	l.SetLimit(perMinute, burst)
+	return l
+}
+
+// SetLimit changes the rate and burst of every key, for example when the configuration is
+// reloaded. Buckets keep their tokens, up to the new burst.
+func (l *RateLimiter) SetLimit(perMinute, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perSecond = float64(perMinute) / 60
+	l.burst = float64(burst)
+}
+
+// Allow takes a token from the bucket of key. If none is left, it returns false and how long
+// the client should wait before retrying.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perSecond <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	l.limited.Add(1)
+	wait := time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have not been used for a while. It must be called with l.mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Limited returns the number of requests rejected since the limiter was created.
+func (l *RateLimiter) Limited() int64 {
+	return l.limited.Load()
+}
+
+// Middleware rejects requests with 429 Too Many Requests once the client identified by key has
+// used up its bucket. Requests for which key returns an empty string are not limited.
+func (l *RateLimiter) Middleware(key func(r *http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok, wait := l.Allow(k); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
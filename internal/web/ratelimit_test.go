@@ -0,0 +1,115 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	limiter := NewRateLimiter(60, 3)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("a")
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if wait <= 0 {
+		t.Errorf("wait = %v, want a positive duration", wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("another key shares the bucket")
+	}
+	if limiter.Limited() != 1 {
+		t.Errorf("Limited = %d, want 1", limiter.Limited())
+	}
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	limiter := NewRateLimiter(-1, 1)
+	for i := 0; i < 10; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+}
+
+func TestRateLimiter_SetLimit(t *testing.T) {
+	limiter := NewRateLimiter(60, 1)
+	limiter.Allow("a")
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	limiter.SetLimit(-1, 1)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("request was rejected after the limiter was disabled")
+	}
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	limiter := NewRateLimiter(60, 1)
+	handler := limiter.Middleware(func(r *http.Request) string {
+		return ClientIP(r, nil)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := make([]int, 2)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes[i] = rec.Code
+		if i == 1 && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 response has no Retry-After header")
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want [200 429]", codes)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("192.0.2.0/24, 10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		proxies    *TrustedProxies
+		want       string
+	}{
+		{"no trusted proxies", "192.0.2.1:1234", "198.51.100.7", "", nil, "192.0.2.1"},
+		{"untrusted peer", "203.0.113.5:1234", "198.51.100.7", "", proxies, "203.0.113.5"},
+		{"trusted peer", "192.0.2.1:1234", "198.51.100.7", "", proxies, "198.51.100.7"},
+		{"proxy chain", "192.0.2.1:1234", "198.51.100.7, 10.0.0.1", "", proxies, "198.51.100.7"},
+		{"spoofed start", "192.0.2.1:1234", "1.2.3.4, 198.51.100.7", "", proxies, "198.51.100.7"},
+		{"real IP", "192.0.2.1:1234", "", "198.51.100.8", proxies, "198.51.100.8"},
+		{"trust all", "203.0.113.5:1234", "198.51.100.7", "", &TrustedProxies{all: true}, "198.51.100.7"},
+		{"trust all spoofed start", "203.0.113.5:1234", "1.2.3.4, 198.51.100.7", "", &TrustedProxies{all: true}, "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if ip := ClientIP(req, tt.proxies); ip != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, ip, tt.want)
+		}
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid range to be rejected")
+	}
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Server is the HTTP server of the bot. Middleware added with Use wraps every request, including
+// those no route matches; each route can add its own on top, such as rate limiting or
+// authentication.
+type Server struct {
+	router     *mux.Router
+	middleware []Middleware
+	server     *http.Server
+}
+
+// NewServer creates a server listening on addr. With tlsConfig, Start serves HTTPS.
+func NewServer(addr string, tlsConfig *tls.Config) *Server {
+	s := &Server{router: mux.NewRouter()}
+	s.server = &http.Server{Addr: addr, Handler: s, TLSConfig: tlsConfig}
+	return s
+}
+
+// Use adds middleware that wraps every request, after the middleware added before. It must be
+// called before the server is started.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Handle registers a handler for a path, wrapped by the given middleware in order.
+func (s *Server) Handle(path string, handler http.Handler, middleware ...Middleware) *mux.Route {
+	return s.router.Handle(path, Chain(middleware...)(handler))
+}
+
+// HandleFunc registers a handler function for a path, wrapped by the given middleware in order.
+func (s *Server) HandleFunc(path string, handler http.HandlerFunc, middleware ...Middleware) *mux.Route {
+	return s.Handle(path, handler, middleware...)
+}
+
+// ServeHTTP serves a request through the middleware and the router.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Chain(s.middleware...)(s.router).ServeHTTP(w, r)
+}
+
+// OnShutdown registers a function to call when Shutdown is called, for example to close
+// hijacked connections, which Shutdown does not wait for.
+func (s *Server) OnShutdown(f func()) {
+	s.server.RegisterOnShutdown(f)
+}
+
+// Start serves requests until the server is shut down, with the certificate and key files if
+// the server has a TLS configuration.
+func (s *Server) Start(certFile, keyFile string) error {
+	var err error
+	if s.server.TLSConfig != nil {
+		err = s.server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits for active ones until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Close closes all connections immediately.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
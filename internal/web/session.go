@@ -0,0 +1,151 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoSession is returned for requests without a session cookie.
+	ErrNoSession = errors.New("no session")
+	// ErrInvalidSession is returned for session cookies that are forged or expired.
+	ErrInvalidSession = errors.New("invalid session")
+	// ErrInvalidCSRFToken is returned for state-changing requests without the CSRF token of
+	// their session.
+	ErrInvalidCSRFToken = errors.New("invalid CSRF token")
+)
+
+const (
+	// CSRFHeader and CSRFField carry the CSRF token of scripts and of forms.
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+// SessionConfig configures the cookies of a SessionManager.
+type SessionConfig struct {
+	CookieName string
+	// Path the cookie is sent for.
+	Path string
+	TTL  time.Duration
+	// Secure restricts the cookie to HTTPS.
+	Secure bool
+}
+
+// Session is the signed-in user of a request.
+type Session struct {
+	// ID is random and kept when the session is refreshed. CSRF tokens are derived from it.
+	ID      string
+	UserID  int64
+	Expires time.Time
+}
+
+// SessionManager keeps sessions in cookies signed with HMAC-SHA256, so no state is stored on the
+// server. A session cannot be revoked before it expires except by changing the key.
+type SessionManager struct {
+	key []byte
+	cfg SessionConfig
+}
+
+// NewSessionManager creates a manager that signs its cookies with key.
+func NewSessionManager(key []byte, cfg SessionConfig) *SessionManager {
+	return &SessionManager{key: key, cfg: cfg}
+}
+
+func (m *SessionManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(m.cfg.CookieName + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Create starts a session for a user by setting its cookie.
+func (m *SessionManager) Create(w http.ResponseWriter, userID int64) Session {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return m.Refresh(w, Session{ID: base64.RawURLEncoding.EncodeToString(id), UserID: userID})
+}
+
+// Refresh extends a session by the TTL from now, keeping its ID and so its CSRF token.
+func (m *SessionManager) Refresh(w http.ResponseWriter, session Session) Session {
+	session.Expires = time.Now().Add(m.cfg.TTL)
+	payload := fmt.Sprintf("%d.%d.%s", session.UserID, session.Expires.Unix(), session.ID)
+	m.setCookie(w, payload+"."+m.sign(payload), session.Expires)
+	return session
+}
+
+// Get returns the session of a request.
+func (m *SessionManager) Get(r *http.Request) (Session, error) {
+	cookie, err := r.Cookie(m.cfg.CookieName)
+	if err != nil {
+		return Session{}, ErrNoSession
+	}
+
+	i := strings.LastIndexByte(cookie.Value, '.')
+	if i < 0 {
+		return Session{}, ErrInvalidSession
+	}
+	payload, signature := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(m.sign(payload))) {
+		return Session{}, ErrInvalidSession
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[2] == "" {
+		return Session{}, ErrInvalidSession
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Session{}, ErrInvalidSession
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return Session{}, ErrInvalidSession
+	}
+	return Session{ID: parts[2], UserID: userID, Expires: time.Unix(expires, 0)}, nil
+}
+
+// CSRFToken returns the token that state-changing requests of a session must carry in the
+// CSRFHeader header or the CSRFField form field.
+func (m *SessionManager) CSRFToken(session Session) string {
+	return m.sign("csrf:" + session.ID)
+}
+
+// CheckCSRF returns ErrInvalidCSRFToken unless a request carries the CSRF token of its session.
+// A site that can make the browser send the cookie cannot read the token.
+func (m *SessionManager) CheckCSRF(r *http.Request, session Session) error {
+	token := r.Header.Get(CSRFHeader)
+	// Only plain forms are parsed, so uploads are not read into memory to find a token.
+	if token == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		token = r.PostFormValue(CSRFField)
+	}
+	if token == "" || !hmac.Equal([]byte(token), []byte(m.CSRFToken(session))) {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+// Clear ends the session of the client by removing its cookie.
+func (m *SessionManager) Clear(w http.ResponseWriter) {
+	m.setCookie(w, "", time.Unix(0, 0))
+}
+
+func (m *SessionManager) setCookie(w http.ResponseWriter, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cfg.CookieName,
+		Value:    value,
+		Path:     m.cfg.Path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   m.cfg.Secure,
+		// Lax keeps the cookie on the redirect back from Telegram's login page, but not on
+		// cross-site form posts.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,87 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionManager(t *testing.T) {
+	m := NewSessionManager([]byte("key"), SessionConfig{CookieName: "session", Path: "/", TTL: time.Hour})
+
+	rec := httptest.NewRecorder()
+	m.Create(rec, 42)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected one HttpOnly cookie, got %v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	if session, err := m.Get(r); err != nil || session.UserID != 42 {
+		t.Errorf("Get = %+v, %v; want user 42", session, err)
+	}
+
+	if _, err := m.Get(httptest.NewRequest(http.MethodGet, "/", nil)); err != ErrNoSession {
+		t.Errorf("Expected ErrNoSession, got %v", err)
+	}
+
+	// A cookie for another user, or signed with another key, is rejected.
+	forged := httptest.NewRequest(http.MethodGet, "/", nil)
+	forged.AddCookie(&http.Cookie{Name: "session", Value: "1" + cookies[0].Value[2:]})
+	if _, err := m.Get(forged); err != ErrInvalidSession {
+		t.Errorf("Expected ErrInvalidSession for a changed user, got %v", err)
+	}
+	other := NewSessionManager([]byte("other"), SessionConfig{CookieName: "session", Path: "/", TTL: time.Hour})
+	if _, err := other.Get(r); err != ErrInvalidSession {
+		t.Errorf("Expected ErrInvalidSession for another key, got %v", err)
+	}
+
+	expired := NewSessionManager([]byte("key"), SessionConfig{CookieName: "session", Path: "/", TTL: -time.Minute})
+	rec = httptest.NewRecorder()
+	expired.Create(rec, 42)
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	if _, err := m.Get(r); err != ErrInvalidSession {
+		t.Errorf("Expected ErrInvalidSession for an expired session, got %v", err)
+	}
+}
+
+func TestSessionManager_CSRF(t *testing.T) {
+	m := NewSessionManager([]byte("key"), SessionConfig{CookieName: "session", Path: "/", TTL: time.Hour})
+	session := m.Create(httptest.NewRecorder(), 42)
+	token := m.CSRFToken(session)
+
+	// Refreshing a session keeps its token; another session of the same user has its own.
+	if refreshed := m.Refresh(httptest.NewRecorder(), session); m.CSRFToken(refreshed) != token {
+		t.Errorf("Expected a refreshed session to keep its CSRF token")
+	}
+	if other := m.Create(httptest.NewRecorder(), 42); m.CSRFToken(other) == token {
+		t.Errorf("Expected another session to have another CSRF token")
+	}
+
+	header := httptest.NewRequest(http.MethodPost, "/", nil)
+	header.Header.Set(CSRFHeader, token)
+	if err := m.CheckCSRF(header, session); err != nil {
+		t.Errorf("Expected the header token to be accepted, got %v", err)
+	}
+
+	form := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(url.Values{CSRFField: {token}}.Encode()))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := m.CheckCSRF(form, session); err != nil {
+		t.Errorf("Expected the form token to be accepted, got %v", err)
+	}
+
+	missing := httptest.NewRequest(http.MethodPost, "/", nil)
+	if err := m.CheckCSRF(missing, session); err != ErrInvalidCSRFToken {
+		t.Errorf("Expected ErrInvalidCSRFToken without a token, got %v", err)
+	}
+	wrong := httptest.NewRequest(http.MethodPost, "/", nil)
+	wrong.Header.Set(CSRFHeader, token+"x")
+	if err := m.CheckCSRF(wrong, session); err != ErrInvalidCSRFToken {
+		t.Errorf("Expected ErrInvalidCSRFToken for a wrong token, got %v", err)
+	}
+}
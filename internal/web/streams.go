@@ -0,0 +1,67 @@
+package web
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamRegistry keeps the contexts of the active HTTP streams and player connections of each
+// user, so an admin can close all of them at once.
+type StreamRegistry struct {
+	mu      sync.Mutex
+	nextID  uint64
+	streams map[int64]map[uint64]context.CancelFunc // By user ID, then by stream
+}
+
+// NewStreamRegistry creates an empty registry.
+func NewStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{streams: make(map[int64]map[uint64]context.CancelFunc)}
+}
+
+// Track returns a context derived from parent that Cancel(userID) cancels. The caller must call
+// the returned function once the stream ends, which removes it and cancels the context.
+func (r *StreamRegistry) Track(parent context.Context, userID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	if r.streams[userID] == nil {
+		r.streams[userID] = make(map[uint64]context.CancelFunc)
+	}
+	r.streams[userID][id] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.streams[userID], id)
+		if len(r.streams[userID]) == 0 {
+			delete(r.streams, userID)
+		}
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// Count returns the number of active streams of a user.
+func (r *StreamRegistry) Count(userID int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.streams[userID])
+}
+
+// Cancel cancels the contexts of all active streams of a user and returns how many there were.
+// The streams stay registered until their handlers return.
+func (r *StreamRegistry) Cancel(userID int64) int {
+	r.mu.Lock()
+	cancels := make([]context.CancelFunc, 0, len(r.streams[userID]))
+	for _, cancel := range r.streams[userID] {
+		cancels = append(cancels, cancel)
+	}
+	r.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return len(cancels)
+}
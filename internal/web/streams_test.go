@@ -0,0 +1,36 @@
+package web
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStreamRegistry(t *testing.T) {
+	registry := NewStreamRegistry()
+	first, doneFirst := registry.Track(context.Background(), 1)
+	second, doneSecond := registry.Track(context.Background(), 1)
+	other, doneOther := registry.Track(context.Background(), 2)
+	defer doneOther()
+
+	if n := registry.Count(1); n != 2 {
+		t.Fatalf("Count = %d, want 2", n)
+	}
+	if n := registry.Cancel(1); n != 2 {
+		t.Errorf("Cancel = %d, want 2", n)
+	}
+	if first.Err() == nil || second.Err() == nil {
+		t.Error("Expected the user's streams to be cancelled")
+	}
+	if other.Err() != nil {
+		t.Error("Expected another user's stream to keep running")
+	}
+
+	doneFirst()
+	doneSecond()
+	if n := registry.Count(1); n != 0 {
+		t.Errorf("Count after the streams ended = %d, want 0", n)
+	}
+	if n := registry.Cancel(1); n != 0 {
+		t.Errorf("Cancel without streams = %d, want 0", n)
+	}
+}
@@ -0,0 +1,170 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"webBridgeBot/internal/reader"
+)
+
+const trackListChunkID = 0 // Track n is cached as chunk n+1.
+
+// ErrTrackNotFound is returned for subtitle tracks a file does not have.
+var ErrTrackNotFound = errors.New("subtitle track not found")
+
+var (
+	subtitleStreamPattern = regexp.MustCompile(`^\s*Stream #\d+:\d+(?:\[\w+\])?(?:\((\w+)\))?: Subtitle: (\w+)`)
+	streamPattern         = regexp.MustCompile(`^\s*Stream #`)
+	titlePattern          = regexp.MustCompile(`^\s*title\s*:\s*(.+)$`)
+)
+
+// textSubtitleCodecs are the subtitle formats ffmpeg can convert to WebVTT. Bitmap formats such
+// as PGS or VobSub would need OCR.
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// SubtitleTrack is a text subtitle stream embedded in a video. Index counts subtitle streams
+// only, as in ffmpeg's 0:s:N stream specifier.
+type SubtitleTrack struct {
+	Index    int    `json:"index"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Codec    string `json:"codec"`
+}
+
+// Label returns a name for the track to show to users.
+func (t SubtitleTrack) Label() string {
+	if t.Title != "" {
+		return t.Title
+	}
+	if t.Language != "" && t.Language != "und" {
+		return t.Language
+	}
+	return fmt.Sprintf("Track %d", t.Index+1)
+}
+
+// SubtitleExtractor lists and converts the subtitle tracks of videos with ffmpeg. Results are
+// kept in the BinaryCache, so each file is only probed and converted once.
+type SubtitleExtractor struct {
+	ffmpegPath string
+	cache      *reader.BinaryCache
+	logger     *log.Logger
+}
+
+// NewSubtitleExtractor creates an extractor that runs the ffmpeg binary at ffmpegPath.
+func NewSubtitleExtractor(ffmpegPath string, cache *reader.BinaryCache, logger *log.Logger) *SubtitleExtractor {
+	return &SubtitleExtractor{ffmpegPath: ffmpegPath, cache: cache, logger: logger}
+}
+
+// subtitleCacheKey returns the BinaryCache location used for the subtitles of a file.
+func subtitleCacheKey(fileID int64) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "subs:%d", fileID)
+	return int64(h.Sum64() >> 1)
+}
+
+// Tracks returns the text subtitle tracks of a file, probing sourceURL if they are not cached.
+func (e *SubtitleExtractor) Tracks(ctx context.Context, fileID int64, sourceURL string) ([]SubtitleTrack, error) {
+	key := subtitleCacheKey(fileID)
+	if data, err := e.cache.GetChunk(key, trackListChunkID); err == nil {
+		var tracks []SubtitleTrack
+		if err := json.Unmarshal(data, &tracks); err == nil {
+			return tracks, nil
+		}
+	}
+
+	// Without an output file ffmpeg prints the stream list and exits with an error.
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.ffmpegPath, "-hide_banner", "-nostdin", "-i", sourceURL)
+	cmd.Stderr = &stderr
+	_ = cmd.Run()
+	if !bytes.Contains(stderr.Bytes(), []byte("Input #0")) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg could not read the file: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	tracks := parseSubtitleTracks(stderr.Bytes())
+	if data, err := json.Marshal(tracks); err == nil {
+		if err := e.cache.PutChunk(key, trackListChunkID, data); err != nil {
+			e.logger.Printf("Failed to cache subtitle tracks of file %d: %v", fileID, err)
+		}
+	}
+	return tracks, nil
+}
+
+// WebVTT returns a subtitle track of a file converted to WebVTT.
+func (e *SubtitleExtractor) WebVTT(ctx context.Context, fileID int64, track int, sourceURL string) ([]byte, error) {
+	key := subtitleCacheKey(fileID)
+	if data, err := e.cache.GetChunk(key, int64(track)+1); err == nil {
+		return data, nil
+	}
+
+	tracks, err := e.Tracks(ctx, fileID, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	if track < 0 || track >= len(tracks) {
+		return nil, ErrTrackNotFound
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.ffmpegPath, "-nostdin", "-loglevel", "error", "-i", sourceURL,
+		"-map", fmt.Sprintf("0:s:%d", tracks[track].Index), "-f", "webvtt", "pipe:1")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	data := stdout.Bytes()
+	if err := e.cache.PutChunk(key, int64(track)+1, data); err != nil {
+		e.logger.Printf("Failed to cache subtitle track %d of file %d: %v", track, fileID, err)
+	}
+	return data, nil
+}
+
+// parseSubtitleTracks reads the text subtitle streams from the stream list ffmpeg prints for
+// its input. Bitmap tracks are left out, but still counted for the stream index.
+func parseSubtitleTracks(output []byte) []SubtitleTrack {
+	tracks := []SubtitleTrack{}
+	index := -1
+	var current *SubtitleTrack
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := subtitleStreamPattern.FindStringSubmatch(line); match != nil {
+			index++
+			current = nil
+			if textSubtitleCodecs[match[2]] {
+				tracks = append(tracks, SubtitleTrack{Index: index, Language: match[1], Codec: match[2]})
+				current = &tracks[len(tracks)-1]
+			}
+			continue
+		}
+		if streamPattern.MatchString(line) {
+			current = nil
+			continue
+		}
+		if match := titlePattern.FindStringSubmatch(line); match != nil && current != nil && current.Title == "" {
+			current.Title = strings.TrimSpace(match[1])
+		}
+	}
+	return tracks
+}
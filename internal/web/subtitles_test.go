@@ -0,0 +1,34 @@
+package web
+
+import "testing"
+
+func TestParseSubtitleTracks(t *testing.T) {
+	output := []byte(`Input #0, matroska,webm, from 'http://127.0.0.1:8080/1/abc':
+  Duration: 00:42:00.00, start: 0.000000, bitrate: 2000 kb/s
+  Stream #0:0: Video: h264 (High), yuv420p, 1920x1080, 23.98 fps
+  Stream #0:1(eng): Audio: aac (LC), 48000 Hz, stereo, fltp (default)
+    Metadata:
+      title           : Stereo
+  Stream #0:2(eng): Subtitle: subrip (default)
+    Metadata:
+      title           : English
+  Stream #0:3(ger): Subtitle: hdmv_pgs_subtitle
+  Stream #0:4(fre): Subtitle: ass
+At least one output file must be specified
+`)
+
+	tracks := parseSubtitleTracks(output)
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2: %+v", len(tracks), tracks)
+	}
+	if tracks[0] != (SubtitleTrack{Index: 0, Language: "eng", Title: "English", Codec: "subrip"}) {
+		t.Errorf("tracks[0] = %+v", tracks[0])
+	}
+	// The bitmap track is skipped but keeps its place in the stream numbering.
+	if tracks[1] != (SubtitleTrack{Index: 2, Language: "fre", Codec: "ass"}) {
+		t.Errorf("tracks[1] = %+v", tracks[1])
+	}
+	if tracks[1].Label() != "fre" {
+		t.Errorf("Label = %q, want fre", tracks[1].Label())
+	}
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExtractThumbnail grabs a single frame at the given offset of a video and returns it as a JPEG
+// scaled to width pixels.
+func ExtractThumbnail(ctx context.Context, ffmpegPath, sourceURL string, at time.Duration, width int) ([]byte, error) {
+	args := []string{
+		"-nostdin", "-loglevel", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", sourceURL,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", width),
+		"-f", "image2", "-c:v", "mjpeg",
+		"pipe:1",
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("ffmpeg produced no frame")
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,285 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// A player that has not answered a ping or sent anything for this long is disconnected.
+	wsPongWait = 60 * time.Second
+	// Pings are sent often enough for a pong to arrive within wsPongWait.
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsWriteWait  = 10 * time.Second
+	// The sweep removes players whose connection is still registered but no longer alive, for
+	// example because their reader is stuck.
+	wsSweepInterval = time.Minute
+	wsStaleAfter    = 2 * wsPongWait
+	// Players only send small reports.
+	wsMaxMessageSize = 64 << 10
+	// Messages queued for a player. One that falls this far behind is disconnected rather than
+	// blocking the bot.
+	wsSendBuffer = 64
+)
+
+var (
+	// ErrNoWebSocketClient is returned when sending to a chat without a connected player.
+	ErrNoWebSocketClient = errors.New("no player connected")
+	// ErrWebSocketClosed is returned when sending to a connection that has been closed.
+	ErrWebSocketClosed = errors.New("connection closed")
+	// ErrSendBufferFull is returned when a player does not keep up with its messages.
+	ErrSendBufferFull = errors.New("send buffer full")
+)
+
+type wsMessage struct {
+	messageType int
+	data        []byte
+}
+
+// WebSocketClient is the WebSocket connection of a chat's player. All writes go through one
+// goroutine per connection, as gorilla/websocket allows only one concurrent writer.
+type WebSocketClient struct {
+	chatID    int64
+	conn      *websocket.Conn
+	send      chan wsMessage
+	done      chan struct{} // Closed when the connection is closed
+	closeOnce sync.Once
+	lastSeen  atomic.Int64 // Unix nanoseconds of the last message or pong
+}
+
+func (c *WebSocketClient) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (c *WebSocketClient) idle() time.Duration {
+	return time.Since(time.Unix(0, c.lastSeen.Load()))
+}
+
+// ReadMessage reads the next message from the player. Every message extends the read deadline,
+// like the pongs do.
+func (c *WebSocketClient) ReadMessage() (int, []byte, error) {
+	messageType, p, err := c.conn.ReadMessage()
+	if err == nil {
+		c.touch()
+		_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	}
+	return messageType, p, err
+}
+
+// Send queues a message for the player without waiting for it to be written.
+func (c *WebSocketClient) Send(messageType int, data []byte) error {
+	select {
+	case <-c.done:
+		return ErrWebSocketClosed
+	default:
+	}
+	select {
+	case c.send <- wsMessage{messageType: messageType, data: data}:
+		return nil
+	case <-c.done:
+		return ErrWebSocketClosed
+	default:
+		return ErrSendBufferFull
+	}
+}
+
+// close closes the connection once, after sending closeMessage if it is not nil.
+func (c *WebSocketClient) close(closeMessage []byte) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if closeMessage != nil {
+			// WriteControl may be called concurrently with the writer goroutine.
+			_ = c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		}
+		c.conn.Close()
+	})
+}
+
+// WebSocketManager keeps the player connection of each chat. It is safe for concurrent use by
+// the HTTP handlers and the bot.
+type WebSocketManager struct {
+	mu      sync.RWMutex
+	clients map[int64]*WebSocketClient
+	logger  *log.Logger
+}
+
+// NewWebSocketManager creates an empty manager.
+func NewWebSocketManager(logger *log.Logger) *WebSocketManager {
+	return &WebSocketManager{clients: make(map[int64]*WebSocketClient), logger: logger}
+}
+
+// Register makes conn the player connection of a chat, replacing an earlier one, and starts
+// writing to and pinging it. The caller reads from the returned client until it fails and then
+// removes it.
+func (m *WebSocketManager) Register(chatID int64, conn *websocket.Conn) *WebSocketClient {
+	c := &WebSocketClient{
+		chatID: chatID,
+		conn:   conn,
+		send:   make(chan wsMessage, wsSendBuffer),
+		done:   make(chan struct{}),
+	}
+	c.touch()
+	// The player must answer pings, or send something itself, within the pong wait.
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		c.touch()
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	m.mu.Lock()
+	m.clients[chatID] = c
+	m.mu.Unlock()
+
+	go m.writePump(c)
+	return c
+}
+
+// writePump writes the queued messages and the pings of a connection until it is closed.
+func (m *WebSocketManager) writePump(c *WebSocketClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(msg.messageType, msg.data); err != nil {
+				m.Remove(c, fmt.Sprintf("write failed: %v", err))
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				m.Remove(c, fmt.Sprintf("ping failed: %v", err))
+				return
+			}
+		}
+	}
+}
+
+// Remove unregisters and closes a player connection. A newer connection of the same chat is
+// left registered.
+func (m *WebSocketManager) Remove(c *WebSocketClient, reason string) {
+	if m.unregister(c) {
+		m.logger.Printf("WebSocket of chat ID %d disconnected: %s", c.chatID, reason)
+	}
+	c.close(nil)
+}
+
+// Close is Remove for connections the server ends, telling the player why. Closing a connection
+// that was closed already does nothing.
+func (m *WebSocketManager) Close(c *WebSocketClient, code int, text string) {
+	if m.unregister(c) {
+		m.logger.Printf("WebSocket of chat ID %d closed: %s", c.chatID, text)
+	}
+	c.close(websocket.FormatCloseMessage(code, text))
+}
+
+// unregister removes a connection if it is the registered one of its chat and reports whether
+// it was.
+func (m *WebSocketManager) unregister(c *WebSocketClient) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	registered := m.clients[c.chatID] == c
+	if registered {
+		delete(m.clients, c.chatID)
+	}
+	return registered
+}
+
+// Send queues a text message for the player of a chat. A player whose queue is full is
+// disconnected; it reconnects and loads the current state again.
+func (m *WebSocketManager) Send(chatID int64, message []byte) error {
+	m.mu.RLock()
+	c, ok := m.clients[chatID]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrNoWebSocketClient
+	}
+
+	err := c.Send(websocket.TextMessage, message)
+	if errors.Is(err, ErrSendBufferFull) {
+		m.Remove(c, fmt.Sprintf("%d messages not sent in time", wsSendBuffer))
+	}
+	return err
+}
+
+// Connected reports whether the player of a chat is connected.
+func (m *WebSocketManager) Connected(chatID int64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.clients[chatID]
+	return ok
+}
+
+// Count returns the number of connected players.
+func (m *WebSocketManager) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.clients)
+}
+
+// CloseAll tells all players why they are disconnected and closes their connections.
+func (m *WebSocketManager) CloseAll(code int, text string) {
+	m.mu.Lock()
+	clients := m.clients
+	m.clients = make(map[int64]*WebSocketClient)
+	m.mu.Unlock()
+
+	closeMessage := websocket.FormatCloseMessage(code, text)
+	for _, c := range clients {
+		c.close(closeMessage)
+	}
+}
+
+// Sweep periodically disconnects players that have been silent for twice the pong wait, until
+// ctx is done.
+func (m *WebSocketManager) Sweep(ctx context.Context) {
+	ticker := time.NewTicker(wsSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		var stale []*WebSocketClient
+		m.mu.RLock()
+		for _, c := range m.clients {
+			if c.idle() > wsStaleAfter {
+				stale = append(stale, c)
+			}
+		}
+		m.mu.RUnlock()
+
+		for _, c := range stale {
+			m.Remove(c, fmt.Sprintf("stale, silent for %s", c.idle().Round(time.Second)))
+		}
+	}
+}
+
+// DisconnectReason describes why reading from a player failed.
+func DisconnectReason(err error) string {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		if closeErr.Text != "" {
+			return fmt.Sprintf("closed by the player (%d: %s)", closeErr.Code, closeErr.Text)
+		}
+		return fmt.Sprintf("closed by the player (%d)", closeErr.Code)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Sprintf("no pong within %s", wsPongWait)
+	}
+	return err.Error()
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestPlayer connects a player for a chat to a server that registers it with the manager.
+func newTestPlayer(t *testing.T, m *WebSocketManager, chatID int64) (*websocket.Conn, <-chan *WebSocketClient) {
+	t.Helper()
+	registered := make(chan *WebSocketClient, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := m.Register(chatID, conn)
+		registered <- client
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				m.Remove(client, DisconnectReason(err))
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, registered
+}
+
+func TestWebSocketManager_ConcurrentSends(t *testing.T) {
+	m := NewWebSocketManager(log.New(io.Discard, "", 0))
+	conn, registered := newTestPlayer(t, m, 1)
+	<-registered
+
+	// Sends from many goroutines must not interleave their writes.
+	const senders, perSender = 8, 5
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				if err := m.Send(1, []byte(fmt.Sprintf(`{"n":"%d-%d"}`, i, j))); err != nil {
+					t.Errorf("Send failed: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(seen) < senders*perSender {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed after %d messages: %v", len(seen), err)
+		}
+		seen[string(data)] = true
+	}
+}
+
+func TestWebSocketManager_Remove(t *testing.T) {
+	m := NewWebSocketManager(log.New(io.Discard, "", 0))
+	_, first := newTestPlayer(t, m, 1)
+	older := <-first
+	_, second := newTestPlayer(t, m, 1)
+	newer := <-second
+
+	// Removing a replaced connection leaves the newer one registered.
+	m.Remove(older, "test")
+	if !m.Connected(1) || m.Count() != 1 {
+		t.Fatalf("Expected the newer connection to stay registered")
+	}
+	if err := older.Send(websocket.TextMessage, []byte("{}")); err != ErrWebSocketClosed {
+		t.Errorf("Expected ErrWebSocketClosed, got %v", err)
+	}
+
+	m.Remove(newer, "test")
+	if err := m.Send(1, []byte("{}")); err != ErrNoWebSocketClient {
+		t.Errorf("Expected ErrNoWebSocketClient, got %v", err)
+	}
+}
+
+func TestWebSocketManager_Close(t *testing.T) {
+	m := NewWebSocketManager(log.New(io.Discard, "", 0))
+	conn, registered := newTestPlayer(t, m, 1)
+	client := <-registered
+
+	m.Close(client, websocket.ClosePolicyViolation, "closed by an admin")
+	if m.Connected(1) {
+		t.Error("Expected the closed connection to be unregistered")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected a policy violation close, got %v", err)
+	}
+	// Closing it again does nothing.
+	m.Close(client, websocket.ClosePolicyViolation, "closed by an admin")
+}
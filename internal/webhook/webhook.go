@@ -0,0 +1,256 @@
+// Package webhook posts events of the bot, such as media being bridged, to HTTP endpoints of the
+// operator, signed with HMAC-SHA256 and retried until they are accepted.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events the bot sends.
+const (
+	EventMediaBridged       = "media.bridged"
+	EventUserRegistered     = "user.registered"
+	EventStreamCompleted    = "stream.completed"
+	EventCacheEvictionStorm = "cache.eviction_storm"
+	EventSessionInvalidated = "session.invalidated"
+)
+
+var knownEvents = map[string]bool{
+	EventMediaBridged:       true,
+	EventUserRegistered:     true,
+	EventStreamCompleted:    true,
+	EventCacheEvictionStorm: true,
+	EventSessionInvalidated: true,
+}
+
+const (
+	requestTimeout = 10 * time.Second
+	// Deliveries are attempted this many times, waiting twice as long after every failure.
+	maxAttempts = 5
+	// Events waiting for delivery; further events are dropped while endpoints are down.
+	queueSize = 256
+)
+
+// Event is the JSON body posted to the endpoints.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Config lists the endpoints, the secret the bodies are signed with and the events to send.
+type Config struct {
+	URLs   []string
+	Secret string
+	// Events to send; empty sends all of them.
+	Events []string
+}
+
+// Dispatcher queues events and posts them to the endpoints in the background. A nil
+// *Dispatcher drops every event, so callers do not need to check whether webhooks are set up.
+type Dispatcher struct {
+	urls       []string
+	secret     []byte
+	events     map[string]bool
+	client     *http.Client
+	queue      chan Event
+	retryDelay time.Duration
+	logger     *log.Logger
+}
+
+// ParseURLs parses a comma-separated list of http or https endpoints.
+func ParseURLs(list string) ([]string, error) {
+	var urls []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", entry)
+		}
+		urls = append(urls, entry)
+	}
+	return urls, nil
+}
+
+// ParseEvents parses a comma-separated list of event types.
+func ParseEvents(list string) ([]string, error) {
+	var events []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !knownEvents[entry] {
+			return nil, fmt.Errorf("unknown webhook event %q", entry)
+		}
+		events = append(events, entry)
+	}
+	return events, nil
+}
+
+// NewDispatcher creates a dispatcher for cfg, or returns nil if it has no endpoints. Events are
+// only posted once Run is started.
+func NewDispatcher(cfg Config, logger *log.Logger) *Dispatcher {
+	if len(cfg.URLs) == 0 {
+		return nil
+	}
+	d := &Dispatcher{
+		urls:       cfg.URLs,
+		secret:     []byte(cfg.Secret),
+		client:     &http.Client{Timeout: requestTimeout},
+		queue:      make(chan Event, queueSize),
+		retryDelay: time.Second,
+		logger:     logger,
+	}
+	if len(cfg.Events) > 0 {
+		d.events = make(map[string]bool)
+		for _, event := range cfg.Events {
+			d.events[event] = true
+		}
+	}
+	return d
+}
+
+// Send queues an event of the given type with data, which is encoded as JSON. It never blocks;
+// the event is dropped if the queue is full.
+func (d *Dispatcher) Send(eventType string, data any) {
+	if d == nil || (d.events != nil && !d.events[eventType]) {
+		return
+	}
+	event := Event{ID: newEventID(), Type: eventType, Time: time.Now().UTC(), Data: data}
+	select {
+	case d.queue <- event:
+	default:
+		d.logger.Printf("Webhook queue is full, dropping %s event %s", eventType, event.ID)
+	}
+}
+
+// Run posts the queued events in order until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case event := <-d.queue:
+			d.dispatch(ctx, event)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush posts the events still queued until the queue is empty or ctx is done, so events sent
+// right before the bot stops are not lost.
+func (d *Dispatcher) Flush(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	for ctx.Err() == nil {
+		select {
+		case event := <-d.queue:
+			d.dispatch(ctx, event)
+		default:
+			return
+		}
+	}
+}
+
+// dispatch posts an event to every endpoint.
+func (d *Dispatcher) dispatch(ctx context.Context, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Printf("Failed to encode %s event %s: %v", event.Type, event.ID, err)
+		return
+	}
+	for _, endpoint := range d.urls {
+		if err := d.deliver(ctx, endpoint, event, body); err != nil {
+			d.logger.Printf("Failed to deliver %s event %s to %s: %v", event.Type, event.ID, endpoint, err)
+		}
+	}
+}
+
+// deliver posts an event to an endpoint, retrying with exponential backoff while it fails with
+// a network error, a server error, 408 or 429.
+func (d *Dispatcher) deliver(ctx context.Context, endpoint string, event Event, body []byte) error {
+	delay := d.retryDelay
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var retry bool
+		if retry, err = d.post(ctx, endpoint, event, body); err == nil || !retry {
+			return err
+		}
+		if attempt == maxAttempts {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", maxAttempts, err)
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth retrying.
+func (d *Dispatcher) post(ctx context.Context, endpoint string, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "webBridgeBot-webhook")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if len(d.secret) > 0 {
+		req.Header.Set("X-Webhook-Signature", "sha256="+Sign(d.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("endpoint answered %s", resp.Status)
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of timestamp, a dot and body with secret. Receivers
+// compute it from the X-Webhook-Timestamp header and the raw body to check the
+// X-Webhook-Signature header, and can reject old timestamps to stop replays.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventID returns a random ID, which receivers can use to ignore retried duplicates.
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
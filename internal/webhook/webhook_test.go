@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	attempts := 0
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// The first attempt fails and is retried.
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Webhook-Signature"), "sha256="+Sign([]byte("secret"), r.Header.Get("X-Webhook-Timestamp"), body); got != want {
+			t.Errorf("Signature = %q, want %q", got, want)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Invalid body: %v", err)
+		}
+		if r.Header.Get("X-Webhook-Event") != event.Type || r.Header.Get("X-Webhook-ID") != event.ID {
+			t.Errorf("Headers %v do not match event %+v", r.Header, event)
+		}
+		received = append(received, event)
+		if len(received) == 2 {
+			close(done)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(Config{
+		URLs:   []string{server.URL},
+		Secret: "secret",
+		Events: []string{EventMediaBridged, EventStreamCompleted},
+	}, log.New(io.Discard, "", 0))
+	d.retryDelay = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Send(EventMediaBridged, map[string]int{"messageId": 1})
+	d.Send(EventUserRegistered, map[string]int{"userId": 2}) // Not subscribed
+	d.Send(EventStreamCompleted, map[string]int{"messageId": 1})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the events")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 || received[0].Type != EventMediaBridged || received[1].Type != EventStreamCompleted {
+		t.Errorf("Unexpected deliveries after %d attempts: %+v", attempts, received)
+	}
+}
+
+func TestDispatcher_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(Config{URLs: []string{server.URL}}, log.New(io.Discard, "", 0))
+	d.retryDelay = time.Millisecond
+	if err := d.deliver(context.Background(), server.URL, Event{Type: EventMediaBridged}, []byte("{}")); err == nil || attempts != 1 {
+		t.Errorf("deliver = %v after %d attempts; want an error after 1", err, attempts)
+	}
+}
+
+func TestDispatcher_Flush(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Webhook-Event"))
+	}))
+	defer server.Close()
+
+	// Events queued without Run are posted by Flush.
+	d := NewDispatcher(Config{URLs: []string{server.URL}}, log.New(io.Discard, "", 0))
+	d.Send(EventSessionInvalidated, map[string]string{"account": "bot"})
+	d.Send(EventStreamCompleted, map[string]int{"messageId": 1})
+	d.Flush(context.Background())
+	if len(received) != 2 || received[0] != EventSessionInvalidated || received[1] != EventStreamCompleted {
+		t.Errorf("Flush posted %v, want both events in order", received)
+	}
+
+	var nilDispatcher *Dispatcher
+	nilDispatcher.Flush(context.Background())
+}
+
+func TestParse(t *testing.T) {
+	if d := NewDispatcher(Config{}, nil); d != nil {
+		t.Error("Expected no dispatcher without URLs")
+	}
+	(*Dispatcher)(nil).Send(EventMediaBridged, nil)
+
+	if urls, err := ParseURLs(" https://example.com/hook , http://10.0.0.2:5678/webhook "); err != nil || len(urls) != 2 {
+		t.Errorf("ParseURLs = %v, %v", urls, err)
+	}
+	if _, err := ParseURLs("ftp://example.com"); err == nil {
+		t.Error("Expected a non-HTTP URL to be rejected")
+	}
+	if _, err := ParseEvents("media.bridged,stream.started"); err == nil {
+		t.Error("Expected an unknown event to be rejected")
+	}
+}
@@ -1,34 +1,71 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/spf13/cobra"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 	"webBridgeBot/internal/bot"
 	"webBridgeBot/internal/config"
+	"webBridgeBot/internal/data"
+	logging "webBridgeBot/internal/logger"
+	"webBridgeBot/internal/reader"
+	"webBridgeBot/internal/rpc"
 )
 
 var cfg config.Configuration
 
 func main() {
-	logger := log.New(os.Stdout, "webBridgeBot: ", log.Ldate|log.Ltime|log.Lshortfile)
+	logger := logging.New(os.Stdout, logging.FormatText)
 	rootCmd := &cobra.Command{
 		Use:   "webBridgeBot",
 		Short: "WebBridgeBot",
 		Run: func(cmd *cobra.Command, args []string) {
 			cfg = config.LoadConfig(logger)
+			out := io.Writer(os.Stdout)
+			if cfg.LogFile != "" {
+				logFile, err := logging.OpenRotatingFile(cfg.LogFile, int64(cfg.LogMaxSize)*1024*1024, cfg.LogMaxBackups, cfg.LogMaxAge)
+				if err != nil {
+					logger.Fatalf("Error opening log file: %v", err)
+				}
+				defer logFile.Close()
+				out = io.MultiWriter(os.Stdout, logFile)
+			}
+			logger = logging.New(out, cfg.LogFormat)
+			logging.SetDefault(logger)
+			if err := logging.SetLevel(cfg.LogLevel); err != nil {
+				logger.Fatalf("Invalid LOG_LEVEL: %v", err)
+			}
 			b, err := bot.NewTelegramBot(&cfg, logger)
 			if err != nil {
 				log.Fatalf("Error initializing Telegram bot: %v", err)
 			}
 
-			b.Run()
+			// Stop gracefully on Ctrl+C and on SIGTERM from Docker or systemd.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			b.Run(ctx)
 		},
 	}
 
 	// Define flags
 	defineFlags(rootCmd)
+	rootCmd.AddCommand(newCacheCommand(logger))
+	rootCmd.AddCommand(newDBCommand(logger))
+	rootCmd.AddCommand(newLoginCommand(logger))
+	rootCmd.AddCommand(newRPCCommand(logger))
+	rootCmd.AddCommand(newUsersCommand(logger))
+	rootCmd.AddCommand(newConfigCommand(logger))
+	rootCmd.AddCommand(newDoctorCommand(logger))
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -40,10 +77,389 @@ func defineFlags(cmd *cobra.Command) {
 	cmd.Flags().IntVar(&cfg.ApiID, "api_id", 0, "API ID")
 	cmd.Flags().StringVar(&cfg.ApiHash, "api_hash", "", "API Hash")
 	cmd.Flags().StringVar(&cfg.BotToken, "bot_token", "", "Bot Token")
+	cmd.Flags().StringVar(&cfg.ClientType, "client_type", "", "Log in as a bot or as a user account: bot or user")
+	cmd.Flags().StringVar(&cfg.PhoneNumber, "phone_number", "", "Phone number of the user account in user mode")
+	cmd.Flags().StringVar(&cfg.SessionPath, "session_path", "", "File that stores the Telegram session")
+	cmd.Flags().StringVar(&cfg.SessionDirectory, "session_directory", "", "Directory of the user account and worker bot sessions")
+	cmd.Flags().StringVar(&cfg.SessionMode, "session_mode", "", "Keep Telegram sessions on disk or log in on every start: persistent or memory")
 	cmd.Flags().StringVar(&cfg.BaseURL, "base_url", "", "Base URL")
 	cmd.Flags().StringVar(&cfg.Port, "port", "", "Port")
 	cmd.Flags().IntVar(&cfg.HashLength, "hash_length", 0, "Hash Length")
 	cmd.Flags().StringVar(&cfg.CacheDirectory, "cache_directory", "", "Cache Directory")
 	cmd.Flags().Int64Var(&cfg.MaxCacheSize, "max_cache_size", 0, "Max Cache Size")
+	cmd.Flags().StringVar(&cfg.CacheCompression, "cache_compression", "", "Compress cached chunks: none or zstd")
+	cmd.Flags().StringVar(&cfg.CacheLayout, "cache_layout", "", "Store cached chunks in one file or a segment file per media file: file or segments")
+	cmd.Flags().Float64Var(&cfg.CacheHotShare, "cache_hot_share", 0, "Share of the cache kept for media being streamed; negative disables")
+	cmd.Flags().Int64Var(&cfg.MinFreeDisk, "min_free_disk", 0, "Free disk space in bytes to keep by shrinking the cache; negative disables")
+	cmd.Flags().BoolVar(&cfg.CacheFsck, "cache_fsck", false, "Verify the checksums of the whole cache at startup")
+	cmd.Flags().Float64Var(&cfg.CacheCompactThreshold, "cache_compact_threshold", 0, "Compact the cache files once this share of them is unused; negative disables")
 	cmd.Flags().BoolVar(&cfg.DebugMode, "debug_mode", false, "Enable Debug Mode")
+	cmd.Flags().StringVar(&cfg.LogFormat, "log_format", "", "Log format: text or json")
+	cmd.Flags().StringVar(&cfg.LogLevel, "log_level", "", "Least severe log lines written: info, warn or error")
+	cmd.Flags().StringVar(&cfg.LogFile, "log_file", "", "Also write logs to this file, with rotation")
+	cmd.Flags().IntVar(&cfg.LogMaxSize, "log_max_size", 0, "Rotate the log file once it reaches this many megabytes")
+	cmd.Flags().IntVar(&cfg.LogMaxBackups, "log_max_backups", 0, "Number of rotated log files to keep")
+	cmd.Flags().DurationVar(&cfg.LogMaxAge, "log_max_age", 0, "Rotate the log file once it is this old; 0 disables")
+	cmd.Flags().StringVar(&cfg.DBDriver, "db_driver", "", "Database driver: sqlite, postgres or mysql")
+	cmd.Flags().StringVar(&cfg.DBDSN, "db_dsn", "", "Database connection string")
+	cmd.Flags().StringVar(&cfg.StreamErrorMode, "stream_error_mode", "", "Stream error handling: abort or notify")
+	cmd.Flags().IntVar(&cfg.ProxyMaxIdleConns, "proxy_max_idle_conns", 0, "Max idle connections of the proxy HTTP client")
+	cmd.Flags().IntVar(&cfg.ProxyMaxIdleConnsPerHost, "proxy_max_idle_conns_per_host", 0, "Max idle connections per host of the proxy HTTP client")
+	cmd.Flags().DurationVar(&cfg.ProxyIdleConnTimeout, "proxy_idle_conn_timeout", 0, "Idle connection timeout of the proxy HTTP client")
+	cmd.Flags().DurationVar(&cfg.ProxyDialTimeout, "proxy_dial_timeout", 0, "Dial timeout of the proxy HTTP client")
+	cmd.Flags().DurationVar(&cfg.ProxyTLSHandshakeTimeout, "proxy_tls_handshake_timeout", 0, "TLS handshake timeout of the proxy HTTP client")
+	cmd.Flags().StringVar(&cfg.ProxyOutboundURL, "proxy_outbound_url", "", "Outbound proxy URL for external media requests")
+	cmd.Flags().StringVar(&cfg.ProxyAllowedDomains, "proxy_allowed_domains", "", "Comma-separated domains external media may be fetched from; empty allows any public host")
+	cmd.Flags().Int64Var(&cfg.ProxyMaxResponseSize, "proxy_max_response_size", 0, "Largest external media file served by the proxy, in bytes")
+	cmd.Flags().DurationVar(&cfg.ProxyCacheTTL, "proxy_cache_ttl", 0, "How long proxied media is cached before it is checked for changes; negative disables the cache")
+	cmd.Flags().Int64Var(&cfg.ProxyCacheMaxFileSize, "proxy_cache_max_file_size", 0, "Largest external media file that is cached, in bytes")
+	cmd.Flags().Int64Var(&cfg.ChunkSize, "chunk_size", 0, "Size of Telegram file requests and cache chunks, a power of two between 4 KB and 1 MB")
+	cmd.Flags().IntVar(&cfg.TelegramRequestsPerSecond, "telegram_requests_per_second", 0, "Max number of Telegram file requests per second")
+	cmd.Flags().IntVar(&cfg.TelegramRequestBurst, "telegram_request_burst", 0, "Telegram file requests that may be sent at once after a pause")
+	cmd.Flags().StringVar(&cfg.WorkerBotTokens, "worker_bot_tokens", "", "Comma-separated tokens of extra bots that download files")
+	cmd.Flags().Int64Var(&cfg.StorageChannelID, "storage_channel_id", 0, "Channel files are copied to for the worker bots")
+	cmd.Flags().IntVar(&cfg.TelegramMaxRetries, "telegram_max_retries", 0, "Max number of attempts per Telegram file request")
+	cmd.Flags().IntVar(&cfg.PrefetchDepth, "prefetch_depth", 0, "Number of chunks requested ahead while streaming")
+	cmd.Flags().IntVar(&cfg.PrefetchWorkers, "prefetch_workers", 0, "Files cached in the background at once")
+	cmd.Flags().Int64Var(&cfg.PrefetchAutoMaxSize, "prefetch_auto_max_size", 0, "Cache files up to this size in bytes as soon as they are sent; 0 disables")
+	cmd.Flags().StringVar(&cfg.FFmpegPath, "ffmpeg_path", "", "Path to ffmpeg; enables HLS playback of videos the browser cannot play")
+	cmd.Flags().BoolVar(&cfg.HLSTranscode, "hls_transcode", false, "Re-encode HLS output to H.264/AAC instead of remuxing")
+	cmd.Flags().DurationVar(&cfg.HLSSegmentDuration, "hls_segment_duration", 0, "Target duration of HLS segments")
+	cmd.Flags().DurationVar(&cfg.HLSIdleTimeout, "hls_idle_timeout", 0, "Stop HLS jobs that are not watched for this long")
+	cmd.Flags().IntVar(&cfg.RateLimitPerIP, "rate_limit_per_ip", 0, "Max stream and proxy requests per minute from one IP address; negative disables")
+	cmd.Flags().IntVar(&cfg.RateLimitPerChat, "rate_limit_per_chat", 0, "Max player API requests per minute for one chat; negative disables")
+	cmd.Flags().IntVar(&cfg.RateLimitBurst, "rate_limit_burst", 0, "Number of requests allowed at once before rate limiting applies")
+	cmd.Flags().Int64Var(&cfg.MaxStreamBandwidth, "max_stream_bandwidth", 0, "Bytes per second each stream may send; 0 is unlimited")
+	cmd.Flags().Int64Var(&cfg.MaxTotalBandwidth, "max_total_bandwidth", 0, "Bytes per second all streams together may send; 0 is unlimited")
+	cmd.Flags().IntVar(&cfg.MaxStreamsPerUser, "max_streams_per_user", 0, "Streams of one user's media that may be active at once; 0 is unlimited")
+	cmd.Flags().BoolVar(&cfg.RateLimitTrustProxy, "rate_limit_trust_proxy", false, "Identify clients by the X-Forwarded-For header set by a reverse proxy")
+	cmd.Flags().StringVar(&cfg.TrustedProxies, "trusted_proxies", "", "Comma-separated addresses and CIDR ranges of reverse proxies whose forwarding headers are trusted")
+	cmd.Flags().StringVar(&cfg.CORSAllowedOrigins, "cors_allowed_origins", "", "Comma-separated origins of other sites that may use the streams, APIs and WebSockets, or * for all")
+	cmd.Flags().StringVar(&cfg.CORSAllowedHeaders, "cors_allowed_headers", "", "Comma-separated request headers those sites may send")
+	cmd.Flags().StringVar(&cfg.HTTPCompression, "http_compression", "", "Compression of pages and API responses in order of preference: zstd, gzip, or none")
+	cmd.Flags().StringVar(&cfg.WebhookURLs, "webhook_urls", "", "Comma-separated URLs that events are posted to")
+	cmd.Flags().StringVar(&cfg.WebhookSecret, "webhook_secret", "", "Secret the webhook bodies are signed with")
+	cmd.Flags().StringVar(&cfg.WebhookEvents, "webhook_events", "", "Comma-separated events to post; empty posts all")
+	cmd.Flags().StringVar(&cfg.AdminRPCSocket, "admin_rpc_socket", "", "Unix socket to serve the JSON-RPC admin API on")
+	cmd.Flags().StringVar(&cfg.TLSCertFile, "tls_cert_file", "", "Certificate file to serve HTTPS and HTTP/2 with")
+	cmd.Flags().StringVar(&cfg.TLSKeyFile, "tls_key_file", "", "Private key file of the TLS certificate")
+	cmd.Flags().BoolVar(&cfg.CastEnabled, "cast_enabled", false, "Enable /cast to play media on DLNA renderers in the local network")
+	cmd.Flags().DurationVar(&cfg.CastDiscoveryTimeout, "cast_discovery_timeout", 0, "How long /cast waits for renderers to answer")
+	cmd.Flags().StringVar(&cfg.PlayerTitle, "player_title", "", "Title shown by the web player and its installed app")
+	cmd.Flags().StringVar(&cfg.PlayerAccentColor, "player_accent_color", "", "Accent color of the web player, as a hex color such as #00aaff")
+	cmd.Flags().StringVar(&cfg.PlayerLogoURL, "player_logo_url", "", "URL of a logo shown next to the title of the web player")
+	cmd.Flags().StringVar(&cfg.PlayerCustomCSS, "player_custom_css", "", "Path to a stylesheet the web player loads after its own styles")
+	cmd.Flags().DurationVar(&cfg.AdminSessionTTL, "admin_session_ttl", 0, "How long an admin stays signed in to the admin panel")
+	cmd.Flags().BoolVar(&cfg.PlayerLoginRequired, "player_login_required", false, "Require signing in with Telegram before a web player is served")
+	cmd.Flags().DurationVar(&cfg.PlayerSessionTTL, "player_session_ttl", 0, "How long a visitor stays signed in to the web player")
+	cmd.Flags().DurationVar(&cfg.MetadataTTL, "metadata_ttl", 0, "How long the file of a message is used from the database before it is looked up again")
+	cmd.Flags().Int64Var(&cfg.QuotaDailyBytes, "quota_daily_bytes", 0, "Default number of bytes a user's media may stream per day; 0 is unlimited")
+	cmd.Flags().Int64Var(&cfg.QuotaMonthlyBytes, "quota_monthly_bytes", 0, "Default number of bytes a user's media may stream per month; 0 is unlimited")
+	cmd.Flags().Int64Var(&cfg.MaxFileSize, "max_file_size", 0, "Largest file in bytes users may bridge; 0 allows files up to the supported maximum")
+	cmd.Flags().DurationVar(&cfg.ShutdownGracePeriod, "shutdown_grace_period", 0, "How long active streams may continue after a shutdown signal")
+}
+
+func newCacheCommand(logger *log.Logger) *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Maintain the binary cache",
+	}
+
+	var legacyChunkSize int64
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Rewrite the cache to the current format and the configured CHUNK_SIZE and CACHE_LAYOUT",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg = config.LoadCacheConfig(logger)
+			if err := reader.ValidateChunkSize(cfg.ChunkSize); err != nil {
+				logger.Fatalf("Invalid CHUNK_SIZE: %v", err)
+			}
+			if err := reader.ValidateLayout(cfg.CacheLayout); err != nil {
+				logger.Fatalf("Invalid CACHE_LAYOUT: %v", err)
+			}
+			kept, err := reader.MigrateCache(cfg.CacheDirectory, legacyChunkSize, cfg.ChunkSize, cfg.MaxCacheSize, cfg.CacheLayout)
+			if err != nil {
+				logger.Fatalf("Error migrating cache: %v", err)
+			}
+			logger.Printf("Migrated cache in %s with %d chunks", cfg.CacheDirectory, kept)
+		},
+	}
+	migrateCmd.Flags().Int64Var(&legacyChunkSize, "legacy_chunk_size", reader.DefaultChunkSize, "Chunk size of caches created before format versioning")
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Print the statistics of the cache while the bot is stopped",
+		Run: func(cmd *cobra.Command, args []string) {
+			cache := openCache(logger)
+			defer cache.Close()
+			stats, err := cache.GetStats()
+			if err != nil {
+				logger.Fatalf("Error reading cache statistics: %v", err)
+			}
+			printJSON(stats)
+		},
+	}
+	compactCmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Give the space of evicted chunks back to the disk while the bot is stopped",
+		Run: func(cmd *cobra.Command, args []string) {
+			cache := openCache(logger)
+			defer cache.Close()
+			reclaimed, err := cache.Compact()
+			if err != nil {
+				logger.Fatalf("Error compacting cache: %v", err)
+			}
+			logger.Printf("Compacted cache in %s, reclaimed %d bytes", cfg.CacheDirectory, reclaimed)
+		},
+	}
+	purgeCmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete every cached chunk while the bot is stopped",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg = config.LoadCacheConfig(logger)
+			freed, err := reader.PurgeCache(cfg.CacheDirectory)
+			if err != nil {
+				logger.Fatalf("Error purging cache: %v", err)
+			}
+			logger.Printf("Purged cache in %s, freed %d bytes", cfg.CacheDirectory, freed)
+		},
+	}
+
+	cacheCmd.AddCommand(migrateCmd, statsCmd, compactCmd, purgeCmd)
+	return cacheCmd
+}
+
+// openCache opens the configured cache for a maintenance command.
+func openCache(logger *log.Logger) *reader.BinaryCache {
+	cfg = config.LoadCacheConfig(logger)
+	cache, err := reader.NewBinaryCacheWithLayout(cfg.CacheDirectory, cfg.MaxCacheSize, cfg.ChunkSize, cfg.CacheLayout, logger)
+	if err != nil {
+		logger.Fatalf("Error opening cache: %v", err)
+	}
+	return cache
+}
+
+func newDBCommand(logger *log.Logger) *cobra.Command {
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Maintain the application database",
+	}
+
+	var target int
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending schema migrations, or revert to an older version with --to",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg = config.LoadCacheConfig(logger)
+			db, err := data.Open(cfg.DBDriver, cfg.DBDSN)
+			if err != nil {
+				logger.Fatalf("Error opening %s database: %v", cfg.DBDriver, err)
+			}
+			defer db.Close()
+
+			migrator := data.NewMigrator(db)
+			if !cmd.Flags().Changed("to") {
+				target = migrator.LatestVersion()
+			}
+			steps, err := migrator.MigrateTo(target)
+			if err != nil {
+				logger.Fatalf("Error migrating database: %v", err)
+			}
+			logger.Printf("Ran %d migration steps, schema is at version %d", steps, target)
+		},
+	}
+	migrateCmd.Flags().IntVar(&target, "to", 0, "Schema version to migrate to (default: latest)")
+
+	dbCmd.AddCommand(migrateCmd)
+	return dbCmd
+}
+
+func newLoginCommand(logger *log.Logger) *cobra.Command {
+	return &cobra.Command{
+		Use:   "login",
+		Short: "Log in to the user account for CLIENT_TYPE=user and save its session",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg = config.LoadCacheConfig(logger)
+			if err := bot.Login(&cfg, logger); err != nil {
+				logger.Fatalf("Error logging in: %v", err)
+			}
+		},
+	}
+}
+
+func newRPCCommand(logger *log.Logger) *cobra.Command {
+	var socket string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "rpc <method> [params-json]",
+		Short: "Call a method of the admin API of a running bot and print the result",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if !cmd.Flags().Changed("socket") {
+				cfg = config.LoadCacheConfig(logger)
+				socket = cfg.AdminRPCSocket
+			}
+			if socket == "" {
+				logger.Fatalf("Set ADMIN_RPC_SOCKET or --socket to the socket of the admin API")
+			}
+			var params json.RawMessage
+			if len(args) == 2 {
+				params = json.RawMessage(args[1])
+				if !json.Valid(params) {
+					logger.Fatalf("Params are not valid JSON: %s", args[1])
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			var result json.RawMessage
+			if err := rpc.Call(ctx, socket, args[0], params, &result); err != nil {
+				logger.Fatalf("Error calling %s: %v", args[0], err)
+			}
+			printJSON(result)
+		},
+	}
+	cmd.Flags().StringVar(&socket, "socket", "", "Socket of the admin API (default: ADMIN_RPC_SOCKET)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "How long to wait for the result")
+	return cmd
+}
+
+func newUsersCommand(logger *log.Logger) *cobra.Command {
+	usersCmd := &cobra.Command{
+		Use:   "users",
+		Short: "Manage users in the database while the bot is stopped",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List all users",
+		Run: func(cmd *cobra.Command, args []string) {
+			users, closeDB := openUsers(logger)
+			defer closeDB()
+			list, err := users.ListUsers()
+			if err != nil {
+				logger.Fatalf("Error listing users: %v", err)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "USER ID\tUSERNAME\tNAME\tAUTHORIZED\tADMIN\tCREATED")
+			for _, u := range list {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%t\t%s\n", u.UserID, u.Username, strings.TrimSpace(u.FirstName+" "+u.LastName), u.IsAuthorized, u.IsAdmin, u.CreatedAt)
+			}
+			w.Flush()
+		},
+	}
+
+	var admin bool
+	authorizeCmd := &cobra.Command{
+		Use:   "authorize <user_id>",
+		Short: "Authorize a user who sent /start, optionally as an admin",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			userID := parseUserID(logger, args[0])
+			users, closeDB := openUsers(logger)
+			defer closeDB()
+			if _, err := users.GetUserInfo(userID); err != nil {
+				logger.Fatalf("User %d has not started the bot: %v", userID, err)
+			}
+			if err := users.AuthorizeUser(userID, admin); err != nil {
+				logger.Fatalf("Error authorizing user %d: %v", userID, err)
+			}
+			logger.Printf("Authorized user %d (admin: %t)", userID, admin)
+		},
+	}
+	authorizeCmd.Flags().BoolVar(&admin, "admin", false, "Make the user an admin")
+
+	deauthorizeCmd := &cobra.Command{
+		Use:   "deauthorize <user_id>",
+		Short: "Revoke the access of a user",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			userID := parseUserID(logger, args[0])
+			users, closeDB := openUsers(logger)
+			defer closeDB()
+			if err := users.DeauthorizeUser(userID); err != nil {
+				logger.Fatalf("Error deauthorizing user %d: %v", userID, err)
+			}
+			logger.Printf("Deauthorized user %d", userID)
+		},
+	}
+
+	usersCmd.AddCommand(listCmd, authorizeCmd, deauthorizeCmd)
+	return usersCmd
+}
+
+// openUsers opens the configured database for a maintenance command.
+func openUsers(logger *log.Logger) (*data.UserRepository, func()) {
+	cfg = config.LoadCacheConfig(logger)
+	db, err := data.Open(cfg.DBDriver, cfg.DBDSN)
+	if err != nil {
+		logger.Fatalf("Error opening %s database: %v", cfg.DBDriver, err)
+	}
+	migrator := data.NewMigrator(db)
+	if version, err := migrator.CurrentVersion(); err != nil {
+		logger.Fatalf("Error reading the database schema version: %v", err)
+	} else if version != migrator.LatestVersion() {
+		logger.Fatalf("Database schema is at version %d, expected %d; run 'webBridgeBot db migrate'", version, migrator.LatestVersion())
+	}
+	return data.NewUserRepository(db), func() { db.Close() }
+}
+
+func parseUserID(logger *log.Logger, arg string) int64 {
+	userID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		logger.Fatalf("Invalid user ID %q", arg)
+	}
+	return userID
+}
+
+func newConfigCommand(logger *log.Logger) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the environment and .env for every setting that would stop the bot from starting",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg = config.LoadCacheConfig(logger)
+			if err := cfg.Validate(); err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					fmt.Fprintln(os.Stderr, line)
+				}
+				os.Exit(1)
+			}
+			fmt.Println("Configuration is valid")
+		},
+	}
+	configCmd.AddCommand(validateCmd)
+	return configCmd
+}
+
+func newDoctorCommand(logger *log.Logger) *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the configuration, Telegram credentials, database, cache directory, port and BASE_URL",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg = config.LoadCacheConfig(logger)
+			failed := false
+			for _, d := range bot.Doctor(cmd.Context(), &cfg) {
+				fmt.Printf("[%-4s] %s: %s\n", d.Status, d.Check, d.Detail)
+				if d.Fix != "" {
+					fmt.Printf("       %s\n", d.Fix)
+				}
+				failed = failed || d.Status == bot.DiagnosticFailed
+			}
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func printJSON(v any) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("Error encoding result: %v", err)
+	}
+	fmt.Println(string(out))
 }
@@ -0,0 +1,45 @@
+// Service worker for the WebBridgeBot media player.
+// It caches the static assets of the player shell. Pages are never cached: the player carries the
+// CSRF token of its session and admin pages must not outlive a sign-out, so they always come from
+// the network.
+const CACHE_NAME = 'webbridgebot-shell-v2';
+const SCOPE = '{{.Scope}}';
+
+self.addEventListener('install', (event) => {
+    event.waitUntil(
+        caches.open(CACHE_NAME).then((cache) => cache.addAll([SCOPE + 'icon.svg']))
+    );
+    self.skipWaiting();
+});
+
+self.addEventListener('activate', (event) => {
+    event.waitUntil(
+        caches.keys().then((keys) => Promise.all(
+            keys.filter((key) => key !== CACHE_NAME).map((key) => caches.delete(key))
+        )).then(() => self.clients.claim())
+    );
+});
+
+self.addEventListener('fetch', (event) => {
+    const request = event.request;
+
+    // Never intercept media streams, range requests or non-GET traffic.
+    if (request.method !== 'GET' || request.headers.has('range')) {
+        return;
+    }
+
+    // Cache-first for the static shell assets.
+    const url = new URL(request.url);
+    if (url.pathname === SCOPE + 'icon.svg' || url.pathname.startsWith(SCOPE + 'manifest/')) {
+        event.respondWith(
+            caches.match(request).then((cached) => cached || fetch(request).then((response) => {
+                const cacheControl = response.headers.get('cache-control') || '';
+                if (response.ok && !cacheControl.includes('no-store')) {
+                    const copy = response.clone();
+                    caches.open(CACHE_NAME).then((cache) => cache.put(request, copy));
+                }
+                return response;
+            }))
+        );
+    }
+});